@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/andi/fileaction/backend/database"
@@ -222,6 +223,42 @@ func (s *Server) activatePluginVersion(c *fiber.Ctx) error {
 	return c.JSON(SuccessResponse{Message: "Version activated successfully"})
 }
 
+// TestPluginRequest represents the request to run a plugin version against a sample file
+type TestPluginRequest struct {
+	Inputs          map[string]string `json:"inputs"`
+	SampleInputPath string            `json:"sample_input_path"`
+}
+
+// testPluginVersion runs a plugin version synchronously against a sample input
+// and returns its log and step results without creating a task
+func (s *Server) testPluginVersion(c *fiber.Ctx) error {
+	pluginID := c.Params("id")
+	versionID := c.Params("version_id")
+
+	var req TestPluginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+	if req.SampleInputPath != "" {
+		if _, err := os.Stat(req.SampleInputPath); err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Sample input not found: %s", req.SampleInputPath)})
+		}
+	}
+
+	repo := database.NewPluginRepo(s.db)
+	version, err := repo.GetPluginVersionByID(versionID)
+	if err != nil || version.PluginID != pluginID {
+		return c.Status(404).JSON(ErrorResponse{Error: "Plugin version not found"})
+	}
+
+	result, err := s.scheduler.TestPlugin(c.Context(), version.YAMLContent, req.Inputs, req.SampleInputPath)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(result)
+}
+
 // searchPlugins searches plugins by query, source, or tags
 func (s *Server) searchPlugins(c *fiber.Ctx) error {
 	query := c.Query("query", "")
@@ -1,14 +1,28 @@
 package api
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/logsink"
+	"github.com/andi/fileaction/backend/metrics"
 	"github.com/andi/fileaction/backend/models"
 	"github.com/andi/fileaction/backend/watcher"
 	"github.com/andi/fileaction/backend/workflow"
@@ -21,19 +35,34 @@ import (
 
 // TaskCanceller defines the interface for cancelling tasks
 type TaskCanceller interface {
-	CancelTask(taskID string) error
+	CancelTask(taskID, reason string) error
 }
 
 // SchedulerStats defines the interface for getting scheduler statistics
 type SchedulerStats interface {
-	GetExecutorPoolStats() map[string]int
-	GetExecutorStatus() interface{}
+	GetExecutorPoolStats() map[string]interface{}
+	GetExecutorStatus(busyOnly bool, limit, offset int) interface{}
+	GetWorkflowRunningCount(workflowID string) int
 }
 
-// Scheduler combines both interfaces
+// DrainController defines the interface for draining the scheduler
+type DrainController interface {
+	Drain()
+	IsDraining() bool
+	GetRunningCount() int
+}
+
+// PluginTester defines the interface for running a plugin outside of a task
+type PluginTester interface {
+	TestPlugin(ctx context.Context, pluginYAML string, inputs map[string]string, sampleInput string) (interface{}, error)
+}
+
+// Scheduler combines all scheduler interfaces
 type Scheduler interface {
 	TaskCanceller
 	SchedulerStats
+	DrainController
+	PluginTester
 }
 
 // Server represents the HTTP API server
@@ -44,10 +73,31 @@ type Server struct {
 	watcher   *watcher.Watcher
 	logDir    string
 	wsHub     *WebSocketHub
+	logSink   logsink.Sink
+
+	// How long archived tasks are kept before a purge removes them
+	archiveRetention time.Duration
+}
+
+// defaultArchiveRetention is how long archived tasks are kept unless configured
+const defaultArchiveRetention = 30 * 24 * time.Hour
+
+// AuthConfig configures authentication of API requests. With neither a token
+// nor basic auth credentials set, requests are not authenticated.
+type AuthConfig struct {
+	Token     string // Accepted as "Authorization: Bearer <token>"
+	Username  string // Basic auth credentials
+	Password  string
+	ProtectUI bool // Also require authentication for the index page and static files
+}
+
+// Enabled reports whether any credentials are configured
+func (a AuthConfig) Enabled() bool {
+	return a.Token != "" || a.Username != ""
 }
 
 // New creates a new API server
-func New(db *database.DB, scheduler Scheduler, watch *watcher.Watcher, logDir string) *Server {
+func New(db *database.DB, scheduler Scheduler, watch *watcher.Watcher, logDir string, auth AuthConfig) *Server {
 	// Initialize HTML template engine
 	engine := html.New("./frontend/templates", ".html")
 
@@ -57,7 +107,8 @@ func New(db *database.DB, scheduler Scheduler, watch *watcher.Watcher, logDir st
 	})
 
 	// Middleware
-	app.Use(recover.New())
+	app.Use(recoverMiddleware())
+	app.Use(jsonCharset)
 
 	// Configure logger to write only to file
 	accessLogPath := filepath.Join(logDir, "access.log")
@@ -78,9 +129,20 @@ func New(db *database.DB, scheduler Scheduler, watch *watcher.Watcher, logDir st
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
 		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
 	}))
 
+	// Registered before the routes so that it runs ahead of every handler
+	if auth.Enabled() {
+		log.Printf("API authentication enabled (protect UI: %v)", auth.ProtectUI)
+		if auth.ProtectUI {
+			app.Use(authMiddleware(auth))
+		} else {
+			app.Use("/api", authMiddleware(auth))
+			app.Use("/metrics", authMiddleware(auth))
+		}
+	}
+
 	server := &Server{
 		app:       app,
 		db:        db,
@@ -102,27 +164,47 @@ func (s *Server) setupRoutes() {
 	// Static files
 	s.app.Static("/static", "./frontend/static")
 
+	// Prometheus metrics
+	s.app.Get("/metrics", s.getMetrics)
+
 	// API routes
 	api := s.app.Group("/api")
 
 	// Workflows
 	api.Get("/workflows", s.listWorkflows)
 	api.Post("/workflows", s.createWorkflow)
+	api.Post("/workflows/import", s.importWorkflows)
+	api.Post("/workflows/preview-command", s.previewCommand)
+	api.Post("/workflows/validate", s.validateWorkflow)
 	api.Get("/workflows/:id", s.getWorkflow)
 	api.Put("/workflows/:id", s.updateWorkflow)
 	api.Put("/workflows/:id/toggle", s.toggleWorkflow)
+	api.Put("/workflows/:id/pause", s.pauseWorkflow)
 	api.Delete("/workflows/:id", s.deleteWorkflow)
 	api.Post("/workflows/:id/scan", s.scanWorkflow)
+	api.Post("/workflows/:id/dry-run", s.dryRunWorkflow)
 	api.Post("/workflows/:id/clear-index", s.clearWorkflowIndex)
+	api.Post("/workflows/:id/reprocess", s.reprocessWorkflow)
+	api.Post("/workflows/:id/pin-plugins", s.pinWorkflowPlugins)
+	api.Get("/workflows/:id/logs.zip", s.downloadWorkflowLogs)
+	api.Get("/workflows/:id/stats", s.getWorkflowStats)
 
 	// Tasks
 	api.Get("/tasks", s.listTasks)
+	api.Post("/tasks/cancel-batch", s.cancelTaskBatch)
+	api.Post("/tasks/purge", s.purgeTasks)
 	api.Get("/tasks/:id", s.getTask)
 	api.Post("/tasks/:id/retry", s.retryTask)
+	api.Post("/tasks/:id/rerun", s.rerunTask)
 	api.Post("/tasks/:id/cancel", s.cancelTask)
 	api.Delete("/tasks/:id", s.deleteTask)
 	api.Get("/tasks/:id/steps", s.getTaskSteps)
+	api.Get("/tasks/:id/outputs", s.getTaskOutputs)
+	api.Get("/tasks/:id/failures", s.getTaskFailures)
+	api.Get("/tasks/:id/execution", s.getTaskExecution)
 	api.Get("/tasks/:id/log/tail", s.tailTaskLog)
+	api.Get("/tasks/:id/log/stream", s.streamTaskLog)
+	api.Get("/tasks/:id/queue-position", s.getTaskQueuePosition)
 
 	// Files
 	api.Get("/files", s.listFiles)
@@ -133,6 +215,12 @@ func (s *Server) setupRoutes() {
 	// Scheduler/Monitoring
 	api.Get("/scheduler/stats", s.getSchedulerStats)
 	api.Get("/scheduler/executors", s.getExecutorStatus)
+	api.Post("/scheduler/drain", s.drainScheduler)
+	api.Get("/scheduler/drain", s.getDrainStatus)
+
+	// Admin
+	api.Get("/admin/defaults", s.getDefaults)
+	api.Post("/admin/reset-defaults", s.resetDefaults)
 
 	// Plugins
 	api.Get("/plugins", s.listPlugins)
@@ -143,6 +231,7 @@ func (s *Server) setupRoutes() {
 	api.Get("/plugins/:id/versions", s.getPluginVersions)
 	api.Post("/plugins/:id/versions", s.createPluginVersion)
 	api.Put("/plugins/:id/versions/:version_id/activate", s.activatePluginVersion)
+	api.Post("/plugins/:id/versions/:version_id/test", s.testPluginVersion)
 	api.Get("/plugins/search", s.searchPlugins)
 }
 
@@ -163,9 +252,39 @@ func (s *Server) GetWebSocketHub() *WebSocketHub {
 	return s.wsHub
 }
 
+// SetLogSink sets the external sink that completed task logs are read from
+func (s *Server) SetLogSink(sink logsink.Sink) {
+	s.logSink = sink
+}
+
+// SetArchiveRetention sets how long archived tasks are kept before
+// POST /api/tasks/purge removes them. Values of 0 or less keep the default.
+func (s *Server) SetArchiveRetention(retention time.Duration) {
+	if retention > 0 {
+		s.archiveRetention = retention
+	}
+}
+
+// loadTaskLog fills in LogText for tasks whose log is stored in the log sink
+func (s *Server) loadTaskLog(task *models.Task) error {
+	if task.LogKey == "" || task.LogText != "" {
+		return nil
+	}
+	if s.logSink == nil {
+		return fmt.Errorf("task log is stored externally but no log sink is configured")
+	}
+	data, err := s.logSink.Get(task.LogKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch task log: %w", err)
+	}
+	task.LogText = string(data)
+	return nil
+}
+
 // Error response
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 // Success response
@@ -174,13 +293,82 @@ type SuccessResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// errorHandler handles fiber errors
+// panicLocal marks a request whose handler panicked
+const panicLocal = "panicked"
+
+// errorHandler handles fiber errors, including recovered panics, with an
+// ErrorResponse JSON body
 func errorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
 	if e, ok := err.(*fiber.Error); ok {
 		code = e.Code
 	}
-	return c.Status(code).JSON(ErrorResponse{Error: err.Error()})
+	message := err.Error()
+	if c.Locals(panicLocal) != nil {
+		message = "Internal server error"
+	}
+	return c.Status(code).JSON(ErrorResponse{Error: message}, fiber.MIMEApplicationJSONCharsetUTF8)
+}
+
+// recoverMiddleware turns handler panics into errors for errorHandler and
+// logs them with their stack trace
+func recoverMiddleware() fiber.Handler {
+	return recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, e interface{}) {
+			c.Locals(panicLocal, true)
+			log.Printf("Panic in %s %s: %v\n%s", c.Method(), c.Path(), e, debug.Stack())
+		},
+	})
+}
+
+// jsonCharset declares UTF-8 on JSON responses
+func jsonCharset(c *fiber.Ctx) error {
+	err := c.Next()
+	if string(c.Response().Header.ContentType()) == fiber.MIMEApplicationJSON {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	}
+	return err
+}
+
+// authMiddleware rejects requests without a valid bearer token or basic auth
+// credentials with 401
+func authMiddleware(auth AuthConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok && auth.Token != "" {
+			if secretEqual(token, auth.Token) {
+				return c.Next()
+			}
+		}
+		if encoded, ok := strings.CutPrefix(header, "Basic "); ok && auth.Username != "" {
+			if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				username, password, _ := strings.Cut(string(decoded), ":")
+				// Both are compared so that a wrong username takes as long as a wrong password
+				userOK := secretEqual(username, auth.Username)
+				passOK := secretEqual(password, auth.Password)
+				if userOK && passOK {
+					return c.Next()
+				}
+			}
+		}
+
+		// Lets browsers prompt for credentials when the UI calls the API
+		if auth.Username != "" {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="FileAction"`)
+		} else {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		}
+		return c.Status(401).JSON(ErrorResponse{Error: "Authentication required"})
+	}
+}
+
+// secretEqual compares a credential in constant time. Hashing first keeps the
+// comparison from revealing the configured secret's length.
+func secretEqual(given, want string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
 }
 
 // ============== Page Rendering ==============
@@ -199,7 +387,129 @@ func (s *Server) listWorkflows(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
 	}
-	return c.JSON(workflows)
+
+	responses := make([]WorkflowResponse, len(workflows))
+	for i, wf := range workflows {
+		responses[i] = WorkflowResponse{Workflow: wf, Health: s.watcher.CheckHealth(wf)}
+	}
+	return c.JSON(responses)
+}
+
+// PreviewCommandRequest represents a request to resolve a command template for a sample file
+type PreviewCommandRequest struct {
+	Command     string            `json:"command"`
+	SampleInput string            `json:"sample_input"`
+	OutputPath  string            `json:"output_path,omitempty"`  // Overrides the derived output path
+	YAMLContent string            `json:"yaml_content,omitempty"` // Workflow used to derive the output path
+	Inputs      map[string]string `json:"inputs,omitempty"`       // Plugin inputs for ${{ inputs.* }}
+}
+
+func (s *Server) previewCommand(c *fiber.Ctx) error {
+	var req PreviewCommandRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+	if req.Command == "" || req.SampleInput == "" {
+		return c.Status(400).JSON(ErrorResponse{Error: "command and sample_input are required"})
+	}
+
+	outputPath := req.OutputPath
+	if outputPath == "" {
+		workflowDef := &workflow.WorkflowDef{}
+		if req.YAMLContent != "" {
+			var err error
+			workflowDef, err = workflow.Parse(req.YAMLContent)
+			if err != nil {
+				return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Invalid workflow YAML: %v", err)})
+			}
+		}
+		// With several targets the first one is previewed. The sample file is
+		// not read, so {hash} is shown as is.
+		outputPath = workflowDef.TaskOutputPaths(req.SampleInput, time.Now(), workflow.OutputTokenHash)[0]
+	}
+
+	command, vars := workflow.PreviewCommand(req.Command, req.SampleInput, outputPath, req.Inputs)
+	return c.JSON(fiber.Map{
+		"command": command,
+		"variables": fiber.Map{
+			"input_path":  vars.InputPath,
+			"output_path": vars.OutputPath,
+			"output_dir":  vars.OutputDir,
+			"file_name":   vars.FileName,
+			"file_dir":    vars.FileDir,
+			"file_base":   vars.FileBase,
+			"file_ext":    vars.FileExt,
+		},
+	})
+}
+
+// ValidateWorkflowRequest represents a request to check workflow YAML without saving it
+type ValidateWorkflowRequest struct {
+	YAMLContent string `json:"yaml_content"`
+}
+
+// ValidateWorkflowResponse lists every problem found in a workflow definition
+type ValidateWorkflowResponse struct {
+	Valid    bool                       `json:"valid"`
+	Errors   []workflow.ValidationError `json:"errors"`
+	Warnings []string                   `json:"warnings,omitempty"`
+}
+
+func (s *Server) validateWorkflow(c *fiber.Ctx) error {
+	var req ValidateWorkflowRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+
+	workflowDef, err := workflow.Decode(req.YAMLContent)
+	if err != nil {
+		return c.JSON(ValidateWorkflowResponse{
+			Errors: []workflow.ValidationError{{Message: err.Error()}},
+		})
+	}
+
+	errs := workflow.ValidateAll(workflowDef)
+	if errs == nil {
+		errs = []workflow.ValidationError{}
+	}
+	warnings := append(workflowWarnings(workflowDef, nil), s.missingPluginWarnings(workflowDef)...)
+	return c.JSON(ValidateWorkflowResponse{
+		Valid:    len(errs) == 0,
+		Errors:   errs,
+		Warnings: warnings,
+	})
+}
+
+// missingPluginWarnings reports step plugin references that no stored plugin version satisfies
+func (s *Server) missingPluginWarnings(workflowDef *workflow.WorkflowDef) []string {
+	var warnings []string
+	pluginRepo := database.NewPluginRepo(s.db)
+	for i, step := range workflowDef.Steps {
+		if step.Uses == "" {
+			continue
+		}
+		field := fmt.Sprintf("steps[%d].uses", i)
+		pluginName, version, err := workflow.ParsePluginReference(step.Uses)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", field, err))
+			continue
+		}
+		plugin, err := pluginRepo.GetPluginByName(pluginName)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: plugin %q not found", field, pluginName))
+			continue
+		}
+		if version == "" {
+			if _, err := pluginRepo.GetPluginCurrentVersion(plugin.ID); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: plugin %q has no current version", field, pluginName))
+			}
+			continue
+		}
+		if _, err := pluginRepo.ResolvePluginVersion(pluginName, version); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: plugin %q has no version matching %s", field, pluginName, version))
+		}
+	}
+	return warnings
 }
 
 type CreateWorkflowRequest struct {
@@ -209,6 +519,46 @@ type CreateWorkflowRequest struct {
 	Enabled     bool   `json:"enabled"`
 }
 
+// WorkflowResponse is a workflow with non-fatal warnings found while saving it
+type WorkflowResponse struct {
+	*models.Workflow
+	Warnings []string                `json:"warnings,omitempty"`
+	Health   *watcher.WorkflowHealth `json:"health,omitempty"`
+}
+
+// collisionSampleSize caps how many indexed inputs are checked for output collisions on save
+const collisionSampleSize = 1000
+
+// workflowWarnings lists issues that don't prevent saving a workflow.
+// sampleInputs are indexed input paths checked for output path collisions.
+func workflowWarnings(workflowDef *workflow.WorkflowDef, sampleInputs []string) []string {
+	var warnings []string
+	for _, name := range workflow.UnpinnedPlugins(workflowDef) {
+		warnings = append(warnings, fmt.Sprintf("Plugin %q has no version; the workflow will follow whichever version is active. Pin it with %s@<version>.", name, name))
+	}
+	// Inputs in {hash} directories only collide if their contents match, which can't be told here
+	if !workflowDef.UsesOutputToken(workflow.OutputTokenHash) {
+		for _, collision := range workflow.FindOutputCollisions(sampleInputs, workflowDef.Convert, workflowDef.Options.OutputDirPattern) {
+			warnings = append(warnings, fmt.Sprintf("Output %s would be written by %d inputs: %s", collision.OutputPath, len(collision.Inputs), strings.Join(collision.Inputs, ", ")))
+		}
+	}
+	return warnings
+}
+
+// sampleInputPaths returns up to collisionSampleSize indexed input paths of a workflow
+func (s *Server) sampleInputPaths(workflowID string) []string {
+	files, err := database.NewFileRepo(s.db).ListByWorkflow(workflowID, collisionSampleSize, 0)
+	if err != nil {
+		log.Printf("Warning: failed to list files for collision check: %v", err)
+		return nil
+	}
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.FilePath
+	}
+	return paths
+}
+
 func (s *Server) createWorkflow(c *fiber.Ctx) error {
 	var req CreateWorkflowRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -238,7 +588,11 @@ func (s *Server) createWorkflow(c *fiber.Ctx) error {
 		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
 	}
 
-	return c.Status(201).JSON(wf)
+	return c.Status(201).JSON(WorkflowResponse{
+		Workflow: wf,
+		Warnings: workflowWarnings(workflowDef, nil), // nothing is indexed yet to check for collisions
+		Health:   s.watcher.CheckHealth(wf),
+	})
 }
 
 func (s *Server) getWorkflow(c *fiber.Ctx) error {
@@ -250,7 +604,7 @@ func (s *Server) getWorkflow(c *fiber.Ctx) error {
 		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
 	}
 
-	return c.JSON(wf)
+	return c.JSON(WorkflowResponse{Workflow: wf, Health: s.watcher.CheckHealth(wf)})
 }
 
 func (s *Server) updateWorkflow(c *fiber.Ctx) error {
@@ -286,7 +640,146 @@ func (s *Server) updateWorkflow(c *fiber.Ctx) error {
 		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
 	}
 
-	return c.JSON(wf)
+	return c.JSON(WorkflowResponse{
+		Workflow: wf,
+		Warnings: workflowWarnings(workflowDef, s.sampleInputPaths(wf.ID)),
+		Health:   s.watcher.CheckHealth(wf),
+	})
+}
+
+// ImportWorkflowsRequest holds several workflows in one YAML stream, separated by "---"
+type ImportWorkflowsRequest struct {
+	YAMLContent string `json:"yaml_content"`
+	Enabled     bool   `json:"enabled"` // Applies to newly created workflows only
+}
+
+// ImportResult is the outcome of importing one document of the stream
+type ImportResult struct {
+	Index    int              `json:"index"`
+	Name     string           `json:"name,omitempty"`
+	Status   string           `json:"status"` // created, updated, invalid, failed or skipped
+	Error    string           `json:"error,omitempty"`
+	Workflow *models.Workflow `json:"workflow,omitempty"`
+}
+
+// ImportWorkflowsResponse reports every document; nothing is saved unless all succeed
+type ImportWorkflowsResponse struct {
+	Error   string         `json:"error,omitempty"`
+	Results []ImportResult `json:"results"`
+}
+
+// importWorkflows creates or updates, by name, every workflow in a
+// multi-document YAML stream. The import is all or nothing: if any document
+// is invalid or fails to save, no workflow is changed and the others are
+// reported as skipped.
+func (s *Server) importWorkflows(c *fiber.Ctx) error {
+	var req ImportWorkflowsRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+
+	defs, err := workflow.ParseMulti(req.YAMLContent)
+	var docErrs workflow.DocumentErrors
+	if err != nil && !errors.As(err, &docErrs) {
+		return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Invalid workflow YAML: %v", err)})
+	}
+
+	results := make([]ImportResult, len(defs))
+	for i, def := range defs {
+		results[i] = ImportResult{Index: i, Status: "skipped"}
+		if def != nil {
+			results[i].Name = def.Name
+		}
+	}
+	if len(docErrs) > 0 {
+		for _, docErr := range docErrs {
+			results[docErr.Index].Name = docErr.Name
+			results[docErr.Index].Status = "invalid"
+			results[docErr.Index].Error = docErr.Err.Error()
+		}
+		return c.Status(400).JSON(ImportWorkflowsResponse{
+			Error:   fmt.Sprintf("%d of %d workflow(s) are invalid, nothing was imported", len(docErrs), len(defs)),
+			Results: results,
+		})
+	}
+
+	workflows := make([]*models.Workflow, len(defs))
+	for i, def := range defs {
+		workflows[i] = &models.Workflow{
+			Name:        def.Name,
+			Description: def.Description,
+			YAMLContent: def.Source,
+			Enabled:     req.Enabled,
+		}
+	}
+
+	created, failed, err := database.NewWorkflowRepo(s.db).SaveAllByName(workflows)
+	if err != nil {
+		if failed >= 0 {
+			results[failed].Status = "failed"
+			results[failed].Error = err.Error()
+		}
+		return c.Status(500).JSON(ImportWorkflowsResponse{
+			Error:   fmt.Sprintf("Import failed, nothing was imported: %v", err),
+			Results: results,
+		})
+	}
+
+	for i, wf := range workflows {
+		results[i].Workflow = wf
+		if created[i] {
+			results[i].Status = "created"
+		} else {
+			results[i].Status = "updated"
+		}
+	}
+	return c.JSON(ImportWorkflowsResponse{Results: results})
+}
+
+// pinWorkflowPlugins rewrites unversioned plugin references in a workflow to
+// the plugins' current versions so later activations don't change its behavior
+func (s *Server) pinWorkflowPlugins(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	repo := database.NewWorkflowRepo(s.db)
+	wf, err := repo.GetByID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Invalid workflow YAML: %v", err)})
+	}
+
+	pluginRepo := database.NewPluginRepo(s.db)
+	versions := make(map[string]string)
+	for _, name := range workflow.UnpinnedPlugins(workflowDef) {
+		plugin, err := pluginRepo.GetPluginByName(name)
+		if err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Plugin %s not found", name)})
+		}
+		if plugin.CurrentVersion == "" {
+			return c.Status(400).JSON(ErrorResponse{Error: fmt.Sprintf("Plugin %s has no current version", name)})
+		}
+		versions[name] = plugin.CurrentVersion
+	}
+
+	pinnedYAML, pinned := workflow.PinPluginReferences(wf.YAMLContent, versions)
+	if pinned > 0 {
+		wf.YAMLContent = pinnedYAML
+		if err := repo.Update(wf); err != nil {
+			return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+		}
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: fmt.Sprintf("Pinned %d plugin reference(s)", pinned),
+		Data: fiber.Map{
+			"workflow": wf,
+			"versions": versions,
+		},
+	})
 }
 
 func (s *Server) toggleWorkflow(c *fiber.Ctx) error {
@@ -298,6 +791,15 @@ func (s *Server) toggleWorkflow(c *fiber.Ctx) error {
 		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
 	}
 
+	// Refuse to enable a workflow whose tasks would all fail on missing tools
+	health := s.watcher.CheckHealth(wf)
+	if !wf.Enabled && !health.Healthy {
+		return c.Status(409).JSON(ErrorResponse{
+			Error:   "Workflow is unhealthy and cannot be enabled",
+			Details: health,
+		})
+	}
+
 	// Toggle enabled status
 	wf.Enabled = !wf.Enabled
 
@@ -316,7 +818,57 @@ func (s *Server) toggleWorkflow(c *fiber.Ctx) error {
 		}
 	}
 
-	return c.JSON(wf)
+	return c.JSON(WorkflowResponse{Workflow: wf, Health: health})
+}
+
+// PauseWorkflowRequest pauses or resumes a workflow
+type PauseWorkflowRequest struct {
+	Paused bool `json:"paused"`
+
+	// On resume, queue tasks for files that were new or changed while paused
+	EnqueueChanged bool `json:"enqueue_changed"`
+}
+
+// PauseWorkflowResponse is the workflow after pausing or resuming, with the
+// number of tasks queued on resume
+type PauseWorkflowResponse struct {
+	*models.Workflow
+	TasksCreated int `json:"tasks_created"`
+}
+
+// pauseWorkflow stops a workflow from queuing tasks while its paths are still
+// watched and indexed, or resumes it. Unlike disabling, the index keeps up with
+// changes, so files that changed in the meantime can be queued on resume.
+func (s *Server) pauseWorkflow(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req PauseWorkflowRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+
+	repo := database.NewWorkflowRepo(s.db)
+	wf, err := repo.GetByID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+	if wf.Paused == req.Paused {
+		return c.JSON(PauseWorkflowResponse{Workflow: wf})
+	}
+
+	wf.Paused = req.Paused
+	if err := repo.Update(wf); err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	if wf.Paused {
+		return c.JSON(PauseWorkflowResponse{Workflow: wf})
+	}
+
+	result, err := s.watcher.ResumeWorkflow(id, req.EnqueueChanged)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: fmt.Sprintf("Workflow resumed but deferred files were not processed: %v", err)})
+	}
+	return c.JSON(PauseWorkflowResponse{Workflow: wf, TasksCreated: result.TasksCreated})
 }
 
 func (s *Server) deleteWorkflow(c *fiber.Ctx) error {
@@ -347,6 +899,72 @@ func (s *Server) scanWorkflow(c *fiber.Ctx) error {
 	return c.JSON(SuccessResponse{Message: "Scan started"})
 }
 
+// DryRunResponse lists the tasks a scan would create, with the scan counters
+type DryRunResponse struct {
+	Entries       []watcher.PlannedTask `json:"entries"`
+	FilesScanned  int                   `json:"files_scanned"`
+	FilesNew      int                   `json:"files_new"`
+	FilesChanged  int                   `json:"files_changed"`
+	FilesSkipped  int                   `json:"files_skipped"`
+	TasksCreated  int                   `json:"tasks_created"` // Tasks the scan would create
+	TasksDeferred int                   `json:"tasks_deferred"`
+	Errors        []string              `json:"errors,omitempty"`
+}
+
+// dryRunWorkflow runs a workflow's scan against the real directories without
+// indexing files or creating tasks, so globs and output patterns can be checked
+// before the workflow is enabled
+func (s *Server) dryRunWorkflow(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	repo := database.NewWorkflowRepo(s.db)
+	if _, err := repo.GetByID(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+
+	result, err := s.watcher.DryRunWorkflow(id)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: fmt.Sprintf("Dry run failed: %v", err)})
+	}
+
+	response := DryRunResponse{
+		Entries:       result.Planned,
+		FilesScanned:  result.FilesScanned,
+		FilesNew:      result.FilesNew,
+		FilesChanged:  result.FilesChanged,
+		FilesSkipped:  result.FilesSkipped,
+		TasksCreated:  result.TasksCreated,
+		TasksDeferred: result.TasksDeferred,
+	}
+	if response.Entries == nil {
+		response.Entries = []watcher.PlannedTask{}
+	}
+	for _, err := range result.Errors {
+		response.Errors = append(response.Errors, err.Error())
+	}
+	return c.JSON(response)
+}
+
+// reprocessWorkflow queues new tasks for all indexed files of a workflow while
+// keeping the index, unlike clearWorkflowIndex which rescans from scratch
+func (s *Server) reprocessWorkflow(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	repo := database.NewWorkflowRepo(s.db)
+	if _, err := repo.GetByID(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+
+	// Run in background; task creation waits while the pending task limit is reached
+	go func() {
+		if _, err := s.watcher.ReprocessWorkflow(id); err != nil {
+			log.Printf("Reprocess failed for workflow %s: %v", id, err)
+		}
+	}()
+
+	return c.JSON(SuccessResponse{Message: "Reprocess started"})
+}
+
 func (s *Server) clearWorkflowIndex(c *fiber.Ctx) error {
 	id := c.Params("id")
 
@@ -396,23 +1014,58 @@ func (s *Server) listTasks(c *fiber.Ctx) error {
 	if limit > 1000 {
 		limit = 1000
 	}
+	if limit <= 0 {
+		limit = 50
+	}
 
+	filter := database.TaskFilter{WorkflowID: workflowID, Status: status, IncludeArchived: c.Query("include_archived") == "true"}
+	if raw := c.Query("priority", ""); raw != "" {
+		priority, err := strconv.Atoi(raw)
+		if err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: "Invalid priority"})
+		}
+		filter.Priority = &priority
+	}
+
+	// One extra task tells whether there is a next page
 	repo := database.NewTaskRepo(s.db)
-	tasks, err := repo.List(workflowID, status, limit, offset)
+	var tasks []*models.Task
+	var err error
+	if after := c.Query("after", ""); after != "" {
+		cursor, parseErr := database.ParseTaskCursor(after)
+		if parseErr != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: parseErr.Error()})
+		}
+		tasks, err = repo.ListAfter(filter, cursor, limit+1)
+	} else {
+		tasks, err = repo.ListFiltered(filter, limit+1, offset)
+	}
 	if err != nil {
 		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
 	}
 
-	count, err := repo.Count(workflowID, status)
+	nextCursor := ""
+	if len(tasks) > limit {
+		tasks = tasks[:limit]
+		nextCursor = database.NewTaskCursor(tasks[len(tasks)-1]).String()
+	}
+
+	count, err := repo.CountFiltered(filter)
 	if err != nil {
 		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
 	}
+	totalPages := 0
+	if limit > 0 {
+		totalPages = (count + limit - 1) / limit
+	}
 
 	return c.JSON(fiber.Map{
-		"tasks":  tasks,
-		"total":  count,
-		"limit":  limit,
-		"offset": offset,
+		"tasks":       tasks,
+		"total":       count,
+		"total_pages": totalPages,
+		"limit":       limit,
+		"offset":      offset,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -420,14 +1073,28 @@ func (s *Server) getTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	repo := database.NewTaskRepo(s.db)
 
-	task, err := repo.GetByID(id)
+	getTask := repo.GetByID
+	if c.Query("include_archived") == "true" {
+		getTask = repo.GetIncludingArchived
+	}
+	task, err := getTask(id)
 	if err != nil {
 		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
 	}
 
+	if err := s.loadTaskLog(task); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
 	return c.JSON(task)
 }
 
+// RetryTaskRequest optionally overrides the env of a retried task. An empty
+// object clears previous overrides; omitting env keeps them.
+type RetryTaskRequest struct {
+	Env map[string]string `json:"env"`
+}
+
 func (s *Server) retryTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	repo := database.NewTaskRepo(s.db)
@@ -437,9 +1104,36 @@ func (s *Server) retryTask(c *fiber.Ctx) error {
 		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
 	}
 
+	// Optionally resume from a later step, reusing the results of the steps before it
+	resumeFrom := 0
+	if fromStep := c.Query("from_step"); fromStep != "" {
+		resumeFrom, err = strconv.Atoi(fromStep)
+		if err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: "Invalid from_step"})
+		}
+		if err := s.validateResumeStep(task, resumeFrom); err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: err.Error()})
+		}
+	}
+
+	// An optional body replaces the task's env overrides
+	if len(c.Body()) > 0 {
+		var req RetryTaskRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+		}
+		if req.Env != nil {
+			task.Env = req.Env
+		}
+	}
+
 	// Reset task status
+	now := time.Now()
 	task.Status = models.TaskStatusPending
+	task.QueuedAt = &now
+	task.ResumeFrom = resumeFrom
 	task.ErrorMessage = ""
+	task.CancelReason = ""
 	task.StartedAt = nil
 	task.CompletedAt = nil
 
@@ -451,25 +1145,235 @@ func (s *Server) retryTask(c *fiber.Ctx) error {
 	return c.JSON(SuccessResponse{Message: "Task reset to pending, will be executed by scheduler"})
 }
 
+// rerunTask queues a new task for the same file, workflow and output, linked
+// to the original through parent_task_id. Unlike retryTask the original run is
+// kept with its steps and logs.
+func (s *Server) rerunTask(c *fiber.Ctx) error {
+	id := c.Params("id")
+	repo := database.NewTaskRepo(s.db)
+
+	task, err := repo.GetByID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+	if task.Status == models.TaskStatusPending || task.Status == models.TaskStatusRunning {
+		return c.Status(409).JSON(ErrorResponse{Error: fmt.Sprintf("Task is still %s", task.Status)})
+	}
+
+	var req RetryTaskRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+		}
+	}
+
+	// An earlier rerun, or a change to the file, may already have queued it
+	queued, err := repo.HasPendingTask(task.WorkflowID, task.InputPath, task.InputMD5)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	if queued {
+		return c.Status(409).JSON(ErrorResponse{Error: "A task for this file is already queued"})
+	}
+
+	rerun, err := repo.CloneAsPending(id)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	if wf, err := database.NewWorkflowRepo(s.db).GetByID(rerun.WorkflowID); err == nil {
+		metrics.TasksCreated(wf.Name, 1)
+	}
+	// The body's env replaces the overrides copied from the original
+	if req.Env != nil {
+		rerun.Env = req.Env
+		if err := repo.Update(rerun); err != nil {
+			return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+		}
+	}
+
+	return c.Status(201).JSON(rerun)
+}
+
+// validateResumeStep checks that a task can be retried from the given 1-based
+// step, which requires every earlier step to have completed in a previous run
+func (s *Server) validateResumeStep(task *models.Task, fromStep int) error {
+	wf, err := database.NewWorkflowRepo(s.db).GetByID(task.WorkflowID)
+	if err != nil {
+		return fmt.Errorf("workflow not found")
+	}
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		return fmt.Errorf("failed to parse workflow: %v", err)
+	}
+	if fromStep < 1 || fromStep > len(workflowDef.Steps) {
+		return fmt.Errorf("from_step must be between 1 and %d", len(workflowDef.Steps))
+	}
+
+	var names []string
+	for _, step := range workflowDef.Steps[:fromStep-1] {
+		for _, expanded := range workflow.ExpandMatrix(step) {
+			names = append(names, expanded.Name)
+		}
+	}
+	latest, err := database.NewTaskStepRepo(s.db).LatestStatuses(task.ID, names)
+	if err != nil {
+		return err
+	}
+	for i, step := range workflowDef.Steps[:fromStep-1] {
+		// Every expansion of a matrix step must have completed
+		for _, expanded := range workflow.ExpandMatrix(step) {
+			status := latest[expanded.Name]
+			if status != models.StepStatusCompleted && status != models.StepStatusSkipped {
+				return fmt.Errorf("step %d (%s) has not completed, cannot resume from step %d", i+1, expanded.Name, fromStep)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) getTaskQueuePosition(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	pos, err := database.NewTaskRepo(s.db).QueuePosition(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+	return c.JSON(pos)
+}
+
 func (s *Server) cancelTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	if err := s.scheduler.CancelTask(id); err != nil {
+	if err := s.scheduler.CancelTask(id, models.CancelReasonUser); err != nil {
 		return c.Status(400).JSON(ErrorResponse{Error: err.Error()})
 	}
 
 	return c.JSON(SuccessResponse{Message: "Task cancelled"})
 }
 
+// CancelBatchRequest selects the tasks cancelled by cancelTaskBatch. An empty
+// status cancels both pending and running tasks.
+type CancelBatchRequest struct {
+	WorkflowID string `json:"workflow_id"`
+	Status     string `json:"status"`
+}
+
+// CancelBatchResponse reports how many tasks of each state were cancelled
+type CancelBatchResponse struct {
+	Pending int64 `json:"pending"`
+	Running int   `json:"running"`
+}
+
+func (s *Server) cancelTaskBatch(c *fiber.Ctx) error {
+	var req CancelBatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: "Invalid request body"})
+	}
+	if req.WorkflowID == "" && req.Status == "" {
+		return c.Status(400).JSON(ErrorResponse{Error: "workflow_id or status is required"})
+	}
+	if req.Status != "" && req.Status != models.TaskStatusPending && req.Status != models.TaskStatusRunning {
+		return c.Status(400).JSON(ErrorResponse{Error: "status must be pending or running"})
+	}
+
+	repo := database.NewTaskRepo(s.db)
+	var resp CancelBatchResponse
+
+	// Pending tasks first, so none of them starts while running ones are
+	// cancelled. They are cancelled per workflow to count them in the metrics.
+	if req.Status != models.TaskStatusRunning {
+		workflowIDs := []string{req.WorkflowID}
+		if req.WorkflowID == "" {
+			counts, err := repo.CountByWorkflow(models.TaskStatusPending)
+			if err != nil {
+				return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+			}
+			workflowIDs = workflowIDs[:0]
+			for id := range counts {
+				workflowIDs = append(workflowIDs, id)
+			}
+		}
+		workflowRepo := database.NewWorkflowRepo(s.db)
+		for _, workflowID := range workflowIDs {
+			cancelled, err := repo.CancelPending(workflowID, models.CancelReasonUser)
+			if err != nil {
+				return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+			}
+			if wf, err := workflowRepo.GetByID(workflowID); err == nil {
+				metrics.TasksCancelled(wf.Name, int(cancelled))
+			}
+			resp.Pending += cancelled
+		}
+	}
+
+	if req.Status != models.TaskStatusPending {
+		ids, err := repo.ListIDs(database.TaskFilter{WorkflowID: req.WorkflowID, Status: models.TaskStatusRunning})
+		if err != nil {
+			return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+		}
+		for _, id := range ids {
+			// A task that finished in the meantime is simply skipped
+			if err := s.scheduler.CancelTask(id, models.CancelReasonUser); err != nil {
+				log.Printf("Failed to cancel task %s: %v", id, err)
+				continue
+			}
+			resp.Running++
+		}
+	}
+
+	return c.JSON(resp)
+}
+
 func (s *Server) deleteTask(c *fiber.Ctx) error {
 	id := c.Params("id")
 	repo := database.NewTaskRepo(s.db)
 
+	task, err := repo.GetByID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+	// Archiving a task the scheduler still holds would hide it mid-run
+	if task.Status == models.TaskStatusPending || task.Status == models.TaskStatusRunning {
+		return c.Status(409).JSON(ErrorResponse{Error: fmt.Sprintf("Task is still %s", task.Status)})
+	}
+
 	if err := repo.Delete(id); err != nil {
 		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
 	}
 
-	return c.JSON(SuccessResponse{Message: "Task deleted"})
+	return c.JSON(SuccessResponse{Message: "Task archived"})
+}
+
+// PurgeTasksResponse reports the permanent removal of archived tasks
+type PurgeTasksResponse struct {
+	Purged         int64     `json:"purged"`
+	ArchivedBefore time.Time `json:"archived_before"` // Tasks archived before this time were removed
+}
+
+// purgeTasks permanently removes tasks archived longer ago than the retention
+// period, or than ?older_than when given
+func (s *Server) purgeTasks(c *fiber.Ctx) error {
+	retention := s.archiveRetention
+	if retention <= 0 {
+		retention = defaultArchiveRetention
+	}
+	if raw := c.Query("older_than"); raw != "" {
+		olderThan, err := time.ParseDuration(raw)
+		if err != nil || olderThan < 0 {
+			return c.Status(400).JSON(ErrorResponse{Error: "Invalid older_than duration"})
+		}
+		retention = olderThan
+	}
+
+	before := time.Now().Add(-retention)
+	purged, err := database.NewTaskRepo(s.db).PurgeArchived(before)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	if purged > 0 {
+		log.Printf("Purged %d task(s) archived before %s", purged, before.Format(time.RFC3339))
+	}
+	return c.JSON(PurgeTasksResponse{Purged: purged, ArchivedBefore: before})
 }
 
 func (s *Server) getTaskSteps(c *fiber.Ctx) error {
@@ -484,6 +1388,102 @@ func (s *Server) getTaskSteps(c *fiber.Ctx) error {
 	return c.JSON(steps)
 }
 
+func (s *Server) getTaskOutputs(c *fiber.Ctx) error {
+	id := c.Params("id")
+	repo := database.NewTaskOutputRepo(s.db)
+
+	// Archived tasks keep their outputs until purged
+	if _, err := database.NewTaskRepo(s.db).GetIncludingArchived(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+
+	outputs, err := repo.GetByTaskID(id)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(outputs)
+}
+
+// TaskExecutionResponse is the stored execution record of the latest run of a task
+type TaskExecutionResponse struct {
+	ID        string          `json:"id"`
+	TaskID    string          `json:"task_id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Record    json.RawMessage `json:"record"`
+}
+
+func (s *Server) getTaskExecution(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	execution, err := database.NewTaskExecutionRepo(s.db).GetLatestByTaskID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "No execution record for task"})
+	}
+
+	return c.JSON(TaskExecutionResponse{
+		ID:        execution.ID,
+		TaskID:    execution.TaskID,
+		CreatedAt: execution.CreatedAt,
+		Record:    json.RawMessage(execution.Record),
+	})
+}
+
+// StepFailure summarizes a failed step without its full output
+type StepFailure struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Command     string     `json:"command"`
+	Status      string     `json:"status"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	StderrTail  string     `json:"stderr_tail,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func (s *Server) getTaskFailures(c *fiber.Ctx) error {
+	id := c.Params("id")
+	lines, _ := strconv.Atoi(c.Query("lines", "20"))
+
+	if _, err := database.NewTaskRepo(s.db).GetByID(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+
+	steps, err := database.NewTaskStepRepo(s.db).GetFailedByTaskID(id)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+
+	failures := make([]StepFailure, len(steps))
+	for i, step := range steps {
+		failures[i] = StepFailure{
+			ID:          step.ID,
+			Name:        step.Name,
+			Command:     step.Command,
+			Status:      step.Status,
+			ExitCode:    step.ExitCode,
+			StderrTail:  tailLines(step.Stderr, lines),
+			StartedAt:   step.StartedAt,
+			CompletedAt: step.CompletedAt,
+		}
+	}
+
+	return c.JSON(failures)
+}
+
+// tailLines returns the last n lines of s
+func tailLines(s string, n int) string {
+	s = strings.TrimRight(s, "\n")
+	if n <= 0 || s == "" {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (s *Server) tailTaskLog(c *fiber.Ctx) error {
 	id := c.Params("id")
 	offset, _ := strconv.Atoi(c.Query("offset", "0"))
@@ -494,8 +1494,11 @@ func (s *Server) tailTaskLog(c *fiber.Ctx) error {
 		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
 	}
 
-	// If task is completed or failed, return from database
+	// If task is completed or failed, return from database or log sink
 	if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusFailed || task.Status == models.TaskStatusCancelled {
+		if err := s.loadTaskLog(task); err != nil {
+			return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+		}
 		content := task.LogText
 		if offset > 0 && offset < len(content) {
 			content = content[offset:]
@@ -535,6 +1538,283 @@ func (s *Server) tailTaskLog(c *fiber.Ctx) error {
 	})
 }
 
+// sseKeepAliveInterval is how often an idle log stream sends a comment line,
+// which keeps proxies from closing it and detects disconnected clients
+const sseKeepAliveInterval = 15 * time.Second
+
+// streamTaskLog streams the log of a task as Server-Sent Events. Log content is
+// sent as "data:" events and a final "complete" event ends the stream.
+func (s *Server) streamTaskLog(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	if _, err := database.NewTaskRepo(s.db).GetByID(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// Subscribe before the log is read so lines written in between are not lost
+	client := s.wsHub.subscribeStream(id)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer s.wsHub.removeClient(client)
+		if err := s.writeTaskLogStream(w, id, client); err != nil {
+			log.Printf("Log stream for task %s ended: %v", id, err)
+		}
+	})
+	return nil
+}
+
+// writeTaskLogStream replays the log written so far, then forwards the log
+// broadcasts received by client until the task completes. Broadcasts carry
+// their offset in the log, so content already replayed is not sent twice.
+func (s *Server) writeTaskLogStream(w *bufio.Writer, taskID string, client *Client) error {
+	task, err := database.NewTaskRepo(s.db).GetByID(taskID)
+	if err != nil {
+		return err
+	}
+
+	if task.Status == models.TaskStatusCompleted || task.Status == models.TaskStatusFailed || task.Status == models.TaskStatusCancelled {
+		if err := s.loadTaskLog(task); err != nil {
+			return err
+		}
+		if err := writeSSE(w, "", task.LogText); err != nil {
+			return err
+		}
+		return writeSSE(w, "complete", taskID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.logDir, fmt.Sprintf("%s.log", taskID)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// A partly written last line follows in full with the broadcasts
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	if err := writeSSE(w, "", string(data)); err != nil {
+		return err
+	}
+	sent := int64(len(data))
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.send:
+			if !ok || msg.Type == "close" {
+				return nil
+			}
+			switch msg.Type {
+			case "log":
+				content := msg.Content
+				if replayed := sent - msg.Offset; replayed > 0 {
+					content = content[min(replayed, int64(len(content))):]
+				}
+				sent = max(sent, msg.Offset+int64(len(msg.Content)))
+				if err := writeSSE(w, "", content); err != nil {
+					return err
+				}
+			case "complete":
+				return writeSSE(w, "complete", taskID)
+			}
+
+		case <-keepAlive.C:
+			if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			// The client is still connected, so the hub must not close it as idle
+			client.mu.Lock()
+			client.lastActivity = time.Now()
+			client.mu.Unlock()
+		}
+	}
+}
+
+// writeSSE writes one event with a data line per line of content and flushes
+// it to the client. Empty content without an event name is skipped.
+func writeSSE(w *bufio.Writer, event, content string) error {
+	if event == "" && content == "" {
+		return nil
+	}
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	w.WriteString("\n")
+	return w.Flush()
+}
+
+// logExportPageSize is how many tasks are loaded at a time while streaming a log archive
+const logExportPageSize = 100
+
+// parseTimeFilter parses an RFC3339 timestamp or a YYYY-MM-DD date. A date used
+// as an upper bound covers the whole day.
+func parseTimeFilter(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or YYYY-MM-DD", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// WorkflowStatsResponse summarizes the files and tasks of a workflow
+type WorkflowStatsResponse struct {
+	WorkflowID         string         `json:"workflow_id"`
+	FilesIndexed       int            `json:"files_indexed"`
+	Tasks              map[string]int `json:"tasks"` // Task counts by status
+	Running            int            `json:"running"`
+	AvgDurationSeconds float64        `json:"avg_duration_seconds"` // Over completed tasks
+	LastScanAt         *time.Time     `json:"last_scan_at,omitempty"`
+}
+
+// getWorkflowStats returns per-workflow counts for the dashboard
+func (s *Server) getWorkflowStats(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := database.NewWorkflowRepo(s.db).GetByID(id); err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+
+	fileRepo := database.NewFileRepo(s.db)
+	taskRepo := database.NewTaskRepo(s.db)
+	stats := WorkflowStatsResponse{WorkflowID: id, Tasks: make(map[string]int)}
+
+	var err error
+	if stats.FilesIndexed, err = fileRepo.CountByWorkflow(id); err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	if stats.LastScanAt, err = fileRepo.LastScannedAt(id); err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	for _, status := range []string{
+		models.TaskStatusPending,
+		models.TaskStatusRunning,
+		models.TaskStatusCompleted,
+		models.TaskStatusFailed,
+		models.TaskStatusCancelled,
+	} {
+		count, err := taskRepo.Count(id, status)
+		if err != nil {
+			return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+		}
+		stats.Tasks[status] = count
+	}
+	avgDuration, _, err := taskRepo.AverageDuration(id)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	stats.AvgDurationSeconds = avgDuration.Seconds()
+	stats.Running = s.scheduler.GetWorkflowRunningCount(id)
+
+	return c.JSON(stats)
+}
+
+// downloadWorkflowLogs streams a ZIP of the task logs of a workflow.
+// Optional filters: status, since and until.
+func (s *Server) downloadWorkflowLogs(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	wf, err := database.NewWorkflowRepo(s.db).GetByID(id)
+	if err != nil {
+		return c.Status(404).JSON(ErrorResponse{Error: "Workflow not found"})
+	}
+
+	since, err := parseTimeFilter(c.Query("since"), false)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: err.Error()})
+	}
+	until, err := parseTimeFilter(c.Query("until"), true)
+	if err != nil {
+		return c.Status(400).JSON(ErrorResponse{Error: err.Error()})
+	}
+	status := c.Query("status")
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-logs.zip"`, wf.Name))
+
+	// The archive is written as tasks are read, so it is never held in memory
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := s.writeWorkflowLogsZip(w, id, status, since, until); err != nil {
+			log.Printf("Error streaming logs for workflow %s: %v", wf.Name, err)
+		}
+	})
+	return nil
+}
+
+// writeWorkflowLogsZip writes one <created>_<task id>.log entry per task that has a log
+func (s *Server) writeWorkflowLogsZip(w io.Writer, workflowID, status string, since, until time.Time) error {
+	repo := database.NewTaskRepo(s.db)
+	zw := zip.NewWriter(w)
+
+	for offset := 0; ; offset += logExportPageSize {
+		tasks, err := repo.ListCreatedBetween(workflowID, status, since, until, logExportPageSize, offset)
+		if err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			content, err := s.readTaskLog(task)
+			if err != nil {
+				log.Printf("Warning: Skipping log of task %s: %v", task.ID, err)
+				continue
+			}
+			if content == "" {
+				continue
+			}
+
+			entry, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     fmt.Sprintf("%s_%s.log", task.CreatedAt.Format("20060102-150405"), task.ID),
+				Method:   zip.Deflate,
+				Modified: task.UpdatedAt,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(entry, content); err != nil {
+				return err
+			}
+		}
+
+		if len(tasks) < logExportPageSize {
+			break
+		}
+	}
+
+	return zw.Close()
+}
+
+// readTaskLog returns the log of a task from the database, the log sink or,
+// for tasks that are still running, the log file
+func (s *Server) readTaskLog(task *models.Task) (string, error) {
+	if err := s.loadTaskLog(task); err != nil {
+		return "", err
+	}
+	if task.LogText != "" {
+		return task.LogText, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.logDir, fmt.Sprintf("%s.log", task.ID)))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
 // File handlers
 
 func (s *Server) listFiles(c *fiber.Ctx) error {
@@ -576,7 +1856,101 @@ func (s *Server) getSchedulerStats(c *fiber.Ctx) error {
 	return c.JSON(stats)
 }
 
+// getMetrics serves task counters, queue depth and executor usage in the
+// Prometheus text format
+func (s *Server) getMetrics(c *fiber.Ctx) error {
+	workflows, err := database.NewWorkflowRepo(s.db).List()
+	if err != nil {
+		return c.Status(500).SendString(err.Error())
+	}
+	pending, err := database.NewTaskRepo(s.db).CountByWorkflow(models.TaskStatusPending)
+	if err != nil {
+		return c.Status(500).SendString(err.Error())
+	}
+
+	var buf strings.Builder
+	metrics.Default.Write(&buf)
+
+	// Every workflow is listed so an emptied queue reads 0 instead of vanishing
+	samples := make([]metrics.Sample, len(workflows))
+	for i, wf := range workflows {
+		samples[i] = metrics.Sample{Labels: []string{"workflow", wf.Name}, Value: float64(pending[wf.ID])}
+	}
+	metrics.WriteGauge(&buf, "fileaction_tasks_pending", "Tasks waiting to run, by workflow.", samples...)
+
+	stats := s.scheduler.GetExecutorPoolStats()
+	busy, _ := stats["busy"].(int)
+	total, _ := stats["total"].(int)
+	draining, _ := stats["draining"].(bool)
+	metrics.WriteGauge(&buf, "fileaction_executors_busy", "Executors running a task.", metrics.Sample{Value: float64(busy)})
+	metrics.WriteGauge(&buf, "fileaction_executors_total", "Size of the executor pool.", metrics.Sample{Value: float64(total)})
+	drainingValue := 0.0
+	if draining {
+		drainingValue = 1
+	}
+	metrics.WriteGauge(&buf, "fileaction_scheduler_draining", "1 while the scheduler is draining.", metrics.Sample{Value: drainingValue})
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(buf.String())
+}
+
 func (s *Server) getExecutorStatus(c *fiber.Ctx) error {
-	status := s.scheduler.GetExecutorStatus()
+	busyOnly := c.Query("busy_only", "false") == "true"
+	limit, _ := strconv.Atoi(c.Query("limit", "0"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	status := s.scheduler.GetExecutorStatus(busyOnly, limit, offset)
 	return c.JSON(status)
 }
+
+// Admin handlers
+
+func (s *Server) drainScheduler(c *fiber.Ctx) error {
+	s.scheduler.Drain()
+	return c.JSON(SuccessResponse{
+		Message: "Scheduler is draining, no new tasks will be dispatched",
+		Data:    s.drainStatus(),
+	})
+}
+
+func (s *Server) getDrainStatus(c *fiber.Ctx) error {
+	return c.JSON(s.drainStatus())
+}
+
+func (s *Server) getDefaults(c *fiber.Ctx) error {
+	seeds, err := database.DefaultSeeds()
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(seeds)
+}
+
+func (s *Server) resetDefaults(c *fiber.Ctx) error {
+	overwrite := c.Query("overwrite", "false") == "true"
+
+	statuses, err := s.db.ResetDefaults(overwrite)
+	if err != nil {
+		return c.Status(500).JSON(ErrorResponse{Error: err.Error()})
+	}
+
+	// Restored workflows may be enabled and watching different paths now
+	if err := s.watcher.ReloadWorkflows(); err != nil {
+		log.Printf("Warning: Failed to reload workflows after reset: %v", err)
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Defaults reset",
+		Data:    statuses,
+	})
+}
+
+// drainStatus reports drain mode and whether all running tasks have finished
+func (s *Server) drainStatus() fiber.Map {
+	draining := s.scheduler.IsDraining()
+	running := s.scheduler.GetRunningCount()
+	return fiber.Map{
+		"draining": draining,
+		"running":  running,
+		"drained":  draining && running == 0,
+	}
+}
@@ -0,0 +1,732 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/metrics"
+	"github.com/andi/fileaction/backend/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupTestServer(t *testing.T) (*Server, *models.Workflow) {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	wf := &models.Workflow{
+		Name:        "test-workflow",
+		YAMLContent: "name: test-workflow\non:\n  paths: [./test]\nsteps:\n  - name: noop\n    run: \"true\"\n",
+	}
+	if err := database.NewWorkflowRepo(db).Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	return &Server{db: db, logDir: t.TempDir()}, wf
+}
+
+// createLoggedTask creates a task with the given status and stored log
+func createLoggedTask(t *testing.T, s *Server, workflowID, name, status, logText string) *models.Task {
+	repo := database.NewTaskRepo(s.db)
+	task := &models.Task{
+		WorkflowID: workflowID,
+		FileID:     "file-" + name,
+		InputPath:  "/in/" + name,
+		OutputPath: "/out/" + name,
+		Status:     status,
+	}
+	if err := repo.Create(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	task.LogText = logText
+	if err := repo.Update(task); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	return task
+}
+
+// readZipEntries returns the contents of a zip archive keyed by task ID
+func readZipEntries(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Invalid zip archive: %v", err)
+	}
+
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+
+		taskID := strings.TrimSuffix(f.Name[strings.Index(f.Name, "_")+1:], ".log")
+		entries[taskID] = string(content)
+	}
+	return entries
+}
+
+func TestWorkflowLogsZip(t *testing.T) {
+	s, wf := setupTestServer(t)
+
+	completed := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusCompleted, "completed log\n")
+	failed := createLoggedTask(t, s, wf.ID, "b", models.TaskStatusFailed, "failed log\n")
+	running := createLoggedTask(t, s, wf.ID, "c", models.TaskStatusRunning, "")
+	createLoggedTask(t, s, wf.ID, "d", models.TaskStatusPending, "")
+
+	// Running tasks are read from their log file
+	if err := os.WriteFile(filepath.Join(s.logDir, running.ID+".log"), []byte("running log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.writeWorkflowLogsZip(&buf, wf.ID, "", time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	entries := readZipEntries(t, buf.Bytes())
+
+	expected := map[string]string{
+		completed.ID: "completed log\n",
+		failed.ID:    "failed log\n",
+		running.ID:   "running log\n",
+	}
+	if len(entries) != len(expected) {
+		var names []string
+		for id := range entries {
+			names = append(names, id)
+		}
+		sort.Strings(names)
+		t.Fatalf("Expected %d entries, got %v", len(expected), names)
+	}
+	for id, content := range expected {
+		if entries[id] != content {
+			t.Errorf("Entry for task %s: expected %q, got %q", id, content, entries[id])
+		}
+	}
+
+	// Status and date filters
+	buf.Reset()
+	if err := s.writeWorkflowLogsZip(&buf, wf.ID, models.TaskStatusFailed, time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("Failed to write filtered zip: %v", err)
+	}
+	if entries := readZipEntries(t, buf.Bytes()); len(entries) != 1 || entries[failed.ID] != "failed log\n" {
+		t.Errorf("Expected only the failed task, got %v", entries)
+	}
+
+	buf.Reset()
+	if err := s.writeWorkflowLogsZip(&buf, wf.ID, "", time.Now().Add(time.Hour), time.Time{}); err != nil {
+		t.Fatalf("Failed to write filtered zip: %v", err)
+	}
+	if entries := readZipEntries(t, buf.Bytes()); len(entries) != 0 {
+		t.Errorf("Expected no tasks created in the future, got %d", len(entries))
+	}
+}
+
+func TestPanicReturnsJSONError(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Use(recoverMiddleware())
+	app.Use(jsonCharset)
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("boom")
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return c.Status(404).JSON(ErrorResponse{Error: "Task not found"})
+	})
+
+	tests := []struct {
+		path    string
+		status  int
+		message string
+	}{
+		{"/panic", 500, "Internal server error"},
+		{"/missing", 404, "Task not found"},
+		{"/no-such-route", 404, "Cannot GET /no-such-route"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != fiber.MIMEApplicationJSONCharsetUTF8 {
+				t.Errorf("Expected content type %q, got %q", fiber.MIMEApplicationJSONCharsetUTF8, ct)
+			}
+			var body ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Response is not JSON: %v", err)
+			}
+			if body.Error != tt.message {
+				t.Errorf("Expected error %q, got %q", tt.message, body.Error)
+			}
+		})
+	}
+}
+
+func TestRetryFromStepValidatesIndex(t *testing.T) {
+	s, wf := setupTestServer(t)
+	task := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusFailed, "")
+
+	// The test workflow has a single step that never ran for this task
+	if err := s.validateResumeStep(task, 2); err == nil || !strings.Contains(err.Error(), "between 1 and 1") {
+		t.Errorf("Expected out of range error, got %v", err)
+	}
+	if err := s.validateResumeStep(task, 0); err == nil {
+		t.Error("Expected error for step 0")
+	}
+	if err := s.validateResumeStep(task, 1); err != nil {
+		t.Errorf("Expected resuming from the first step to be allowed, got %v", err)
+	}
+}
+
+func TestRetryClearsCancellation(t *testing.T) {
+	s, wf := setupTestServer(t)
+	repo := database.NewTaskRepo(s.db)
+	task := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusPending, "")
+	if err := repo.MarkCancelled(task.ID, models.CancelReasonPendingTTL); err != nil {
+		t.Fatalf("Failed to cancel task: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/tasks/:id/retry", s.retryTask)
+	if resp, _ := app.Test(httptest.NewRequest("POST", "/tasks/"+task.ID+"/retry", nil)); resp.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	got, err := repo.GetByID(task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if got.Status != models.TaskStatusPending || got.CancelReason != "" {
+		t.Errorf("Expected a pending task without cancel reason, got %s (%q)", got.Status, got.CancelReason)
+	}
+}
+
+func TestRetryFromStepAfterPluginStep(t *testing.T) {
+	s, wf := setupTestServer(t)
+	wf.YAMLContent = "name: test-workflow\non:\n  paths: [./test]\nsteps:\n  - name: probe\n    uses: probe-plugin\n  - name: convert\n    run: \"true\"\n"
+	if err := database.NewWorkflowRepo(s.db).Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	task := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusFailed, "")
+
+	// A plugin step is recorded only through its plugin steps
+	stepRepo := database.NewTaskStepRepo(s.db)
+	for _, name := range []string{"probe / inspect", "probe / measure", "convert"} {
+		status := models.StepStatusCompleted
+		if name == "convert" {
+			status = models.StepStatusFailed
+		}
+		if err := stepRepo.Create(&models.TaskStep{TaskID: task.ID, Name: name, Status: status}); err != nil {
+			t.Fatalf("Failed to create step: %v", err)
+		}
+	}
+	if err := s.validateResumeStep(task, 2); err != nil {
+		t.Errorf("Expected resuming after the completed plugin step to be allowed, got %v", err)
+	}
+
+	if err := stepRepo.Create(&models.TaskStep{TaskID: task.ID, Name: "probe / measure", Status: models.StepStatusFailed}); err != nil {
+		t.Fatalf("Failed to create step: %v", err)
+	}
+	if err := s.validateResumeStep(task, 2); err == nil {
+		t.Error("Expected resuming after a failed plugin step to be rejected")
+	}
+}
+
+func TestTaskLogStream(t *testing.T) {
+	s, wf := setupTestServer(t)
+	s.wsHub = NewWebSocketHub()
+	defer s.wsHub.Stop()
+
+	// Completed tasks replay their stored log and close
+	completed := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusCompleted, "line 1\nline 2\n")
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	client := s.wsHub.subscribeStream(completed.ID)
+	if err := s.writeTaskLogStream(w, completed.ID, client); err != nil {
+		t.Fatalf("Failed to stream completed log: %v", err)
+	}
+	s.wsHub.removeClient(client)
+	want := "data: line 1\ndata: line 2\n\nevent: complete\ndata: " + completed.ID + "\n\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+
+	// Running tasks replay their log file, then follow the broadcasts. A line
+	// both in the file and in a broadcast still buffered by the hub, and the
+	// part of a line not yet fully written, are sent once.
+	running := createLoggedTask(t, s, wf.ID, "b", models.TaskStatusRunning, "")
+	if err := os.WriteFile(filepath.Join(s.logDir, running.ID+".log"), []byte("earlier\nlat"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+	s.wsHub.BroadcastLog(running.ID, "earlier\n")
+	buf.Reset()
+	client = s.wsHub.subscribeStream(running.ID)
+	defer s.wsHub.removeClient(client)
+	go func() {
+		s.wsHub.BroadcastLog(running.ID, "later\n")
+		s.wsHub.BroadcastTaskComplete(running.ID)
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- s.writeTaskLogStream(w, running.ID, client) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to stream running log: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream did not end after the task completed")
+	}
+	want = "data: earlier\n\ndata: later\n\nevent: complete\ndata: " + running.ID + "\n\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+// cancelRecorder is a Scheduler that records the tasks it is asked to cancel
+type cancelRecorder struct {
+	Scheduler
+	repo      *database.TaskRepo
+	cancelled []string
+}
+
+func (r *cancelRecorder) CancelTask(taskID, reason string) error {
+	r.cancelled = append(r.cancelled, taskID)
+	return r.repo.MarkCancelled(taskID, reason)
+}
+
+func TestCancelTaskBatch(t *testing.T) {
+	s, wf := setupTestServer(t)
+	repo := database.NewTaskRepo(s.db)
+	recorder := &cancelRecorder{repo: repo}
+	s.scheduler = recorder
+
+	pending := []*models.Task{
+		createLoggedTask(t, s, wf.ID, "a", models.TaskStatusPending, ""),
+		createLoggedTask(t, s, wf.ID, "b", models.TaskStatusPending, ""),
+	}
+	running := createLoggedTask(t, s, wf.ID, "c", models.TaskStatusRunning, "")
+	completed := createLoggedTask(t, s, wf.ID, "d", models.TaskStatusCompleted, "")
+	otherPending := createLoggedTask(t, s, "other-workflow", "e", models.TaskStatusPending, "")
+
+	app := fiber.New()
+	app.Post("/tasks/cancel-batch", s.cancelTaskBatch)
+	cancelBatch := func(body string) (int, CancelBatchResponse) {
+		req := httptest.NewRequest("POST", "/tasks/cancel-batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result CancelBatchResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	status, result := cancelBatch(`{"workflow_id": "` + wf.ID + `"}`)
+	if status != 200 || result.Pending != 2 || result.Running != 1 {
+		t.Fatalf("Expected 2 pending and 1 running cancelled, got %d %+v", status, result)
+	}
+	if len(recorder.cancelled) != 1 || recorder.cancelled[0] != running.ID {
+		t.Errorf("Expected the running task to go through the scheduler, got %v", recorder.cancelled)
+	}
+	for _, task := range pending {
+		if got, _ := repo.GetByID(task.ID); got.Status != models.TaskStatusCancelled || got.CancelReason != models.CancelReasonUser {
+			t.Errorf("Expected pending task to be cancelled by the user, got %s (%s)", got.Status, got.CancelReason)
+		}
+	}
+	if got, _ := repo.GetByID(completed.ID); got.Status != models.TaskStatusCompleted {
+		t.Errorf("Completed task changed to %s", got.Status)
+	}
+	if got, _ := repo.GetByID(otherPending.ID); got.Status != models.TaskStatusPending {
+		t.Errorf("Task of another workflow changed to %s", got.Status)
+	}
+
+	// Calling it again finds nothing left to cancel
+	if status, result := cancelBatch(`{"workflow_id": "` + wf.ID + `"}`); status != 200 || result.Pending != 0 || result.Running != 0 {
+		t.Errorf("Expected nothing to cancel, got %d %+v", status, result)
+	}
+
+	// Without a workflow, pending tasks of every workflow are cancelled
+	if status, result := cancelBatch(`{"status": "pending"}`); status != 200 || result.Pending != 1 {
+		t.Errorf("Expected the other workflow's task to be cancelled, got %d %+v", status, result)
+	}
+
+	for _, body := range []string{`{}`, `{"status": "completed"}`} {
+		if status, _ := cancelBatch(body); status != 400 {
+			t.Errorf("Expected 400 for %s, got %d", body, status)
+		}
+	}
+}
+
+func TestValidateWorkflow(t *testing.T) {
+	s, _ := setupTestServer(t)
+	if _, _, err := database.NewPluginRepo(s.db).CreatePlugin("resize", "", "name: resize\nversion: 1.0.0\nsteps:\n  - name: resize\n    run: \"true\"\n", "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/workflows/validate", s.validateWorkflow)
+	validate := func(yamlContent string) ValidateWorkflowResponse {
+		body, _ := json.Marshal(ValidateWorkflowRequest{YAMLContent: yamlContent})
+		req := httptest.NewRequest("POST", "/workflows/validate", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		var result ValidateWorkflowResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+
+	result := validate("name: bad name\nsteps:\n  - name: first\n    run: \"true\"\n  - name: second\n")
+	if result.Valid {
+		t.Fatal("Expected workflow to be invalid")
+	}
+	var fields []string
+	for _, e := range result.Errors {
+		fields = append(fields, e.Field)
+	}
+	if want := []string{"name", "on.paths", "steps[1].run"}; strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected errors for %v, got %+v", want, result.Errors)
+	}
+
+	result = validate("name: ok\non:\n  paths: [./in]\nsteps:\n  - name: a\n    uses: resize@1.0.0\n  - name: b\n    uses: resize@9\n  - name: c\n    uses: missing@1.0.0\n")
+	if !result.Valid || len(result.Errors) != 0 {
+		t.Fatalf("Expected workflow to be valid, got %+v", result.Errors)
+	}
+	warnings := strings.Join(result.Warnings, "\n")
+	if strings.Contains(warnings, "steps[0]") || !strings.Contains(warnings, "steps[1].uses") || !strings.Contains(warnings, "steps[2].uses") {
+		t.Errorf("Expected warnings for steps 1 and 2 only, got %v", result.Warnings)
+	}
+
+	if result := validate("name: [unclosed"); result.Valid || len(result.Errors) != 1 {
+		t.Errorf("Expected a single YAML error, got %+v", result)
+	}
+}
+
+func TestImportWorkflows(t *testing.T) {
+	s, existing := setupTestServer(t)
+	repo := database.NewWorkflowRepo(s.db)
+
+	app := fiber.New()
+	app.Post("/workflows/import", s.importWorkflows)
+	importYAML := func(yamlContent string) (int, ImportWorkflowsResponse) {
+		body, _ := json.Marshal(ImportWorkflowsRequest{YAMLContent: yamlContent, Enabled: true})
+		req := httptest.NewRequest("POST", "/workflows/import", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var result ImportWorkflowsResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	updated := "name: test-workflow\ndescription: updated\non:\n  paths: [./test]\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	added := "name: imported\non:\n  paths: [./in]\nsteps:\n  - name: noop\n    run: \"true\"\n"
+
+	status, result := importYAML(updated + "---\nname: also-new\non:\n  paths: [./in]\nsteps:\n  - name: noop\n---\n" + added)
+	if status != 400 || len(result.Results) != 3 || result.Results[1].Status != "invalid" || result.Results[0].Status != "skipped" {
+		t.Fatalf("Expected the invalid document to fail the import, got %d %+v", status, result)
+	}
+	if _, err := repo.GetByName("imported"); err == nil {
+		t.Error("Expected nothing to be imported after a validation failure")
+	}
+
+	status, result = importYAML(updated + "---\n" + added)
+	if status != 200 || len(result.Results) != 2 {
+		t.Fatalf("Expected the import to succeed, got %d %+v", status, result)
+	}
+	if result.Results[0].Status != "updated" || result.Results[0].Workflow.ID != existing.ID || result.Results[1].Status != "created" {
+		t.Errorf("Expected test-workflow updated and imported created, got %+v", result.Results)
+	}
+
+	wf, err := repo.GetByID(existing.ID)
+	if err != nil {
+		t.Fatalf("Failed to get workflow: %v", err)
+	}
+	if wf.Description != "updated" || wf.YAMLContent != updated || !wf.CreatedAt.Equal(existing.CreatedAt) {
+		t.Errorf("Expected the existing workflow updated in place, got %+v", wf)
+	}
+	imported, err := repo.GetByName("imported")
+	if err != nil {
+		t.Fatalf("Expected imported workflow to exist: %v", err)
+	}
+	if imported.YAMLContent != added || !imported.Enabled {
+		t.Errorf("Expected the new workflow to hold its own document and be enabled, got %+v", imported)
+	}
+}
+
+// runningCounter reports a fixed number of running tasks per workflow
+type runningCounter struct {
+	Scheduler
+	running map[string]int
+}
+
+func (r *runningCounter) GetWorkflowRunningCount(workflowID string) int {
+	return r.running[workflowID]
+}
+
+func TestWorkflowStats(t *testing.T) {
+	s, wf := setupTestServer(t)
+	s.scheduler = &runningCounter{running: map[string]int{wf.ID: 1}}
+
+	fileRepo := database.NewFileRepo(s.db)
+	lastScan := time.Now().Add(-time.Minute).Local()
+	for i, scannedAt := range []time.Time{lastScan.Add(-time.Hour), lastScan} {
+		file := &models.File{WorkflowID: wf.ID, FilePath: "/in/" + string(rune('a'+i)), LastScannedAt: scannedAt}
+		if err := fileRepo.Create(file); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	taskRepo := database.NewTaskRepo(s.db)
+	for i, seconds := range []int{10, 30} {
+		task := createLoggedTask(t, s, wf.ID, string(rune('a'+i)), models.TaskStatusCompleted, "")
+		completedAt := time.Now()
+		startedAt := completedAt.Add(-time.Duration(seconds) * time.Second)
+		task.StartedAt, task.CompletedAt = &startedAt, &completedAt
+		if err := taskRepo.Update(task); err != nil {
+			t.Fatalf("Failed to update task: %v", err)
+		}
+	}
+	createLoggedTask(t, s, wf.ID, "c", models.TaskStatusFailed, "")
+	createLoggedTask(t, s, wf.ID, "d", models.TaskStatusRunning, "")
+
+	app := fiber.New()
+	app.Get("/workflows/:id/stats", s.getWorkflowStats)
+	resp, err := app.Test(httptest.NewRequest("GET", "/workflows/"+wf.ID+"/stats", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats WorkflowStatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if stats.FilesIndexed != 2 {
+		t.Errorf("Expected 2 files indexed, got %d", stats.FilesIndexed)
+	}
+	if stats.Tasks[models.TaskStatusCompleted] != 2 || stats.Tasks[models.TaskStatusFailed] != 1 || stats.Tasks[models.TaskStatusRunning] != 1 || stats.Tasks[models.TaskStatusPending] != 0 {
+		t.Errorf("Unexpected task counts: %v", stats.Tasks)
+	}
+	if stats.AvgDurationSeconds < 19.9 || stats.AvgDurationSeconds > 20.1 {
+		t.Errorf("Expected an average duration of 20s, got %v", stats.AvgDurationSeconds)
+	}
+	if stats.LastScanAt == nil || !stats.LastScanAt.Equal(lastScan) {
+		t.Errorf("Expected last scan at %v, got %v", lastScan, stats.LastScanAt)
+	}
+	if stats.Running != 1 {
+		t.Errorf("Expected 1 running task from the scheduler, got %d", stats.Running)
+	}
+
+	if resp, _ := app.Test(httptest.NewRequest("GET", "/workflows/missing/stats", nil)); resp.StatusCode != 404 {
+		t.Errorf("Expected 404 for an unknown workflow, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := AuthConfig{Token: "s3cret", Username: "admin", Password: "pw"}
+	app := fiber.New()
+	app.Use("/api", authMiddleware(auth))
+	app.Get("/api/workflows", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("index") })
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{name: "no credentials", path: "/api/workflows", want: 401},
+		{name: "bearer token", path: "/api/workflows", header: "Bearer s3cret", want: 200},
+		{name: "wrong token", path: "/api/workflows", header: "Bearer s3cre", want: 401},
+		{name: "basic auth", path: "/api/workflows", header: "Basic YWRtaW46cHc=", want: 200},            // admin:pw
+		{name: "wrong password", path: "/api/workflows", header: "Basic YWRtaW46cHd4", want: 401},        // admin:pwx
+		{name: "token as password", path: "/api/workflows", header: "Basic YWRtaW46czNjcmV0", want: 401}, // admin:s3cret
+		{name: "open index", path: "/", want: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, resp.StatusCode)
+			}
+			if tt.want == 401 && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestRerunTaskKeepsOriginal(t *testing.T) {
+	s, wf := setupTestServer(t)
+	repo := database.NewTaskRepo(s.db)
+	original := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusFailed, "first run log")
+	pending := createLoggedTask(t, s, wf.ID, "b", models.TaskStatusPending, "")
+
+	app := fiber.New()
+	app.Post("/tasks/:id/rerun", s.rerunTask)
+
+	req := httptest.NewRequest("POST", "/tasks/"+original.ID+"/rerun", strings.NewReader(`{"env": {"DEBUG": "1"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected 201, got %d", resp.StatusCode)
+	}
+	var rerun models.Task
+	if err := json.NewDecoder(resp.Body).Decode(&rerun); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	stored, err := repo.GetByID(rerun.ID)
+	if err != nil {
+		t.Fatalf("Rerun task was not stored: %v", err)
+	}
+	if stored.ID == original.ID || stored.ParentTaskID != original.ID || stored.Status != models.TaskStatusPending {
+		t.Errorf("Unexpected rerun task: %+v", stored)
+	}
+	if stored.InputPath != original.InputPath || stored.OutputPath != original.OutputPath || stored.FileID != original.FileID {
+		t.Errorf("Expected rerun to target the same file and output, got %+v", stored)
+	}
+	if stored.Env["DEBUG"] != "1" {
+		t.Errorf("Expected env override on the rerun, got %v", stored.Env)
+	}
+
+	kept, _ := repo.GetByID(original.ID)
+	if kept.Status != models.TaskStatusFailed || kept.LogText != "first run log" {
+		t.Errorf("Expected the original task to be unchanged, got status %s and log %q", kept.Status, kept.LogText)
+	}
+
+	if resp, _ := app.Test(httptest.NewRequest("POST", "/tasks/"+pending.ID+"/rerun", nil)); resp.StatusCode != 409 {
+		t.Errorf("Expected 409 for a pending task, got %d", resp.StatusCode)
+	}
+	if resp, _ := app.Test(httptest.NewRequest("POST", "/tasks/"+original.ID+"/rerun", nil)); resp.StatusCode != 409 {
+		t.Errorf("Expected 409 while the first rerun is queued, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeleteTaskRejectsActiveTasks(t *testing.T) {
+	s, wf := setupTestServer(t)
+	done := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusCompleted, "")
+	running := createLoggedTask(t, s, wf.ID, "b", models.TaskStatusRunning, "")
+
+	app := fiber.New()
+	app.Delete("/tasks/:id", s.deleteTask)
+
+	if resp, _ := app.Test(httptest.NewRequest("DELETE", "/tasks/"+running.ID, nil)); resp.StatusCode != 409 {
+		t.Errorf("Expected 409 for a running task, got %d", resp.StatusCode)
+	}
+	if _, err := database.NewTaskRepo(s.db).GetByID(running.ID); err != nil {
+		t.Errorf("Expected the running task to stay live: %v", err)
+	}
+
+	if resp, _ := app.Test(httptest.NewRequest("DELETE", "/tasks/"+done.ID, nil)); resp.StatusCode != 200 {
+		t.Errorf("Expected 200 for a completed task, got %d", resp.StatusCode)
+	}
+	if _, err := database.NewTaskRepo(s.db).GetByID(done.ID); err == nil {
+		t.Error("Expected the completed task to be archived")
+	}
+}
+
+// statsScheduler reports fixed executor pool stats; other Scheduler methods are unused
+type statsScheduler struct {
+	Scheduler
+	stats map[string]interface{}
+}
+
+func (s statsScheduler) GetExecutorPoolStats() map[string]interface{} {
+	return s.stats
+}
+
+func TestTaskOutputsUnknownTask(t *testing.T) {
+	s, wf := setupTestServer(t)
+	task := createLoggedTask(t, s, wf.ID, "a", models.TaskStatusCompleted, "")
+
+	app := fiber.New()
+	app.Get("/tasks/:id/outputs", s.getTaskOutputs)
+
+	if resp, _ := app.Test(httptest.NewRequest("GET", "/tasks/"+task.ID+"/outputs", nil)); resp.StatusCode != 200 {
+		t.Errorf("Expected 200 for a task without outputs, got %d", resp.StatusCode)
+	}
+	if resp, _ := app.Test(httptest.NewRequest("GET", "/tasks/no-such-task/outputs", nil)); resp.StatusCode != 404 {
+		t.Errorf("Expected 404 for an unknown task, got %d", resp.StatusCode)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s, wf := setupTestServer(t)
+	s.scheduler = statsScheduler{stats: map[string]interface{}{"total": 4, "busy": 1, "available": 3, "draining": false}}
+	createLoggedTask(t, s, wf.ID, "a", models.TaskStatusPending, "")
+	createLoggedTask(t, s, wf.ID, "b", models.TaskStatusPending, "")
+	metrics.TasksCreated("metrics-test", 2)
+	metrics.TaskFinished("metrics-test", models.TaskStatusFailed, 3*time.Second)
+
+	app := fiber.New()
+	app.Get("/metrics", s.getMetrics)
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, line := range []string{
+		`fileaction_tasks_created_total{workflow="metrics-test"} 2`,
+		`fileaction_tasks_finished_total{workflow="metrics-test",status="failed"} 1`,
+		`fileaction_task_duration_seconds_bucket{workflow="metrics-test",le="1"} 0`,
+		`fileaction_task_duration_seconds_bucket{workflow="metrics-test",le="5"} 1`,
+		`fileaction_task_duration_seconds_count{workflow="metrics-test"} 1`,
+		`fileaction_tasks_pending{workflow="test-workflow"} 2`,
+		`fileaction_executors_busy 1`,
+		`fileaction_executors_total 4`,
+		"# TYPE fileaction_task_duration_seconds histogram",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
@@ -2,27 +2,49 @@ package api
 
 import (
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/andi/fileaction/backend/scheduler"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
 // ClientMessage represents a message from client to server
 type ClientMessage struct {
-	Action string `json:"action"` // "subscribe", "unsubscribe", "ping"
-	TaskID string `json:"task_id"`
+	Action string `json:"action"`  // "subscribe", "unsubscribe", "ping"
+	TaskID string `json:"task_id"` // "*" subscribes to scheduler events
+	Scope  string `json:"scope"`   // "scheduler" subscribes to scheduler events
 }
 
 // ServerMessage represents a message from server to client
 type ServerMessage struct {
-	Type    string `json:"type"` // "log", "complete", "error"
+	Type    string `json:"type"` // "log", "complete", "error", or a scheduler event type
 	TaskID  string `json:"task_id"`
 	Content string `json:"content"`
 	Time    string `json:"time"`
+	Offset  int64  `json:"offset,omitempty"` // Where a log message's content starts in the task log
+
+	// Scheduler events
+	WorkflowID string `json:"workflow_id,omitempty"`
+	Workflow   string `json:"workflow,omitempty"`
+	ExecutorID int    `json:"executor_id,omitempty"`
+	Status     string `json:"status,omitempty"`
 }
 
+// schedulerTopic is the subscription key of clients following scheduler events
+const schedulerTopic = "*"
+
+// Scheduler events are sent in batches at most once per interval, and at most
+// schedulerEventBurst per batch; the rest are dropped and counted in an
+// "events_dropped" message, so a burst of dispatches can't flood clients
+const (
+	defaultSchedulerEventInterval = 250 * time.Millisecond
+	schedulerEventBurst           = 12
+)
+
 // Client represents a connected WebSocket client
 type Client struct {
 	conn           *websocket.Conn
@@ -32,6 +54,20 @@ type Client struct {
 	mu             sync.Mutex
 }
 
+// Log lines of a task are coalesced into one "log" message per interval, or
+// sooner once the buffered content reaches the batch size
+const (
+	defaultLogFlushInterval = 100 * time.Millisecond
+	defaultLogBatchSize     = 32 * 1024
+)
+
+// logBuffer holds log content of a task that has not been sent yet
+type logBuffer struct {
+	content strings.Builder
+	offset  int64 // Where content starts in the task log
+	timer   *time.Timer
+}
+
 // WebSocketHub manages all WebSocket connections and broadcasts
 type WebSocketHub struct {
 	// Map of client ID to client
@@ -46,16 +82,36 @@ type WebSocketHub struct {
 
 	mu     sync.RWMutex
 	stopCh chan struct{}
+
+	// Pending log content and the length of the log broadcast so far, by task ID
+	logBuffers       map[string]*logBuffer
+	logOffsets       map[string]int64
+	logMu            sync.Mutex
+	logFlushInterval time.Duration
+	logBatchSize     int
+
+	// Scheduler events waiting for the next batch
+	schedulerEvents        []ServerMessage
+	schedulerDropped       int
+	schedulerTimer         *time.Timer
+	schedulerMu            sync.Mutex
+	schedulerEventInterval time.Duration
 }
 
 // NewWebSocketHub creates a new WebSocket hub
 func NewWebSocketHub() *WebSocketHub {
 	hub := &WebSocketHub{
-		clients:         make(map[*Client]bool),
-		taskSubscribers: make(map[string][]*Client),
-		register:        make(chan *Client, 16),
-		unregister:      make(chan *Client, 16),
-		stopCh:          make(chan struct{}),
+		clients:          make(map[*Client]bool),
+		taskSubscribers:  make(map[string][]*Client),
+		register:         make(chan *Client, 16),
+		unregister:       make(chan *Client, 16),
+		stopCh:           make(chan struct{}),
+		logBuffers:       make(map[string]*logBuffer),
+		logOffsets:       make(map[string]int64),
+		logFlushInterval: defaultLogFlushInterval,
+		logBatchSize:     defaultLogBatchSize,
+
+		schedulerEventInterval: defaultSchedulerEventInterval,
 	}
 
 	go hub.run()
@@ -139,6 +195,23 @@ func (h *WebSocketHub) subscribeClient(client *Client, taskID string) {
 		taskID, len(h.taskSubscribers[taskID]))
 }
 
+// subscribeStream registers a client that is not backed by a WebSocket
+// connection, such as a Server-Sent Events response, and subscribes it to a
+// task. It is released with removeClient.
+func (h *WebSocketHub) subscribeStream(taskID string) *Client {
+	client := &Client{
+		lastActivity: time.Now(),
+		send:         make(chan ServerMessage, 64),
+	}
+
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
+
+	h.subscribeClient(client, taskID)
+	return client
+}
+
 // sendToTaskSubscribers sends a message to all clients subscribed to the task
 func (h *WebSocketHub) sendToTaskSubscribers(taskID string, msg ServerMessage) {
 	h.mu.RLock()
@@ -164,19 +237,122 @@ func (h *WebSocketHub) sendToTaskSubscribers(taskID string, msg ServerMessage) {
 	}
 }
 
-// BroadcastLog broadcasts a log message to all clients watching a task
+// BroadcastLog queues log content for all clients watching a task. Content is
+// batched and sent by flushLogs.
 func (h *WebSocketHub) BroadcastLog(taskID, content string) {
-	msg := ServerMessage{
+	h.logMu.Lock()
+	defer h.logMu.Unlock()
+
+	buf, ok := h.logBuffers[taskID]
+	if !ok {
+		buf = &logBuffer{offset: h.logOffsets[taskID]}
+		buf.timer = time.AfterFunc(h.logFlushInterval, func() {
+			h.logMu.Lock()
+			defer h.logMu.Unlock()
+			// A size-triggered flush may already have replaced this buffer
+			if h.logBuffers[taskID] == buf {
+				h.flushLogsLocked(taskID)
+			}
+		})
+		h.logBuffers[taskID] = buf
+	}
+
+	buf.content.WriteString(content)
+	h.logOffsets[taskID] += int64(len(content))
+	if buf.content.Len() >= h.logBatchSize {
+		h.flushLogsLocked(taskID)
+	}
+}
+
+// flushLogs sends any buffered log content of a task immediately
+func (h *WebSocketHub) flushLogs(taskID string) {
+	h.logMu.Lock()
+	defer h.logMu.Unlock()
+	h.flushLogsLocked(taskID)
+}
+
+// flushLogsLocked sends buffered log content; the caller holds logMu, which
+// keeps batches of a task in order
+func (h *WebSocketHub) flushLogsLocked(taskID string) {
+	buf, ok := h.logBuffers[taskID]
+	if !ok {
+		return
+	}
+	delete(h.logBuffers, taskID)
+	buf.timer.Stop()
+
+	if buf.content.Len() == 0 {
+		return
+	}
+	h.sendToTaskSubscribers(taskID, ServerMessage{
 		Type:    "log",
 		TaskID:  taskID,
-		Content: content,
+		Content: buf.content.String(),
 		Time:    time.Now().Format(time.RFC3339),
+		Offset:  buf.offset,
+	})
+}
+
+// BroadcastSchedulerEvent queues a scheduler event for clients subscribed to
+// the scheduler. Events are sent by flushSchedulerEvents.
+func (h *WebSocketHub) BroadcastSchedulerEvent(event scheduler.Event) {
+	h.mu.RLock()
+	subscribed := len(h.taskSubscribers[schedulerTopic]) > 0
+	h.mu.RUnlock()
+	if !subscribed {
+		return
+	}
+
+	h.schedulerMu.Lock()
+	defer h.schedulerMu.Unlock()
+
+	if len(h.schedulerEvents) >= schedulerEventBurst {
+		h.schedulerDropped++
+	} else {
+		h.schedulerEvents = append(h.schedulerEvents, ServerMessage{
+			Type:       event.Type,
+			TaskID:     event.TaskID,
+			Time:       time.Now().Format(time.RFC3339),
+			WorkflowID: event.WorkflowID,
+			Workflow:   event.Workflow,
+			ExecutorID: event.ExecutorID,
+			Status:     event.Status,
+		})
+	}
+	if h.schedulerTimer == nil {
+		h.schedulerTimer = time.AfterFunc(h.schedulerEventInterval, h.flushSchedulerEvents)
+	}
+}
+
+// flushSchedulerEvents sends the queued scheduler events, reporting any that
+// were dropped
+func (h *WebSocketHub) flushSchedulerEvents() {
+	h.schedulerMu.Lock()
+	events, dropped := h.schedulerEvents, h.schedulerDropped
+	h.schedulerEvents, h.schedulerDropped, h.schedulerTimer = nil, 0, nil
+	h.schedulerMu.Unlock()
+
+	for _, msg := range events {
+		h.sendToTaskSubscribers(schedulerTopic, msg)
+	}
+	if dropped > 0 {
+		h.sendToTaskSubscribers(schedulerTopic, ServerMessage{
+			Type:    "events_dropped",
+			Content: strconv.Itoa(dropped),
+			Time:    time.Now().Format(time.RFC3339),
+		})
 	}
-	h.sendToTaskSubscribers(taskID, msg)
 }
 
 // BroadcastTaskComplete notifies clients that a task has completed
 func (h *WebSocketHub) BroadcastTaskComplete(taskID string) {
+	// Remaining log lines go out before the completion message. A retry
+	// writes a new log, so the offsets start over.
+	h.logMu.Lock()
+	h.flushLogsLocked(taskID)
+	delete(h.logOffsets, taskID)
+	h.logMu.Unlock()
+
 	msg := ServerMessage{
 		Type:   "complete",
 		TaskID: taskID,
@@ -263,6 +439,19 @@ func (h *WebSocketHub) checkIdleClients() {
 
 // Stop stops the WebSocket hub
 func (h *WebSocketHub) Stop() {
+	h.logMu.Lock()
+	for _, buf := range h.logBuffers {
+		buf.timer.Stop()
+	}
+	h.logBuffers = make(map[string]*logBuffer)
+	h.logMu.Unlock()
+
+	h.schedulerMu.Lock()
+	if h.schedulerTimer != nil {
+		h.schedulerTimer.Stop()
+	}
+	h.schedulerMu.Unlock()
+
 	close(h.stopCh)
 }
 
@@ -289,6 +478,10 @@ func (s *Server) HandleWebSocket(c *fiber.Ctx) error {
 
 		// Unregister when done
 		s.wsHub.unregister <- client
+	}, websocket.Config{
+		// Negotiate permessage-deflate; clients that don't offer it get
+		// uncompressed frames as before
+		EnableCompression: true,
 	})(c)
 }
 
@@ -310,6 +503,9 @@ func (c *Client) readPump(hub *WebSocketHub) {
 
 		switch msg.Action {
 		case "subscribe":
+			if msg.Scope == "scheduler" {
+				msg.TaskID = schedulerTopic
+			}
 			if msg.TaskID != "" {
 				hub.subscribeClient(c, msg.TaskID)
 
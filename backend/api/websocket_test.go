@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andi/fileaction/backend/scheduler"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestBroadcastLogBatchesLines(t *testing.T) {
+	hub := NewWebSocketHub()
+	defer hub.Stop()
+	hub.logBatchSize = 4 * 1024
+
+	client := &Client{lastActivity: time.Now(), send: make(chan ServerMessage, 16)}
+	hub.subscribeClient(client, "task-1")
+
+	var want strings.Builder
+	for i := 0; i < 1000; i++ {
+		line := fmt.Sprintf("[2024-01-01T00:00:00Z] output line %d\n", i)
+		want.WriteString(line)
+		hub.BroadcastLog("task-1", line)
+	}
+	hub.BroadcastTaskComplete("task-1")
+
+	var got strings.Builder
+	frames := 0
+	for {
+		select {
+		case msg := <-client.send:
+			if msg.Type == "complete" {
+				if got.String() != want.String() {
+					t.Fatalf("Batched content differs from the broadcast lines (got %d bytes, want %d)", got.Len(), want.Len())
+				}
+				// ~41KB of output at a 4KB batch size
+				if frames > 12 {
+					t.Errorf("Expected lines to be coalesced into few frames, got %d", frames)
+				}
+				return
+			}
+			if msg.Type != "log" || msg.TaskID != "task-1" {
+				t.Fatalf("Unexpected message %+v", msg)
+			}
+			frames++
+			got.WriteString(msg.Content)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for completion, got %d frames", frames)
+		}
+	}
+}
+
+func TestBroadcastLogFlushesAfterInterval(t *testing.T) {
+	hub := NewWebSocketHub()
+	defer hub.Stop()
+	hub.logFlushInterval = 20 * time.Millisecond
+
+	client := &Client{lastActivity: time.Now(), send: make(chan ServerMessage, 16)}
+	hub.subscribeClient(client, "task-1")
+
+	hub.BroadcastLog("task-1", "first\n")
+	hub.BroadcastLog("task-1", "second\n")
+
+	select {
+	case msg := <-client.send:
+		if msg.Content != "first\nsecond\n" {
+			t.Errorf("Expected both lines in one frame, got %q", msg.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Buffered lines were not flushed after the interval")
+	}
+}
+
+func TestWebSocketCompressionNegotiated(t *testing.T) {
+	hub := NewWebSocketHub()
+	defer hub.Stop()
+	s := &Server{wsHub: hub}
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", s.HandleWebSocket)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	dialer := fastws.Dialer{EnableCompression: true, HandshakeTimeout: 2 * time.Second}
+	conn, resp, err := dialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") {
+		t.Fatalf("Expected permessage-deflate to be negotiated, got %q", ext)
+	}
+
+	if err := conn.WriteJSON(ClientMessage{Action: "subscribe", TaskID: "task-1"}); err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ack ServerMessage
+	if err := conn.ReadJSON(&ack); err != nil || ack.Type != "subscribed" {
+		t.Fatalf("Expected subscription ack, got %+v (%v)", ack, err)
+	}
+
+	var want strings.Builder
+	for i := 0; i < 500; i++ {
+		line := fmt.Sprintf("[2024-01-01T00:00:00Z] output line %d\n", i)
+		want.WriteString(line)
+		hub.BroadcastLog("task-1", line)
+	}
+	hub.BroadcastTaskComplete("task-1")
+
+	var got strings.Builder
+	for {
+		var msg ServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if msg.Type == "complete" {
+			break
+		}
+		got.WriteString(msg.Content)
+	}
+	if got.String() != want.String() {
+		t.Errorf("Compressed log content differs (got %d bytes, want %d)", got.Len(), want.Len())
+	}
+}
+
+func TestSchedulerEventsAreRateLimited(t *testing.T) {
+	hub := NewWebSocketHub()
+	defer hub.Stop()
+	hub.schedulerEventInterval = 20 * time.Millisecond
+
+	// Without subscribers events are not even queued
+	hub.BroadcastSchedulerEvent(scheduler.Event{Type: scheduler.EventExecutorBusy, ExecutorID: 1})
+
+	client := &Client{lastActivity: time.Now(), send: make(chan ServerMessage, 64)}
+	hub.subscribeClient(client, schedulerTopic)
+	taskClient := &Client{lastActivity: time.Now(), send: make(chan ServerMessage, 64)}
+	hub.subscribeClient(taskClient, "task-1")
+
+	total := schedulerEventBurst + 8
+	for i := 0; i < total; i++ {
+		hub.BroadcastSchedulerEvent(scheduler.Event{
+			Type:       scheduler.EventTaskStarted,
+			TaskID:     fmt.Sprintf("task-%d", i),
+			Workflow:   "images",
+			ExecutorID: 2,
+		})
+	}
+
+	for i := 0; i < schedulerEventBurst; i++ {
+		select {
+		case msg := <-client.send:
+			if msg.Type != scheduler.EventTaskStarted || msg.TaskID != fmt.Sprintf("task-%d", i) || msg.Workflow != "images" || msg.ExecutorID != 2 {
+				t.Fatalf("Unexpected message %d: %+v", i, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+	select {
+	case msg := <-client.send:
+		if msg.Type != "events_dropped" || msg.Content != "8" {
+			t.Fatalf("Expected 8 dropped events to be reported, got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the dropped events report")
+	}
+
+	if len(taskClient.send) != 0 {
+		t.Errorf("Expected task subscribers not to receive scheduler events, got %d", len(taskClient.send))
+	}
+}
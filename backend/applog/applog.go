@@ -0,0 +1,108 @@
+package applog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Application log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Setup directs the application log to w. The text format keeps the standard
+// log package's lines; the json format writes one object per line with level,
+// ts and msg plus contextual fields such as task_id. Lines from the standard
+// log package are logged at info, or at warn/error when their message starts
+// with "Warning", "Error" or "Failed". An unknown level logs a warning and
+// falls back to info; an unknown format is an error.
+func Setup(w io.Writer, format, level string) error {
+	minLevel, levelErr := parseLevel(level)
+
+	switch format {
+	case "", FormatText:
+		log.SetOutput(w)
+		slog.SetLogLoggerLevel(minLevel)
+	case FormatJSON:
+		handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: minLevel,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					a.Key = "ts"
+				}
+				return a
+			},
+		})
+		slog.SetDefault(slog.New(&stdLevelHandler{Handler: handler}))
+	default:
+		return fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+
+	if levelErr != nil {
+		log.Printf("Warning: %v, logging at info", levelErr)
+	}
+	return nil
+}
+
+// parseLevel maps the logging.level setting to a slog level, or to info with
+// an error if it is unknown
+func parseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", level)
+}
+
+// stdLevelHandler raises info records whose message reads as a warning or
+// error, since everything logged through the standard log package arrives at
+// info
+type stdLevelHandler struct {
+	slog.Handler
+}
+
+func (h *stdLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// Info is let through so that promoted records are not dropped early
+	return level == slog.LevelInfo || h.Handler.Enabled(ctx, level)
+}
+
+func (h *stdLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelInfo {
+		r.Level = messageLevel(r.Message)
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *stdLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &stdLevelHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *stdLevelHandler) WithGroup(name string) slog.Handler {
+	return &stdLevelHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// messageLevel infers the level of an info message from its first word
+func messageLevel(msg string) slog.Level {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.HasPrefix(lower, "warning"):
+		return slog.LevelWarn
+	case strings.HasPrefix(lower, "error"), strings.HasPrefix(lower, "failed"):
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
@@ -0,0 +1,97 @@
+package applog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// restoreLogging puts the standard and slog loggers back after Setup changed them
+func restoreLogging(t *testing.T) {
+	t.Helper()
+	writer, flags, logger := log.Writer(), log.Flags(), slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected slog.Level
+		valid    bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"", slog.LevelInfo, true},
+		{"INFO", slog.LevelInfo, true},
+		{"warn", slog.LevelWarn, true},
+		{"warning", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"verbose", slog.LevelInfo, false},
+	}
+
+	for _, tt := range tests {
+		level, err := parseLevel(tt.level)
+		if level != tt.expected || (err == nil) != tt.valid {
+			t.Errorf("parseLevel(%q) = %v, %v; expected %v (valid %v)", tt.level, level, err, tt.expected, tt.valid)
+		}
+	}
+}
+
+func TestSetupJSON(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := Setup(&buf, FormatJSON, "warn"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	log.Println("Scan completed")
+	log.Println("Warning: disk almost full")
+	slog.Debug("hidden")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected only the warning to be logged, got %q", buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", lines[0], err)
+	}
+	if record["level"] != "WARN" || record["msg"] != "Warning: disk almost full" || record["ts"] == nil {
+		t.Errorf("Unexpected record %v", record)
+	}
+}
+
+func TestSetupText(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := Setup(&buf, "", "info"); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	log.Println("Scan completed")
+	if !strings.Contains(buf.String(), "Scan completed") || strings.HasPrefix(buf.String(), "{") {
+		t.Errorf("Expected a plain text line, got %q", buf.String())
+	}
+}
+
+func TestSetupUnknownSettings(t *testing.T) {
+	restoreLogging(t)
+	var buf bytes.Buffer
+	if err := Setup(&buf, FormatText, "verbose"); err != nil {
+		t.Fatalf("Expected an unknown level to fall back to info, got %v", err)
+	}
+	if !strings.Contains(buf.String(), `Warning: unknown log level "verbose"`) {
+		t.Errorf("Expected a warning about the level, got %q", buf.String())
+	}
+
+	if err := Setup(&buf, "xml", "info"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
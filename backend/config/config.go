@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -18,13 +19,39 @@ type Config struct {
 	} `yaml:"server"`
 
 	Database struct {
-		Path string `yaml:"path"`
+		Path       string `yaml:"path"`
+		IDFormat   string `yaml:"id_format"`   // "uuid" (default) or "sortable" for time-ordered task/file IDs
+		MaxRetries int    `yaml:"max_retries"` // Retries of task writes on lock conflicts
+
+		// How long deleted (archived) tasks are kept before POST /api/tasks/purge removes them
+		ArchiveRetention time.Duration `yaml:"archive_retention"`
 	} `yaml:"database"`
 
 	Logging struct {
 		Dir    string `yaml:"dir"`
 		AppLog string `yaml:"app_log"`
 		Level  string `yaml:"level"`
+		Format string `yaml:"format"` // "text" (default) or "json", one object per line
+
+		// Cap on each task's stored log and step output; the middle of longer
+		// content is dropped. Negative disables the cap.
+		MaxLogBytes int64 `yaml:"max_log_bytes"`
+
+		// Sink selects where completed task logs are stored.
+		// Empty keeps logs in the database (default).
+		Sink struct {
+			Type string `yaml:"type"` // "", "filesystem" or "s3"
+			Dir  string `yaml:"dir"`  // filesystem sink directory
+
+			S3 struct {
+				Endpoint  string `yaml:"endpoint"`
+				Region    string `yaml:"region"`
+				Bucket    string `yaml:"bucket"`
+				Prefix    string `yaml:"prefix"`
+				AccessKey string `yaml:"access_key"`
+				SecretKey string `yaml:"secret_key"`
+			} `yaml:"s3"`
+		} `yaml:"sink"`
 	} `yaml:"logging"`
 
 	Execution struct {
@@ -32,6 +59,9 @@ type Config struct {
 		MaxConcurrency     int           `yaml:"max_concurrency"`
 		TaskTimeout        time.Duration `yaml:"task_timeout"`
 		StepTimeout        time.Duration `yaml:"step_timeout"`
+		AllowedRoots       []string      `yaml:"allowed_roots"`   // Step commands referencing other absolute paths are audited
+		PathAudit          string        `yaml:"path_audit"`      // "warn" (default) or "fail"
+		MaxChainDepth      int           `yaml:"max_chain_depth"` // Limit on trigger_workflow hops from a watched file
 	} `yaml:"execution"`
 
 	Polling struct {
@@ -41,11 +71,61 @@ type Config struct {
 	Scheduler struct {
 		MaxRunning   int           `yaml:"max_running"`
 		ScanInterval time.Duration `yaml:"scan_interval"`
+
+		// Finished tasks older than this many days are archived by a background
+		// janitor every cleanup_interval; 0 keeps them forever
+		RetentionDays   int           `yaml:"retention_days"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval"`
 	} `yaml:"scheduler"`
 
 	Watcher struct {
-		MaxPendingTasks int `yaml:"max_pending_tasks"`
+		MaxPendingTasks int           `yaml:"max_pending_tasks"`
+		BatchWindow     time.Duration `yaml:"batch_window"` // 0 processes each file on its own
+		ScanConcurrency int           `yaml:"scan_concurrency"`
+		OpenRetries     int           `yaml:"open_retries"`     // Retries for opening a locked file for hashing
+		HashAlgorithm   string        `yaml:"hash_algorithm"`   // md5, sha1 or sha256
+		StabilityWindow time.Duration `yaml:"stability_window"` // How long a changed file must stay unchanged before it is processed (0 disables)
+		StabilityChecks int           `yaml:"stability_checks"` // Polls of the file's size and mtime within the window
 	} `yaml:"watcher"`
+
+	// Notifications posts finished tasks to a webhook, e.g. a Slack or Teams
+	// incoming webhook
+	Notifications struct {
+		WebhookURL string   `yaml:"webhook_url"` // Empty disables notifications
+		On         []string `yaml:"on"`          // Task statuses to report: completed, failed, cancelled (default failed)
+	} `yaml:"notifications"`
+
+	// Security enables authentication of /api requests when a token or basic
+	// auth credentials are set. Either one is accepted when both are.
+	Security struct {
+		Token     string `yaml:"token"` // Sent as "Authorization: Bearer <token>"
+		BasicAuth struct {
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		} `yaml:"basic_auth"`
+		ProtectUI bool `yaml:"protect_ui"` // Also protect the index page and static files
+	} `yaml:"security"`
+}
+
+// redacted replaces secret values in Redacted
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with credentials replaced,
+// for logging
+func (c *Config) Redacted() *Config {
+	cfg := *c
+	redact(&cfg.Security.Token)
+	redact(&cfg.Security.BasicAuth.Password)
+	redact(&cfg.Logging.Sink.S3.SecretKey)
+	redact(&cfg.Notifications.WebhookURL) // Webhook URLs embed their access token
+	return &cfg
+}
+
+// redact replaces a set value
+func redact(value *string) {
+	if *value != "" {
+		*value = redacted
+	}
 }
 
 // Load loads configuration from a YAML file
@@ -56,6 +136,11 @@ func Load(path string) (*Config, error) {
 	}
 
 	var cfg Config
+	// Defaults for which an explicit 0 means something else are set before
+	// decoding, so only a missing key gets them
+	cfg.Watcher.MaxPendingTasks = 50 // 0 means no limit
+	cfg.Database.MaxRetries = 3      // 0 disables retries
+	cfg.Watcher.OpenRetries = 3      // 0 disables retries
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
@@ -70,12 +155,21 @@ func Load(path string) (*Config, error) {
 	if cfg.Database.Path == "" {
 		cfg.Database.Path = "./data/fileaction.db"
 	}
+	if cfg.Database.IDFormat == "" {
+		cfg.Database.IDFormat = "uuid"
+	}
 	if cfg.Logging.Dir == "" {
 		cfg.Logging.Dir = "./data/logs"
 	}
 	if cfg.Logging.AppLog == "" {
 		cfg.Logging.AppLog = "./data/logs/app.log"
 	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = "text"
+	}
+	if cfg.Logging.Sink.Type == "filesystem" && cfg.Logging.Sink.Dir == "" {
+		cfg.Logging.Sink.Dir = cfg.Logging.Dir + "/tasks"
+	}
 	if cfg.Execution.DefaultConcurrency == 0 {
 		cfg.Execution.DefaultConcurrency = 4
 	}
@@ -88,14 +182,29 @@ func Load(path string) (*Config, error) {
 	if cfg.Execution.StepTimeout == 0 {
 		cfg.Execution.StepTimeout = 1800 * time.Second
 	}
+	if cfg.Execution.MaxChainDepth == 0 {
+		cfg.Execution.MaxChainDepth = 5
+	}
 	if cfg.Polling.Interval == 0 {
 		cfg.Polling.Interval = 2 * time.Second
 	}
 	if cfg.Scheduler.ScanInterval == 0 {
 		cfg.Scheduler.ScanInterval = 2 * time.Second
 	}
-	if cfg.Watcher.MaxPendingTasks == 0 {
-		cfg.Watcher.MaxPendingTasks = 50 // Default to 50, 0 means no limit after override
+	if cfg.Scheduler.CleanupInterval == 0 {
+		cfg.Scheduler.CleanupInterval = time.Hour
+	}
+	if cfg.Watcher.ScanConcurrency <= 0 {
+		cfg.Watcher.ScanConcurrency = 2
+	}
+	if cfg.Logging.MaxLogBytes == 0 {
+		cfg.Logging.MaxLogBytes = 10 << 20 // 10MB
+	}
+	if cfg.Database.ArchiveRetention == 0 {
+		cfg.Database.ArchiveRetention = 30 * 24 * time.Hour
+	}
+	if cfg.Watcher.HashAlgorithm == "" {
+		cfg.Watcher.HashAlgorithm = "md5"
 	}
 
 	return &cfg, nil
@@ -112,20 +221,80 @@ func LoadFromEnv(path string) (*Config, error) {
 	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
 		cfg.Database.Path = dbPath
 	}
+	if idFormat := os.Getenv("DB_ID_FORMAT"); idFormat != "" {
+		cfg.Database.IDFormat = idFormat
+	}
+	if maxRetries := os.Getenv("DB_MAX_RETRIES"); maxRetries != "" {
+		if val, err := strconv.Atoi(maxRetries); err == nil && val >= 0 {
+			cfg.Database.MaxRetries = val // 0 disables retries
+		}
+	}
 	if logDir := os.Getenv("LOG_DIR"); logDir != "" {
 		cfg.Logging.Dir = logDir
 		cfg.Logging.AppLog = logDir + "/app.log"
 	}
+	if logFormat := os.Getenv("LOG_FORMAT"); logFormat != "" {
+		cfg.Logging.Format = logFormat
+	}
+	if accessKey := os.Getenv("LOG_SINK_S3_ACCESS_KEY"); accessKey != "" {
+		cfg.Logging.Sink.S3.AccessKey = accessKey
+	}
+	if secretKey := os.Getenv("LOG_SINK_S3_SECRET_KEY"); secretKey != "" {
+		cfg.Logging.Sink.S3.SecretKey = secretKey
+	}
 	if maxRunning := os.Getenv("MAX_RUNNING"); maxRunning != "" {
 		if val, err := strconv.Atoi(maxRunning); err == nil && val > 0 {
 			cfg.Execution.DefaultConcurrency = val
 		}
 	}
+	if retentionDays := os.Getenv("RETENTION_DAYS"); retentionDays != "" {
+		if val, err := strconv.Atoi(retentionDays); err == nil && val >= 0 {
+			cfg.Scheduler.RetentionDays = val // 0 disables the janitor
+		}
+	}
 	if maxPending := os.Getenv("MAX_PENDING_TASKS"); maxPending != "" {
 		if val, err := strconv.Atoi(maxPending); err == nil && val >= 0 {
 			cfg.Watcher.MaxPendingTasks = val // 0 means no limit
 		}
 	}
+	if scanConcurrency := os.Getenv("SCAN_CONCURRENCY"); scanConcurrency != "" {
+		if val, err := strconv.Atoi(scanConcurrency); err == nil && val > 0 {
+			cfg.Watcher.ScanConcurrency = val
+		}
+	}
+	if openRetries := os.Getenv("OPEN_RETRIES"); openRetries != "" {
+		if val, err := strconv.Atoi(openRetries); err == nil && val >= 0 {
+			cfg.Watcher.OpenRetries = val // 0 disables retries
+		}
+	}
+	if maxLogBytes := os.Getenv("MAX_LOG_BYTES"); maxLogBytes != "" {
+		if val, err := strconv.ParseInt(maxLogBytes, 10, 64); err == nil && val != 0 {
+			cfg.Logging.MaxLogBytes = val // negative disables the cap
+		}
+	}
+	if maxChainDepth := os.Getenv("MAX_CHAIN_DEPTH"); maxChainDepth != "" {
+		if val, err := strconv.Atoi(maxChainDepth); err == nil && val > 0 {
+			cfg.Execution.MaxChainDepth = val
+		}
+	}
+	if hashAlgorithm := os.Getenv("HASH_ALGORITHM"); hashAlgorithm != "" {
+		cfg.Watcher.HashAlgorithm = hashAlgorithm
+	}
+	if webhookURL := os.Getenv("NOTIFY_WEBHOOK_URL"); webhookURL != "" {
+		cfg.Notifications.WebhookURL = webhookURL
+	}
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		cfg.Security.Token = token
+	}
+	if password := os.Getenv("API_BASIC_AUTH_PASSWORD"); password != "" {
+		cfg.Security.BasicAuth.Password = password
+	}
+
+	// A username without a password would accept any request naming it
+	basicAuth := cfg.Security.BasicAuth
+	if (basicAuth.Username == "") != (basicAuth.Password == "") {
+		return nil, fmt.Errorf("security.basic_auth needs both a username and a password")
+	}
 
 	return cfg, nil
 }
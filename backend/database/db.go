@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/andi/fileaction/backend/models"
+	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
@@ -20,10 +23,90 @@ var defaultWorkflowYAML string
 //go:embed default-plugins/jpeg-to-heic-converter.yaml
 var defaultPluginJpegToHeic string
 
+// defaultWorkflowID is the fixed ID of the seeded default workflow
+const defaultWorkflowID = "default-jpeg-to-heic"
+
+// ID formats for task and file records
+const (
+	IDFormatUUID     = "uuid"     // Random UUIDv4 (default)
+	IDFormatSortable = "sortable" // Time-ordered UUIDv7; inserts append to the primary key index
+)
+
+// MaxPathLength is the size of the path columns in characters
+const MaxPathLength = 1024
+
+// CheckPathLength returns a descriptive error for a path that does not fit the
+// path columns, instead of leaving it to the database to truncate or reject
+func CheckPathLength(kind, path string) error {
+	n := utf8.RuneCountInString(path)
+	if n <= MaxPathLength {
+		return nil
+	}
+	prefix := []rune(path)[:80]
+	return fmt.Errorf("%s path is %d characters, the limit is %d: %s...", kind, n, MaxPathLength, string(prefix))
+}
+
+// checkTaskPaths checks the paths of a task before it is stored
+func checkTaskPaths(task *models.Task) error {
+	if err := CheckPathLength("input", task.InputPath); err != nil {
+		return err
+	}
+	return CheckPathLength("output", task.OutputPath)
+}
+
 // DB wraps the GORM database connection
 type DB struct {
-	conn   *gorm.DB
-	dbType string // "mysql" or "sqlite"
+	conn         *gorm.DB
+	dbType       string // "mysql" or "sqlite"
+	idFormat     string
+	maxRetries   int           // Retries of hot-path writes on transient errors
+	retryBackoff time.Duration // Delay before the first retry, doubled after each attempt
+}
+
+// millisBetween returns an SQL expression for the milliseconds from the
+// timestamp column start to end
+func (db *DB) millisBetween(start, end string) string {
+	if db.dbType == "mysql" {
+		return fmt.Sprintf("TIMESTAMPDIFF(MICROSECOND, %s, %s) / 1000", start, end)
+	}
+	return fmt.Sprintf("(%s - %s) * 1000", sqliteUnixSeconds(end), sqliteUnixSeconds(start))
+}
+
+// sqliteUnixSeconds returns an SQL expression for the Unix time in seconds of
+// a SQLite timestamp column. The driver stores times in Go's default format,
+// "2006-01-02 15:04:05.999999999 -0700 MST", which SQLite's date functions
+// only read up to the seconds, so the fraction and zone offset are added here.
+func sqliteUnixSeconds(column string) string {
+	rest := fmt.Sprintf("substr(%s, 20)", column) // Fraction, zone and monotonic clock reading
+	space := fmt.Sprintf("instr(%s, ' ')", rest)
+	fraction := fmt.Sprintf("(CASE WHEN substr(%s, 1, 1) = '.' THEN CAST('0' || substr(%s, 1, %s - 1) AS REAL) ELSE 0 END)", rest, rest, space)
+	offset := fmt.Sprintf("substr(%s, %s + 1, 5)", rest, space)
+	offsetSeconds := fmt.Sprintf("(CASE substr(%s, 1, 1) WHEN '-' THEN -1 ELSE 1 END) * (CAST(substr(%s, 2, 2) AS INTEGER) * 3600 + CAST(substr(%s, 4, 2) AS INTEGER) * 60)", offset, offset, offset)
+	return fmt.Sprintf("((julianday(substr(%s, 1, 19)) - 2440587.5) * 86400 + %s - %s)", column, fraction, offsetSeconds)
+}
+
+// SetIDFormat selects how IDs of new tasks and files are generated
+func (db *DB) SetIDFormat(format string) error {
+	switch format {
+	case "", IDFormatUUID:
+		db.idFormat = IDFormatUUID
+	case IDFormatSortable:
+		db.idFormat = IDFormatSortable
+	default:
+		return fmt.Errorf("unknown id format %q", format)
+	}
+	return nil
+}
+
+// newID generates an ID for a task or file record in the configured format
+func (db *DB) newID() string {
+	if db.idFormat == IDFormatSortable {
+		// UUIDv7 is monotonic within the process, so IDs sort by creation time
+		if id, err := uuid.NewV7(); err == nil {
+			return id.String()
+		}
+	}
+	return uuid.New().String()
 }
 
 // New creates a new database connection and initializes schema
@@ -78,8 +161,11 @@ func New(dsn string) (*DB, error) {
 	}
 
 	db := &DB{
-		conn:   gormDB,
-		dbType: dbType,
+		conn:         gormDB,
+		dbType:       dbType,
+		idFormat:     IDFormatUUID,
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: 50 * time.Millisecond,
 	}
 
 	// Initialize schema
@@ -122,6 +208,8 @@ func (db *DB) initSchema() error {
 		&FileModel{},
 		&TaskModel{},
 		&TaskStepModel{},
+		&TaskExecutionModel{},
+		&TaskOutputModel{},
 		&PluginModel{},
 		&PluginVersionModel{},
 	)
@@ -152,7 +240,7 @@ func (db *DB) initDefaultWorkflows() error {
 
 	// Create default workflow
 	workflow := &WorkflowModel{
-		ID:          "default-jpeg-to-heic",
+		ID:          defaultWorkflowID,
 		Name:        workflowData.Name,
 		Description: workflowData.Description,
 		YAMLContent: defaultWorkflowYAML,
@@ -216,13 +304,15 @@ func (db *DB) initDefaultPlugins() error {
 
 // GORM Models
 type WorkflowModel struct {
-	ID          string    `gorm:"primaryKey;type:varchar(36)"`
-	Name        string    `gorm:"uniqueIndex;type:varchar(255);not null"`
-	Description string    `gorm:"type:text"`
-	YAMLContent string    `gorm:"type:text;not null"`
-	Enabled     bool      `gorm:"default:true;index"`
-	CreatedAt   time.Time `gorm:"autoCreateTime"`
-	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	ID          string     `gorm:"primaryKey;type:varchar(36)"`
+	Name        string     `gorm:"uniqueIndex;type:varchar(255);not null"`
+	Description string     `gorm:"type:text"`
+	YAMLContent string     `gorm:"type:text;not null"`
+	Enabled     bool       `gorm:"default:true;index"`
+	Paused      bool       `gorm:"default:false"`
+	BaselineAt  *time.Time // Set once by MarkBaseline
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `gorm:"autoUpdateTime"`
 }
 
 func (WorkflowModel) TableName() string {
@@ -233,8 +323,11 @@ type FileModel struct {
 	ID            string    `gorm:"primaryKey;type:varchar(36)"`
 	WorkflowID    string    `gorm:"type:varchar(36);not null;index"`
 	FilePath      string    `gorm:"type:varchar(1024);not null"`
-	FileMD5       string    `gorm:"type:varchar(32);not null;index"`
+	FileMD5       string    `gorm:"type:varchar(64);not null;index"` // Hex digest under the watcher's hash algorithm
 	FileSize      int64     `gorm:"not null"`
+	ModTime       int64     `gorm:"column:mtime;default:0"` // Unix nanoseconds, exact on every database
+	Stale         bool      `gorm:"default:false"`
+	Deferred      bool      `gorm:"default:false"`
 	LastScannedAt time.Time `gorm:"autoCreateTime"`
 	CreatedAt     time.Time `gorm:"autoCreateTime"`
 	UpdatedAt     time.Time `gorm:"autoUpdateTime"`
@@ -245,18 +338,30 @@ func (FileModel) TableName() string {
 }
 
 type TaskModel struct {
-	ID           string     `gorm:"primaryKey;type:varchar(36)"`
-	WorkflowID   string     `gorm:"type:varchar(36);not null;index"`
-	FileID       string     `gorm:"type:varchar(36);not null;index"`
-	InputPath    string     `gorm:"type:varchar(1024);not null"`
-	OutputPath   string     `gorm:"type:varchar(1024)"`
-	Status       string     `gorm:"type:varchar(20);not null;default:'pending';index"`
-	LogText      string     `gorm:"type:text"`
-	ErrorMessage string     `gorm:"type:text"`
-	StartedAt    *time.Time `gorm:"index"`
+	ID           string            `gorm:"primaryKey;type:varchar(36)"`
+	WorkflowID   string            `gorm:"type:varchar(36);not null;index;index:idx_tasks_workflow_input_status,priority:1"`
+	FileID       string            `gorm:"type:varchar(36);not null;index"`
+	InputPath    string            `gorm:"type:varchar(1024);not null;index:idx_tasks_workflow_input_status,priority:2"`
+	OutputPath   string            `gorm:"type:varchar(1024)"`
+	Status       string            `gorm:"type:varchar(20);not null;default:'pending';index;index:idx_tasks_workflow_input_status,priority:3"`
+	LogText      string            `gorm:"type:text"`
+	LogKey       string            `gorm:"type:varchar(1024)"`
+	ErrorMessage string            `gorm:"type:text"`
+	OutputSize   int64             `gorm:"default:0"`
+	OutputMD5    string            `gorm:"type:varchar(32)"`
+	CancelReason string            `gorm:"type:varchar(32)"`
+	InputMD5     string            `gorm:"type:varchar(64)"`
+	ResumeFrom   int               `gorm:"default:0"`
+	Priority     int               `gorm:"default:0;index"`
+	ChainDepth   int               `gorm:"default:0"`
+	TaskEnv      map[string]string `gorm:"column:task_env;type:text;serializer:json"`
+	ParentTaskID string            `gorm:"type:varchar(36);index"`
+	QueuedAt     *time.Time        `gorm:"index"` // Unset on tasks queued before the column existed
+	StartedAt    *time.Time        `gorm:"index"`
 	CompletedAt  *time.Time
-	CreatedAt    time.Time `gorm:"autoCreateTime;index"`
-	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+	CreatedAt    time.Time      `gorm:"autoCreateTime;index"`
+	UpdatedAt    time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"` // Set when the task is archived; archived tasks are hidden from queries
 }
 
 func (TaskModel) TableName() string {
@@ -270,6 +375,7 @@ type TaskStepModel struct {
 	Command     string `gorm:"type:text;not null"`
 	Status      string `gorm:"type:varchar(20);not null;default:'pending';index"`
 	ExitCode    *int   `gorm:"type:int"`
+	Attempts    int    `gorm:"default:0"`
 	Stdout      string `gorm:"type:text"`
 	Stderr      string `gorm:"type:text"`
 	StartedAt   *time.Time
@@ -281,3 +387,29 @@ type TaskStepModel struct {
 func (TaskStepModel) TableName() string {
 	return "task_steps"
 }
+
+type TaskExecutionModel struct {
+	ID        string    `gorm:"primaryKey;type:varchar(36)"`
+	TaskID    string    `gorm:"type:varchar(36);not null;index"`
+	Record    string    `gorm:"type:text;not null"` // JSON encoded scheduler.ExecutionRecord
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+}
+
+func (TaskExecutionModel) TableName() string {
+	return "task_executions"
+}
+
+type TaskOutputModel struct {
+	ID        string `gorm:"primaryKey;type:varchar(36)"`
+	TaskID    string `gorm:"type:varchar(36);not null;index"`
+	Position  int    `gorm:"not null;default:0"` // Index in the workflow's outputs list
+	Path      string `gorm:"type:varchar(1024);not null"`
+	Exists    bool   `gorm:"default:false"`
+	Size      int64  `gorm:"default:0"`
+	CheckedAt *time.Time
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+func (TaskOutputModel) TableName() string {
+	return "task_outputs"
+}
@@ -1,10 +1,15 @@
 package database
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/andi/fileaction/backend/models"
+	"gorm.io/gorm"
 )
 
 func setupTestDB(t *testing.T) *DB {
@@ -267,3 +272,820 @@ func TestFileCRUD(t *testing.T) {
 		t.Errorf("Expected count 1, got %d", count)
 	}
 }
+
+func TestResolvePluginVersion(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPluginRepo(db)
+
+	pluginYAML := func(version string) string {
+		return "name: test-plugin\nversion: " + version + "\nsteps:\n  - name: run\n    run: echo ok\n"
+	}
+
+	plugin, _, err := repo.CreatePlugin("test-plugin", "Test plugin", pluginYAML("1.0.0"), "test")
+	if err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	for _, v := range []string{"1.1.0", "2.0.0"} {
+		if _, err := repo.CreatePluginVersion(plugin.ID, pluginYAML(v)); err != nil {
+			t.Fatalf("Failed to create version %s: %v", v, err)
+		}
+	}
+
+	tests := []struct {
+		constraint string
+		expected   string
+	}{
+		{"1.0.0", "1.0.0"},
+		{"1.0", "1.0.0"},
+		{"^1.0.0", "1.1.0"},
+		{"2", "2.0.0"},
+	}
+	for _, tt := range tests {
+		version, err := repo.ResolvePluginVersion("test-plugin", tt.constraint)
+		if err != nil {
+			t.Fatalf("Failed to resolve %q: %v", tt.constraint, err)
+		}
+		if version.Version != tt.expected {
+			t.Errorf("Expected %s for %q, got %s", tt.expected, tt.constraint, version.Version)
+		}
+	}
+
+	if _, err := repo.ResolvePluginVersion("test-plugin", "^3.0.0"); err == nil {
+		t.Error("Expected error for unsatisfiable constraint")
+	}
+}
+
+func TestResetDefaults(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	pluginRepo := NewPluginRepo(db)
+
+	seeds, err := DefaultSeeds()
+	if err != nil {
+		t.Fatalf("Failed to load default seeds: %v", err)
+	}
+	var workflowSeed, pluginSeed DefaultSeed
+	for _, seed := range seeds {
+		switch seed.Kind {
+		case SeedKindWorkflow:
+			workflowSeed = seed
+		case SeedKindPlugin:
+			pluginSeed = seed
+		}
+	}
+
+	// Edit the default workflow and activate a newer plugin version
+	wf, err := workflowRepo.GetByID(defaultWorkflowID)
+	if err != nil {
+		t.Fatalf("Failed to get default workflow: %v", err)
+	}
+	wf.YAMLContent += "\n# local edit\n"
+	if err := workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	plugin, err := pluginRepo.GetPluginByName(pluginSeed.Name)
+	if err != nil {
+		t.Fatalf("Failed to get default plugin: %v", err)
+	}
+	edited := strings.Replace(pluginSeed.YAMLContent, "version: "+pluginSeed.Version, "version: 99.0.0", 1)
+	if _, err := pluginRepo.CreatePluginVersion(plugin.ID, edited); err != nil {
+		t.Fatalf("Failed to create plugin version: %v", err)
+	}
+
+	actions := func(statuses []SeedStatus) map[string]string {
+		result := make(map[string]string)
+		for _, status := range statuses {
+			result[status.Kind] = status.Action
+		}
+		return result
+	}
+
+	statuses, err := db.ResetDefaults(false)
+	if err != nil {
+		t.Fatalf("ResetDefaults(false) failed: %v", err)
+	}
+	got := actions(statuses)
+	if got[SeedKindWorkflow] != SeedActionModified || got[SeedKindPlugin] != SeedActionModified {
+		t.Errorf("Expected both seeds to be reported modified, got %v", got)
+	}
+	if wf, _ := workflowRepo.GetByID(defaultWorkflowID); wf.YAMLContent == workflowSeed.YAMLContent {
+		t.Error("Workflow should not be restored without overwrite")
+	}
+
+	statuses, err = db.ResetDefaults(true)
+	if err != nil {
+		t.Fatalf("ResetDefaults(true) failed: %v", err)
+	}
+	got = actions(statuses)
+	if got[SeedKindWorkflow] != SeedActionRestored || got[SeedKindPlugin] != SeedActionRestored {
+		t.Errorf("Expected both seeds to be restored, got %v", got)
+	}
+	if wf, _ := workflowRepo.GetByID(defaultWorkflowID); wf.YAMLContent != workflowSeed.YAMLContent {
+		t.Error("Workflow YAML was not restored")
+	}
+	current, err := pluginRepo.GetPluginCurrentVersion(plugin.ID)
+	if err != nil {
+		t.Fatalf("Failed to get current plugin version: %v", err)
+	}
+	if current.Version != pluginSeed.Version {
+		t.Errorf("Expected plugin version %s to be current, got %s", pluginSeed.Version, current.Version)
+	}
+
+	// Deleted seeds are re-created
+	if err := workflowRepo.Delete(defaultWorkflowID); err != nil {
+		t.Fatalf("Failed to delete workflow: %v", err)
+	}
+	statuses, err = db.ResetDefaults(false)
+	if err != nil {
+		t.Fatalf("ResetDefaults failed: %v", err)
+	}
+	got = actions(statuses)
+	if got[SeedKindWorkflow] != SeedActionCreated || got[SeedKindPlugin] != SeedActionUnchanged {
+		t.Errorf("Expected workflow created and plugin unchanged, got %v", got)
+	}
+}
+
+func TestCreateBatch(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	fileRepo := NewFileRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	workflow := &models.Workflow{
+		Name:        "batch-workflow",
+		YAMLContent: "name: test",
+		Enabled:     true,
+	}
+	if err := workflowRepo.Create(workflow); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	// Sequential inserts for comparison
+	sequential := &models.File{WorkflowID: workflow.ID, FilePath: "/test/seq.jpg", FileMD5: "seq", FileSize: 1}
+	if err := fileRepo.Create(sequential); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	files := make([]*models.File, 250) // spans several insert chunks
+	for i := range files {
+		files[i] = &models.File{
+			WorkflowID: workflow.ID,
+			FilePath:   fmt.Sprintf("/test/batch-%03d.jpg", i),
+			FileMD5:    fmt.Sprintf("md5-%03d", i),
+			FileSize:   int64(i),
+		}
+	}
+	if err := fileRepo.CreateBatch(files); err != nil {
+		t.Fatalf("Failed to batch create files: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file.ID == "" || seen[file.ID] {
+			t.Fatalf("Expected unique ID for %s, got %q", file.FilePath, file.ID)
+		}
+		seen[file.ID] = true
+		if file.CreatedAt.IsZero() || file.UpdatedAt.IsZero() {
+			t.Errorf("Expected timestamps for %s", file.FilePath)
+		}
+	}
+
+	count, err := fileRepo.CountByWorkflow(workflow.ID)
+	if err != nil {
+		t.Fatalf("Failed to count files: %v", err)
+	}
+	if count != len(files)+1 {
+		t.Errorf("Expected %d files, got %d", len(files)+1, count)
+	}
+
+	// Batch rows read back exactly like sequential ones
+	stored, err := fileRepo.GetByWorkflowAndPaths(workflow.ID, []string{"/test/seq.jpg", "/test/batch-042.jpg", "/test/missing.jpg"})
+	if err != nil {
+		t.Fatalf("Failed to get files: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(stored))
+	}
+	if got := stored["/test/batch-042.jpg"]; got.ID != files[42].ID || got.FileMD5 != "md5-042" || got.FileSize != 42 {
+		t.Errorf("Batch file mismatch: %+v", got)
+	}
+	if got := stored["/test/seq.jpg"]; got.ID != sequential.ID || got.FileMD5 != "seq" {
+		t.Errorf("Sequential file mismatch: %+v", got)
+	}
+
+	// Tasks
+	tasks := make([]*models.Task, len(files))
+	for i, file := range files {
+		tasks[i] = &models.Task{
+			WorkflowID: workflow.ID,
+			FileID:     file.ID,
+			InputPath:  file.FilePath,
+			OutputPath: file.FilePath + ".out",
+			Status:     models.TaskStatusPending,
+		}
+	}
+	if err := taskRepo.CreateBatch(tasks); err != nil {
+		t.Fatalf("Failed to batch create tasks: %v", err)
+	}
+
+	for _, task := range tasks {
+		retrieved, err := taskRepo.GetByID(task.ID)
+		if err != nil {
+			t.Fatalf("Failed to get task %s: %v", task.ID, err)
+		}
+		if retrieved.FileID != task.FileID || retrieved.InputPath != task.InputPath || retrieved.Status != models.TaskStatusPending {
+			t.Errorf("Task mismatch: %+v", retrieved)
+		}
+		if retrieved.CreatedAt.IsZero() {
+			t.Errorf("Expected created_at for task %s", task.ID)
+		}
+	}
+
+	if err := taskRepo.CreateBatch(nil); err != nil {
+		t.Errorf("Expected empty batch to be a no-op, got %v", err)
+	}
+}
+
+func BenchmarkFileCreate(b *testing.B) {
+	const fileCount = 500
+
+	newFiles := func(workflowID string) []*models.File {
+		files := make([]*models.File, fileCount)
+		for i := range files {
+			files[i] = &models.File{WorkflowID: workflowID, FilePath: fmt.Sprintf("/bench/%d.jpg", i), FileMD5: "md5", FileSize: 1}
+		}
+		return files
+	}
+
+	run := func(b *testing.B, insert func(repo *FileRepo, files []*models.File) error) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, err := New(filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatalf("Failed to create database: %v", err)
+			}
+			files := newFiles("bench-workflow")
+			b.StartTimer()
+
+			if err := insert(NewFileRepo(db), files); err != nil {
+				b.Fatalf("Insert failed: %v", err)
+			}
+
+			b.StopTimer()
+			db.Close()
+		}
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		run(b, func(repo *FileRepo, files []*models.File) error {
+			for _, file := range files {
+				if err := repo.Create(file); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		run(b, func(repo *FileRepo, files []*models.File) error {
+			return repo.CreateBatch(files)
+		})
+	})
+}
+
+func TestSortableIDs(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.SetIDFormat(IDFormatSortable); err != nil {
+		t.Fatalf("Failed to set ID format: %v", err)
+	}
+	if err := db.SetIDFormat("snowflake"); err == nil {
+		t.Error("Expected error for unknown ID format")
+	}
+
+	repo := NewTaskRepo(db)
+	var ids []string
+	for i := 0; i < 50; i++ {
+		task := &models.Task{WorkflowID: "wf", FileID: "file", InputPath: fmt.Sprintf("/in/%d", i), Status: models.TaskStatusPending}
+		if err := repo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+
+	batch := make([]*models.Task, 50)
+	for i := range batch {
+		batch[i] = &models.Task{WorkflowID: "wf", FileID: "file", InputPath: fmt.Sprintf("/batch/%d", i), Status: models.TaskStatusPending}
+	}
+	if err := repo.CreateBatch(batch); err != nil {
+		t.Fatalf("Failed to create batch: %v", err)
+	}
+	for _, task := range batch {
+		ids = append(ids, task.ID)
+	}
+
+	// IDs compare in the order they were created
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			t.Fatalf("ID %d (%s) does not sort after ID %d (%s)", i, ids[i], i-1, ids[i-1])
+		}
+	}
+}
+
+func BenchmarkTaskInsertIDFormat(b *testing.B) {
+	const taskCount = 2000
+
+	for _, format := range []string{IDFormatUUID, IDFormatSortable} {
+		b.Run(format, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db, err := New(filepath.Join(b.TempDir(), "bench.db"))
+				if err != nil {
+					b.Fatalf("Failed to create database: %v", err)
+				}
+				db.SetIDFormat(format)
+				tasks := make([]*models.Task, taskCount)
+				for j := range tasks {
+					tasks[j] = &models.Task{WorkflowID: "wf", FileID: "file", InputPath: fmt.Sprintf("/bench/%d.jpg", j), Status: models.TaskStatusPending}
+				}
+				b.StartTimer()
+
+				if err := NewTaskRepo(db).CreateBatch(tasks); err != nil {
+					b.Fatalf("Insert failed: %v", err)
+				}
+
+				b.StopTimer()
+				db.Close()
+			}
+		})
+	}
+}
+
+func TestOverlongPathsAreRejected(t *testing.T) {
+	db := setupTestDB(t)
+	fileRepo := NewFileRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	longPath := "/" + strings.Repeat("nested-directory/", 70) + "image.jpg" // > 1024 characters
+	maxPath := "/" + strings.Repeat("a", MaxPathLength-1)
+
+	err := fileRepo.Create(&models.File{WorkflowID: "wf", FilePath: longPath, FileMD5: "md5"})
+	if err == nil || !strings.Contains(err.Error(), "limit is 1024") {
+		t.Errorf("Expected path length error for file, got %v", err)
+	}
+	err = fileRepo.CreateBatch([]*models.File{
+		{WorkflowID: "wf", FilePath: "/ok.jpg", FileMD5: "md5"},
+		{WorkflowID: "wf", FilePath: longPath, FileMD5: "md5"},
+	})
+	if err == nil {
+		t.Error("Expected path length error for file batch")
+	}
+	if count, _ := fileRepo.CountByWorkflow("wf"); count != 0 {
+		t.Errorf("Expected rejected batch to insert nothing, got %d files", count)
+	}
+
+	err = taskRepo.Create(&models.Task{WorkflowID: "wf", FileID: "file", InputPath: "/in.jpg", OutputPath: longPath, Status: models.TaskStatusPending})
+	if err == nil || !strings.Contains(err.Error(), "output path") {
+		t.Errorf("Expected output path length error for task, got %v", err)
+	}
+
+	// Paths at the limit are stored unchanged
+	file := &models.File{WorkflowID: "wf", FilePath: maxPath, FileMD5: "md5"}
+	if err := fileRepo.Create(file); err != nil {
+		t.Fatalf("Expected path at the limit to be accepted, got %v", err)
+	}
+	stored, err := fileRepo.GetByWorkflowAndPath("wf", maxPath)
+	if err != nil || stored.FilePath != maxPath {
+		t.Errorf("Expected path at the limit to round-trip, got %v", err)
+	}
+}
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	db := setupTestDB(t)
+	db.retryBackoff = time.Millisecond
+	taskRepo := NewTaskRepo(db)
+
+	task := &models.Task{WorkflowID: "wf", FileID: "file", InputPath: "/in.jpg", OutputPath: "/out.png", Status: models.TaskStatusPending}
+	if err := taskRepo.Create(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	// Fail the next updates with the given error
+	var failures int
+	var injected error
+	err := db.conn.Callback().Update().Before("gorm:update").Register("test:inject_error", func(tx *gorm.DB) {
+		if failures > 0 {
+			failures--
+			tx.AddError(injected)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to register callback: %v", err)
+	}
+
+	injected = fmt.Errorf("database is locked (5) (SQLITE_BUSY)")
+	failures = 2
+	task.Status = models.TaskStatusRunning
+	if err := taskRepo.Update(task); err != nil {
+		t.Fatalf("Expected update to succeed on retry, got %v", err)
+	}
+	if stored, _ := taskRepo.GetByID(task.ID); stored.Status != models.TaskStatusRunning {
+		t.Errorf("Expected status running after retry, got %s", stored.Status)
+	}
+
+	// Retries are bounded
+	failures = db.maxRetries + 1
+	if err := taskRepo.Update(task); err == nil {
+		t.Error("Expected update to fail after exhausting retries")
+	}
+
+	// Other errors are returned right away
+	injected = fmt.Errorf("constraint failed")
+	failures = 1
+	if err := taskRepo.Update(task); err == nil || failures != 0 {
+		t.Errorf("Expected a non-transient error to fail without retry, got %v", err)
+	}
+	if err := taskRepo.Update(task); err != nil {
+		t.Errorf("Expected update to succeed once the error is gone, got %v", err)
+	}
+}
+
+func TestQueuePosition(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	var workflows []*models.Workflow
+	for _, name := range []string{"queue-a", "queue-b"} {
+		wf := &models.Workflow{Name: name, YAMLContent: "name: " + name, Enabled: true}
+		if err := workflowRepo.Create(wf); err != nil {
+			t.Fatalf("Failed to create workflow: %v", err)
+		}
+		workflows = append(workflows, wf)
+	}
+
+	// Tasks are queued in this order; the fourth one is already running
+	base := time.Now().Add(-time.Hour)
+	queued := []struct {
+		workflow int
+		status   string
+	}{
+		{0, models.TaskStatusPending},
+		{1, models.TaskStatusPending},
+		{0, models.TaskStatusPending},
+		{1, models.TaskStatusRunning},
+		{0, models.TaskStatusPending},
+	}
+	var tasks []*models.Task
+	for i, q := range queued {
+		task := &models.Task{
+			WorkflowID: workflows[q.workflow].ID,
+			FileID:     fmt.Sprintf("file-%d", i),
+			InputPath:  fmt.Sprintf("/in/%d", i),
+			OutputPath: fmt.Sprintf("/out/%d", i),
+			Status:     q.status,
+			CreatedAt:  base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	expected := []struct {
+		status             string
+		global, inWorkflow int
+	}{
+		{models.TaskStatusPending, 1, 1},
+		{models.TaskStatusPending, 2, 1},
+		{models.TaskStatusPending, 3, 2},
+		{models.TaskStatusRunning, 0, 0},
+		{models.TaskStatusPending, 4, 3},
+	}
+	for i, want := range expected {
+		pos, err := taskRepo.QueuePosition(tasks[i].ID)
+		if err != nil {
+			t.Fatalf("QueuePosition failed: %v", err)
+		}
+		if pos.Status != want.status || pos.Position != want.global || pos.WorkflowPosition != want.inWorkflow {
+			t.Errorf("Task %d: expected %s at %d/%d, got %s at %d/%d", i, want.status, want.global, want.inWorkflow,
+				pos.Status, pos.Position, pos.WorkflowPosition)
+		}
+	}
+
+	if _, err := taskRepo.QueuePosition("missing"); err == nil {
+		t.Error("Expected an error for an unknown task")
+	}
+}
+
+func TestQueuePositionSkipsHeldWorkflows(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	// The disabled and paused workflows' tasks are queued first but never dispatched
+	var workflows []*models.Workflow
+	for _, name := range []string{"held-disabled", "held-paused", "held-active"} {
+		wf := &models.Workflow{Name: name, YAMLContent: "name: " + name, Enabled: true}
+		if err := workflowRepo.Create(wf); err != nil {
+			t.Fatalf("Failed to create workflow: %v", err)
+		}
+		workflows = append(workflows, wf)
+	}
+	workflows[0].Enabled = false
+	workflows[1].Paused = true
+	for _, wf := range workflows[:2] {
+		if err := workflowRepo.Update(wf); err != nil {
+			t.Fatalf("Failed to update workflow: %v", err)
+		}
+	}
+
+	base := time.Now().Add(-time.Hour)
+	var tasks []*models.Task
+	for i, wf := range workflows {
+		task := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     fmt.Sprintf("file-%d", i),
+			InputPath:  fmt.Sprintf("/in/%d", i),
+			OutputPath: fmt.Sprintf("/out/%d", i),
+			Status:     models.TaskStatusPending,
+			CreatedAt:  base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	pending, err := taskRepo.GetPendingTasks(10)
+	if err != nil {
+		t.Fatalf("GetPendingTasks failed: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != tasks[2].ID {
+		t.Errorf("Expected only the active workflow's task to be dispatched, got %d tasks", len(pending))
+	}
+
+	for i, task := range tasks {
+		pos, err := taskRepo.QueuePosition(task.ID)
+		if err != nil {
+			t.Fatalf("QueuePosition failed: %v", err)
+		}
+		held := i < 2
+		position := 1
+		if held {
+			position = 0
+		}
+		if pos.Held != held || pos.Position != position || pos.WorkflowPosition != position {
+			t.Errorf("Task %d: expected held=%v at %d/%d, got held=%v at %d/%d", i, held, position, position,
+				pos.Held, pos.Position, pos.WorkflowPosition)
+		}
+	}
+}
+
+func TestPendingTasksOrderedByPriority(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	wf := &models.Workflow{Name: "priority", YAMLContent: "name: priority", Enabled: true}
+	if err := workflowRepo.Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	// Queued oldest first; higher priorities jump ahead, equal ones stay FIFO
+	base := time.Now().Add(-time.Hour)
+	priorities := []int{0, 5, 0, 5, -1}
+	var tasks []*models.Task
+	for i, priority := range priorities {
+		task := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     fmt.Sprintf("file-%d", i),
+			InputPath:  fmt.Sprintf("/in/%d", i),
+			OutputPath: fmt.Sprintf("/out/%d", i),
+			Status:     models.TaskStatusPending,
+			Priority:   priority,
+			CreatedAt:  base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	pending, err := taskRepo.GetPendingTasks(10)
+	if err != nil {
+		t.Fatalf("GetPendingTasks failed: %v", err)
+	}
+	order := []int{1, 3, 0, 2, 4}
+	for i, want := range order {
+		if pending[i].ID != tasks[want].ID {
+			t.Errorf("Position %d: expected task %d, got %s", i+1, want, pending[i].InputPath)
+		}
+		pos, err := taskRepo.QueuePosition(tasks[want].ID)
+		if err != nil {
+			t.Fatalf("QueuePosition failed: %v", err)
+		}
+		if pos.Position != i+1 {
+			t.Errorf("Task %d: expected queue position %d, got %d", want, i+1, pos.Position)
+		}
+	}
+
+	high := 5
+	filtered, err := taskRepo.ListFiltered(TaskFilter{Priority: &high}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListFiltered failed: %v", err)
+	}
+	if count, _ := taskRepo.CountFiltered(TaskFilter{Priority: &high}); len(filtered) != 2 || count != 2 {
+		t.Errorf("Expected 2 tasks with priority 5, got %d (count %d)", len(filtered), count)
+	}
+}
+
+func TestListAfterPagesWithCursor(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	wf := &models.Workflow{Name: "paging", YAMLContent: "name: paging", Enabled: true}
+	if err := workflowRepo.Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	// Pairs of tasks share a creation time, so the ID has to break ties. Local
+	// drops the monotonic reading, as GORM's own timestamps do.
+	base := time.Now().Add(-time.Hour).Local()
+	for i := 0; i < 7; i++ {
+		task := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     fmt.Sprintf("file-%d", i),
+			InputPath:  fmt.Sprintf("/in/%d", i),
+			OutputPath: fmt.Sprintf("/out/%d", i),
+			Status:     models.TaskStatusPending,
+			CreatedAt:  base.Add(time.Duration(i/2) * time.Minute),
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	filter := TaskFilter{WorkflowID: wf.ID}
+	all, err := taskRepo.ListFiltered(filter, 100, 0)
+	if err != nil {
+		t.Fatalf("ListFiltered failed: %v", err)
+	}
+
+	var paged []*models.Task
+	page, err := taskRepo.ListFiltered(filter, 3, 0)
+	for len(page) > 0 {
+		if err != nil {
+			t.Fatalf("Listing failed: %v", err)
+		}
+		paged = append(paged, page...)
+
+		// Cursors survive a round trip through their token
+		cursor, err := ParseTaskCursor(NewTaskCursor(page[len(page)-1]).String())
+		if err != nil {
+			t.Fatalf("Failed to parse cursor: %v", err)
+		}
+		page, err = taskRepo.ListAfter(filter, cursor, 3)
+	}
+
+	if len(paged) != len(all) {
+		t.Fatalf("Expected %d tasks across pages, got %d", len(all), len(paged))
+	}
+	for i := range all {
+		if paged[i].ID != all[i].ID {
+			t.Errorf("Position %d: expected %s, got %s", i, all[i].InputPath, paged[i].InputPath)
+		}
+	}
+
+	if _, err := ParseTaskCursor("not-a-cursor"); err == nil {
+		t.Error("Expected an invalid cursor to be rejected")
+	}
+}
+
+func TestAverageDuration(t *testing.T) {
+	db := setupTestDB(t)
+	taskRepo := NewTaskRepo(db)
+
+	wf := &models.Workflow{Name: "timed", YAMLContent: "name: timed", Enabled: true}
+	if err := NewWorkflowRepo(db).Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+	if avg, n, err := taskRepo.AverageDuration(wf.ID); err != nil || avg != 0 || n != 0 {
+		t.Errorf("Expected no average without tasks, got %v over %d (%v)", avg, n, err)
+	}
+
+	started := time.Now().Add(-time.Hour)
+	for i, d := range []time.Duration{1500 * time.Millisecond, 4500 * time.Millisecond, time.Hour} {
+		completed := started.Add(d)
+		status := models.TaskStatusCompleted
+		if d == time.Hour {
+			status = models.TaskStatusFailed // Only completed tasks count
+		}
+		task := &models.Task{
+			WorkflowID:  wf.ID,
+			FileID:      fmt.Sprintf("file-%d", i),
+			InputPath:   fmt.Sprintf("/in/%d", i),
+			Status:      status,
+			StartedAt:   &started,
+			CompletedAt: &completed,
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	avg, n, err := taskRepo.AverageDuration(wf.ID)
+	if err != nil {
+		t.Fatalf("AverageDuration failed: %v", err)
+	}
+	if n != 2 || avg < 2990*time.Millisecond || avg > 3010*time.Millisecond {
+		t.Errorf("Expected 3s over 2 tasks, got %v over %d", avg, n)
+	}
+}
+
+func TestDeletedTasksAreArchived(t *testing.T) {
+	db := setupTestDB(t)
+	workflowRepo := NewWorkflowRepo(db)
+	taskRepo := NewTaskRepo(db)
+
+	wf := &models.Workflow{Name: "archive", YAMLContent: "name: archive", Enabled: true}
+	if err := workflowRepo.Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	var tasks []*models.Task
+	for i := 0; i < 2; i++ {
+		task := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     fmt.Sprintf("file-%d", i),
+			InputPath:  fmt.Sprintf("/in/%d", i),
+			OutputPath: fmt.Sprintf("/out/%d", i),
+			Status:     models.TaskStatusCompleted,
+		}
+		if err := taskRepo.Create(task); err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	archived := tasks[0]
+
+	if err := taskRepo.Delete(archived.ID); err != nil {
+		t.Fatalf("Failed to delete task: %v", err)
+	}
+
+	// Hidden from normal queries
+	if _, err := taskRepo.GetByID(archived.ID); err == nil {
+		t.Error("Expected an archived task to be hidden from GetByID")
+	}
+
+	// Updating a copy read before archiving does not bring the task back
+	if err := taskRepo.Update(archived); err == nil {
+		t.Error("Expected updating an archived task to fail")
+	}
+	if _, err := taskRepo.GetByID(archived.ID); err == nil {
+		t.Error("Expected the task to stay archived after an update")
+	}
+	live := tasks[1]
+	live.ErrorMessage = "updated"
+	if err := taskRepo.Update(live); err != nil {
+		t.Fatalf("Failed to update live task: %v", err)
+	}
+	if count, _ := taskRepo.CountFiltered(TaskFilter{WorkflowID: wf.ID}); count != 1 {
+		t.Errorf("Expected 1 live task, got %d", count)
+	}
+
+	// Still available on request
+	got, err := taskRepo.GetIncludingArchived(archived.ID)
+	if err != nil {
+		t.Fatalf("Failed to get archived task: %v", err)
+	}
+	if got.ArchivedAt == nil {
+		t.Error("Expected archived_at to be set")
+	}
+	listed, err := taskRepo.ListFiltered(TaskFilter{WorkflowID: wf.ID, IncludeArchived: true}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListFiltered failed: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("Expected 2 tasks including archived, got %d", len(listed))
+	}
+
+	// Purging respects the cutoff
+	if purged, err := taskRepo.PurgeArchived(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("Expected nothing purged before the cutoff, got %d (%v)", purged, err)
+	}
+	purged, err := taskRepo.PurgeArchived(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("PurgeArchived failed: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("Expected 1 task purged, got %d", purged)
+	}
+	if _, err := taskRepo.GetIncludingArchived(archived.ID); err == nil {
+		t.Error("Expected the purged task to be gone")
+	}
+	if _, err := taskRepo.GetByID(tasks[1].ID); err != nil {
+		t.Errorf("Expected the live task to survive the purge: %v", err)
+	}
+}
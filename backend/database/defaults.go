@@ -0,0 +1,180 @@
+package database
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Seed kinds
+const (
+	SeedKindWorkflow = "workflow"
+	SeedKindPlugin   = "plugin"
+)
+
+// Seed reset actions
+const (
+	SeedActionCreated   = "created"   // Seed was missing and has been created
+	SeedActionRestored  = "restored"  // Modified seed was overwritten with the default
+	SeedActionModified  = "modified"  // Seed differs from the default and was left alone
+	SeedActionUnchanged = "unchanged" // Seed already matches the default
+)
+
+// DefaultSeed is a workflow or plugin embedded in the binary and seeded on startup
+type DefaultSeed struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version,omitempty"` // Plugins only
+	YAMLContent string `json:"yaml_content"`
+}
+
+// SeedStatus reports what a reset did to one seed
+type SeedStatus struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// DefaultSeeds returns the embedded default workflows and plugins
+func DefaultSeeds() ([]DefaultSeed, error) {
+	sources := []struct {
+		kind        string
+		yamlContent string
+	}{
+		{SeedKindWorkflow, defaultWorkflowYAML},
+		{SeedKindPlugin, defaultPluginJpegToHeic},
+	}
+
+	seeds := make([]DefaultSeed, 0, len(sources))
+	for _, src := range sources {
+		var meta struct {
+			Name        string `yaml:"name"`
+			Description string `yaml:"description"`
+			Version     string `yaml:"version"`
+		}
+		if err := yaml.Unmarshal([]byte(src.yamlContent), &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse default %s: %w", src.kind, err)
+		}
+		seed := DefaultSeed{
+			Kind:        src.kind,
+			Name:        meta.Name,
+			Description: meta.Description,
+			YAMLContent: src.yamlContent,
+		}
+		if src.kind == SeedKindPlugin {
+			seed.Version = meta.Version
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, nil
+}
+
+// ResetDefaults re-creates missing default workflows and plugins. With
+// overwrite, seeds that were modified are restored to the embedded content:
+// workflows get their YAML and description back (their enabled state is kept)
+// and plugins get the default version re-activated.
+func (db *DB) ResetDefaults(overwrite bool) ([]SeedStatus, error) {
+	seeds, err := DefaultSeeds()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]SeedStatus, 0, len(seeds))
+	for _, seed := range seeds {
+		var action string
+		var err error
+		switch seed.Kind {
+		case SeedKindWorkflow:
+			action, err = db.resetDefaultWorkflow(seed, overwrite)
+		case SeedKindPlugin:
+			action, err = db.resetDefaultPlugin(seed, overwrite)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to reset default %s %s: %w", seed.Kind, seed.Name, err)
+		}
+		statuses = append(statuses, SeedStatus{Kind: seed.Kind, Name: seed.Name, Action: action})
+	}
+	return statuses, nil
+}
+
+// resetDefaultWorkflow restores a single default workflow
+func (db *DB) resetDefaultWorkflow(seed DefaultSeed, overwrite bool) (string, error) {
+	repo := NewWorkflowRepo(db)
+	wf, err := repo.GetByName(seed.Name)
+	if err != nil {
+		// The seed may have been renamed rather than deleted
+		if wf, err = repo.GetByID(defaultWorkflowID); err != nil {
+			if err := db.initDefaultWorkflows(); err != nil {
+				return "", err
+			}
+			return SeedActionCreated, nil
+		}
+	}
+
+	if wf.Name == seed.Name && wf.YAMLContent == seed.YAMLContent && wf.Description == seed.Description {
+		return SeedActionUnchanged, nil
+	}
+	if !overwrite {
+		return SeedActionModified, nil
+	}
+
+	wf.Name = seed.Name
+	wf.YAMLContent = seed.YAMLContent
+	wf.Description = seed.Description
+	if err := repo.Update(wf); err != nil {
+		return "", err
+	}
+	return SeedActionRestored, nil
+}
+
+// resetDefaultPlugin restores a single default plugin
+func (db *DB) resetDefaultPlugin(seed DefaultSeed, overwrite bool) (string, error) {
+	repo := NewPluginRepo(db)
+	plugin, err := repo.GetPluginByName(seed.Name)
+	if err != nil {
+		if _, _, err := repo.CreatePlugin(seed.Name, seed.Description, seed.YAMLContent, "system"); err != nil {
+			return "", err
+		}
+		return SeedActionCreated, nil
+	}
+
+	versions, err := repo.GetPluginVersions(plugin.ID)
+	if err != nil {
+		return "", err
+	}
+	var seedVersion *PluginVersion
+	for _, v := range versions {
+		if v.Version == seed.Version {
+			seedVersion = v
+			break
+		}
+	}
+
+	if seedVersion != nil && seedVersion.YAMLContent == seed.YAMLContent && plugin.CurrentVersionID == seedVersion.ID {
+		return SeedActionUnchanged, nil
+	}
+	if !overwrite {
+		return SeedActionModified, nil
+	}
+
+	if seedVersion == nil {
+		// CreatePluginVersion also makes the new version current
+		if _, err := repo.CreatePluginVersion(plugin.ID, seed.YAMLContent); err != nil {
+			return "", err
+		}
+		return SeedActionRestored, nil
+	}
+
+	if seedVersion.YAMLContent != seed.YAMLContent {
+		err := db.conn.Model(&PluginVersionModel{}).Where("id = ?", seedVersion.ID).
+			Update("yaml_content", seed.YAMLContent).Error
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := repo.SetCurrentVersion(plugin.ID, seedVersion.ID); err != nil {
+		return "", err
+	}
+	return SeedActionRestored, nil
+}
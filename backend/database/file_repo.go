@@ -2,9 +2,10 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/andi/fileaction/backend/models"
-	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // FileRepo handles file database operations
@@ -19,8 +20,11 @@ func NewFileRepo(db *DB) *FileRepo {
 
 // Create creates a new file record
 func (r *FileRepo) Create(file *models.File) error {
+	if err := CheckPathLength("file", file.FilePath); err != nil {
+		return err
+	}
 	if file.ID == "" {
-		file.ID = uuid.New().String()
+		file.ID = r.db.newID()
 	}
 
 	model := FromFile(file)
@@ -32,6 +36,36 @@ func (r *FileRepo) Create(file *models.File) error {
 	return nil
 }
 
+// CreateBatch creates several file records with multi-row inserts in a single transaction
+func (r *FileRepo) CreateBatch(files []*models.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	modelList := make([]*FileModel, len(files))
+	for i, file := range files {
+		if err := CheckPathLength("file", file.FilePath); err != nil {
+			return err
+		}
+		if file.ID == "" {
+			file.ID = r.db.newID()
+		}
+		modelList[i] = FromFile(file)
+	}
+
+	err := r.db.conn.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(modelList, 100).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, model := range modelList {
+		*files[i] = *model.ToFile()
+	}
+	return nil
+}
+
 // GetByWorkflowAndPath retrieves a file by workflow ID and path
 func (r *FileRepo) GetByWorkflowAndPath(workflowID, filePath string) (*models.File, error) {
 	var model FileModel
@@ -42,6 +76,31 @@ func (r *FileRepo) GetByWorkflowAndPath(workflowID, filePath string) (*models.Fi
 	return model.ToFile(), nil
 }
 
+// GetByWorkflowAndPaths retrieves the indexed files of a workflow for many paths
+// at once, keyed by file path. Paths that are not indexed are absent from the map.
+func (r *FileRepo) GetByWorkflowAndPaths(workflowID string, filePaths []string) (map[string]*models.File, error) {
+	const chunkSize = 500 // stay well below SQL variable limits
+
+	files := make(map[string]*models.File, len(filePaths))
+	for start := 0; start < len(filePaths); start += chunkSize {
+		end := start + chunkSize
+		if end > len(filePaths) {
+			end = len(filePaths)
+		}
+
+		var modelList []FileModel
+		err := r.db.conn.Where("workflow_id = ? AND file_path IN ?", workflowID, filePaths[start:end]).
+			Find(&modelList).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range modelList {
+			files[model.FilePath] = model.ToFile()
+		}
+	}
+	return files, nil
+}
+
 // Update updates a file record
 func (r *FileRepo) Update(file *models.File) error {
 	model := FromFile(file)
@@ -75,6 +134,30 @@ func (r *FileRepo) ListByWorkflow(workflowID string, limit, offset int) ([]*mode
 	return files, nil
 }
 
+// ListDeferred retrieves the files of a workflow that changed while it was paused
+func (r *FileRepo) ListDeferred(workflowID string) ([]*models.File, error) {
+	var modelList []FileModel
+	err := r.db.conn.Where("workflow_id = ? AND deferred = ?", workflowID, true).
+		Order("file_path").
+		Find(&modelList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*models.File, len(modelList))
+	for i, model := range modelList {
+		files[i] = model.ToFile()
+	}
+	return files, nil
+}
+
+// ClearDeferred unflags every deferred file of a workflow
+func (r *FileRepo) ClearDeferred(workflowID string) error {
+	return r.db.conn.Model(&FileModel{}).
+		Where("workflow_id = ? AND deferred = ?", workflowID, true).
+		Update("deferred", false).Error
+}
+
 // CountByWorkflow counts files for a workflow
 func (r *FileRepo) CountByWorkflow(workflowID string) (int, error) {
 	var count int64
@@ -82,6 +165,26 @@ func (r *FileRepo) CountByWorkflow(workflowID string) (int, error) {
 	return int(count), err
 }
 
+// LastScannedAt returns when a file of the workflow was last seen by a scan,
+// or nil if none is indexed
+func (r *FileRepo) LastScannedAt(workflowID string) (*time.Time, error) {
+	var modelList []FileModel
+	err := r.db.conn.Select("last_scanned_at").
+		Where("workflow_id = ?", workflowID).
+		Order("last_scanned_at DESC").
+		Limit(1).
+		Find(&modelList).Error
+	if err != nil || len(modelList) == 0 {
+		return nil, err
+	}
+	return &modelList[0].LastScannedAt, nil
+}
+
+// Delete deletes a file record
+func (r *FileRepo) Delete(id string) error {
+	return r.db.conn.Delete(&FileModel{}, "id = ?", id).Error
+}
+
 // DeleteByWorkflow deletes all files for a workflow
 func (r *FileRepo) DeleteByWorkflow(workflowID string) error {
 	return r.db.conn.Delete(&FileModel{}, "workflow_id = ?", workflowID).Error
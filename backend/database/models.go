@@ -2,6 +2,7 @@ package database
 
 import (
 	"github.com/andi/fileaction/backend/models"
+	"gorm.io/gorm"
 )
 
 // ToWorkflow converts WorkflowModel to models.Workflow
@@ -12,6 +13,8 @@ func (m *WorkflowModel) ToWorkflow() *models.Workflow {
 		Description: m.Description,
 		YAMLContent: m.YAMLContent,
 		Enabled:     m.Enabled,
+		Paused:      m.Paused,
+		BaselineAt:  m.BaselineAt,
 		CreatedAt:   m.CreatedAt,
 		UpdatedAt:   m.UpdatedAt,
 	}
@@ -25,6 +28,8 @@ func FromWorkflow(w *models.Workflow) *WorkflowModel {
 		Description: w.Description,
 		YAMLContent: w.YAMLContent,
 		Enabled:     w.Enabled,
+		Paused:      w.Paused,
+		BaselineAt:  w.BaselineAt,
 		CreatedAt:   w.CreatedAt,
 		UpdatedAt:   w.UpdatedAt,
 	}
@@ -38,6 +43,9 @@ func (m *FileModel) ToFile() *models.File {
 		FilePath:      m.FilePath,
 		FileMD5:       m.FileMD5,
 		FileSize:      m.FileSize,
+		ModTime:       m.ModTime,
+		Stale:         m.Stale,
+		Deferred:      m.Deferred,
 		LastScannedAt: m.LastScannedAt,
 		CreatedAt:     m.CreatedAt,
 		UpdatedAt:     m.UpdatedAt,
@@ -52,6 +60,9 @@ func FromFile(f *models.File) *FileModel {
 		FilePath:      f.FilePath,
 		FileMD5:       f.FileMD5,
 		FileSize:      f.FileSize,
+		ModTime:       f.ModTime,
+		Stale:         f.Stale,
+		Deferred:      f.Deferred,
 		LastScannedAt: f.LastScannedAt,
 		CreatedAt:     f.CreatedAt,
 		UpdatedAt:     f.UpdatedAt,
@@ -60,7 +71,7 @@ func FromFile(f *models.File) *FileModel {
 
 // ToTask converts TaskModel to models.Task
 func (m *TaskModel) ToTask() *models.Task {
-	return &models.Task{
+	task := &models.Task{
 		ID:           m.ID,
 		WorkflowID:   m.WorkflowID,
 		FileID:       m.FileID,
@@ -68,17 +79,33 @@ func (m *TaskModel) ToTask() *models.Task {
 		OutputPath:   m.OutputPath,
 		Status:       m.Status,
 		LogText:      m.LogText,
+		LogKey:       m.LogKey,
 		ErrorMessage: m.ErrorMessage,
+		OutputSize:   m.OutputSize,
+		OutputMD5:    m.OutputMD5,
+		CancelReason: m.CancelReason,
+		InputMD5:     m.InputMD5,
+		ResumeFrom:   m.ResumeFrom,
+		Priority:     m.Priority,
+		ChainDepth:   m.ChainDepth,
+		Env:          m.TaskEnv,
+		ParentTaskID: m.ParentTaskID,
+		QueuedAt:     m.QueuedAt,
 		StartedAt:    m.StartedAt,
 		CompletedAt:  m.CompletedAt,
 		CreatedAt:    m.CreatedAt,
 		UpdatedAt:    m.UpdatedAt,
 	}
+	if m.DeletedAt.Valid {
+		archivedAt := m.DeletedAt.Time
+		task.ArchivedAt = &archivedAt
+	}
+	return task
 }
 
 // FromTask converts models.Task to TaskModel
 func FromTask(t *models.Task) *TaskModel {
-	return &TaskModel{
+	model := &TaskModel{
 		ID:           t.ID,
 		WorkflowID:   t.WorkflowID,
 		FileID:       t.FileID,
@@ -86,12 +113,27 @@ func FromTask(t *models.Task) *TaskModel {
 		OutputPath:   t.OutputPath,
 		Status:       t.Status,
 		LogText:      t.LogText,
+		LogKey:       t.LogKey,
 		ErrorMessage: t.ErrorMessage,
+		OutputSize:   t.OutputSize,
+		OutputMD5:    t.OutputMD5,
+		CancelReason: t.CancelReason,
+		InputMD5:     t.InputMD5,
+		ResumeFrom:   t.ResumeFrom,
+		Priority:     t.Priority,
+		ChainDepth:   t.ChainDepth,
+		TaskEnv:      t.Env,
+		ParentTaskID: t.ParentTaskID,
+		QueuedAt:     t.QueuedAt,
 		StartedAt:    t.StartedAt,
 		CompletedAt:  t.CompletedAt,
 		CreatedAt:    t.CreatedAt,
 		UpdatedAt:    t.UpdatedAt,
 	}
+	if t.ArchivedAt != nil {
+		model.DeletedAt = gorm.DeletedAt{Time: *t.ArchivedAt, Valid: true}
+	}
+	return model
 }
 
 // ToTaskStep converts TaskStepModel to models.TaskStep
@@ -103,6 +145,7 @@ func (m *TaskStepModel) ToTaskStep() *models.TaskStep {
 		Command:     m.Command,
 		Status:      m.Status,
 		ExitCode:    m.ExitCode,
+		Attempts:    m.Attempts,
 		Stdout:      m.Stdout,
 		Stderr:      m.Stderr,
 		StartedAt:   m.StartedAt,
@@ -112,6 +155,54 @@ func (m *TaskStepModel) ToTaskStep() *models.TaskStep {
 	}
 }
 
+// ToTaskExecution converts TaskExecutionModel to models.TaskExecution
+func (m *TaskExecutionModel) ToTaskExecution() *models.TaskExecution {
+	return &models.TaskExecution{
+		ID:        m.ID,
+		TaskID:    m.TaskID,
+		Record:    m.Record,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// FromTaskExecution converts models.TaskExecution to TaskExecutionModel
+func FromTaskExecution(te *models.TaskExecution) *TaskExecutionModel {
+	return &TaskExecutionModel{
+		ID:        te.ID,
+		TaskID:    te.TaskID,
+		Record:    te.Record,
+		CreatedAt: te.CreatedAt,
+	}
+}
+
+// ToTaskOutput converts TaskOutputModel to models.TaskOutput
+func (m *TaskOutputModel) ToTaskOutput() *models.TaskOutput {
+	return &models.TaskOutput{
+		ID:        m.ID,
+		TaskID:    m.TaskID,
+		Position:  m.Position,
+		Path:      m.Path,
+		Exists:    m.Exists,
+		Size:      m.Size,
+		CheckedAt: m.CheckedAt,
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+// FromTaskOutput converts models.TaskOutput to TaskOutputModel
+func FromTaskOutput(to *models.TaskOutput) *TaskOutputModel {
+	return &TaskOutputModel{
+		ID:        to.ID,
+		TaskID:    to.TaskID,
+		Position:  to.Position,
+		Path:      to.Path,
+		Exists:    to.Exists,
+		Size:      to.Size,
+		CheckedAt: to.CheckedAt,
+		CreatedAt: to.CreatedAt,
+	}
+}
+
 // FromTaskStep converts models.TaskStep to TaskStepModel
 func FromTaskStep(ts *models.TaskStep) *TaskStepModel {
 	return &TaskStepModel{
@@ -121,6 +212,7 @@ func FromTaskStep(ts *models.TaskStep) *TaskStepModel {
 		Command:     ts.Command,
 		Status:      ts.Status,
 		ExitCode:    ts.ExitCode,
+		Attempts:    ts.Attempts,
 		Stdout:      ts.Stdout,
 		Stderr:      ts.Stderr,
 		StartedAt:   ts.StartedAt,
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/andi/fileaction/backend/workflow"
 	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
@@ -196,6 +197,37 @@ func (r *PluginRepo) GetPluginVersionByNumber(pluginName, version string) (*Plug
 	return versionModel.ToPluginVersion(), nil
 }
 
+// ResolvePluginVersion returns the best stored version of a plugin matching a
+// version constraint (exact, prefix, ^, ~ or comparison operators)
+func (r *PluginRepo) ResolvePluginVersion(pluginName, constraint string) (*PluginVersion, error) {
+	plugin, err := r.GetPluginByName(pluginName)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := r.GetPluginVersions(plugin.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make([]string, len(versions))
+	for i, v := range versions {
+		available[i] = v.Version
+	}
+
+	selected, err := workflow.SelectVersion(constraint, available)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", pluginName, err)
+	}
+
+	for _, v := range versions {
+		if v.Version == selected {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin %s: version %s not found", pluginName, selected)
+}
+
 // GetPluginCurrentVersion returns the current/latest version of a plugin
 func (r *PluginRepo) GetPluginCurrentVersion(pluginID string) (*PluginVersion, error) {
 	var plugin PluginModel
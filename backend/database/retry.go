@@ -0,0 +1,55 @@
+package database
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultMaxRetries is how often a write is retried after a transient error
+const defaultMaxRetries = 3
+
+// transientErrors are substrings of driver errors that go away on retry:
+// SQLite lock contention and MySQL deadlocks/lock timeouts
+var transientErrors = []string{
+	"database is locked",
+	"database table is locked",
+	"SQLITE_BUSY",
+	"Error 1213", // Deadlock found when trying to get lock
+	"Error 1205", // Lock wait timeout exceeded
+}
+
+// SetMaxRetries sets how often hot-path writes are retried after a transient
+// error. 0 disables retries.
+func (db *DB) SetMaxRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	db.maxRetries = n
+}
+
+// isTransient reports whether err is a lock conflict worth retrying
+func isTransient(err error) bool {
+	msg := err.Error()
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs op, retrying it with exponential backoff while it fails with
+// a transient error
+func (db *DB) withRetry(op func() error) error {
+	backoff := db.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= db.maxRetries || !isTransient(err) {
+			return err
+		}
+		log.Printf("Transient database error, retrying in %v (%d/%d): %v", backoff, attempt+1, db.maxRetries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
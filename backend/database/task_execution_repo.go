@@ -0,0 +1,51 @@
+package database
+
+import (
+	"github.com/andi/fileaction/backend/models"
+	"github.com/google/uuid"
+)
+
+// TaskExecutionRepo handles task execution record database operations
+type TaskExecutionRepo struct {
+	db *DB
+}
+
+// NewTaskExecutionRepo creates a new task execution repository
+func NewTaskExecutionRepo(db *DB) *TaskExecutionRepo {
+	return &TaskExecutionRepo{db: db}
+}
+
+// Create stores the execution record of a task run
+func (r *TaskExecutionRepo) Create(execution *models.TaskExecution) error {
+	if execution.ID == "" {
+		execution.ID = uuid.New().String()
+	}
+
+	model := FromTaskExecution(execution)
+	err := r.db.withRetry(func() error {
+		return r.db.conn.Create(model).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	*execution = *model.ToTaskExecution()
+	return nil
+}
+
+// GetLatestByTaskID retrieves the record of the most recent run of a task
+func (r *TaskExecutionRepo) GetLatestByTaskID(taskID string) (*models.TaskExecution, error) {
+	var model TaskExecutionModel
+	err := r.db.conn.Where("task_id = ?", taskID).
+		Order("created_at DESC").
+		First(&model).Error
+	if err != nil {
+		return nil, err
+	}
+	return model.ToTaskExecution(), nil
+}
+
+// DeleteByTaskID deletes all execution records of a task
+func (r *TaskExecutionRepo) DeleteByTaskID(taskID string) error {
+	return r.db.conn.Delete(&TaskExecutionModel{}, "task_id = ?", taskID).Error
+}
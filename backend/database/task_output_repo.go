@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TaskOutputRepo handles declared task output database operations
+type TaskOutputRepo struct {
+	db *DB
+}
+
+// NewTaskOutputRepo creates a new task output repository
+func NewTaskOutputRepo(db *DB) *TaskOutputRepo {
+	return &TaskOutputRepo{db: db}
+}
+
+// ReplaceForTask records the declared output paths of a task run, replacing
+// those of an earlier run
+func (r *TaskOutputRepo) ReplaceForTask(taskID string, paths []string) ([]*models.TaskOutput, error) {
+	modelList := make([]TaskOutputModel, len(paths))
+	for i, path := range paths {
+		modelList[i] = TaskOutputModel{ID: uuid.New().String(), TaskID: taskID, Position: i, Path: path}
+	}
+
+	err := r.db.withRetry(func() error {
+		return r.db.conn.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&TaskOutputModel{}, "task_id = ?", taskID).Error; err != nil {
+				return err
+			}
+			if len(modelList) == 0 {
+				return nil
+			}
+			return tx.Create(&modelList).Error
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]*models.TaskOutput, len(modelList))
+	for i := range modelList {
+		outputs[i] = modelList[i].ToTaskOutput()
+	}
+	return outputs, nil
+}
+
+// GetByTaskID retrieves the declared outputs of a task in declaration order
+func (r *TaskOutputRepo) GetByTaskID(taskID string) ([]*models.TaskOutput, error) {
+	var modelList []TaskOutputModel
+	err := r.db.conn.Where("task_id = ?", taskID).
+		Order("position").
+		Find(&modelList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make([]*models.TaskOutput, len(modelList))
+	for i, model := range modelList {
+		outputs[i] = model.ToTaskOutput()
+	}
+	return outputs, nil
+}
+
+// Update updates a declared output
+func (r *TaskOutputRepo) Update(output *models.TaskOutput) error {
+	model := FromTaskOutput(output)
+	var result *gorm.DB
+	err := r.db.withRetry(func() error {
+		result = r.db.conn.Save(model)
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("task output not found")
+	}
+	*output = *model.ToTaskOutput()
+	return nil
+}
@@ -1,11 +1,14 @@
 package database
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/andi/fileaction/backend/models"
-	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // TaskRepo handles task database operations
@@ -20,12 +23,19 @@ func NewTaskRepo(db *DB) *TaskRepo {
 
 // Create creates a new task
 func (r *TaskRepo) Create(task *models.Task) error {
+	if err := checkTaskPaths(task); err != nil {
+		return err
+	}
 	if task.ID == "" {
-		task.ID = uuid.New().String()
+		task.ID = r.db.newID()
 	}
+	setQueuedAt(task)
 
 	model := FromTask(task)
-	if err := r.db.conn.Create(model).Error; err != nil {
+	err := r.db.withRetry(func() error {
+		return r.db.conn.Create(model).Error
+	})
+	if err != nil {
 		return err
 	}
 
@@ -33,6 +43,45 @@ func (r *TaskRepo) Create(task *models.Task) error {
 	return nil
 }
 
+// setQueuedAt records when a new pending task was queued
+func setQueuedAt(task *models.Task) {
+	if task.QueuedAt == nil && (task.Status == "" || task.Status == models.TaskStatusPending) {
+		now := time.Now()
+		task.QueuedAt = &now
+	}
+}
+
+// CreateBatch creates several tasks with multi-row inserts in a single transaction
+func (r *TaskRepo) CreateBatch(tasks []*models.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	modelList := make([]*TaskModel, len(tasks))
+	for i, task := range tasks {
+		if err := checkTaskPaths(task); err != nil {
+			return err
+		}
+		if task.ID == "" {
+			task.ID = r.db.newID()
+		}
+		setQueuedAt(task)
+		modelList[i] = FromTask(task)
+	}
+
+	err := r.db.conn.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(modelList, 100).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, model := range modelList {
+		*tasks[i] = *model.ToTask()
+	}
+	return nil
+}
+
 // GetByID retrieves a task by ID
 func (r *TaskRepo) GetByID(id string) (*models.Task, error) {
 	var model TaskModel
@@ -42,19 +91,129 @@ func (r *TaskRepo) GetByID(id string) (*models.Task, error) {
 	return model.ToTask(), nil
 }
 
+// GetIncludingArchived retrieves a task by ID even if it has been archived
+func (r *TaskRepo) GetIncludingArchived(id string) (*models.Task, error) {
+	var model TaskModel
+	if err := r.db.conn.Unscoped().Where("id = ?", id).First(&model).Error; err != nil {
+		return nil, fmt.Errorf("task not found")
+	}
+	return model.ToTask(), nil
+}
+
+// TaskFilter narrows task listings; empty fields are not filtered on
+type TaskFilter struct {
+	WorkflowID      string
+	Status          string
+	Priority        *int
+	IncludeArchived bool // Also match archived tasks
+}
+
+// apply adds the filter's conditions to a query
+func (f TaskFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.IncludeArchived {
+		query = query.Unscoped()
+	}
+	if f.WorkflowID != "" {
+		query = query.Where("workflow_id = ?", f.WorkflowID)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Priority != nil {
+		query = query.Where("priority = ?", *f.Priority)
+	}
+	return query
+}
+
 // List retrieves tasks with optional filters
 func (r *TaskRepo) List(workflowID, status string, limit, offset int) ([]*models.Task, error) {
-	query := r.db.conn.Model(&TaskModel{})
+	return r.ListFiltered(TaskFilter{WorkflowID: workflowID, Status: status}, limit, offset)
+}
+
+// ListFiltered retrieves tasks matching a filter, newest first
+func (r *TaskRepo) ListFiltered(filter TaskFilter, limit, offset int) ([]*models.Task, error) {
+	query := filter.apply(r.db.conn.Model(&TaskModel{}))
+	return r.findTasks(query.Offset(offset), limit)
+}
+
+// TaskCursor marks a position in the newest-first task listing
+type TaskCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
 
-	if workflowID != "" {
-		query = query.Where("workflow_id = ?", workflowID)
+// NewTaskCursor returns the cursor positioned after task
+func NewTaskCursor(task *models.Task) TaskCursor {
+	return TaskCursor{CreatedAt: task.CreatedAt, ID: task.ID}
+}
+
+// String encodes the cursor as an opaque URL-safe token
+func (c TaskCursor) String() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseTaskCursor decodes a token returned by TaskCursor.String
+func ParseTaskCursor(token string) (TaskCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return TaskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return TaskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return TaskCursor{}, fmt.Errorf("invalid cursor")
+	}
+	// Stored timestamps are in local time; SQLite compares them as text
+	return TaskCursor{CreatedAt: t.Local(), ID: id}, nil
+}
+
+// ListAfter retrieves tasks matching a filter that come after cursor in the
+// newest-first listing. Unlike an offset it seeks on the (created_at, id)
+// position, so deep pages cost the same as the first.
+func (r *TaskRepo) ListAfter(filter TaskFilter, cursor TaskCursor, limit int) ([]*models.Task, error) {
+	query := filter.apply(r.db.conn.Model(&TaskModel{})).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	return r.findTasks(query, limit)
+}
+
+// findTasks runs a task listing query, newest first with the ID as tie breaker
+func (r *TaskRepo) findTasks(query *gorm.DB, limit int) ([]*models.Task, error) {
+	var modelList []TaskModel
+	err := query.Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&modelList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*models.Task, len(modelList))
+	for i, model := range modelList {
+		tasks[i] = model.ToTask()
 	}
+	return tasks, nil
+}
+
+// ListCreatedBetween retrieves tasks of a workflow created in [since, until),
+// oldest first. Zero times and an empty status are not filtered on.
+func (r *TaskRepo) ListCreatedBetween(workflowID, status string, since, until time.Time, limit, offset int) ([]*models.Task, error) {
+	query := r.db.conn.Model(&TaskModel{}).Where("workflow_id = ?", workflowID)
+
 	if status != "" {
 		query = query.Where("status = ?", status)
 	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+	if !until.IsZero() {
+		query = query.Where("created_at < ?", until)
+	}
 
 	var modelList []TaskModel
-	err := query.Order("created_at DESC").
+	err := query.Order("created_at ASC").Order("id ASC").
 		Limit(limit).
 		Offset(offset).
 		Find(&modelList).Error
@@ -69,28 +228,142 @@ func (r *TaskRepo) List(workflowID, status string, limit, offset int) ([]*models
 	return tasks, nil
 }
 
+// CloneAsPending creates a pending copy of a task for the same file, workflow
+// and output, linked to the original through ParentTaskID. The original task
+// and its steps and logs are left as they are.
+func (r *TaskRepo) CloneAsPending(id string) (*models.Task, error) {
+	original, err := r.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := &models.Task{
+		WorkflowID:   original.WorkflowID,
+		FileID:       original.FileID,
+		InputPath:    original.InputPath,
+		OutputPath:   original.OutputPath,
+		InputMD5:     original.InputMD5,
+		Priority:     original.Priority,
+		ChainDepth:   original.ChainDepth,
+		Env:          original.Env,
+		ParentTaskID: original.ID,
+		Status:       models.TaskStatusPending,
+	}
+	if err := r.Create(clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// HasPendingTask reports whether a workflow already has a task for an input
+// file that will process its content with the given hash: a pending task, which
+// reads the file when it starts, or a running task started on that content
+func (r *TaskRepo) HasPendingTask(workflowID, inputPath, inputMD5 string) (bool, error) {
+	var count int64
+	err := r.db.conn.Model(&TaskModel{}).
+		Where("workflow_id = ? AND input_path = ?", workflowID, inputPath).
+		Where("status = ? OR (status = ? AND input_md5 = ?)",
+			models.TaskStatusPending, models.TaskStatusRunning, inputMD5).
+		Limit(1).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// PendingFileIDs returns the IDs of files of a workflow that already have a pending task
+func (r *TaskRepo) PendingFileIDs(workflowID string) (map[string]bool, error) {
+	var fileIDs []string
+	err := r.db.conn.Model(&TaskModel{}).
+		Where("workflow_id = ? AND status = ?", workflowID, models.TaskStatusPending).
+		Distinct().
+		Pluck("file_id", &fileIDs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(fileIDs))
+	for _, id := range fileIDs {
+		result[id] = true
+	}
+	return result, nil
+}
+
+// OutputPathsByFile returns the distinct output paths of a workflow's tasks for a file
+func (r *TaskRepo) OutputPathsByFile(workflowID, fileID string) ([]string, error) {
+	var outputPaths []string
+	err := r.db.conn.Model(&TaskModel{}).
+		Where("workflow_id = ? AND file_id = ?", workflowID, fileID).
+		Distinct().
+		Pluck("output_path", &outputPaths).Error
+	return outputPaths, err
+}
+
 // Count counts tasks with optional filters
 func (r *TaskRepo) Count(workflowID, status string) (int, error) {
-	query := r.db.conn.Model(&TaskModel{})
+	return r.CountFiltered(TaskFilter{WorkflowID: workflowID, Status: status})
+}
 
-	if workflowID != "" {
-		query = query.Where("workflow_id = ?", workflowID)
+// CountByWorkflow counts tasks with a status, keyed by workflow ID
+func (r *TaskRepo) CountByWorkflow(status string) (map[string]int, error) {
+	var rows []struct {
+		WorkflowID string
+		Count      int
 	}
-	if status != "" {
-		query = query.Where("status = ?", status)
+	err := r.db.conn.Model(&TaskModel{}).
+		Select("workflow_id, COUNT(*) AS count").
+		Where("status = ?", status).
+		Group("workflow_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.WorkflowID] = row.Count
 	}
+	return counts, nil
+}
+
+// CountFiltered counts tasks matching a filter
+func (r *TaskRepo) CountFiltered(filter TaskFilter) (int, error) {
+	query := filter.apply(r.db.conn.Model(&TaskModel{}))
 
 	var count int64
 	err := query.Count(&count).Error
 	return int(count), err
 }
 
+// AverageDuration returns the mean run time of a workflow's completed tasks
+// and how many tasks it covers
+func (r *TaskRepo) AverageDuration(workflowID string) (time.Duration, int, error) {
+	var row struct {
+		AvgMillis *float64
+		Count     int
+	}
+	err := r.db.conn.Model(&TaskModel{}).
+		Select("AVG("+r.db.millisBetween("started_at", "completed_at")+") AS avg_millis, COUNT(*) AS count").
+		Where("workflow_id = ? AND status = ?", workflowID, models.TaskStatusCompleted).
+		Where("started_at IS NOT NULL AND completed_at IS NOT NULL").
+		Scan(&row).Error
+	if err != nil || row.AvgMillis == nil {
+		return 0, 0, err
+	}
+	return time.Duration(*row.AvgMillis * float64(time.Millisecond)), row.Count, nil
+}
+
 // Update updates a task
 func (r *TaskRepo) Update(task *models.Task) error {
 	model := FromTask(task)
-	result := r.db.conn.Save(model)
-	if result.Error != nil {
+	var result *gorm.DB
+	err := r.db.withRetry(func() error {
+		// Archiving is left to Delete: a copy read before the task was archived
+		// must not bring it back. Selecting the columns also keeps Save from
+		// recreating an archived task it cannot update.
+		result = r.db.conn.Select("*").Omit("deleted_at").Save(model)
 		return result.Error
+	})
+	if err != nil {
+		return err
 	}
 	if result.RowsAffected == 0 {
 		return fmt.Errorf("task not found")
@@ -111,7 +384,61 @@ func (r *TaskRepo) UpdateStatus(id, status string) error {
 	return nil
 }
 
-// Delete deletes a task
+// MarkCancelled sets a task's status to cancelled and records why
+func (r *TaskRepo) MarkCancelled(id, reason string) error {
+	result := r.db.conn.Model(&TaskModel{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":        models.TaskStatusCancelled,
+		"cancel_reason": reason,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("task not found")
+	}
+	return nil
+}
+
+// CancelStalePending cancels the pending tasks of a workflow queued before the
+// given time, recording reason, and returns how many were cancelled. A retried
+// task counts from its retry.
+func (r *TaskRepo) CancelStalePending(workflowID string, before time.Time, reason string) (int64, error) {
+	now := time.Now()
+	result := r.db.conn.Model(&TaskModel{}).
+		Where("workflow_id = ? AND status = ? AND COALESCE(queued_at, created_at) < ?", workflowID, models.TaskStatusPending, before).
+		Updates(map[string]interface{}{
+			"status":        models.TaskStatusCancelled,
+			"cancel_reason": reason,
+			"error_message": fmt.Sprintf("Task cancelled (%s)", reason),
+			"completed_at":  now,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// CancelPending cancels the pending tasks matching a workflow filter, recording
+// reason, and returns how many were cancelled. Tasks that already left the
+// pending state are not touched.
+func (r *TaskRepo) CancelPending(workflowID, reason string) (int64, error) {
+	now := time.Now()
+	query := TaskFilter{WorkflowID: workflowID, Status: models.TaskStatusPending}.apply(r.db.conn.Model(&TaskModel{}))
+	result := query.Updates(map[string]interface{}{
+		"status":        models.TaskStatusCancelled,
+		"cancel_reason": reason,
+		"error_message": fmt.Sprintf("Task cancelled (%s)", reason),
+		"completed_at":  now,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// ListIDs returns the IDs of all tasks matching a filter
+func (r *TaskRepo) ListIDs(filter TaskFilter) ([]string, error) {
+	var ids []string
+	err := filter.apply(r.db.conn.Model(&TaskModel{})).Order("created_at").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// Delete archives a task. Archived tasks are hidden from every query except
+// those asking for them and are removed for good by PurgeArchived.
 func (r *TaskRepo) Delete(id string) error {
 	result := r.db.conn.Delete(&TaskModel{}, "id = ?", id)
 	if result.Error != nil {
@@ -123,16 +450,80 @@ func (r *TaskRepo) Delete(id string) error {
 	return nil
 }
 
-// DeleteByWorkflow deletes all tasks for a workflow
+// DeleteByWorkflow archives all tasks for a workflow
 func (r *TaskRepo) DeleteByWorkflow(workflowID string) error {
 	return r.db.conn.Delete(&TaskModel{}, "workflow_id = ?", workflowID).Error
 }
 
-// GetPendingTasks retrieves all pending tasks
-func (r *TaskRepo) GetPendingTasks(limit int) ([]*models.Task, error) {
+// ArchiveFinishedBefore archives completed, failed and cancelled tasks that
+// finished before the given time and returns how many were archived. Tasks
+// without a completion time are aged by their last update.
+func (r *TaskRepo) ArchiveFinishedBefore(before time.Time) (int64, error) {
+	var archived int64
+	err := r.db.withRetry(func() error {
+		result := r.db.conn.
+			Where("status IN ?", []string{models.TaskStatusCompleted, models.TaskStatusFailed, models.TaskStatusCancelled}).
+			Where("COALESCE(completed_at, updated_at) < ?", before).
+			Delete(&TaskModel{})
+		archived = result.RowsAffected
+		return result.Error
+	})
+	return archived, err
+}
+
+// purgeBatchSize bounds the IDs bound in one purge statement
+const purgeBatchSize = 500
+
+// PurgeArchived permanently deletes tasks archived before the given time,
+// together with their steps, execution records and declared outputs, and
+// returns how many tasks were removed
+func (r *TaskRepo) PurgeArchived(before time.Time) (int64, error) {
+	var ids []string
+	err := r.db.conn.Unscoped().Model(&TaskModel{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for start := 0; start < len(ids); start += purgeBatchSize {
+		batch := ids[start:min(start+purgeBatchSize, len(ids))]
+		err := r.db.withRetry(func() error {
+			return r.db.conn.Transaction(func(tx *gorm.DB) error {
+				for _, related := range []interface{}{&TaskStepModel{}, &TaskExecutionModel{}, &TaskOutputModel{}} {
+					if err := tx.Where("task_id IN ?", batch).Delete(related).Error; err != nil {
+						return err
+					}
+				}
+				return tx.Unscoped().Where("id IN ?", batch).Delete(&TaskModel{}).Error
+			})
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(len(batch))
+	}
+	return purged, nil
+}
+
+// heldWorkflowIDs selects the workflows whose pending tasks are not dispatched:
+// the disabled and the paused ones
+func (r *TaskRepo) heldWorkflowIDs() *gorm.DB {
+	return r.db.conn.Model(&WorkflowModel{}).Select("id").Where("enabled = ? OR paused = ?", false, true)
+}
+
+// GetPendingTasks retrieves pending tasks in dispatch order, skipping those of
+// the given workflows and of disabled or paused ones
+func (r *TaskRepo) GetPendingTasks(limit int, excludeWorkflowIDs ...string) ([]*models.Task, error) {
 	var modelList []TaskModel
-	err := r.db.conn.Where("status = ?", models.TaskStatusPending).
-		Order("created_at").
+	query := r.db.conn.Where("status = ?", models.TaskStatusPending).
+		Where("workflow_id NOT IN (?)", r.heldWorkflowIDs())
+	if len(excludeWorkflowIDs) > 0 {
+		query = query.Where("workflow_id NOT IN ?", excludeWorkflowIDs)
+	}
+	err := query.
+		Order("priority DESC, created_at, id").
 		Limit(limit).
 		Find(&modelList).Error
 	if err != nil {
@@ -152,12 +543,66 @@ func (r *TaskRepo) GetPendingTasks(limit int) ([]*models.Task, error) {
 	return tasks, nil
 }
 
+// QueuePosition is where a task stands in the pending queue. Positions are
+// 1-based and 0 for tasks that are not pending or whose workflow is held.
+type QueuePosition struct {
+	TaskID           string `json:"task_id"`
+	Status           string `json:"status"`
+	Held             bool   `json:"held,omitempty"`    // Its workflow is disabled or paused
+	Position         int    `json:"position"`          // Among all pending tasks
+	WorkflowPosition int    `json:"workflow_position"` // Among the pending tasks of the same workflow
+}
+
+// QueuePosition counts the pending tasks the scheduler will dispatch before the
+// given one, using the same ordering and skipping the same workflows as
+// GetPendingTasks
+func (r *TaskRepo) QueuePosition(taskID string) (*QueuePosition, error) {
+	var model TaskModel
+	if err := r.db.conn.Where("id = ?", taskID).First(&model).Error; err != nil {
+		return nil, err
+	}
+
+	pos := &QueuePosition{TaskID: model.ID, Status: model.Status}
+	if model.Status != models.TaskStatusPending {
+		return pos, nil
+	}
+
+	var held int64
+	if err := r.heldWorkflowIDs().Where("id = ?", model.WorkflowID).Count(&held).Error; err != nil {
+		return nil, err
+	}
+	if held > 0 {
+		pos.Held = true
+		return pos, nil
+	}
+
+	ahead := r.db.conn.Model(&TaskModel{}).
+		Where("status = ?", models.TaskStatusPending).
+		Where("workflow_id NOT IN (?)", r.heldWorkflowIDs()).
+		Where("priority > ? OR (priority = ? AND (created_at < ? OR (created_at = ? AND id < ?)))",
+			model.Priority, model.Priority, model.CreatedAt, model.CreatedAt, model.ID)
+
+	var global, inWorkflow int64
+	if err := ahead.Session(&gorm.Session{}).Count(&global).Error; err != nil {
+		return nil, err
+	}
+	if err := ahead.Session(&gorm.Session{}).Where("workflow_id = ?", model.WorkflowID).Count(&inWorkflow).Error; err != nil {
+		return nil, err
+	}
+	pos.Position = int(global) + 1
+	pos.WorkflowPosition = int(inWorkflow) + 1
+	return pos, nil
+}
+
 // ResetRunningTasks resets all running tasks to pending status
 // This should be called on application startup to handle tasks that were interrupted
 func (r *TaskRepo) ResetRunningTasks() (int, error) {
 	result := r.db.conn.Model(&TaskModel{}).
 		Where("status = ?", models.TaskStatusRunning).
-		Update("status", models.TaskStatusPending)
+		Updates(map[string]interface{}{
+			"status":    models.TaskStatusPending,
+			"queued_at": time.Now(),
+		})
 
 	if result.Error != nil {
 		return 0, result.Error
@@ -2,9 +2,11 @@ package database
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/andi/fileaction/backend/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // TaskStepRepo handles task step database operations
@@ -49,12 +51,73 @@ func (r *TaskStepRepo) GetByTaskID(taskID string) ([]*models.TaskStep, error) {
 	return steps, nil
 }
 
+// LatestStatuses returns the status of the latest run of each of the named
+// steps of a task. A plugin step has no record of its own: its plugin steps are
+// recorded as "<step> / <plugin step>", and it takes the first of their
+// statuses that is not completed or skipped, or completed. Steps that never
+// ran are left out.
+func (r *TaskStepRepo) LatestStatuses(taskID string, names []string) (map[string]string, error) {
+	steps, err := r.GetByTaskID(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Steps are ordered by creation, so the last record of a name is its latest run
+	latest := make(map[string]string)
+	for _, step := range steps {
+		latest[step.Name] = step.Status
+	}
+
+	statuses := make(map[string]string)
+	for _, name := range names {
+		if status, ok := latest[name]; ok {
+			statuses[name] = status
+			continue
+		}
+		for _, step := range steps {
+			if !strings.HasPrefix(step.Name, name+" / ") {
+				continue
+			}
+			status := latest[step.Name]
+			if status == models.StepStatusCompleted || status == models.StepStatusSkipped {
+				status = models.StepStatusCompleted
+			}
+			if current, ok := statuses[name]; !ok || current == models.StepStatusCompleted {
+				statuses[name] = status
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// GetFailedByTaskID retrieves the failed and timed out steps of a task
+func (r *TaskStepRepo) GetFailedByTaskID(taskID string) ([]*models.TaskStep, error) {
+	var modelList []TaskStepModel
+	err := r.db.conn.Where("task_id = ? AND status IN ?", taskID,
+		[]string{models.StepStatusFailed, models.StepStatusTimedOut}).
+		Order("created_at").
+		Find(&modelList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]*models.TaskStep, len(modelList))
+	for i, model := range modelList {
+		steps[i] = model.ToTaskStep()
+	}
+	return steps, nil
+}
+
 // Update updates a task step
 func (r *TaskStepRepo) Update(step *models.TaskStep) error {
 	model := FromTaskStep(step)
-	result := r.db.conn.Save(model)
-	if result.Error != nil {
+	var result *gorm.DB
+	err := r.db.withRetry(func() error {
+		result = r.db.conn.Save(model)
 		return result.Error
+	})
+	if err != nil {
+		return err
 	}
 	if result.RowsAffected == 0 {
 		return fmt.Errorf("task step not found")
@@ -2,9 +2,11 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/andi/fileaction/backend/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // WorkflowRepo handles workflow database operations
@@ -64,16 +66,80 @@ func (r *WorkflowRepo) List() ([]*models.Workflow, error) {
 	return workflows, nil
 }
 
-// Update updates a workflow
+// Update updates a workflow. The baseline marker is only set by MarkBaseline.
 func (r *WorkflowRepo) Update(workflow *models.Workflow) error {
 	model := FromWorkflow(workflow)
-	if err := r.db.conn.Save(model).Error; err != nil {
+	if err := r.db.conn.Omit("baseline_at").Save(model).Error; err != nil {
 		return err
 	}
 	*workflow = *model.ToWorkflow()
 	return nil
 }
 
+// MarkBaseline records that the baseline scan of a workflow has run, so later
+// scans queue tasks for the files it indexed
+func (r *WorkflowRepo) MarkBaseline(id string, at time.Time) error {
+	result := r.db.conn.Model(&WorkflowModel{}).Where("id = ?", id).Update("baseline_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("workflow not found")
+	}
+	return nil
+}
+
+// SaveAllByName creates or updates each workflow, matched by name, in a single
+// transaction. Existing workflows keep their ID, enabled and paused state. created
+// reports which workflows were new. If a save fails nothing is written and
+// failed is the index of the workflow that failed, otherwise it is -1.
+func (r *WorkflowRepo) SaveAllByName(workflows []*models.Workflow) (created []bool, failed int, err error) {
+	created = make([]bool, len(workflows))
+	saved := make([]*WorkflowModel, len(workflows))
+	failed = -1
+	err = r.db.conn.Transaction(func(tx *gorm.DB) error {
+		for i, workflow := range workflows {
+			var existing WorkflowModel
+			result := tx.Where("name = ?", workflow.Name).Limit(1).Find(&existing)
+			if result.Error != nil {
+				failed = i
+				return result.Error
+			}
+
+			model := FromWorkflow(workflow)
+			var saveErr error
+			if result.RowsAffected > 0 {
+				model.ID = existing.ID
+				model.Enabled = existing.Enabled
+				model.Paused = existing.Paused
+				model.BaselineAt = existing.BaselineAt
+				model.CreatedAt = existing.CreatedAt
+				saveErr = tx.Save(model).Error
+			} else {
+				if model.ID == "" {
+					model.ID = uuid.New().String()
+				}
+				created[i] = true
+				saveErr = tx.Create(model).Error
+			}
+			if saveErr != nil {
+				failed = i
+				return saveErr
+			}
+			saved[i] = model
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, failed, err
+	}
+
+	for i, model := range saved {
+		*workflows[i] = *model.ToWorkflow()
+	}
+	return created, -1, nil
+}
+
 // Delete deletes a workflow
 func (r *WorkflowRepo) Delete(id string) error {
 	result := r.db.conn.Delete(&WorkflowModel{}, "id = ?", id)
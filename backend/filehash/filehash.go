@@ -0,0 +1,62 @@
+package filehash
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Supported hash algorithms
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+)
+
+// Default is the algorithm used when none is configured
+const Default = MD5
+
+// New returns a hash for the named algorithm
+func New(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case MD5:
+		return md5.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q (expected md5, sha1 or sha256)", algorithm)
+	}
+}
+
+// Sum hashes everything read from r, returning the hex digest and the number of bytes read
+func Sum(r io.Reader, algorithm string) (string, int64, error) {
+	h, err := New(algorithm)
+	if err != nil {
+		return "", 0, err
+	}
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), size, nil
+}
+
+// AlgorithmOf guesses the algorithm that produced a hex digest from its
+// length, returning "" if it matches none of them
+func AlgorithmOf(digest string) string {
+	switch len(digest) {
+	case 2 * md5.Size:
+		return MD5
+	case 2 * sha1.Size:
+		return SHA1
+	case 2 * sha256.Size:
+		return SHA256
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,189 @@
+package logsink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options configures an S3-compatible log sink
+type S3Options struct {
+	Endpoint  string // e.g. https://s3.amazonaws.com or http://minio:9000
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3Sink stores task logs in an S3-compatible object store using
+// path-style requests signed with AWS Signature Version 4
+type S3Sink struct {
+	opts   S3Options
+	client *http.Client
+}
+
+// NewS3Sink creates a new S3-compatible log sink
+func NewS3Sink(opts S3Options) (*S3Sink, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("s3 endpoint is required")
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+
+	return &S3Sink{
+		opts:   opts,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Put uploads the log content and returns its object key
+func (s *S3Sink) Put(taskID string, content []byte) (string, error) {
+	key := fmt.Sprintf("%s.log", taskID)
+	if s.opts.Prefix != "" {
+		key = strings.TrimSuffix(s.opts.Prefix, "/") + "/" + key
+	}
+
+	resp, err := s.do(http.MethodPut, key, content)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", s.responseError("upload", key, resp)
+	}
+	return key, nil
+}
+
+// Get downloads the log content for a key
+func (s *S3Sink) Get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, s.responseError("download", key, resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes the object for a key
+func (s *S3Sink) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s.responseError("delete", key, resp)
+	}
+	return nil
+}
+
+// responseError builds an error from a non-2xx S3 response
+func (s *S3Sink) responseError(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s of %s failed: %s: %s", op, key, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// do builds, signs and sends a request for the given object key
+func (s *S3Sink) do(method, key string, body []byte) (*http.Response, error) {
+	segments := strings.Split(s.opts.Bucket+"/"+key, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	canonicalURI := "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequest(method, s.opts.Endpoint+canonicalURI, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	s.sign(req, canonicalURI, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to the request
+func (s *S3Sink) sign(req *http.Request, canonicalURI string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, s.opts.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), dateStamp)
+	signingKey = hmacSHA256(signingKey, s.opts.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,52 @@
+package logsink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Sink stores completed task logs outside of the database.
+// Implementations return an object key that is persisted on the task
+// and later used to fetch the log content back.
+type Sink interface {
+	Put(taskID string, content []byte) (string, error)
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// FilesystemSink stores task logs as files under a base directory
+type FilesystemSink struct {
+	dir string
+}
+
+// NewFilesystemSink creates a new filesystem log sink
+func NewFilesystemSink(dir string) (*FilesystemSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log sink directory: %w", err)
+	}
+	return &FilesystemSink{dir: dir}, nil
+}
+
+// Put writes the log content to <dir>/<taskID>.log
+func (s *FilesystemSink) Put(taskID string, content []byte) (string, error) {
+	key := fmt.Sprintf("%s.log", taskID)
+	if err := os.WriteFile(filepath.Join(s.dir, key), content, 0644); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Get reads the log content for a key
+func (s *FilesystemSink) Get(key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.Base(key)))
+}
+
+// Delete removes the log content for a key
+func (s *FilesystemSink) Delete(key string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.Base(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
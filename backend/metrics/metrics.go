@@ -0,0 +1,192 @@
+// Package metrics collects task counters and durations and writes them in the
+// Prometheus text exposition format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the task duration histogram
+var durationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 10800}
+
+// Registry holds task counters and duration histograms labelled by workflow
+type Registry struct {
+	mu        sync.Mutex
+	created   map[string]uint64
+	finished  map[finishedKey]uint64
+	durations map[string]*histogram
+}
+
+type finishedKey struct {
+	workflow string
+	status   string
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		created:   make(map[string]uint64),
+		finished:  make(map[finishedKey]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// Default is the registry the watcher, scheduler and API report to
+var Default = NewRegistry()
+
+// TasksCreated counts n tasks queued for a workflow in the default registry
+func TasksCreated(workflow string, n int) {
+	Default.TasksCreated(workflow, n)
+}
+
+// TaskFinished records a task run ending in the default registry
+func TaskFinished(workflow, status string, duration time.Duration) {
+	Default.TaskFinished(workflow, status, duration)
+}
+
+// TasksCancelled records n pending tasks cancelled in the default registry
+func TasksCancelled(workflow string, n int) {
+	Default.TasksCancelled(workflow, n)
+}
+
+// TasksCreated counts n tasks queued for a workflow
+func (r *Registry) TasksCreated(workflow string, n int) {
+	if n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created[workflow] += uint64(n)
+}
+
+// TaskFinished counts a task run ending with the given status and adds its
+// duration to the workflow's histogram
+func (r *Registry) TaskFinished(workflow, status string, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finished[finishedKey{workflow, status}]++
+
+	h, ok := r.durations[workflow]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		r.durations[workflow] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// TasksCancelled counts n pending tasks of a workflow cancelled before they
+// started. They end as cancelled but never ran, so no duration is recorded.
+func (r *Registry) TasksCancelled(workflow string, n int) {
+	if n <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished[finishedKey{workflow, "cancelled"}] += uint64(n)
+}
+
+// Write writes the counters and histograms in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	writeHeader(w, "fileaction_tasks_created_total", "counter", "Tasks queued, by workflow.")
+	for _, workflow := range sortedKeys(r.created) {
+		writeSample(w, "fileaction_tasks_created_total", float64(r.created[workflow]), "workflow", workflow)
+	}
+
+	finished := make([]finishedKey, 0, len(r.finished))
+	for key := range r.finished {
+		finished = append(finished, key)
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		if finished[i].workflow != finished[j].workflow {
+			return finished[i].workflow < finished[j].workflow
+		}
+		return finished[i].status < finished[j].status
+	})
+	writeHeader(w, "fileaction_tasks_finished_total", "counter", "Task runs that ended, by workflow and status (completed, failed or cancelled).")
+	for _, key := range finished {
+		writeSample(w, "fileaction_tasks_finished_total", float64(r.finished[key]), "workflow", key.workflow, "status", key.status)
+	}
+
+	writeHeader(w, "fileaction_task_duration_seconds", "histogram", "Run time of finished tasks, by workflow.")
+	for _, workflow := range sortedKeys(r.durations) {
+		h := r.durations[workflow]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			writeSample(w, "fileaction_task_duration_seconds_bucket", float64(cumulative), "workflow", workflow, "le", formatFloat(bound))
+		}
+		writeSample(w, "fileaction_task_duration_seconds_bucket", float64(h.count), "workflow", workflow, "le", "+Inf")
+		writeSample(w, "fileaction_task_duration_seconds_sum", h.sum, "workflow", workflow)
+		writeSample(w, "fileaction_task_duration_seconds_count", float64(h.count), "workflow", workflow)
+	}
+}
+
+// Sample is one value of a gauge with its label pairs
+type Sample struct {
+	Labels []string // Alternating names and values
+	Value  float64
+}
+
+// WriteGauge writes a gauge computed at scrape time in the Prometheus text format
+func WriteGauge(w io.Writer, name, help string, samples ...Sample) {
+	writeHeader(w, name, "gauge", help)
+	for _, sample := range samples {
+		writeSample(w, name, sample.Value, sample.Labels...)
+	}
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w io.Writer, name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(value))
+}
+
+// labelEscaper escapes label values as the text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
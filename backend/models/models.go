@@ -6,13 +6,15 @@ import (
 
 // Workflow represents a workflow definition
 type Workflow struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	YAMLContent string    `json:"yaml_content"`
-	Enabled     bool      `json:"enabled"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	YAMLContent string     `json:"yaml_content"`
+	Enabled     bool       `json:"enabled"`
+	Paused      bool       `json:"paused"`                // Files are watched and indexed but no tasks are queued
+	BaselineAt  *time.Time `json:"baseline_at,omitempty"` // When the scan for options.baseline indexed the existing files
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
 // File represents an indexed file
@@ -22,6 +24,9 @@ type File struct {
 	FilePath      string    `json:"file_path"`
 	FileMD5       string    `json:"file_md5"`
 	FileSize      int64     `json:"file_size"`
+	ModTime       int64     `json:"mtime,omitempty"`    // Modification time in Unix nanoseconds when the file was last hashed
+	Stale         bool      `json:"stale,omitempty"`    // The source was removed; its outputs were kept
+	Deferred      bool      `json:"deferred,omitempty"` // Changed while the workflow was paused; no task was queued yet
 	LastScannedAt time.Time `json:"last_scanned_at"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
@@ -29,18 +34,30 @@ type File struct {
 
 // Task represents a conversion task
 type Task struct {
-	ID           string     `json:"id"`
-	WorkflowID   string     `json:"workflow_id"`
-	FileID       string     `json:"file_id"`
-	InputPath    string     `json:"input_path"`
-	OutputPath   string     `json:"output_path"`
-	Status       string     `json:"status"` // pending, running, completed, failed, cancelled
-	LogText      string     `json:"log_text,omitempty"`
-	ErrorMessage string     `json:"error_message,omitempty"`
-	StartedAt    *time.Time `json:"started_at,omitempty"`
-	CompletedAt  *time.Time `json:"completed_at,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID           string            `json:"id"`
+	WorkflowID   string            `json:"workflow_id"`
+	FileID       string            `json:"file_id"`
+	InputPath    string            `json:"input_path"`
+	OutputPath   string            `json:"output_path"`
+	InputMD5     string            `json:"input_md5,omitempty"` // Input hash when the task was queued
+	Status       string            `json:"status"`              // pending, running, completed, failed, cancelled
+	LogText      string            `json:"log_text,omitempty"`
+	LogKey       string            `json:"log_key,omitempty"` // Object key when the log is stored in an external sink
+	ErrorMessage string            `json:"error_message,omitempty"`
+	OutputSize   int64             `json:"output_size,omitempty"` // Recorded when the workflow verifies its output
+	OutputMD5    string            `json:"output_md5,omitempty"`
+	CancelReason string            `json:"cancel_reason,omitempty"`  // Why a cancelled task was stopped
+	ResumeFrom   int               `json:"resume_from,omitempty"`    // 1-based step a retried task resumes from; earlier steps are not rerun
+	Priority     int               `json:"priority"`                 // Copied from the workflow when queued; higher runs first
+	ChainDepth   int               `json:"chain_depth,omitempty"`    // Number of trigger_workflow hops that led to this task
+	Env          map[string]string `json:"env,omitempty"`            // Overrides applied on top of the workflow, plugin and step env
+	ParentTaskID string            `json:"parent_task_id,omitempty"` // Task this one was rerun from
+	QueuedAt     *time.Time        `json:"queued_at,omitempty"`      // When the task last became pending; pending_ttl counts from here
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	ArchivedAt   *time.Time        `json:"archived_at,omitempty"` // Set when the task was deleted; purged later
 }
 
 // TaskStep represents a step within a task
@@ -49,8 +66,9 @@ type TaskStep struct {
 	TaskID      string     `json:"task_id"`
 	Name        string     `json:"name"`
 	Command     string     `json:"command"`
-	Status      string     `json:"status"` // pending, running, completed, failed, skipped
+	Status      string     `json:"status"` // pending, running, completed, failed, timedout, skipped
 	ExitCode    *int       `json:"exit_code,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
 	Stdout      string     `json:"stdout,omitempty"`
 	Stderr      string     `json:"stderr,omitempty"`
 	StartedAt   *time.Time `json:"started_at,omitempty"`
@@ -59,6 +77,26 @@ type TaskStep struct {
 	UpdatedAt   time.Time  `json:"updated_at"`
 }
 
+// TaskExecution stores the full execution record of one run of a task as JSON
+type TaskExecution struct {
+	ID        string    `json:"id"`
+	TaskID    string    `json:"task_id"`
+	Record    string    `json:"record"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TaskOutput is an additional output file declared by a task's workflow
+type TaskOutput struct {
+	ID        string     `json:"id"`
+	TaskID    string     `json:"task_id"`
+	Position  int        `json:"position"`
+	Path      string     `json:"path"`
+	Exists    bool       `json:"exists"`
+	Size      int64      `json:"size"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"` // When the task finished and the file was looked for
+	CreatedAt time.Time  `json:"created_at"`
+}
+
 // TaskStatus constants
 const (
 	TaskStatusPending   = "pending"
@@ -68,11 +106,21 @@ const (
 	TaskStatusCancelled = "cancelled"
 )
 
+// CancelReason constants record why a task was cancelled
+const (
+	CancelReasonUser             = "user"
+	CancelReasonShutdown         = "shutdown"
+	CancelReasonWorkflowDisabled = "workflow_disabled"
+	CancelReasonSuperseded       = "superseded"
+	CancelReasonPendingTTL       = "pending_ttl"
+)
+
 // StepStatus constants
 const (
 	StepStatusPending   = "pending"
 	StepStatusRunning   = "running"
 	StepStatusCompleted = "completed"
 	StepStatusFailed    = "failed"
+	StepStatusTimedOut  = "timedout"
 	StepStatusSkipped   = "skipped"
 )
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// atomicTempPath returns the temporary path steps write to with
+// options.atomic_output. It sits next to the output so publishing it is a
+// rename on the same filesystem, and keeps the extension for tools that pick
+// the format from it.
+func atomicTempPath(outputPath, taskID string) string {
+	dir := filepath.Dir(outputPath)
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	if len(taskID) > 8 {
+		taskID = taskID[:8]
+	}
+	return filepath.Join(dir, fmt.Sprintf(".%s.fileaction-%s%s", base, taskID, ext))
+}
+
+// moveFile renames src to dst, falling back to copying and removing src when
+// they are on different filesystems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// Copy to a temporary name next to dst first so dst never holds a partial file
+	tmp := dst + ".partial"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
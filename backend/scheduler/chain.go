@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/metrics"
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// defaultMaxChainDepth limits trigger_workflow hops unless configured otherwise
+const defaultMaxChainDepth = 5
+
+// SetMaxChainDepth limits how many trigger_workflow hops may follow a task
+// queued for a watched file. Tasks at the limit don't trigger another workflow.
+func (e *Executor) SetMaxChainDepth(n int) {
+	e.maxChainDepth = n
+}
+
+// SetHashAlgorithm sets the algorithm outputs passed to trigger_workflow are
+// indexed with, which should match the watcher's
+func (e *Executor) SetHashAlgorithm(algorithm string) {
+	e.hashAlgorithm = algorithm
+}
+
+// triggerWorkflow queues tasks of the named workflow with the output of the
+// completed task as their input. The output is indexed under that workflow as
+// if the watcher had found it, and deferred if the workflow is paused.
+// Problems are logged; they don't fail the task.
+func (e *Executor) triggerWorkflow(task *models.Task, name string, logWriter *bufio.Writer, execRecord *ExecutionRecord) {
+	if task.ChainDepth >= e.maxChainDepth {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Not triggering workflow %s: chain depth limit of %d reached", name, e.maxChainDepth))
+		return
+	}
+	info, err := os.Stat(task.OutputPath)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Not triggering workflow %s: output %s does not exist", name, task.OutputPath))
+		return
+	}
+
+	wf, err := e.workflowRepo.GetByName(name)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to trigger workflow %s: %v", name, err))
+		return
+	}
+	if !wf.Enabled {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Not triggering workflow %s: it is disabled", name))
+		return
+	}
+	def, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to parse triggered workflow %s: %v", name, err))
+		return
+	}
+
+	hash, size, err := hashFile(task.OutputPath, e.hashAlgorithm)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to trigger workflow %s: %v", name, err))
+		return
+	}
+	file, err := e.indexOutput(wf, task.OutputPath, hash, size, info.ModTime().UnixNano())
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to index %s for workflow %s: %v", task.OutputPath, name, err))
+		return
+	}
+	if wf.Paused {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Workflow %s is paused, task deferred for %s", name, task.OutputPath))
+		return
+	}
+
+	// One task per conversion target, as for a watched file
+	for _, outputPath := range def.TaskOutputPaths(task.OutputPath, time.Now(), hash) {
+		next := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     file.ID,
+			InputPath:  task.OutputPath,
+			OutputPath: outputPath,
+			InputMD5:   hash,
+			Priority:   def.Priority,
+			ChainDepth: task.ChainDepth + 1,
+			Status:     models.TaskStatusPending,
+		}
+		if err := e.taskRepo.Create(next); err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to create task for workflow %s: %v", name, err))
+			return
+		}
+		metrics.TasksCreated(wf.Name, 1)
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Triggered workflow %s: task %s (%s -> %s)", name, next.ID, next.InputPath, next.OutputPath))
+	}
+}
+
+// indexOutput records a triggered workflow's input in its file index, flagged
+// as deferred while the workflow is paused so that resuming it queues the task
+func (e *Executor) indexOutput(wf *models.Workflow, path, hash string, size, modTime int64) (*models.File, error) {
+	file, err := e.fileRepo.GetByWorkflowAndPath(wf.ID, path)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		file = &models.File{
+			WorkflowID:    wf.ID,
+			FilePath:      path,
+			FileMD5:       hash,
+			FileSize:      size,
+			ModTime:       modTime,
+			LastScannedAt: time.Now(),
+			Deferred:      wf.Paused,
+		}
+		return file, e.fileRepo.Create(file)
+	}
+
+	file.FileMD5 = hash
+	file.FileSize = size
+	file.ModTime = modTime
+	file.LastScannedAt = time.Now()
+	file.Stale = false
+	file.Deferred = file.Deferred || wf.Paused
+	return file, e.fileRepo.Update(file)
+}
+
+// hashFile returns the content hash and size of a file
+func hashFile(path, algorithm string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	return filehash.Sum(file, algorithm)
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"log/slog"
+
+	"github.com/andi/fileaction/backend/database"
+)
+
+// Scheduler event types sent to WebSocket clients subscribed to the scheduler
+const (
+	EventTaskStarted  = "task_started"
+	EventTaskFinished = "task_finished"
+	EventExecutorBusy = "executor_busy"
+	EventExecutorIdle = "executor_idle"
+)
+
+// Event is a task lifecycle or executor pool transition
+type Event struct {
+	Type       string
+	TaskID     string
+	WorkflowID string
+	Workflow   string // Workflow name
+	ExecutorID int
+	Status     string // Final task status, for task_finished
+}
+
+// broadcastEvent passes an event to the WebSocket hub, if one is connected
+func (s *Scheduler) broadcastEvent(event Event) {
+	s.wsHubMu.RLock()
+	defer s.wsHubMu.RUnlock()
+	if s.wsHub != nil {
+		s.wsHub.BroadcastSchedulerEvent(event)
+	}
+}
+
+// workflowName looks up a workflow's name for events; an unknown workflow has
+// an empty name
+func (s *Scheduler) workflowName(workflowID string) string {
+	wf, err := database.NewWorkflowRepo(s.db).GetByID(workflowID)
+	if err != nil {
+		slog.Debug("Workflow not found for scheduler event", "workflow_id", workflowID, "error", err)
+		return ""
+	}
+	return wf.Name
+}
@@ -4,15 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/logsink"
+	"github.com/andi/fileaction/backend/metrics"
 	"github.com/andi/fileaction/backend/models"
 	"github.com/andi/fileaction/backend/workflow"
 )
@@ -35,27 +42,78 @@ func (e *WorkflowStopFailure) Error() string {
 	return e.Message
 }
 
+// TaskCancelled is the cancellation cause of a task stopped by CancelTask
+type TaskCancelled struct {
+	Reason string
+}
+
+func (e *TaskCancelled) Error() string {
+	return "task cancelled: " + e.Reason
+}
+
+// cancelReason returns why ctx was cancelled by CancelTask, or "" if it wasn't
+func cancelReason(ctx context.Context) string {
+	var cancelled *TaskCancelled
+	if errors.As(context.Cause(ctx), &cancelled) {
+		return cancelled.Reason
+	}
+	return ""
+}
+
 // ExecutionRecord stores detailed execution information
 type ExecutionRecord struct {
-	TaskID      string
-	StartTime   time.Time
-	EndTime     time.Time
-	Environment map[string]string
-	Steps       []StepRecord
-	LogEntries  []string
+	TaskID      string            `json:"task_id"`
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     time.Time         `json:"end_time"`
+	Environment map[string]string `json:"environment"`
+	Steps       []StepRecord      `json:"steps"`
+	LogEntries  []string          `json:"log_entries"`
+	// StepOutputs holds the outputs of the run's plugin steps, which a retry
+	// resuming after them restores
+	StepOutputs map[string]map[string]string `json:"step_outputs,omitempty"`
+
+	// masked holds the workflow's secrets, whose values must not be logged or
+	// stored
+	masked map[string]bool
+}
+
+// maskedEnvValue replaces the value of a masked env variable in the log and
+// the execution record
+const maskedEnvValue = "***"
+
+// maskEnv makes the record show the values of the named env variables masked
+func (r *ExecutionRecord) maskEnv(names ...string) {
+	if r == nil {
+		return
+	}
+	if r.masked == nil {
+		r.masked = make(map[string]bool)
+	}
+	for _, name := range names {
+		r.masked[name] = true
+	}
+}
+
+// envValue returns value as it may appear in the log and the record of env
+// variable key
+func (r *ExecutionRecord) envValue(key, value string) string {
+	if r != nil && r.masked[key] {
+		return maskedEnvValue
+	}
+	return value
 }
 
 // StepRecord stores information about a step execution
 type StepRecord struct {
-	Name        string
-	Command     string
-	Environment map[string]string
-	StartTime   time.Time
-	EndTime     time.Time
-	ExitCode    int
-	Stdout      string
-	Stderr      string
-	LogEntries  []string
+	Name        string            `json:"name"`
+	Command     string            `json:"command"`
+	Environment map[string]string `json:"environment"`
+	StartTime   time.Time         `json:"start_time"`
+	EndTime     time.Time         `json:"end_time"`
+	ExitCode    int               `json:"exit_code"`
+	Stdout      string            `json:"stdout"`
+	Stderr      string            `json:"stderr"`
+	LogEntries  []string          `json:"log_entries"`
 }
 
 // Executor handles task execution with detailed logging
@@ -63,7 +121,10 @@ type Executor struct {
 	id              int
 	taskRepo        *database.TaskRepo
 	stepRepo        *database.TaskStepRepo
+	executionRepo   *database.TaskExecutionRepo
+	outputRepo      *database.TaskOutputRepo
 	workflowRepo    *database.WorkflowRepo
+	fileRepo        *database.FileRepo
 	pluginRepo      *database.PluginRepo
 	logDir          string
 	taskTimeout     time.Duration
@@ -72,23 +133,38 @@ type Executor struct {
 	currentTask     string
 	currentWorkflow string
 	currentFile     string
+	startedAt       time.Time
 	stateMu         sync.RWMutex
 	wsHub           WebSocketHub
 	wsHubMu         sync.RWMutex
+	logSink         logsink.Sink
+	logSinkMu       sync.RWMutex
+	logMu           sync.Mutex // Serializes writeLog; the soft timeout hook logs concurrently with steps
+	allowedRoots    []string   // Path audit of step commands; empty disables it
+	pathAuditMode   string
+	maxLogBytes     int64 // Cap on the stored task log and each step's output; 0 disables it
+	maxChainDepth   int   // Limit on trigger_workflow hops
+	hashAlgorithm   string
+	notifier        *webhookNotifier
 }
 
 // newExecutor creates a new executor instance
 func newExecutor(id int, db *database.DB, logDir string, taskTimeout, stepTimeout time.Duration) *Executor {
 	return &Executor{
-		id:           id,
-		taskRepo:     database.NewTaskRepo(db),
-		stepRepo:     database.NewTaskStepRepo(db),
-		workflowRepo: database.NewWorkflowRepo(db),
-		pluginRepo:   database.NewPluginRepo(db),
-		logDir:       logDir,
-		taskTimeout:  taskTimeout,
-		stepTimeout:  stepTimeout,
-		busy:         false,
+		id:            id,
+		taskRepo:      database.NewTaskRepo(db),
+		stepRepo:      database.NewTaskStepRepo(db),
+		executionRepo: database.NewTaskExecutionRepo(db),
+		outputRepo:    database.NewTaskOutputRepo(db),
+		workflowRepo:  database.NewWorkflowRepo(db),
+		fileRepo:      database.NewFileRepo(db),
+		pluginRepo:    database.NewPluginRepo(db),
+		logDir:        logDir,
+		taskTimeout:   taskTimeout,
+		stepTimeout:   stepTimeout,
+		maxLogBytes:   defaultMaxLogBytes,
+		maxChainDepth: defaultMaxChainDepth,
+		hashAlgorithm: filehash.Default,
 	}
 }
 
@@ -118,6 +194,68 @@ func (e *Executor) GetCurrentWorkflowAndFile() (string, string) {
 	return e.currentWorkflow, e.currentFile
 }
 
+// GetStartedAt returns when the current task started running, or the zero time if idle
+func (e *Executor) GetStartedAt() time.Time {
+	e.stateMu.RLock()
+	defer e.stateMu.RUnlock()
+	return e.startedAt
+}
+
+// Status returns the executor's state as of now, read under a single lock so
+// that a task finishing concurrently can't leave it half updated
+func (e *Executor) Status(now time.Time) ExecutorStatus {
+	e.stateMu.RLock()
+	defer e.stateMu.RUnlock()
+
+	status := ExecutorStatus{
+		ID:              e.id,
+		Busy:            e.busy,
+		CurrentTask:     e.currentTask,
+		CurrentWorkflow: e.currentWorkflow,
+		CurrentFile:     e.currentFile,
+	}
+	if !e.startedAt.IsZero() {
+		startedAt := e.startedAt
+		status.StartedAt = &startedAt
+		status.ElapsedSeconds = now.Sub(startedAt).Seconds()
+	}
+	return status
+}
+
+// beginTask marks the executor busy with a task
+func (e *Executor) beginTask(taskID string) {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	e.busy = true
+	e.currentTask = taskID
+}
+
+// setCurrentWorkflowAndFile records what the current task works on for monitoring
+func (e *Executor) setCurrentWorkflowAndFile(workflowName, fileName string) {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	e.currentWorkflow = workflowName
+	e.currentFile = fileName
+}
+
+// setStartedAt records when the current task started running
+func (e *Executor) setStartedAt(startedAt time.Time) {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	e.startedAt = startedAt
+}
+
+// endTask marks the executor idle and clears the current task
+func (e *Executor) endTask() {
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	e.busy = false
+	e.currentTask = ""
+	e.currentWorkflow = ""
+	e.currentFile = ""
+	e.startedAt = time.Time{}
+}
+
 // SetWebSocketHub sets the WebSocket hub for real-time log broadcasting
 func (e *Executor) SetWebSocketHub(hub WebSocketHub) {
 	e.wsHubMu.Lock()
@@ -125,6 +263,20 @@ func (e *Executor) SetWebSocketHub(hub WebSocketHub) {
 	e.wsHub = hub
 }
 
+// SetLogSink sets the external sink used to store completed task logs
+func (e *Executor) SetLogSink(sink logsink.Sink) {
+	e.logSinkMu.Lock()
+	defer e.logSinkMu.Unlock()
+	e.logSink = sink
+}
+
+// getLogSink returns the configured log sink, or nil to store logs in the database
+func (e *Executor) getLogSink() logsink.Sink {
+	e.logSinkMu.RLock()
+	defer e.logSinkMu.RUnlock()
+	return e.logSink
+}
+
 // broadcastLog sends log content to WebSocket clients if hub is available
 func (e *Executor) broadcastLog(taskID, content string) {
 	e.wsHubMu.RLock()
@@ -143,20 +295,15 @@ func (e *Executor) broadcastTaskComplete(taskID string) {
 	}
 }
 
+// logger returns the application logger tagged with the executor and task
+func (e *Executor) logger(task *models.Task) *slog.Logger {
+	return slog.With("executor_id", e.id, "task_id", task.ID, "workflow_id", task.WorkflowID)
+}
+
 // ExecuteTask executes a single task with detailed logging
-func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
-	e.stateMu.Lock()
-	e.busy = true
-	e.currentTask = taskID
-	e.stateMu.Unlock()
-	defer func() {
-		e.stateMu.Lock()
-		e.busy = false
-		e.currentTask = ""
-		e.currentWorkflow = ""
-		e.currentFile = ""
-		e.stateMu.Unlock()
-	}()
+func (e *Executor) ExecuteTask(ctx context.Context, taskID string) (retErr error) {
+	e.beginTask(taskID)
+	defer e.endTask()
 
 	// Get task
 	task, err := e.taskRepo.GetByID(taskID)
@@ -166,7 +313,7 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 
 	// Check if task is already running or completed
 	if task.Status != models.TaskStatusPending {
-		log.Printf("[Executor-%d] Task %s is not pending (status: %s), skipping", e.id, taskID, task.Status)
+		e.logger(task).Info("Task is not pending, skipping", "status", task.Status)
 		return nil
 	}
 
@@ -177,10 +324,7 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 	}
 
 	// Set current workflow and file for monitoring
-	e.stateMu.Lock()
-	e.currentWorkflow = wf.Name
-	e.currentFile = filepath.Base(task.InputPath)
-	e.stateMu.Unlock()
+	e.setCurrentWorkflowAndFile(wf.Name, filepath.Base(task.InputPath))
 
 	// Parse workflow
 	workflowDef, err := workflow.Parse(wf.YAMLContent)
@@ -188,12 +332,23 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 		return fmt.Errorf("failed to parse workflow: %w", err)
 	}
 
-	// Create context with timeout if not provided
+	// Create context with timeout if not provided. The workflow's
+	// options.timeout replaces the global task timeout and also applies to
+	// the context the scheduler passes in.
+	taskTimeout, _ := workflowDef.Options.GetTimeout()
 	if ctx == nil {
+		if taskTimeout <= 0 {
+			taskTimeout = e.taskTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), taskTimeout)
+		defer cancel()
+	} else if taskTimeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), e.taskTimeout)
+		ctx, cancel = context.WithTimeout(ctx, taskTimeout)
 		defer cancel()
 	}
+	taskCtx := ctx
 
 	// Create log file
 	logFilePath := filepath.Join(e.logDir, fmt.Sprintf("%s.log", taskID))
@@ -215,15 +370,19 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 		LogEntries:  make([]string, 0),
 	}
 
-	// Record global environment variables
-	for key, value := range workflowDef.Env {
-		execRecord.Environment[key] = value
-	}
+	// A panic while running steps must not leave the task running forever;
+	// record it as a failure along with the log written so far
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = e.failTaskOnPanic(task, wf.Name, r, logFilePath, logWriter, execRecord)
+		}
+	}()
 
 	// Update task status to running
 	now := time.Now()
 	task.Status = models.TaskStatusRunning
 	task.StartedAt = &now
+	e.setStartedAt(now)
 	if err := e.taskRepo.Update(task); err != nil {
 		return fmt.Errorf("failed to update task status: %w", err)
 	}
@@ -232,18 +391,67 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Input: %s", task.InputPath))
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Output: %s", task.OutputPath))
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Workflow: %s", wf.Name))
+	if task.ResumeFrom > 1 {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Resuming from step %d", task.ResumeFrom))
+	}
+
+	// The values of the workflow's secrets only reach the commands; the log and
+	// the execution record show them masked
+	execRecord.maskEnv(workflowDef.Secrets...)
+
+	// Variables from env_file sit under the workflow's inline env
+	fileEnv, err := workflowDef.EnvFile.Load()
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: %v", err))
+		task.Status = models.TaskStatusFailed
+		task.ErrorMessage = fmt.Sprintf("Failed to load environment: %v", err)
+		completedAt := time.Now()
+		task.CompletedAt = &completedAt
+		if err := e.finishTask(task, wf.Name, workflowDef, workflow.GetVariables(task.InputPath, task.OutputPath), logFilePath, logWriter, execRecord); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to load env_file: %w", err)
+	}
+	if len(fileEnv) > 0 {
+		workflowDef.Env = workflow.MergeEnvironment(fileEnv, workflowDef.Env, nil, nil)
+	}
+
+	// Task env overrides take precedence over every env the workflow defines
+	if len(task.Env) > 0 {
+		applyTaskEnv(workflowDef, task.Env)
+	}
 
-	// Log environment variables
+	// Record and log global environment variables
 	if len(workflowDef.Env) > 0 {
 		e.writeLog(logWriter, execRecord, "Environment variables:")
 		for key, value := range workflowDef.Env {
+			value = execRecord.envValue(key, value)
+			execRecord.Environment[key] = value
 			e.writeLog(logWriter, execRecord, fmt.Sprintf("  %s=%s", key, value))
 		}
 	}
 
-	// Create output directory if it doesn't exist
+	// The input may have changed again while the task waited in the queue; that
+	// change queues its own task, so this one is stale
+	if workflowDef.Options.VerifyInputHash && task.InputMD5 != "" {
+		if current := hashFileLike(task.InputPath, task.InputMD5); current != "" && current != task.InputMD5 {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Input changed since the task was queued (hash %s, now %s), skipping", task.InputMD5, current))
+			task.Status = models.TaskStatusCancelled
+			task.CancelReason = models.CancelReasonSuperseded
+			task.ErrorMessage = "Input changed after the task was queued"
+			completedAt := time.Now()
+			task.CompletedAt = &completedAt
+			return e.finishTask(task, wf.Name, workflowDef, workflow.GetVariables(task.InputPath, task.OutputPath), logFilePath, logWriter, execRecord)
+		}
+	}
+
+	// Create output directory if it doesn't exist, unless the workflow
+	// defers it to the steps that actually write output
 	outputDir := filepath.Dir(task.OutputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+	outputDirExisted := dirExists(outputDir)
+	if workflowDef.Options.LazyOutputDir {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Output directory: %s (created on demand)", outputDir))
+	} else if err := os.MkdirAll(outputDir, 0755); err != nil {
 		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to create output directory: %v", err))
 		task.Status = models.TaskStatusFailed
 		task.ErrorMessage = fmt.Sprintf("Failed to create output directory: %v", err)
@@ -251,26 +459,160 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 		task.CompletedAt = &completedAt
 		e.taskRepo.Update(task)
 		return fmt.Errorf("failed to create output directory: %w", err)
+	} else {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Output directory: %s", outputDir))
 	}
-	e.writeLog(logWriter, execRecord, fmt.Sprintf("Output directory: %s", outputDir))
 
 	// Get variables for substitution
 	vars := workflow.GetVariables(task.InputPath, task.OutputPath)
+	if workflowDef.Options.AtomicOutput {
+		vars.OutputTmp = atomicTempPath(task.OutputPath, taskID)
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Temporary output: %s", vars.OutputTmp))
+		defer os.Remove(vars.OutputTmp) // gone after a successful publish; discards partial output otherwise
+	}
+
+	// Metadata is extracted once and shared by every step of the task
+	if workflowDef.Options.MetadataCommand != "" {
+		meta, err := e.extractMetadata(ctx, workflowDef, vars, logWriter, execRecord)
+		if err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Metadata extraction failed: %v", err))
+			task.Status = models.TaskStatusFailed
+			task.ErrorMessage = fmt.Sprintf("Metadata extraction failed: %v", err)
+			completedAt := time.Now()
+			task.CompletedAt = &completedAt
+			if err := e.finishTask(task, wf.Name, workflowDef, vars, logFilePath, logWriter, execRecord); err != nil {
+				return err
+			}
+			return fmt.Errorf("metadata extraction failed: %w", err)
+		}
+		vars.Meta = meta
+	}
+
+	// Declared outputs are recorded before the steps run so they can be seen
+	// while the task is running
+	var declaredOutputs []*models.TaskOutput
+	if len(workflowDef.Outputs) > 0 {
+		declaredOutputs = e.recordDeclaredOutputs(taskID, workflowDef, vars, logWriter, execRecord)
+	}
+
+	// A step with a matrix runs once per combination of values. i stays the
+	// step's position in the workflow, which resume_from refers to.
+	var runSteps []workflow.Step
+	var positions []int
+	for i, step := range workflowDef.Steps {
+		for _, expanded := range workflow.ExpandMatrix(step) {
+			runSteps = append(runSteps, expanded)
+			positions = append(positions, i)
+		}
+	}
+
+	// Shared with the per-step copies of vars, so later steps see the outputs
+	// and statuses of earlier ones
+	vars.StepOutputs = make(map[string]map[string]string)
+	vars.StepStatuses = make(map[string]string)
+	execRecord.StepOutputs = vars.StepOutputs
+
+	// The steps a resumed task skips keep the statuses and outputs of their
+	// last run, for the conditions and templates of the steps after them
+	if task.ResumeFrom > 1 {
+		e.restoreStepResults(task, runSteps[:resumeIndex(positions, task.ResumeFrom)], vars)
+	}
+
+	// The soft timeout hook reads the step results from its own goroutine
+	var resultsMu sync.Mutex
+	setStepStatus := func(name, status string) {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		vars.StepStatuses[name] = status
+	}
+
+	// Two-phase timeout: the hard timeout kills the running step, the soft
+	// timeout only warns and runs the on_timeout hook
+	hardTimeout, err := workflowDef.Options.GetHardTimeout()
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Ignoring invalid hard_timeout: %v", err))
+	}
+	if hardTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hardTimeout)
+		defer cancel()
+	}
+	softTimeout, err := workflowDef.Options.GetSoftTimeout()
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Ignoring invalid soft_timeout: %v", err))
+	}
+	var softTimer *time.Timer
+	softDone := make(chan struct{})
+	if softTimeout > 0 {
+		softTimer = time.AfterFunc(softTimeout, func() {
+			defer close(softDone)
+			resultsMu.Lock()
+			hookVars := vars.Clone()
+			resultsMu.Unlock()
+			e.runSoftTimeout(taskID, softTimeout, workflowDef, hookVars, logWriter, execRecord)
+		})
+		defer softTimer.Stop()
+	}
 
 	// Execute steps
 	allStepsSucceeded := true
 	workflowStoppedWithSuccess := false
 	workflowStoppedWithFailure := false
+	toleratedFailures := 0 // Failed steps with continue_on_error
+
+	// Detect the input content type once if any step routes on it
+	contentType := ""
+	for _, step := range workflowDef.Steps {
+		if len(step.Match.ContentTypes) > 0 {
+			contentType = workflow.DetectContentType(task.InputPath)
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Input content type: %s", contentType))
+			break
+		}
+	}
 
-	for i, step := range workflowDef.Steps {
+	for n, step := range runSteps {
+		i := positions[n]
+		stepVars := vars
+		stepVars.Matrix = step.MatrixValues
 		e.writeLog(logWriter, execRecord, fmt.Sprintf("\n--- Step %d: %s ---", i+1, step.Name))
 
+		// A task retried from a later step keeps the results of the steps before it
+		if i+1 < task.ResumeFrom {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Skipping step (resuming from step %d)", task.ResumeFrom))
+			continue
+		}
+
+		// Skip steps whose match filter does not apply to this input
+		if !step.Match.Matches(task.InputPath, contentType) {
+			e.writeLog(logWriter, execRecord, "Skipping step (input does not match step filter)")
+			e.recordSkippedStep(taskID, step, logWriter, execRecord)
+			setStepStatus(step.Name, models.StepStatusSkipped)
+			continue
+		}
+
+		// Skip steps whose if condition is false
+		if condition := step.GetCondition(); condition != "" {
+			shouldExecute := workflow.EvaluateCondition(condition, nil, stepVars)
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Condition: %s = %v", condition, shouldExecute))
+			if !shouldExecute {
+				e.writeLog(logWriter, execRecord, "Skipping step (condition not met)")
+				e.recordSkippedStep(taskID, step, logWriter, execRecord)
+				setStepStatus(step.Name, models.StepStatusSkipped)
+				continue
+			}
+		}
+
 		// Check if this is a plugin step
 		if step.Uses != "" {
 			e.writeLog(logWriter, execRecord, fmt.Sprintf("Plugin: %s", step.Uses))
 
 			// Execute plugin
-			pluginErr := e.executePluginStep(ctx, taskID, step, vars, workflowDef.Env, logWriter, execRecord)
+			outputs, pluginErr := e.executePluginStep(ctx, taskID, step, stepVars, workflowDef.Env, task.Env, e.stepTimeoutFor(workflowDef), logWriter, execRecord)
+			if outputs != nil {
+				resultsMu.Lock()
+				vars.StepOutputs[step.Name] = outputs
+				resultsMu.Unlock()
+			}
 			if pluginErr != nil {
 				// Check for workflow control errors
 				if stopSuccess, ok := pluginErr.(*WorkflowStopSuccess); ok {
@@ -285,10 +627,17 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 					break
 				}
 
+				if step.ContinueOnError && ctx.Err() == nil {
+					e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Plugin step failed, continuing (continue_on_error): %v", pluginErr))
+					toleratedFailures++
+					setStepStatus(step.Name, models.StepStatusFailed)
+					continue
+				}
 				e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Plugin step failed: %v", pluginErr))
 				allStepsSucceeded = false
 				break
 			}
+			setStepStatus(step.Name, models.StepStatusCompleted)
 
 			// Check if context was cancelled
 			if ctx.Err() != nil {
@@ -314,7 +663,7 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 		}
 
 		// Execute step and get detailed record
-		stepRecord, err := e.executeStep(ctx, stepModel, step, vars, workflowDef.Env, logWriter, execRecord)
+		stepRecord, err := e.executeStep(ctx, stepModel, step, stepVars, workflowDef, logWriter, execRecord)
 		if stepRecord != nil {
 			execRecord.Steps = append(execRecord.Steps, *stepRecord)
 		}
@@ -334,10 +683,17 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 			}
 
 			// Regular step failure
+			if step.ContinueOnError && ctx.Err() == nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Step failed, continuing (continue_on_error): %v", err))
+				toleratedFailures++
+				setStepStatus(step.Name, models.StepStatusFailed)
+				continue
+			}
 			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Step failed: %v", err))
 			allStepsSucceeded = false
 			break
 		}
+		setStepStatus(step.Name, models.StepStatusCompleted)
 
 		// Check if context was cancelled
 		if ctx.Err() != nil {
@@ -347,34 +703,163 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 		}
 	}
 
+	// Publish the temporary output only once every step has succeeded
+	var publishErr error
+	if workflowDef.Options.AtomicOutput && allStepsSucceeded && cancelReason(ctx) == "" {
+		if _, err := os.Stat(vars.OutputTmp); err == nil {
+			if publishErr = moveFile(vars.OutputTmp, task.OutputPath); publishErr != nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to publish output: %v", publishErr))
+				allStepsSucceeded = false
+			} else {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("Published output: %s", task.OutputPath))
+			}
+		} else {
+			e.writeLog(logWriter, execRecord, "WARNING: Steps did not write ${{ output_tmp }}, nothing to publish")
+		}
+	}
+
+	// Make sure the steps actually produced output
+	var verifyErr error
+	if allStepsSucceeded && !workflowStoppedWithSuccess && workflowDef.Options.VerifiesOutput() {
+		if verifyErr = e.verifyOutput(ctx, task, workflowDef, vars, logWriter, execRecord); verifyErr != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Output verification failed: %v", verifyErr))
+			allStepsSucceeded = false
+		}
+	}
+
+	// Every declared output must exist once the steps are done
+	var missingOutputs []string
+	if allStepsSucceeded && !workflowStoppedWithSuccess && len(declaredOutputs) > 0 {
+		if missingOutputs = e.checkDeclaredOutputs(declaredOutputs, logWriter, execRecord); len(missingOutputs) > 0 {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Declared outputs missing: %s", strings.Join(missingOutputs, ", ")))
+			allStepsSucceeded = false
+		}
+	}
+
+	// Check the generated output before declaring success
+	outputInvalid := false
+	if allStepsSucceeded && !workflowStoppedWithSuccess && len(workflowDef.Validate.Steps) > 0 {
+		outputInvalid = !e.validateOutput(ctx, taskID, workflowDef, vars, logWriter, execRecord)
+		if outputInvalid {
+			allStepsSucceeded = false
+			if workflowDef.Validate.DeleteOnFailure {
+				if err := os.Remove(task.OutputPath); err == nil {
+					e.writeLog(logWriter, execRecord, fmt.Sprintf("Deleted invalid output: %s", task.OutputPath))
+				} else if !os.IsNotExist(err) {
+					e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to delete invalid output: %v", err))
+				}
+			}
+		}
+	}
+
+	// Wait for a soft timeout hook that is already running so it doesn't write
+	// to the log after it has been stored
+	if softTimer != nil && !softTimer.Stop() {
+		<-softDone
+	}
+	taskTimedOut := taskCtx.Err() == context.DeadlineExceeded
+	hardTimedOut := hardTimeout > 0 && !taskTimedOut && ctx.Err() == context.DeadlineExceeded
+	if hardTimedOut {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Task exceeded hard timeout of %v and was killed", hardTimeout))
+	} else if taskTimedOut {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Task exceeded timeout of %v and was killed", taskTimeout))
+	}
+
+	// Don't leave behind an output directory that a lazy workflow created but never wrote to
+	if workflowDef.Options.LazyOutputDir && !outputDirExisted && dirExists(outputDir) {
+		if entries, err := os.ReadDir(outputDir); err == nil && len(entries) == 0 {
+			if err := os.Remove(outputDir); err == nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("Removed empty output directory: %s", outputDir))
+			}
+		}
+	}
+
 	execRecord.EndTime = time.Now()
 
 	// Update task status
 	completedAt := time.Now()
 	task.CompletedAt = &completedAt
 
-	if workflowStoppedWithSuccess || allStepsSucceeded {
+	if reason := cancelReason(ctx); reason != "" {
+		// Keep the status CancelTask recorded instead of reporting a failure
+		task.Status = models.TaskStatusCancelled
+		task.CancelReason = reason
+		task.ErrorMessage = fmt.Sprintf("Task cancelled (%s)", reason)
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("\n[Executor-%d] Task cancelled (%s)", e.id, reason))
+	} else if workflowStoppedWithSuccess || allStepsSucceeded {
 		task.Status = models.TaskStatusCompleted
+		if toleratedFailures > 0 {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("\n%d step(s) failed with continue_on_error", toleratedFailures))
+		}
 		e.writeLog(logWriter, execRecord, fmt.Sprintf("\n[Executor-%d] Task completed successfully", e.id))
+		if workflowDef.Options.TriggerWorkflow != "" {
+			e.triggerWorkflow(task, workflowDef.Options.TriggerWorkflow, logWriter, execRecord)
+		}
 	} else {
 		task.Status = models.TaskStatusFailed
 		if workflowStoppedWithFailure {
 			task.ErrorMessage = "Workflow stopped with failure"
+		} else if hardTimedOut {
+			task.ErrorMessage = fmt.Sprintf("Task exceeded hard timeout of %v", hardTimeout)
+		} else if taskTimedOut {
+			task.ErrorMessage = fmt.Sprintf("Task exceeded timeout of %v", taskTimeout)
+		} else if publishErr != nil {
+			task.ErrorMessage = fmt.Sprintf("Failed to publish output: %v", publishErr)
+		} else if verifyErr != nil {
+			task.ErrorMessage = fmt.Sprintf("Output verification failed: %v", verifyErr)
+		} else if len(missingOutputs) > 0 {
+			task.ErrorMessage = fmt.Sprintf("Declared outputs missing: %s", strings.Join(missingOutputs, ", "))
+		} else if outputInvalid {
+			task.ErrorMessage = "Output validation failed"
 		} else {
 			task.ErrorMessage = "One or more steps failed"
 		}
 		e.writeLog(logWriter, execRecord, fmt.Sprintf("\n[Executor-%d] Task failed", e.id))
 	}
 
+	// Write the result receipt next to the output
+	opts := workflowDef.Options
+	if opts.EmitResultJSON && (task.Status == models.TaskStatusCompleted || opts.ResultJSONOnFailure) {
+		resultPath := resultJSONPath(task.OutputPath, opts.ResultJSONSuffix)
+		steps, err := e.stepRepo.GetByTaskID(taskID)
+		if err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to load steps for result file: %v", err))
+		} else if err := writeTaskResult(resultPath, buildTaskResult(task, wf.Name, steps)); err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to write result file: %v", err))
+		} else {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Result file: %s", resultPath))
+		}
+	}
+
+	return e.finishTask(task, wf.Name, workflowDef, vars, logFilePath, logWriter, execRecord)
+}
+
+// finishTask stores the log and the execution record of a task whose final
+// status is set, then reports the outcome to metrics, notifications and
+// WebSocket clients
+func (e *Executor) finishTask(task *models.Task, workflowName string, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logFilePath string, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+	if execRecord.EndTime.IsZero() {
+		execRecord.EndTime = time.Now()
+	}
 	duration := execRecord.EndTime.Sub(execRecord.StartTime)
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Total execution time: %v", duration))
 
 	logWriter.Flush()
 
-	// Read log file content and store in database
-	logContent, err := os.ReadFile(logFilePath)
+	// Read log file content and store it in the log sink or the database
+	logContent, err := readLogFile(logFilePath, e.maxLogBytes)
 	if err != nil {
-		log.Printf("[Executor-%d] Failed to read log file: %v", e.id, err)
+		e.logger(task).Error("Failed to read log file", "error", err)
+	} else if sink := e.getLogSink(); sink != nil {
+		key, err := sink.Put(task.ID, logContent)
+		if err != nil {
+			// Fall back to the database so the log is not lost
+			e.logger(task).Warn("Failed to upload log to sink, storing in database", "error", err)
+			task.LogText = string(logContent)
+		} else {
+			task.LogKey = key
+			task.LogText = ""
+		}
 	} else {
 		task.LogText = string(logContent)
 	}
@@ -382,21 +867,47 @@ func (e *Executor) ExecuteTask(ctx context.Context, taskID string) error {
 	if err := e.taskRepo.Update(task); err != nil {
 		return fmt.Errorf("failed to update task: %w", err)
 	}
+	e.reportTaskFinished(task, workflowName, duration, notificationContext(workflowDef, vars), logFilePath, execRecord)
+
+	e.logger(task).Info("Task completed", "status", task.Status, "duration", duration)
+	return nil
+}
+
+// reportTaskFinished stores the execution record of a stored finished task,
+// reports it to metrics, notifications and WebSocket clients and removes its
+// log file
+func (e *Executor) reportTaskFinished(task *models.Task, workflowName string, duration time.Duration, notifyContext map[string]string, logFilePath string, execRecord *ExecutionRecord) {
+	e.saveExecutionRecord(execRecord)
+	metrics.TaskFinished(workflowName, task.Status, duration)
+	e.notifier.notify(task, workflowName, duration, notifyContext)
 
 	// Broadcast task completion to WebSocket clients
-	e.broadcastTaskComplete(taskID)
+	e.broadcastTaskComplete(task.ID)
 
 	// Remove log file after importing to database
 	if err := os.Remove(logFilePath); err != nil {
-		log.Printf("[Executor-%d] Failed to remove log file: %v", e.id, err)
+		e.logger(task).Warn("Failed to remove log file", "error", err)
 	}
+}
 
-	log.Printf("[Executor-%d] Task %s completed with status: %s (duration: %v)", e.id, taskID, task.Status, duration)
-	return nil
+// recordSkippedStep stores a step that did not run for this task
+func (e *Executor) recordSkippedStep(taskID string, step workflow.Step, logWriter *bufio.Writer, execRecord *ExecutionRecord) {
+	skipped := &models.TaskStep{
+		TaskID:  taskID,
+		Name:    step.Name,
+		Command: step.Run,
+		Status:  models.StepStatusSkipped,
+	}
+	if step.Uses != "" {
+		skipped.Command = step.Uses
+	}
+	if err := e.stepRepo.Create(skipped); err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to create step record: %v", err))
+	}
 }
 
 // executeStep executes a single step with detailed logging
-func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep, step workflow.Step, vars workflow.Variables, globalEnv map[string]string, logWriter *bufio.Writer, execRecord *ExecutionRecord) (*StepRecord, error) {
+func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep, step workflow.Step, vars workflow.Variables, workflowDef *workflow.WorkflowDef, logWriter *bufio.Writer, execRecord *ExecutionRecord) (*StepRecord, error) {
 	stepRecord := &StepRecord{
 		Name:        step.Name,
 		Command:     step.Run,
@@ -410,6 +921,58 @@ func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep,
 	stepRecord.Command = command
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Command: %s", command))
 
+	// Refuse commands that reach outside the allowed roots
+	err := e.auditCommand(command, logWriter, execRecord)
+
+	// A missing working directory fails the step before it runs
+	workDir := workflow.SubstituteVariables(step.WorkingDir, vars)
+	if err == nil && workDir != "" {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Working directory: %s", workDir))
+		if err = checkWorkingDir(workDir); err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: %v", err))
+		}
+	}
+
+	// So does a shell that isn't installed
+	var shellArgs []string
+	if err == nil {
+		shell := step.Shell
+		if shell == "" {
+			shell = workflowDef.Options.Shell
+		}
+		if shell != "" {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Shell: %s", shell))
+		}
+		if shellArgs, err = resolveShell(shell); err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: %v", err))
+		}
+	}
+
+	// And a stdin_file that can't be read
+	stdinText := workflow.SubstituteVariables(step.Stdin, vars)
+	stdinFile := workflow.SubstituteVariables(step.StdinFile, vars)
+	if err == nil && stdinFile != "" {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Stdin file: %s", stdinFile))
+		if err = e.auditStdinFile(stdinFile, logWriter, execRecord); err == nil {
+			if err = checkStdinFile(stdinFile); err != nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: %v", err))
+			}
+		}
+	} else if stdinText != "" {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Stdin: %d bytes", len(stdinText)))
+	}
+	if err != nil {
+		completedAt := time.Now()
+		stepRecord.EndTime = completedAt
+		stepModel.Status = models.StepStatusFailed
+		stepModel.CompletedAt = &completedAt
+		stepModel.Stderr = err.Error()
+		if updateErr := e.stepRepo.Update(stepModel); updateErr != nil {
+			return stepRecord, fmt.Errorf("failed to update step: %w", updateErr)
+		}
+		return stepRecord, err
+	}
+
 	// Update step status to running
 	now := time.Now()
 	stepModel.Status = models.StepStatusRunning
@@ -418,70 +981,122 @@ func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep,
 		return stepRecord, fmt.Errorf("failed to update step status: %w", err)
 	}
 
-	// Create context with step timeout
-	stepCtx, cancel := context.WithTimeout(ctx, e.stepTimeout)
-	defer cancel()
-
-	// Create command
-	cmd := exec.CommandContext(stepCtx, "sh", "-c", command)
-
 	// Set environment variables
-	cmd.Env = os.Environ()
+	cmdEnv := os.Environ()
 
-	// Add global environment variables
-	for key, value := range globalEnv {
-		envVar := fmt.Sprintf("%s=%s", key, value)
-		cmd.Env = append(cmd.Env, envVar)
-		stepRecord.Environment[key] = value
+	// Add global environment variables. ${NAME} references to the server's
+	// environment are only resolved for the command, so secrets passed that
+	// way don't end up in the log or the execution record.
+	for key, value := range workflowDef.Env {
+		envVar := fmt.Sprintf("%s=%s", key, e.expandHostEnv(key, value, logWriter, execRecord))
+		cmdEnv = append(cmdEnv, envVar)
+		stepRecord.Environment[key] = execRecord.envValue(key, value)
 	}
 
 	// Add step-specific environment variables
 	for key, value := range step.Env {
-		substValue := workflow.SubstituteVariables(value, vars)
+		substValue := workflow.SubstituteVariables(e.expandHostEnv(key, value, logWriter, execRecord), vars)
 		envVar := fmt.Sprintf("%s=%s", key, substValue)
-		cmd.Env = append(cmd.Env, envVar)
-		stepRecord.Environment[key] = substValue
+		cmdEnv = append(cmdEnv, envVar)
+		stepRecord.Environment[key] = execRecord.envValue(key, workflow.SubstituteVariables(value, vars))
 	}
 
 	// Log environment variables for this step
 	if len(step.Env) > 0 {
 		e.writeLog(logWriter, execRecord, "Step environment variables:")
-		for key, value := range step.Env {
-			substValue := workflow.SubstituteVariables(value, vars)
-			e.writeLog(logWriter, execRecord, fmt.Sprintf("  %s=%s", key, substValue))
+		for key := range step.Env {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  %s=%s", key, stepRecord.Environment[key]))
 		}
 	}
 
-	// Capture output
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Run the command, retrying failures the workflow's retry policy covers
+	retry := workflowDef.Options.Retry
+	maxAttempts := retry.Attempts()
+	backoff, _ := retry.GetBackoff()
 
-	e.writeLog(logWriter, execRecord, "Executing command...")
+	var stdout, stderr string
+	exitCode := 0
+	attempts := 0
+	timedOut := false
+	for attempts < maxAttempts {
+		attempts++
+		if attempts > 1 {
+			delay := backoff * time.Duration(attempts-1)
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Retrying in %v (attempt %d/%d)", delay, attempts, maxAttempts))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
 
-	// Execute command
-	err := cmd.Run()
-	stepRecord.EndTime = time.Now()
+		// Create context with step timeout
+		stepCtx, cancel := context.WithTimeout(ctx, e.stepTimeoutFor(workflowDef))
 
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
+		// Create command
+		cmd := shellCommand(stepCtx, shellArgs, command)
+		cmd.Env = cmdEnv
+		cmd.Dir = workDir
+		stdin, err := openStdin(stdinText, stdinFile)
+		if stdin != nil {
+			cmd.Stdin = stdin
+		}
+
+		// Capture output; on a terminal stdout and stderr are combined. Otherwise
+		// both streams are logged line by line as they are produced.
+		stdout, stderr = "", ""
+		if err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to open stdin: %v", err))
+		} else if workflowDef.Options.PTY {
+			e.writeLog(logWriter, execRecord, "Executing command on a pseudo-terminal...")
+			stdoutLog := newLineLogger(e, logWriter, execRecord, "stdout")
+			err = runWithPTY(cmd, stdoutLog)
+			stdoutLog.Flush()
+			stdout = stdoutLog.String()
 		} else {
-			exitCode = 1
+			stdoutLog := newLineLogger(e, logWriter, execRecord, "stdout")
+			stderrLog := newLineLogger(e, logWriter, execRecord, "stderr")
+			cmd.Stdout = stdoutLog
+			cmd.Stderr = stderrLog
+			e.writeLog(logWriter, execRecord, "Executing command...")
+			err = cmd.Run()
+			stdoutLog.Flush()
+			stderrLog.Flush()
+			stdout, stderr = stdoutLog.String(), stderrLog.String()
+		}
+		if stdin != nil {
+			stdin.Close()
+		}
+		timedOut = stepCtx.Err() == context.DeadlineExceeded
+		cancel()
+
+		exitCode = 0
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			} else {
+				exitCode = 1
+			}
 		}
-	}
-	stepRecord.ExitCode = exitCode
 
-	// Write output to log
-	if stdout.Len() > 0 {
-		stepRecord.Stdout = stdout.String()
-		e.writeLog(logWriter, execRecord, fmt.Sprintf("STDOUT:\n%s", stdout.String()))
+		if !retry.ShouldRetry(exitCode) || ctx.Err() != nil {
+			break
+		}
+		if attempts < maxAttempts {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("Attempt %d/%d failed with exit code %d", attempts, maxAttempts, exitCode))
+		}
 	}
-	if stderr.Len() > 0 {
-		stepRecord.Stderr = stderr.String()
-		e.writeLog(logWriter, execRecord, fmt.Sprintf("STDERR:\n%s", stderr.String()))
+	if maxAttempts > 1 {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Attempts: %d", attempts))
 	}
+	stepModel.Attempts = attempts
+	stepRecord.EndTime = time.Now()
+	stepRecord.ExitCode = exitCode
+
+	stepRecord.Stdout = stdout
+	stepRecord.Stderr = stderr
 
 	duration := stepRecord.EndTime.Sub(stepRecord.StartTime)
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Exit code: %d", exitCode))
@@ -491,8 +1106,8 @@ func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep,
 	completedAt := time.Now()
 	stepModel.CompletedAt = &completedAt
 	stepModel.ExitCode = &exitCode
-	stepModel.Stdout = stdout.String()
-	stepModel.Stderr = stderr.String()
+	stepModel.Stdout = stdout
+	stepModel.Stderr = stderr
 
 	// Handle special exit codes:
 	// 0: Success (continue to next step)
@@ -520,6 +1135,10 @@ func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep,
 		e.writeLog(logWriter, execRecord, "INFO: Workflow stopped with failure (exit code 101)")
 	default:
 		stepModel.Status = models.StepStatusFailed
+		if timedOut {
+			stepModel.Status = models.StepStatusTimedOut
+			e.writeLog(logWriter, execRecord, "ERROR: Step timed out")
+		}
 	}
 
 	if err := e.stepRepo.Update(stepModel); err != nil {
@@ -546,6 +1165,9 @@ func (e *Executor) executeStep(ctx context.Context, stepModel *models.TaskStep,
 // writeLog writes a timestamped log entry to both the writer and execution record
 // and broadcasts it via WebSocket if available
 func (e *Executor) writeLog(w *bufio.Writer, record *ExecutionRecord, message string) {
+	e.logMu.Lock()
+	defer e.logMu.Unlock()
+
 	timestamp := time.Now().Format(time.RFC3339)
 	logEntry := fmt.Sprintf("[%s] %s\n", timestamp, message)
 	fmt.Fprint(w, logEntry)
@@ -556,12 +1178,351 @@ func (e *Executor) writeLog(w *bufio.Writer, record *ExecutionRecord, message st
 	}
 }
 
+// validateOutput runs the workflow's validate steps against the output and
+// reports whether all of them passed
+func (e *Executor) validateOutput(ctx context.Context, taskID string, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logWriter *bufio.Writer, execRecord *ExecutionRecord) bool {
+	e.writeLog(logWriter, execRecord, "\n--- Validating output ---")
+
+	for _, step := range workflowDef.Validate.Steps {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Validate: %s", step.Name))
+
+		stepModel := &models.TaskStep{
+			TaskID:  taskID,
+			Name:    step.Name,
+			Command: step.Run,
+			Status:  models.StepStatusPending,
+		}
+		if err := e.stepRepo.Create(stepModel); err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Failed to create step record: %v", err))
+			return false
+		}
+
+		stepRecord, err := e.executeStep(ctx, stepModel, step, vars, workflowDef, logWriter, execRecord)
+		if stepRecord != nil {
+			execRecord.Steps = append(execRecord.Steps, *stepRecord)
+		}
+		if _, ok := err.(*WorkflowStopSuccess); ok {
+			break
+		}
+		if err != nil {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Output validation failed: %v", err))
+			return false
+		}
+	}
+
+	e.writeLog(logWriter, execRecord, "Output validation passed")
+	return true
+}
+
+// failTaskOnPanic marks a task failed after a recovered panic. The partial log
+// is stored in the database directly, bypassing the log sink, since the sink
+// itself may be what panicked.
+func (e *Executor) failTaskOnPanic(task *models.Task, workflowName string, r interface{}, logFilePath string, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+	e.logger(task).Error("Panic while executing task", "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("\n[Executor-%d] PANIC: %v", e.id, r))
+	logWriter.Flush()
+
+	completedAt := time.Now()
+	task.Status = models.TaskStatusFailed
+	task.ErrorMessage = fmt.Sprintf("Executor panic: %v", r)
+	task.CompletedAt = &completedAt
+	if logContent, err := readLogFile(logFilePath, e.maxLogBytes); err == nil {
+		task.LogText = string(logContent)
+		task.LogKey = ""
+	}
+	if err := e.taskRepo.Update(task); err != nil {
+		e.logger(task).Error("Failed to mark panicked task as failed", "error", err)
+	}
+	execRecord.EndTime = completedAt
+
+	// The notification context is left out; rendering it may be what panicked
+	e.reportTaskFinished(task, workflowName, completedAt.Sub(execRecord.StartTime), nil, logFilePath, execRecord)
+
+	return fmt.Errorf("task panicked: %v", r)
+}
+
+// resumeIndex returns the index in the expanded steps of the first step at
+// the 1-based workflow position resumeFrom
+func resumeIndex(positions []int, resumeFrom int) int {
+	for n, i := range positions {
+		if i+1 >= resumeFrom {
+			return n
+		}
+	}
+	return len(positions)
+}
+
+// restoreStepResults fills vars with the statuses of the given steps' latest
+// runs and the outputs stored in the task's previous execution record
+func (e *Executor) restoreStepResults(task *models.Task, steps []workflow.Step, vars workflow.Variables) {
+	names := make([]string, len(steps))
+	for n, step := range steps {
+		names[n] = step.Name
+	}
+	statuses, err := e.stepRepo.LatestStatuses(task.ID, names)
+	if err != nil {
+		slog.Warn("Failed to load step statuses to resume from", "executor_id", e.id, "task_id", task.ID, "error", err)
+	}
+	for name, status := range statuses {
+		vars.StepStatuses[name] = status
+	}
+
+	execution, err := e.executionRepo.GetLatestByTaskID(task.ID)
+	if err != nil {
+		return
+	}
+	var previous ExecutionRecord
+	if err := json.Unmarshal([]byte(execution.Record), &previous); err != nil {
+		slog.Warn("Failed to decode execution record to resume from", "executor_id", e.id, "task_id", task.ID, "error", err)
+		return
+	}
+	for _, name := range names {
+		if outputs, ok := previous.StepOutputs[name]; ok {
+			vars.StepOutputs[name] = outputs
+		}
+	}
+}
+
+// saveExecutionRecord stores the execution record of the run for auditing. It
+// keeps the full log entries, so it outlives truncated or externally stored logs.
+func (e *Executor) saveExecutionRecord(record *ExecutionRecord) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode execution record", "executor_id", e.id, "task_id", record.TaskID, "error", err)
+		return
+	}
+	execution := &models.TaskExecution{TaskID: record.TaskID, Record: string(data)}
+	if err := e.executionRepo.Create(execution); err != nil {
+		slog.Error("Failed to store execution record", "executor_id", e.id, "task_id", record.TaskID, "error", err)
+	}
+}
+
+// verifyOutput checks that the output exists and is non-empty, records its size
+// and hash on the task, compares them against expected_size and expected_hash
+// and runs the workflow's verify_command if set
+func (e *Executor) verifyOutput(ctx context.Context, task *models.Task, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+	e.writeLog(logWriter, execRecord, "\n--- Verifying output ---")
+
+	output := describeFile(task.OutputPath)
+	if output.MD5 == "" {
+		return fmt.Errorf("output %s does not exist", task.OutputPath)
+	}
+	task.OutputSize = output.Size
+	task.OutputMD5 = output.MD5
+	if output.Size == 0 {
+		return fmt.Errorf("output %s is empty", task.OutputPath)
+	}
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("Output: %d bytes, md5 %s", output.Size, output.MD5))
+
+	if expectedSize, err := workflowDef.Options.GetExpectedSize(); err == nil && expectedSize > 0 && output.Size != expectedSize {
+		return fmt.Errorf("output %s is %d bytes, expected %d", task.OutputPath, output.Size, expectedSize)
+	}
+	if expectedHash := strings.ToLower(workflowDef.Options.ExpectedHash); expectedHash != "" {
+		digest := output.MD5
+		if filehash.AlgorithmOf(expectedHash) != filehash.MD5 {
+			digest = hashFileLike(task.OutputPath, expectedHash)
+		}
+		if digest != expectedHash {
+			return fmt.Errorf("output %s has %s %s, expected %s", task.OutputPath, filehash.AlgorithmOf(expectedHash), digest, expectedHash)
+		}
+	}
+
+	if workflowDef.Options.VerifyCommand == "" {
+		return nil
+	}
+	command := workflow.SubstituteVariables(workflowDef.Options.VerifyCommand, vars)
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("Running verify_command: %s", command))
+	if err := e.auditCommand(command, logWriter, execRecord); err != nil {
+		return err
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, e.stepTimeoutFor(workflowDef))
+	defer cancel()
+
+	cmd, err := workflowCommand(verifyCtx, workflowDef, command)
+	if err != nil {
+		return fmt.Errorf("verify_command: %w", err)
+	}
+	cmd.Env = e.commandEnv(workflowDef.Env, logWriter, execRecord)
+
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("verify_command output:\n%s", out))
+	}
+	if err != nil {
+		return fmt.Errorf("verify_command failed: %w", err)
+	}
+	return nil
+}
+
+// recordDeclaredOutputs resolves the workflow's outputs for a task and stores
+// them. If they can't be stored they are still returned for the final check.
+func (e *Executor) recordDeclaredOutputs(taskID string, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logWriter *bufio.Writer, execRecord *ExecutionRecord) []*models.TaskOutput {
+	paths := make([]string, len(workflowDef.Outputs))
+	for i, output := range workflowDef.Outputs {
+		paths[i] = workflow.SubstituteVariables(output, vars)
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Declared output: %s", paths[i]))
+	}
+
+	outputs, err := e.outputRepo.ReplaceForTask(taskID, paths)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Failed to record declared outputs: %v", err))
+		outputs = make([]*models.TaskOutput, len(paths))
+		for i, path := range paths {
+			outputs[i] = &models.TaskOutput{TaskID: taskID, Position: i, Path: path}
+		}
+	}
+	return outputs
+}
+
+// checkDeclaredOutputs records whether each declared output exists and its
+// size, and returns the paths of those that are missing
+func (e *Executor) checkDeclaredOutputs(outputs []*models.TaskOutput, logWriter *bufio.Writer, execRecord *ExecutionRecord) []string {
+	var missing []string
+	checkedAt := time.Now()
+	for _, output := range outputs {
+		info, err := os.Stat(output.Path)
+		output.Exists = err == nil && !info.IsDir()
+		output.Size = 0
+		if output.Exists {
+			output.Size = info.Size()
+		} else {
+			missing = append(missing, output.Path)
+		}
+		output.CheckedAt = &checkedAt
+
+		if output.ID != "" {
+			if err := e.outputRepo.Update(output); err != nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Failed to update declared output %s: %v", output.Path, err))
+			}
+		}
+	}
+	return missing
+}
+
+// stepTimeoutFor returns the workflow's options.step_timeout, or the global
+// step timeout if it doesn't set one
+func (e *Executor) stepTimeoutFor(workflowDef *workflow.WorkflowDef) time.Duration {
+	if timeout, err := workflowDef.Options.GetStepTimeout(); err == nil && timeout > 0 {
+		return timeout
+	}
+	return e.stepTimeout
+}
+
+// extractMetadata runs the workflow's metadata_command and parses its JSON
+// output into ${{ meta.* }} variables
+func (e *Executor) extractMetadata(ctx context.Context, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logWriter *bufio.Writer, execRecord *ExecutionRecord) (map[string]string, error) {
+	command := workflow.SubstituteVariables(workflowDef.Options.MetadataCommand, vars)
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("Extracting metadata: %s", command))
+	if err := e.auditCommand(command, logWriter, execRecord); err != nil {
+		return nil, err
+	}
+
+	metaCtx, cancel := context.WithTimeout(ctx, e.stepTimeoutFor(workflowDef))
+	defer cancel()
+
+	cmd, err := workflowCommand(metaCtx, workflowDef, command)
+	if err != nil {
+		return nil, fmt.Errorf("metadata_command: %w", err)
+	}
+	cmd.Env = e.commandEnv(workflowDef.Env, logWriter, execRecord)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if stderr.Len() > 0 {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("metadata_command stderr:\n%s", stderr.String()))
+		}
+		return nil, fmt.Errorf("metadata_command failed: %w", err)
+	}
+
+	meta, err := workflow.ParseMetadata(out)
+	if err != nil {
+		return nil, err
+	}
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("Metadata: %d field(s)", len(meta)))
+	return meta, nil
+}
+
+// runSoftTimeout warns that a task reached its soft timeout and runs the
+// workflow's on_timeout command, e.g. to capture diagnostics of a slow step
+func (e *Executor) runSoftTimeout(taskID string, softTimeout time.Duration, workflowDef *workflow.WorkflowDef, vars workflow.Variables, logWriter *bufio.Writer, execRecord *ExecutionRecord) {
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Task still running after soft timeout of %v", softTimeout))
+
+	hook := workflowDef.Options.OnTimeout
+	if hook == "" {
+		return
+	}
+	command := workflow.SubstituteVariables(hook, vars)
+	e.writeLog(logWriter, execRecord, fmt.Sprintf("Running on_timeout: %s", command))
+	if err := e.auditCommand(command, logWriter, execRecord); err != nil {
+		return
+	}
+
+	// The hook runs on its own deadline so the hard timeout doesn't cut it short
+	ctx, cancel := context.WithTimeout(context.Background(), e.stepTimeoutFor(workflowDef))
+	defer cancel()
+
+	cmd, err := workflowCommand(ctx, workflowDef, command)
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: on_timeout: %v", err))
+		return
+	}
+	cmd.Env = e.commandEnv(workflowDef.Env, logWriter, execRecord)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("FILEACTION_TASK_ID=%s", taskID))
+
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("on_timeout output:\n%s", output))
+	}
+	if err != nil {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: on_timeout failed: %v", err))
+	}
+}
+
+// applyTaskEnv merges a task's env overrides into the workflow env and the env
+// of every step, so they win over anything the workflow sets
+func applyTaskEnv(workflowDef *workflow.WorkflowDef, taskEnv map[string]string) {
+	workflowDef.Env = workflow.MergeEnvironment(nil, workflowDef.Env, nil, taskEnv)
+	for i := range workflowDef.Steps {
+		workflowDef.Steps[i].Env = workflow.MergeEnvironment(nil, workflowDef.Steps[i].Env, nil, taskEnv)
+	}
+}
+
+// expandHostEnv resolves ${NAME} references to the server's environment in
+// the value of env variable key, warning about variables that are not set
+func (e *Executor) expandHostEnv(key, value string, logWriter *bufio.Writer, execRecord *ExecutionRecord) string {
+	expanded, missing := workflow.ExpandHostEnv(value)
+	for _, name := range missing {
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Host environment variable %s used by %s is not set, using an empty value", name, key))
+	}
+	return expanded
+}
+
+// commandEnv returns the server's environment extended with the workflow env,
+// for commands that run outside of the steps
+func (e *Executor) commandEnv(env map[string]string, logWriter *bufio.Writer, execRecord *ExecutionRecord) []string {
+	cmdEnv := os.Environ()
+	for key, value := range env {
+		cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", key, e.expandHostEnv(key, value, logWriter, execRecord)))
+	}
+	return cmdEnv
+}
+
+// dirExists reports whether path exists and is a directory
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
 // executePluginStep executes a plugin-based step
-func (e *Executor) executePluginStep(ctx context.Context, taskID string, step workflow.Step, vars workflow.Variables, globalEnv map[string]string, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+func (e *Executor) executePluginStep(ctx context.Context, taskID string, step workflow.Step, vars workflow.Variables, globalEnv, taskEnv map[string]string, stepTimeout time.Duration, logWriter *bufio.Writer, execRecord *ExecutionRecord) (map[string]string, error) {
 	// Parse plugin reference
 	pluginName, version, err := workflow.ParsePluginReference(step.Uses)
 	if err != nil {
-		return fmt.Errorf("invalid plugin reference: %w", err)
+		return nil, fmt.Errorf("invalid plugin reference: %w", err)
 	}
 
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Loading plugin: %s (version: %s)", pluginName, version))
@@ -570,33 +1531,91 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 	var pluginVersion *database.PluginVersion
 	var loadErr error
 	if version != "" {
-		pluginVersion, loadErr = e.pluginRepo.GetPluginVersionByNumber(pluginName, version)
+		pluginVersion, loadErr = e.pluginRepo.ResolvePluginVersion(pluginName, version)
 	} else {
 		// Get current version if no version specified
 		plugin, pluginErr := e.pluginRepo.GetPluginByName(pluginName)
 		if pluginErr != nil {
-			return fmt.Errorf("plugin not found: %w", pluginErr)
+			return nil, fmt.Errorf("plugin not found: %w", pluginErr)
 		}
 		pluginVersion, loadErr = e.pluginRepo.GetPluginCurrentVersion(plugin.ID)
 	}
 
 	if loadErr != nil {
-		return fmt.Errorf("failed to load plugin: %w", loadErr)
+		return nil, fmt.Errorf("failed to load plugin: %w", loadErr)
 	}
 
 	// Parse plugin definition
 	pluginDef, err := workflow.ParsePlugin(pluginVersion.YAMLContent)
 	if err != nil {
-		return fmt.Errorf("failed to parse plugin: %w", err)
+		return nil, fmt.Errorf("failed to parse plugin: %w", err)
+	}
+	fileEnv, err := pluginDef.EnvFile.Load()
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", pluginName, err)
+	}
+	if len(fileEnv) > 0 {
+		pluginDef.Env = workflow.MergeEnvironment(fileEnv, pluginDef.Env, nil, nil)
+	}
+
+	// Steps append their outputs to a file named by $FILEACTION_OUTPUT
+	outputFile, err := os.CreateTemp("", "fileaction-output-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outputs file: %w", err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+	taskEnv = workflow.MergeEnvironment(nil, nil, taskEnv, map[string]string{workflow.OutputFileEnv: outputFile.Name()})
+
+	if err := e.runPlugin(ctx, e.stepRepo, taskID, step.Name, pluginDef, step.With, vars, globalEnv, taskEnv, stepTimeout, logWriter, execRecord); err != nil {
+		return nil, err
+	}
+	return e.readPluginOutputs(outputFile.Name(), pluginDef, logWriter, execRecord)
+}
+
+// readPluginOutputs parses the outputs file of a plugin run. Only declared
+// outputs are kept; a declared output that was never written is empty.
+func (e *Executor) readPluginOutputs(path string, pluginDef *workflow.PluginDef, logWriter *bufio.Writer, execRecord *ExecutionRecord) (map[string]string, error) {
+	if len(pluginDef.Outputs) == 0 {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read plugin outputs: %w", err)
+	}
+	written, err := workflow.ParseOutputs(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin outputs: %w", err)
+	}
+
+	outputs := make(map[string]string, len(pluginDef.Outputs))
+	for name := range pluginDef.Outputs {
+		outputs[name] = written[name]
 	}
+	for name, value := range written {
+		if _, declared := pluginDef.Outputs[name]; !declared {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: Ignoring undeclared plugin output %q", name))
+			continue
+		}
+		e.writeLog(logWriter, execRecord, fmt.Sprintf("Output %s=%s", name, value))
+	}
+	return outputs, nil
+}
 
+// runPlugin runs the steps of a loaded plugin, recording each of them in steps.
+// taskEnv overrides the plugin's own env.
+func (e *Executor) runPlugin(ctx context.Context, steps stepStore, taskID, stepName string, pluginDef *workflow.PluginDef, with map[string]string, vars workflow.Variables, globalEnv, taskEnv map[string]string, stepTimeout time.Duration, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Plugin loaded: %s v%s", pluginDef.Name, pluginDef.Version))
 	e.writeLog(logWriter, execRecord, fmt.Sprintf("Description: %s", pluginDef.Description))
 
 	// Validate dependencies
 	if len(pluginDef.Dependencies) > 0 {
 		e.writeLog(logWriter, execRecord, "Checking dependencies...")
-		if err := workflow.ValidatePluginDependencies(pluginDef.Dependencies); err != nil {
+		warnings, err := workflow.ValidatePluginDependencies(pluginDef.Dependencies)
+		for _, warning := range warnings {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("WARNING: %s", warning))
+		}
+		if err != nil {
 			e.writeLog(logWriter, execRecord, fmt.Sprintf("ERROR: Dependency check failed: %v", err))
 			return fmt.Errorf("dependency check failed: %w", err)
 		}
@@ -604,7 +1623,7 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 	}
 
 	// Prepare inputs
-	inputs, err := workflow.PreparePluginInputs(pluginDef, step.With)
+	inputs, err := workflow.PreparePluginInputs(pluginDef, with)
 	if err != nil {
 		return fmt.Errorf("failed to prepare inputs: %w", err)
 	}
@@ -633,11 +1652,11 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 		// Create step record
 		stepModel := &models.TaskStep{
 			TaskID:  taskID,
-			Name:    fmt.Sprintf("%s / %s", step.Name, pluginStep.Name),
+			Name:    fmt.Sprintf("%s / %s", stepName, pluginStep.Name),
 			Command: pluginStep.Run,
 			Status:  models.StepStatusPending,
 		}
-		if err := e.stepRepo.Create(stepModel); err != nil {
+		if err := steps.Create(stepModel); err != nil {
 			e.writeLog(logWriter, execRecord, fmt.Sprintf("  ERROR: Failed to create step record: %v", err))
 			return err
 		}
@@ -648,80 +1667,175 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 
 		e.writeLog(logWriter, execRecord, fmt.Sprintf("  Command: %s", command))
 
+		err := e.auditCommand(command, logWriter, execRecord)
+
+		// A missing working directory fails the step before it runs
+		workDir := workflow.SubstituteVariables(workflow.SubstitutePluginInputs(pluginStep.WorkingDir, inputs), vars)
+		if err == nil && workDir != "" {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Working directory: %s", workDir))
+			if err = checkWorkingDir(workDir); err != nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  ERROR: %v", err))
+			}
+		}
+
+		// So does a shell that isn't installed
+		var shellArgs []string
+		if err == nil {
+			if pluginStep.Shell != "" {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  Shell: %s", pluginStep.Shell))
+			}
+			if shellArgs, err = resolveShell(pluginStep.Shell); err != nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  ERROR: %v", err))
+			}
+		}
+
+		// And a stdin_file that can't be read
+		stdinText := workflow.SubstituteVariables(workflow.SubstitutePluginInputs(pluginStep.Stdin, inputs), vars)
+		stdinFile := workflow.SubstituteVariables(workflow.SubstitutePluginInputs(pluginStep.StdinFile, inputs), vars)
+		if err == nil && stdinFile != "" {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Stdin file: %s", stdinFile))
+			if err = e.auditStdinFile(stdinFile, logWriter, execRecord); err == nil {
+				if err = checkStdinFile(stdinFile); err != nil {
+					e.writeLog(logWriter, execRecord, fmt.Sprintf("  ERROR: %v", err))
+				}
+			}
+		} else if stdinText != "" {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Stdin: %d bytes", len(stdinText)))
+		}
+		if err != nil {
+			completedAt := time.Now()
+			stepModel.Status = models.StepStatusFailed
+			stepModel.CompletedAt = &completedAt
+			stepModel.Stderr = err.Error()
+			if updateErr := steps.Update(stepModel); updateErr != nil {
+				return fmt.Errorf("failed to update step: %w", updateErr)
+			}
+			if pluginStep.ContinueOnError {
+				e.writeLog(logWriter, execRecord, "  WARNING: Step failed, continuing (continue_on_error)")
+				continue
+			}
+			return err
+		}
+
 		// Update step status to running
 		now := time.Now()
 		stepModel.Status = models.StepStatusRunning
 		stepModel.StartedAt = &now
 		stepModel.Command = command
-		if err := e.stepRepo.Update(stepModel); err != nil {
+		if err := steps.Update(stepModel); err != nil {
 			return fmt.Errorf("failed to update step status: %w", err)
 		}
 
-		// Create context with step timeout (use plugin timeout if specified)
-		timeout := e.stepTimeout
+		// Use plugin timeout if specified
+		timeout := stepTimeout
 		if pluginStep.Timeout > 0 {
 			timeout = time.Duration(pluginStep.Timeout) * time.Second
 		}
-		stepCtx, cancel := context.WithTimeout(ctx, timeout)
-
-		// Create command
-		cmd := exec.CommandContext(stepCtx, "sh", "-c", command)
 
 		// Merge environment variables
 		mergedEnv := workflow.MergeEnvironment(
 			make(map[string]string), // base env (we use os.Environ() instead)
 			globalEnv,
 			pluginDef.Env,
-			pluginStep.Env,
+			workflow.MergeEnvironment(nil, nil, pluginStep.Env, taskEnv),
 		)
 
-		cmd.Env = os.Environ()
+		cmdEnv := os.Environ()
 		for key, value := range mergedEnv {
-			substValue := workflow.SubstituteVariables(value, vars)
+			substValue := workflow.SubstituteVariables(e.expandHostEnv(key, value, logWriter, execRecord), vars)
 			substValue = workflow.SubstitutePluginInputs(substValue, inputs)
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, substValue))
+			cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", key, substValue))
 		}
 
-		// Capture output
-		var stdout, stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
+		retryDelay, _ := pluginStep.GetRetryDelay()
+		maxAttempts := pluginStep.Retry + 1
 
-		e.writeLog(logWriter, execRecord, "  Executing command...")
+		var stdout, stderr string
+		exitCode := 0
+		attempts := 0
+		timedOut := false
+		for attempts < maxAttempts {
+			attempts++
+			if attempts > 1 {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  Retrying in %v (attempt %d/%d)", retryDelay, attempts, maxAttempts))
+				select {
+				case <-time.After(retryDelay):
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
 
-		// Execute command
-		startTime := time.Now()
-		err := cmd.Run()
-		endTime := time.Now()
-		cancel() // Clean up context
+			// Create context with step timeout
+			stepCtx, cancel := context.WithTimeout(ctx, timeout)
 
-		exitCode := 0
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				exitCode = exitErr.ExitCode()
+			// Create command
+			cmd := shellCommand(stepCtx, shellArgs, command)
+			cmd.Env = cmdEnv
+			cmd.Dir = workDir
+
+			// Log both streams line by line as they are produced
+			stdoutLog := newLineLogger(e, logWriter, execRecord, "stdout")
+			stderrLog := newLineLogger(e, logWriter, execRecord, "stderr")
+			cmd.Stdout = stdoutLog
+			cmd.Stderr = stderrLog
+
+			e.writeLog(logWriter, execRecord, "  Executing command...")
+
+			// Execute command
+			startTime := time.Now()
+			stdin, err := openStdin(stdinText, stdinFile)
+			if err == nil {
+				if stdin != nil {
+					cmd.Stdin = stdin
+				}
+				err = cmd.Run()
+				if stdin != nil {
+					stdin.Close()
+				}
 			} else {
-				exitCode = 1
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  ERROR: Failed to open stdin: %v", err))
+			}
+			endTime := time.Now()
+			stdoutLog.Flush()
+			stderrLog.Flush()
+			stdout, stderr = stdoutLog.String(), stderrLog.String()
+			timedOut = stepCtx.Err() == context.DeadlineExceeded
+			cancel() // Clean up context
+
+			exitCode = 0
+			if err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					exitCode = exitErr.ExitCode()
+				} else {
+					exitCode = 1
+				}
 			}
-		}
 
-		// Write output to log
-		if stdout.Len() > 0 {
-			e.writeLog(logWriter, execRecord, fmt.Sprintf("  STDOUT:\n%s", stdout.String()))
+			duration := endTime.Sub(startTime)
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Exit code: %d", exitCode))
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Duration: %v", duration))
+
+			// Exit codes 0, 100 and 101 are final; only plain failures are retried
+			if exitCode == 0 || exitCode == 100 || exitCode == 101 || ctx.Err() != nil {
+				break
+			}
+			if attempts < maxAttempts {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  Attempt %d/%d failed with exit code %d", attempts, maxAttempts, exitCode))
+			}
 		}
-		if stderr.Len() > 0 {
-			e.writeLog(logWriter, execRecord, fmt.Sprintf("  STDERR:\n%s", stderr.String()))
+		if maxAttempts > 1 {
+			e.writeLog(logWriter, execRecord, fmt.Sprintf("  Attempts: %d", attempts))
 		}
-
-		duration := endTime.Sub(startTime)
-		e.writeLog(logWriter, execRecord, fmt.Sprintf("  Exit code: %d", exitCode))
-		e.writeLog(logWriter, execRecord, fmt.Sprintf("  Duration: %v", duration))
+		stepModel.Attempts = attempts
 
 		// Update step
 		completedAt := time.Now()
 		stepModel.CompletedAt = &completedAt
 		stepModel.ExitCode = &exitCode
-		stepModel.Stdout = stdout.String()
-		stepModel.Stderr = stderr.String()
+		stepModel.Stdout = stdout
+		stepModel.Stderr = stderr
 
 		// Handle exit codes
 		stopWorkflow := false
@@ -743,9 +1857,13 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 			e.writeLog(logWriter, execRecord, "  INFO: Workflow stopped with failure (exit code 101)")
 		default:
 			stepModel.Status = models.StepStatusFailed
+			if timedOut {
+				stepModel.Status = models.StepStatusTimedOut
+				e.writeLog(logWriter, execRecord, "  ERROR: Step timed out")
+			}
 		}
 
-		if err := e.stepRepo.Update(stepModel); err != nil {
+		if err := steps.Update(stepModel); err != nil {
 			return fmt.Errorf("failed to update step: %w", err)
 		}
 
@@ -760,6 +1878,10 @@ func (e *Executor) executePluginStep(ctx context.Context, taskID string, step wo
 		}
 
 		if exitCode != 0 && exitCode != 100 {
+			if pluginStep.ContinueOnError && ctx.Err() == nil {
+				e.writeLog(logWriter, execRecord, fmt.Sprintf("  WARNING: Step exited with code %d, continuing (continue_on_error)", exitCode))
+				continue
+			}
 			return fmt.Errorf("plugin step '%s' exited with code %d", pluginStep.Name, exitCode)
 		}
 
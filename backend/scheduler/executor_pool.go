@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/logsink"
 )
 
 // ExecutorPool manages a pool of executors
@@ -95,6 +96,58 @@ func (p *ExecutorPool) SetWebSocketHub(hub WebSocketHub) {
 	log.Println("WebSocket hub set for executor pool")
 }
 
+// SetLogSink sets the external log sink for all executors
+func (p *ExecutorPool) SetLogSink(sink logsink.Sink) {
+	for _, executor := range p.executors {
+		executor.SetLogSink(sink)
+	}
+}
+
+// SetPathAudit sets the path audit of all executors
+func (p *ExecutorPool) SetPathAudit(roots []string, mode string) {
+	for _, executor := range p.executors {
+		executor.SetPathAudit(roots, mode)
+	}
+}
+
+// pluginTestExecutor returns an executor for plugin test runs, with the pool's
+// step timeout and path audit
+func (p *ExecutorPool) pluginTestExecutor() *Executor {
+	e := &Executor{stepTimeout: p.stepTimeout}
+	if len(p.executors) > 0 {
+		e.SetPathAudit(p.executors[0].allowedRoots, p.executors[0].pathAuditMode)
+	}
+	return e
+}
+
+// SetMaxLogBytes sets the stored log cap of all executors
+func (p *ExecutorPool) SetMaxLogBytes(n int64) {
+	for _, executor := range p.executors {
+		executor.SetMaxLogBytes(n)
+	}
+}
+
+// SetMaxChainDepth sets the trigger_workflow depth limit of all executors
+func (p *ExecutorPool) SetMaxChainDepth(n int) {
+	for _, executor := range p.executors {
+		executor.SetMaxChainDepth(n)
+	}
+}
+
+// SetHashAlgorithm sets the hash algorithm of all executors
+func (p *ExecutorPool) SetHashAlgorithm(algorithm string) {
+	for _, executor := range p.executors {
+		executor.SetHashAlgorithm(algorithm)
+	}
+}
+
+// SetNotifier sets the task notifier of all executors
+func (p *ExecutorPool) SetNotifier(n *webhookNotifier) {
+	for _, executor := range p.executors {
+		executor.SetNotifier(n)
+	}
+}
+
 // GetPoolSize returns the total number of executors in the pool
 func (p *ExecutorPool) GetPoolSize() int {
 	return len(p.executors)
@@ -110,18 +163,17 @@ func (p *ExecutorPool) GetBusyCount() int {
 	return p.GetPoolSize() - p.GetAvailableCount()
 }
 
-// GetExecutorStatus returns the status of all executors
-func (p *ExecutorPool) GetExecutorStatus() []ExecutorStatus {
-	statuses := make([]ExecutorStatus, len(p.executors))
-	for i, executor := range p.executors {
-		workflowName, fileName := executor.GetCurrentWorkflowAndFile()
-		statuses[i] = ExecutorStatus{
-			ID:              executor.GetID(),
-			Busy:            executor.IsBusy(),
-			CurrentTask:     executor.GetCurrentTask(),
-			CurrentWorkflow: workflowName,
-			CurrentFile:     fileName,
+// GetExecutorStatus returns the status of all executors, or only the busy
+// ones when busyOnly is set
+func (p *ExecutorPool) GetExecutorStatus(busyOnly bool) []ExecutorStatus {
+	statuses := make([]ExecutorStatus, 0, len(p.executors))
+	now := time.Now()
+	for _, executor := range p.executors {
+		status := executor.Status(now)
+		if busyOnly && !status.Busy {
+			continue
 		}
+		statuses = append(statuses, status)
 	}
 	return statuses
 }
@@ -142,9 +194,11 @@ func (p *ExecutorPool) Close() {
 
 // ExecutorStatus represents the status of an executor
 type ExecutorStatus struct {
-	ID              int    `json:"id"`
-	Busy            bool   `json:"busy"`
-	CurrentTask     string `json:"current_task,omitempty"`
-	CurrentWorkflow string `json:"current_workflow,omitempty"`
-	CurrentFile     string `json:"current_file,omitempty"`
+	ID              int        `json:"id"`
+	Busy            bool       `json:"busy"`
+	CurrentTask     string     `json:"current_task,omitempty"`
+	CurrentWorkflow string     `json:"current_workflow,omitempty"`
+	CurrentFile     string     `json:"current_file,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	ElapsedSeconds  float64    `json:"elapsed_seconds,omitempty"`
 }
@@ -0,0 +1,2420 @@
+package scheduler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+func setupTestDB(t *testing.T) *database.DB {
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createTestWorkflow(t *testing.T, db *database.DB, yamlContent string) *models.Workflow {
+	wf := &models.Workflow{
+		Name:        "test-workflow",
+		YAMLContent: yamlContent,
+		Enabled:     true,
+	}
+	if err := database.NewWorkflowRepo(db).Create(wf); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+	return wf
+}
+
+func createTestTask(t *testing.T, db *database.DB, workflowID, inputPath, outputPath string) *models.Task {
+	task := &models.Task{
+		WorkflowID: workflowID,
+		FileID:     "file-1",
+		InputPath:  inputPath,
+		OutputPath: outputPath,
+		Status:     models.TaskStatusPending,
+	}
+	if err := database.NewTaskRepo(db).Create(task); err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	return task
+}
+
+// getTestTask reloads a task from the database
+func getTestTask(t *testing.T, db *database.DB, id string) *models.Task {
+	task, err := database.NewTaskRepo(db).GetByID(id)
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	return task
+}
+
+// getTestSteps returns the step records of a task keyed by name
+func getTestSteps(t *testing.T, db *database.DB, taskID string) map[string]*models.TaskStep {
+	steps, err := database.NewTaskStepRepo(db).GetByTaskID(taskID)
+	if err != nil {
+		t.Fatalf("Failed to get steps: %v", err)
+	}
+	result := make(map[string]*models.TaskStep)
+	for _, step := range steps {
+		result[step.Name] = step
+	}
+	return result
+}
+
+func newTestExecutor(t *testing.T, db *database.DB) *Executor {
+	return newExecutor(1, db, t.TempDir(), time.Minute, time.Minute)
+}
+
+type mockLogSink struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *mockLogSink) Put(taskID string, content []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := "logs/" + taskID
+	m.objects[key] = content
+	return key, nil
+}
+
+func (m *mockLogSink) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.objects[key], nil
+}
+
+func (m *mockLogSink) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+const echoWorkflow = `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: say-hello
+    run: echo hello-from-step
+`
+
+func TestExecuteTaskStoresLogInSink(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, echoWorkflow)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	sink := &mockLogSink{objects: make(map[string][]byte)}
+	executor := newTestExecutor(t, db)
+	executor.SetLogSink(sink)
+
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	stored := getTestTask(t, db, task.ID)
+	if stored.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected status completed, got %s", stored.Status)
+	}
+	if stored.LogKey != "logs/"+task.ID {
+		t.Errorf("Expected log key 'logs/%s', got '%s'", task.ID, stored.LogKey)
+	}
+	if stored.LogText != "" {
+		t.Errorf("Expected empty LogText when using a sink, got %d bytes", len(stored.LogText))
+	}
+
+	content, _ := sink.Get(stored.LogKey)
+	if !strings.Contains(string(content), "hello-from-step") {
+		t.Errorf("Expected sink content to contain step output, got: %s", content)
+	}
+}
+
+func TestExecuteTaskRoutesStepsByInputType(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: transcode-video
+    run: echo video
+    match:
+      content_types: ["video/*"]
+  - name: convert-image
+    run: echo image
+    match:
+      extensions: [png, .jpg]
+  - name: always
+    run: echo always
+`)
+
+	videoPath := filepath.Join(dir, "clip.mp4")
+	imagePath := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(videoPath, []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imagePath, []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input   string
+		ran     string
+		skipped string
+	}{
+		{input: videoPath, ran: "transcode-video", skipped: "convert-image"},
+		{input: imagePath, ran: "convert-image", skipped: "transcode-video"},
+	}
+
+	executor := newTestExecutor(t, db)
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.input), func(t *testing.T) {
+			task := createTestTask(t, db, wf.ID, tt.input, tt.input+".out")
+			if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+
+			if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusCompleted {
+				t.Fatalf("Expected status completed, got %s", status)
+			}
+
+			steps := getTestSteps(t, db, task.ID)
+			if steps[tt.ran] == nil || steps[tt.ran].Status != models.StepStatusCompleted {
+				t.Errorf("Expected step %s to complete", tt.ran)
+			}
+			if steps[tt.skipped] == nil || steps[tt.skipped].Status != models.StepStatusSkipped {
+				t.Errorf("Expected step %s to be skipped", tt.skipped)
+			}
+			if steps["always"] == nil || steps["always"].Status != models.StepStatusCompleted {
+				t.Errorf("Expected unfiltered step to complete")
+			}
+		})
+	}
+}
+
+func TestPluginStepRetrySucceedsOnSecondAttempt(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "attempted")
+
+	pluginYAML := `
+name: flaky-plugin
+version: 1.0.0
+steps:
+  - name: upload
+    run: |
+      if [ -f "` + marker + `" ]; then
+        echo uploaded
+      else
+        touch "` + marker + `"
+        echo transient failure >&2
+        exit 1
+      fi
+    retry: 2
+    retry_delay: 10ms
+`
+	if _, _, err := database.NewPluginRepo(db).CreatePlugin("flaky-plugin", "", pluginYAML, "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: publish
+    uses: flaky-plugin@1.0.0
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusCompleted {
+		t.Fatalf("Expected status completed, got %s", status)
+	}
+
+	steps, err := database.NewTaskStepRepo(db).GetByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get steps: %v", err)
+	}
+	if len(steps) != 1 {
+		t.Fatalf("Expected a single step record, got %d", len(steps))
+	}
+	step := steps[0]
+	if step.Status != models.StepStatusCompleted {
+		t.Errorf("Expected step completed, got %s", step.Status)
+	}
+	if step.ExitCode == nil || *step.ExitCode != 0 {
+		t.Errorf("Expected final exit code 0, got %v", step.ExitCode)
+	}
+	if step.Attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", step.Attempts)
+	}
+	if !strings.Contains(step.Stdout, "uploaded") {
+		t.Errorf("Expected stdout of the successful attempt, got %q", step.Stdout)
+	}
+}
+
+func TestLazyOutputDirLeavesNoEmptyDirectory(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  lazy_output_dir: true
+steps:
+  - name: prepare
+    run: mkdir -p "${{ output_dir }}"
+  - name: nothing-to-do
+    run: exit 100
+`)
+	outputDir := filepath.Join(dir, "out", "nested")
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(outputDir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if stored := getTestTask(t, db, task.ID); stored.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task completed, got %s", stored.Status)
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no output directory to be left behind, stat err: %v", err)
+	}
+}
+
+func TestLazyOutputDirKeepsDirectoryWithOutput(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  lazy_output_dir: true
+steps:
+  - name: write
+    run: mkdir -p "${{ output_dir }}" && echo done > "${{ output_path }}"
+`)
+	outputPath := filepath.Join(dir, "out", "out.txt")
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if _, err := os.Stat(outputPath); err != nil {
+		t.Errorf("Expected output file to exist: %v", err)
+	}
+}
+
+func TestValidateFailureFailsTaskAndDeletesOutput(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: produce
+    run: echo broken > "${{ output_path }}"
+validate:
+  delete_on_failure: true
+  steps:
+    - name: check-output
+      run: grep -q valid "${{ output_path }}"
+`)
+	outputPath := filepath.Join(dir, "out.txt")
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	stored := getTestTask(t, db, task.ID)
+	if stored.Status != models.TaskStatusFailed {
+		t.Fatalf("Expected task failed, got %s", stored.Status)
+	}
+	if stored.ErrorMessage != "Output validation failed" {
+		t.Errorf("Unexpected error message: %q", stored.ErrorMessage)
+	}
+
+	steps := getTestSteps(t, db, task.ID)
+	if steps["produce"] == nil || steps["produce"].Status != models.StepStatusCompleted {
+		t.Errorf("Expected produce step completed, got %+v", steps["produce"])
+	}
+	if steps["check-output"] == nil || steps["check-output"].Status != models.StepStatusFailed {
+		t.Errorf("Expected validation step failed, got %+v", steps["check-output"])
+	}
+
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected invalid output to be deleted, stat err: %v", err)
+	}
+}
+
+func TestPinnedPluginIgnoresLaterActivation(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	pluginRepo := database.NewPluginRepo(db)
+
+	pluginYAML := func(version string) string {
+		return "name: echo-plugin\nversion: " + version + "\nsteps:\n  - name: say\n    run: echo plugin-" + version + "\n"
+	}
+	plugin, _, err := pluginRepo.CreatePlugin("echo-plugin", "", pluginYAML("1.0.0"), "test")
+	if err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	wfYAML := `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: greet
+    uses: echo-plugin # follows the active version
+`
+	def, err := workflow.Parse(wfYAML)
+	if err != nil {
+		t.Fatalf("Failed to parse workflow: %v", err)
+	}
+	if unpinned := workflow.UnpinnedPlugins(def); len(unpinned) != 1 || unpinned[0] != "echo-plugin" {
+		t.Fatalf("Expected echo-plugin to be unpinned, got %v", unpinned)
+	}
+
+	current, err := pluginRepo.GetPluginByName("echo-plugin")
+	if err != nil {
+		t.Fatalf("Failed to get plugin: %v", err)
+	}
+	pinnedYAML, pinned := workflow.PinPluginReferences(wfYAML, map[string]string{"echo-plugin": current.CurrentVersion})
+	if pinned != 1 || !strings.Contains(pinnedYAML, "uses: echo-plugin@1.0.0 # follows the active version") {
+		t.Fatalf("Expected reference to be pinned, got %d in:\n%s", pinned, pinnedYAML)
+	}
+	wf := createTestWorkflow(t, db, pinnedYAML)
+
+	// A newer version becomes active after pinning
+	if _, err := pluginRepo.CreatePluginVersion(plugin.ID, pluginYAML("2.0.0")); err != nil {
+		t.Fatalf("Failed to create version: %v", err)
+	}
+
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	step := getTestSteps(t, db, task.ID)["greet / say"]
+	if step == nil {
+		t.Fatal("Expected plugin step record")
+	}
+	if !strings.Contains(step.Stdout, "plugin-1.0.0") {
+		t.Errorf("Expected pinned version output, got %q", step.Stdout)
+	}
+}
+
+func TestFailedStepsAreQueryable(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: prepare
+    run: echo ready
+  - name: convert
+    run: echo "codec not found" >&2; exit 3
+  - name: cleanup
+    run: echo never
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	failed, err := database.NewTaskStepRepo(db).GetFailedByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get failed steps: %v", err)
+	}
+	if len(failed) != 1 {
+		t.Fatalf("Expected 1 failed step, got %d", len(failed))
+	}
+	if failed[0].Name != "convert" || failed[0].ExitCode == nil || *failed[0].ExitCode != 3 {
+		t.Errorf("Unexpected failed step: %+v", failed[0])
+	}
+	if !strings.Contains(failed[0].Stderr, "codec not found") {
+		t.Errorf("Expected stderr of failed step, got %q", failed[0].Stderr)
+	}
+}
+
+func TestMatrixStepRunsPerCombination(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: render
+    run: touch `+dir+`/${{ matrix.size }}-${{ matrix.fps }}
+    matrix:
+      size: [720p, 1080p]
+      fps: [30]
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s: %s", got.Status, got.ErrorMessage)
+	}
+	steps := getTestSteps(t, db, task.ID)
+	for _, name := range []string{"render (fps=30, size=720p)", "render (fps=30, size=1080p)"} {
+		if step, ok := steps[name]; !ok || step.Status != models.StepStatusCompleted {
+			t.Errorf("Expected completed step %q, got %+v", name, step)
+		}
+	}
+	for _, output := range []string{"720p-30", "1080p-30"} {
+		if _, err := os.Stat(filepath.Join(dir, output)); err != nil {
+			t.Errorf("Expected output %s: %v", output, err)
+		}
+	}
+
+	// A failing expansion stops the task like any other step
+	db = setupTestDB(t)
+	wf = createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: check
+    run: test ${{ matrix.n }} -ne 2
+    matrix:
+      n: [1, 2, 3]
+`)
+	task = createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusFailed {
+		t.Fatalf("Expected task to fail, got %s", got.Status)
+	}
+	steps = getTestSteps(t, db, task.ID)
+	if len(steps) != 2 || steps["check (n=2)"].Status != models.StepStatusFailed {
+		t.Errorf("Expected expansions 1 and 2 to run and 2 to fail, got %+v", steps)
+	}
+}
+
+func TestPluginOutputsAreSubstituted(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	pluginYAML := `
+name: probe-plugin
+version: 1.0.0
+outputs:
+  width:
+    description: Image width
+  summary:
+    description: Multi-line summary
+steps:
+  - name: probe
+    run: |
+      echo "width=1920" >> "$FILEACTION_OUTPUT"
+      printf 'summary<<EOF\nline one\nline two\nEOF\n' >> "$FILEACTION_OUTPUT"
+      echo "secret=hidden" >> "$FILEACTION_OUTPUT"
+`
+	if _, _, err := database.NewPluginRepo(db).CreatePlugin("probe-plugin", "", pluginYAML, "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	result := filepath.Join(dir, "result.txt")
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: probe-input
+    uses: probe-plugin@1.0.0
+  - name: report
+    run: printf '%s|%s|%s' '${{ steps.probe-input.outputs.width }}' '${{ steps.probe-input.outputs.summary }}' '${{ steps.probe-input.outputs.secret }}' > `+result+`
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s: %s", got.Status, got.ErrorMessage)
+	}
+
+	// Undeclared outputs are not substituted
+	content, err := os.ReadFile(result)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+	if want := "1920|line one\nline two|${{ steps.probe-input.outputs.secret }}"; string(content) != want {
+		t.Errorf("Expected %q, got %q", want, content)
+	}
+}
+
+func TestContinueOnErrorRunsLaterSteps(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: thumbnail
+    run: exit 2
+    continue_on_error: true
+  - name: convert
+    run: echo converted
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s", got.Status)
+	}
+	steps := getTestSteps(t, db, task.ID)
+	if len(steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(steps))
+	}
+	if steps["thumbnail"].Status != models.StepStatusFailed {
+		t.Errorf("Expected thumbnail step to be failed, got %s", steps["thumbnail"].Status)
+	}
+	if steps["convert"].Status != models.StepStatusCompleted {
+		t.Errorf("Expected convert step to be completed, got %s", steps["convert"].Status)
+	}
+
+	// The same failure without the flag still fails the task
+	db = setupTestDB(t)
+	wf = createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: thumbnail
+    run: exit 2
+  - name: convert
+    run: echo converted
+`)
+	task = createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusFailed {
+		t.Errorf("Expected task to fail, got %s", got.Status)
+	}
+}
+
+func TestDeclaredOutputsAreVerified(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+outputs:
+  - ${{ output_dir }}/${{ file_base }}.jpg
+  - ${{ output_dir }}/${{ file_base }}.json
+steps:
+  - name: convert
+    run: echo video > "${{ output_path }}" && echo thumb > "${{ output_dir }}/${{ file_base }}.jpg"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "clip.mov"), filepath.Join(dir, "clip.mp4"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	stored := getTestTask(t, db, task.ID)
+	if stored.Status != models.TaskStatusFailed {
+		t.Fatalf("Expected task to fail on a missing output, got %s", stored.Status)
+	}
+	if !strings.Contains(stored.ErrorMessage, "clip.json") {
+		t.Errorf("Expected error to name the missing output, got %q", stored.ErrorMessage)
+	}
+
+	outputs, err := database.NewTaskOutputRepo(db).GetByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get outputs: %v", err)
+	}
+	if len(outputs) != 2 {
+		t.Fatalf("Expected 2 declared outputs, got %d", len(outputs))
+	}
+	if outputs[0].Path != filepath.Join(dir, "clip.jpg") || !outputs[0].Exists || outputs[0].Size != 6 {
+		t.Errorf("Unexpected thumbnail output: %+v", outputs[0])
+	}
+	if outputs[1].Path != filepath.Join(dir, "clip.json") || outputs[1].Exists || outputs[1].CheckedAt == nil {
+		t.Errorf("Unexpected sidecar output: %+v", outputs[1])
+	}
+}
+
+func TestPanickedTaskPostsWebhookNotification(t *testing.T) {
+	received := make(chan TaskNotification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification TaskNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("Invalid notification body: %v", err)
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, echoWorkflow)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	notifier, err := newWebhookNotifier(server.URL, []string{models.TaskStatusFailed})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	executor := newTestExecutor(t, db)
+	executor.SetNotifier(notifier)
+	executor.SetLogSink(&panicLogSink{mockLogSink: mockLogSink{objects: make(map[string][]byte)}})
+
+	if err := executor.ExecuteTask(context.Background(), task.ID); err == nil {
+		t.Fatal("Expected ExecuteTask to report the panic")
+	}
+
+	select {
+	case notification := <-received:
+		if notification.TaskID != task.ID || notification.Status != models.TaskStatusFailed || !strings.Contains(notification.ErrorMessage, "panic") {
+			t.Errorf("Unexpected notification: %+v", notification)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No notification for the panicked task")
+	}
+	if _, err := database.NewTaskExecutionRepo(db).GetLatestByTaskID(task.ID); err != nil {
+		t.Errorf("Expected an execution record: %v", err)
+	}
+}
+
+func TestFailedTaskPostsWebhookNotification(t *testing.T) {
+	var attempts int
+	var mu sync.Mutex
+	received := make(chan TaskNotification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var notification TaskNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("Invalid notification body: %v", err)
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: convert
+    run: exit 1
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	notifier, err := newWebhookNotifier(server.URL, []string{models.TaskStatusFailed})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	notifier.retryDelay = 10 * time.Millisecond
+	executor := newTestExecutor(t, db)
+	executor.SetNotifier(notifier)
+
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	select {
+	case notification := <-received:
+		if notification.TaskID != task.ID || notification.Workflow != "test-workflow" || notification.Status != models.TaskStatusFailed {
+			t.Errorf("Unexpected notification: %+v", notification)
+		}
+		if notification.InputPath != task.InputPath || notification.ErrorMessage == "" {
+			t.Errorf("Expected input path and error message, got %+v", notification)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notification was not delivered after a retry")
+	}
+
+	if _, err := newWebhookNotifier(server.URL, []string{"done"}); err == nil {
+		t.Error("Expected an unknown status to be rejected")
+	}
+}
+
+func TestNotificationContextLeavesOutSecrets(t *testing.T) {
+	received := make(chan TaskNotification, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification TaskNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("Invalid notification body: %v", err)
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	t.Setenv("FILEACTION_TEST_API_KEY", "s3cret")
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+env:
+  DEPLOYMENT: staging
+  API_KEY: ${FILEACTION_TEST_API_KEY}
+  AUTH: Bearer ${FILEACTION_TEST_API_KEY}
+secrets: [API_KEY]
+notifications:
+  context:
+    deployment: ${DEPLOYMENT}
+    file: ${{ file_name }}
+    key: ${API_KEY}
+    auth: ${AUTH}
+steps:
+  - name: convert
+    run: exit 1
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	notifier, err := newWebhookNotifier(server.URL, []string{models.TaskStatusFailed})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	executor := newTestExecutor(t, db)
+	executor.SetNotifier(notifier)
+
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	select {
+	case notification := <-received:
+		want := map[string]string{"deployment": "staging", "file": "in.txt"}
+		if !maps.Equal(notification.Context, want) {
+			t.Errorf("Expected context %v, got %v", want, notification.Context)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notification was not delivered")
+	}
+}
+
+func TestStepTimeoutIsMarkedTimedOut(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: hang
+    run: exec sleep 5
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newExecutor(1, db, t.TempDir(), time.Minute, 100*time.Millisecond)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	failed, err := database.NewTaskStepRepo(db).GetFailedByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get failed steps: %v", err)
+	}
+	if len(failed) != 1 || failed[0].Status != models.StepStatusTimedOut {
+		t.Fatalf("Expected one timed out step, got %+v", failed)
+	}
+}
+
+func TestSoftTimeoutHookFiresBeforeHardKill(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	hookFile := filepath.Join(dir, "hook.txt")
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  soft_timeout: 100ms
+  hard_timeout: 500ms
+  on_timeout: echo "$FILEACTION_TASK_ID ${{ steps.prepare.status }}" > `+hookFile+`
+steps:
+  - name: prepare
+    run: "true"
+  - name: hang
+    run: exec sleep 5
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	start := time.Now()
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Expected hard timeout to kill the step, task took %v", elapsed)
+	}
+
+	data, err := os.ReadFile(hookFile)
+	if err != nil {
+		t.Fatalf("Expected on_timeout hook to run: %v", err)
+	}
+	// The hook sees the results of the steps that finished before it fired
+	if strings.TrimSpace(string(data)) != task.ID+" completed" {
+		t.Errorf("Expected hook to receive task ID %s and the prepare step's status, got %q", task.ID, data)
+	}
+
+	result := getTestTask(t, db, task.ID)
+	if result.Status != models.TaskStatusFailed || !strings.Contains(result.ErrorMessage, "hard timeout") {
+		t.Errorf("Expected task to fail on hard timeout, got %s: %q", result.Status, result.ErrorMessage)
+	}
+	soft := strings.Index(result.LogText, "soft timeout")
+	hard := strings.Index(result.LogText, "exceeded hard timeout")
+	if soft < 0 || hard < 0 || soft > hard {
+		t.Errorf("Expected soft timeout warning before hard kill in log:\n%s", result.LogText)
+	}
+}
+
+func TestVerifyOutputFailsOnEmptyOutput(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  verify_output: true
+steps:
+  - name: convert
+    run: if [ -s "${{ input_path }}" ]; then cp "${{ input_path }}" "${{ output_path }}"; else touch "${{ output_path }}"; fi
+`)
+	emptyInput := filepath.Join(dir, "empty.txt")
+	goodInput := filepath.Join(dir, "good.txt")
+	if err := os.WriteFile(emptyInput, nil, 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	if err := os.WriteFile(goodInput, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	empty := createTestTask(t, db, wf.ID, emptyInput, filepath.Join(dir, "empty.out"))
+	good := createTestTask(t, db, wf.ID, goodInput, filepath.Join(dir, "good.out"))
+
+	executor := newTestExecutor(t, db)
+	for _, task := range []*models.Task{empty, good} {
+		if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+			t.Fatalf("ExecuteTask failed: %v", err)
+		}
+	}
+
+	// The step exits 0 but leaves a zero-byte output
+	result := getTestTask(t, db, empty.ID)
+	if result.Status != models.TaskStatusFailed || !strings.Contains(result.ErrorMessage, "is empty") {
+		t.Errorf("Expected empty output to fail the task, got %s: %q", result.Status, result.ErrorMessage)
+	}
+	if steps := getTestSteps(t, db, empty.ID); steps["convert"].Status != models.StepStatusCompleted {
+		t.Errorf("Expected the step itself to succeed, got %s", steps["convert"].Status)
+	}
+
+	result = getTestTask(t, db, good.ID)
+	if result.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected non-empty output to pass, got %s: %q", result.Status, result.ErrorMessage)
+	}
+	if result.OutputSize != 5 || result.OutputMD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Expected output size and hash to be recorded, got %d %q", result.OutputSize, result.OutputMD5)
+	}
+}
+
+func TestVerifyOutputExpectedSizeAndHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		errText string
+	}{
+		{"matching", "expected_size: 5\n  expected_hash: 2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824", ""},
+		{"wrong size", "expected_size: 1KB", "is 5 bytes, expected 1024"},
+		{"wrong hash", "expected_hash: 00000000000000000000000000000000", "has md5 5d41402abc4b2a76b9719d911017c592"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			dir := t.TempDir()
+			wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  `+tt.options+`
+steps:
+  - name: convert
+    run: printf hello > "${{ output_path }}"
+`)
+			task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+			if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+
+			result := getTestTask(t, db, task.ID)
+			if tt.errText == "" {
+				if result.Status != models.TaskStatusCompleted {
+					t.Errorf("Expected the task to complete, got %s: %q", result.Status, result.ErrorMessage)
+				}
+				return
+			}
+			if result.Status != models.TaskStatusFailed || !strings.Contains(result.ErrorMessage, tt.errText) {
+				t.Errorf("Expected the task to fail with %q, got %s: %q", tt.errText, result.Status, result.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestWorkflowEnvFile(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "shared.env")
+	if err := os.WriteFile(envPath, []byte("# shared\nQUALITY=80\nPRESET='slow'\nAPI_TOKEN=s3cr3t-from-file\n"), 0644); err != nil {
+		t.Fatalf("Failed to write env file: %v", err)
+	}
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+env_file: `+envPath+`
+env:
+  PRESET: fast
+  PASSWORD: inline-secret
+secrets: [PASSWORD, API_TOKEN]
+steps:
+  - name: print
+    run: echo "$QUALITY $PRESET $API_TOKEN" > "${{ output_path }}"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "80 fast s3cr3t-from-file" {
+		t.Errorf("Expected inline env to override env_file, got %q", got)
+	}
+
+	// Secrets reach the command but are masked everywhere else; other env_file
+	// values are logged as they are
+	execution, err := database.NewTaskExecutionRepo(db).GetLatestByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Expected an execution record: %v", err)
+	}
+	logText := getTestTask(t, db, task.ID).LogText
+	for _, secret := range []string{"s3cr3t-from-file", "inline-secret"} {
+		if strings.Contains(logText, secret) || strings.Contains(execution.Record, secret) {
+			t.Errorf("Expected %q to be masked in the log and the execution record", secret)
+		}
+	}
+	if !strings.Contains(execution.Record, `"API_TOKEN":"***"`) || !strings.Contains(execution.Record, `"QUALITY":"80"`) {
+		t.Errorf("Expected masked secrets and plain env_file values in the execution record:\n%s", execution.Record)
+	}
+	if !strings.Contains(logText, "API_TOKEN=***") || !strings.Contains(logText, "QUALITY=80") {
+		t.Errorf("Expected masked secrets and plain env_file values in log:\n%s", logText)
+	}
+
+	// A missing env file fails the task through the normal completion path
+	// before any step runs
+	if err := os.Remove(envPath); err != nil {
+		t.Fatalf("Failed to remove env file: %v", err)
+	}
+	missing := createTestTask(t, db, wf.ID, filepath.Join(dir, "in2.txt"), filepath.Join(dir, "out2.txt"))
+	newTestExecutor(t, db).ExecuteTask(context.Background(), missing.ID)
+	result := getTestTask(t, db, missing.ID)
+	if result.Status != models.TaskStatusFailed || !strings.Contains(result.ErrorMessage, "does not exist") {
+		t.Errorf("Expected a missing env_file to fail the task, got %s: %q", result.Status, result.ErrorMessage)
+	}
+	if !strings.Contains(result.LogText, "does not exist") {
+		t.Errorf("Expected the env_file error in the stored log, got %q", result.LogText)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out2.txt")); err == nil {
+		t.Error("Expected no step to run")
+	}
+}
+
+func TestStepStdin(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	pluginYAML := `
+name: upper
+version: 1.0.0
+inputs:
+  suffix:
+    type: string
+steps:
+  - name: upper
+    stdin: "plugin ${{ inputs.suffix }}"
+    run: tr a-z A-Z > "${{ output_path }}.plugin"
+`
+	if _, _, err := database.NewPluginRepo(db).CreatePlugin("upper", "", pluginYAML, "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: from-file
+    stdin_file: ${{ input_path }}
+    run: tr a-z A-Z > "${{ output_path }}"
+  - name: from-text
+    stdin: "name=${{ file_name }}"
+    run: cat > "${{ output_path }}.text"
+  - name: plugin
+    uses: upper@1.0.0
+    with:
+      suffix: text
+`)
+	input := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	output := filepath.Join(dir, "out.txt")
+	task := createTestTask(t, db, wf.ID, input, output)
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if result := getTestTask(t, db, task.ID); result.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s: %q", result.Status, result.ErrorMessage)
+	}
+
+	for path, want := range map[string]string{output: "HELLO", output + ".text": "name=in.txt", output + ".plugin": "PLUGIN TEXT"} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("Expected %s to hold %q, got %q", filepath.Base(path), want, got)
+		}
+	}
+
+	// A missing stdin_file fails the step before it runs
+	missing := createTestTask(t, db, wf.ID, filepath.Join(dir, "missing.txt"), filepath.Join(dir, "missing.out"))
+	newTestExecutor(t, db).ExecuteTask(context.Background(), missing.ID)
+	if steps := getTestSteps(t, db, missing.ID); steps["from-file"].Status != models.StepStatusFailed || !strings.Contains(steps["from-file"].Stderr, "stdin file") {
+		t.Errorf("Expected the step to fail on its stdin file, got %s: %q", steps["from-file"].Status, steps["from-file"].Stderr)
+	}
+
+	// A stdin_file outside the allowed roots fails the path audit
+	outside := createTestTask(t, db, wf.ID, "/etc/hostname", filepath.Join(dir, "outside.out"))
+	audited := newTestExecutor(t, db)
+	audited.SetPathAudit([]string{dir}, PathAuditFail)
+	audited.ExecuteTask(context.Background(), outside.ID)
+	if steps := getTestSteps(t, db, outside.ID); steps["from-file"].Status != models.StepStatusFailed || !strings.Contains(steps["from-file"].Stderr, "path audit failed") {
+		t.Errorf("Expected the step to fail the path audit, got %s: %q", steps["from-file"].Status, steps["from-file"].Stderr)
+	}
+}
+
+func TestEmitResultJSONMatchesTask(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  emit_result_json: true
+steps:
+  - name: copy
+    run: cp "${{ input_path }}" "${{ output_path }}"
+`)
+	inputPath := filepath.Join(dir, "in.txt")
+	outputPath := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(inputPath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	task := createTestTask(t, db, wf.ID, inputPath, outputPath)
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath + ".fileaction.json")
+	if err != nil {
+		t.Fatalf("Expected result file: %v", err)
+	}
+	var result TaskResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Invalid result JSON: %v", err)
+	}
+
+	stored := getTestTask(t, db, task.ID)
+	if result.TaskID != stored.ID || result.Status != stored.Status || result.Workflow != "test-workflow" {
+		t.Errorf("Result does not match task: %+v", result)
+	}
+	if result.StartedAt == nil || !result.StartedAt.Equal(*stored.StartedAt) {
+		t.Errorf("Expected started_at %v, got %v", stored.StartedAt, result.StartedAt)
+	}
+	const helloMD5 = "5d41402abc4b2a76b9719d911017c592"
+	if result.Input.MD5 != helloMD5 || result.Output.MD5 != helloMD5 || result.Output.Size != 5 {
+		t.Errorf("Unexpected file hashes: input=%+v output=%+v", result.Input, result.Output)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].Name != "copy" || result.Steps[0].Status != models.StepStatusCompleted {
+		t.Errorf("Unexpected steps: %+v", result.Steps)
+	}
+}
+
+func TestEmitResultJSONOnFailure(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  emit_result_json: true
+  result_json_suffix: .result.json
+  result_json_on_failure: true
+steps:
+  - name: fail
+    run: exit 2
+`)
+	outputPath := filepath.Join(dir, "out.txt")
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath + ".result.json")
+	if err != nil {
+		t.Fatalf("Expected result file for failed task: %v", err)
+	}
+	var result TaskResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Invalid result JSON: %v", err)
+	}
+	if result.Status != models.TaskStatusFailed || result.ErrorMessage == "" {
+		t.Errorf("Expected failed result with error, got %+v", result)
+	}
+	if result.Output.MD5 != "" {
+		t.Errorf("Expected no hash for missing output, got %q", result.Output.MD5)
+	}
+}
+
+func TestRunPluginTestReturnsStepOutput(t *testing.T) {
+	sample := filepath.Join(t.TempDir(), "sample.txt")
+	if err := os.WriteFile(sample, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write sample: %v", err)
+	}
+
+	pluginYAML := `
+name: greet-plugin
+version: 1.0.0
+inputs:
+  greeting:
+    type: string
+    default: hi
+steps:
+  - name: greet
+    run: echo "${{ inputs.greeting }} $(cat "${{ input_path }}")"
+`
+	result, err := RunPluginTest(context.Background(), pluginYAML, map[string]string{"greeting": "hey"}, sample, time.Minute)
+	if err != nil {
+		t.Fatalf("RunPluginTest failed: %v", err)
+	}
+
+	if !result.Success {
+		t.Fatalf("Expected success, got error %q\n%s", result.Error, result.Log)
+	}
+	if len(result.Steps) != 1 {
+		t.Fatalf("Expected a single step result, got %d", len(result.Steps))
+	}
+	step := result.Steps[0]
+	if step.Status != models.StepStatusCompleted {
+		t.Errorf("Expected step completed, got %s", step.Status)
+	}
+	if step.Stdout != "hey hello\n" {
+		t.Errorf("Expected step output %q, got %q", "hey hello\n", step.Stdout)
+	}
+	if !strings.Contains(result.Log, "[stdout] hey hello\n") {
+		t.Errorf("Expected the step output streamed to the log, got:\n%s", result.Log)
+	}
+	if !strings.Contains(result.Log, "Plugin 'greet-plugin' completed successfully") {
+		t.Errorf("Expected completion in log, got:\n%s", result.Log)
+	}
+}
+
+func TestPTYOptionAttachesTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pty is only supported on Linux")
+	}
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skip("no pseudo-terminal support")
+	}
+
+	for _, tc := range []struct {
+		pty  bool
+		want string
+	}{
+		{pty: false, want: "notty\n"},
+		{pty: true, want: "tty\n"},
+	} {
+		t.Run(fmt.Sprintf("pty=%v", tc.pty), func(t *testing.T) {
+			db := setupTestDB(t)
+			dir := t.TempDir()
+			wf := createTestWorkflow(t, db, fmt.Sprintf(`
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  pty: %v
+steps:
+  - name: check
+    run: if [ -t 1 ]; then echo tty; else echo notty; fi
+`, tc.pty))
+			task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+			if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+
+			step := getTestSteps(t, db, task.ID)["check"]
+			if step == nil || step.Status != models.StepStatusCompleted {
+				t.Fatalf("Expected step to complete, got %+v", step)
+			}
+			if step.Stdout != tc.want {
+				t.Errorf("Expected output %q, got %q", tc.want, step.Stdout)
+			}
+		})
+	}
+}
+
+func TestMetadataVariablesSubstitute(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  metadata_command: |
+    echo x >> "`+calls+`"
+    echo '[{"ImageWidth": 1920, "CreateDate": "2024:01:02 10:00:00", "GPS": {"Latitude": 52.5}}]'
+steps:
+  - name: size
+    run: echo "${{ meta.ImageWidth }} ${{meta.GPS.Latitude}}"
+  - name: date
+    run: echo "${{ meta.CreateDate }} ${{ file_name }}"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "photo.png"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	steps := getTestSteps(t, db, task.ID)
+	if got := steps["size"].Stdout; got != "1920 52.5\n" {
+		t.Errorf("Expected numeric metadata to substitute, got %q", got)
+	}
+	if got := steps["date"].Stdout; got != "2024:01:02 10:00:00 photo.jpg\n" {
+		t.Errorf("Expected string metadata to substitute, got %q", got)
+	}
+
+	// The extractor runs once per task, not once per step
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Extractor did not run: %v", err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("Expected the extractor to run once, ran %d times", n)
+	}
+}
+
+func TestMetadataFailureFinishesTask(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  metadata_command: echo "not json"
+steps:
+  - name: never
+    run: echo x > "${{ output_path }}"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "photo.jpg"), filepath.Join(dir, "photo.png"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err == nil {
+		t.Fatal("Expected ExecuteTask to report the metadata failure")
+	}
+
+	got := getTestTask(t, db, task.ID)
+	if got.Status != models.TaskStatusFailed || !strings.Contains(got.ErrorMessage, "Metadata extraction failed") {
+		t.Errorf("Expected the task to fail on metadata, got %s: %q", got.Status, got.ErrorMessage)
+	}
+	if !strings.Contains(got.LogText, "Metadata extraction failed") {
+		t.Errorf("Expected the failure in the stored log, got %q", got.LogText)
+	}
+	if _, err := database.NewTaskExecutionRepo(db).GetLatestByTaskID(task.ID); err != nil {
+		t.Errorf("Expected an execution record: %v", err)
+	}
+}
+
+func TestPathAuditFlagsCommandOutsideRoots(t *testing.T) {
+	dir := t.TempDir()
+	workflowYAML := `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: convert
+    run: cp "${{ input_path }}" "${{ output_path }}" 2>/dev/null || true
+  - name: leak
+    run: echo x > /etc/fileaction-audit-test
+`
+
+	t.Run("fail", func(t *testing.T) {
+		db := setupTestDB(t)
+		wf := createTestWorkflow(t, db, workflowYAML)
+		task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+		executor := newTestExecutor(t, db)
+		executor.SetPathAudit([]string{dir}, PathAuditFail)
+		if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+			t.Fatalf("ExecuteTask failed: %v", err)
+		}
+
+		if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusFailed {
+			t.Errorf("Expected task to fail, got %s", status)
+		}
+		steps := getTestSteps(t, db, task.ID)
+		if steps["convert"].Status != models.StepStatusCompleted {
+			t.Errorf("Expected step inside the allowed root to run, got %s", steps["convert"].Status)
+		}
+		leak := steps["leak"]
+		if leak.Status != models.StepStatusFailed || !strings.Contains(leak.Stderr, "/etc/fileaction-audit-test") {
+			t.Errorf("Expected step writing to /etc to be refused, got %s: %q", leak.Status, leak.Stderr)
+		}
+		if leak.ExitCode != nil {
+			t.Errorf("Expected refused step not to run, got exit code %d", *leak.ExitCode)
+		}
+	})
+
+	t.Run("workflow commands", func(t *testing.T) {
+		db := setupTestDB(t)
+		wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  metadata_command: cat /etc/hostname
+steps:
+  - name: convert
+    run: touch "${{ output_path }}"
+`)
+		task := createTestTask(t, db, wf.ID, filepath.Join(dir, "meta.txt"), filepath.Join(dir, "meta.out"))
+
+		executor := newTestExecutor(t, db)
+		executor.SetPathAudit([]string{dir}, PathAuditFail)
+		executor.ExecuteTask(context.Background(), task.ID)
+
+		got := getTestTask(t, db, task.ID)
+		if got.Status != models.TaskStatusFailed || !strings.Contains(got.ErrorMessage, "path audit failed") {
+			t.Errorf("Expected metadata_command to fail the audit, got %s: %q", got.Status, got.ErrorMessage)
+		}
+	})
+
+	t.Run("plugin test", func(t *testing.T) {
+		executor := &Executor{stepTimeout: time.Minute}
+		executor.SetPathAudit([]string{dir}, PathAuditFail)
+		result, err := runPluginTest(context.Background(), executor, "name: leak\nversion: 1.0.0\nsteps:\n  - name: leak\n    run: cat /etc/hostname\n", nil, "")
+		if err != nil {
+			t.Fatalf("runPluginTest failed: %v", err)
+		}
+		if result.Success || !strings.Contains(result.Error, "path audit failed") {
+			t.Errorf("Expected the plugin test to fail the audit, got success=%v error=%q", result.Success, result.Error)
+		}
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		executor := &Executor{allowedRoots: []string{dir}, pathAuditMode: PathAuditWarn}
+		var buf strings.Builder
+		logWriter := bufio.NewWriter(&buf)
+		if err := executor.auditCommand("echo x > /etc/fileaction-audit-test", logWriter, nil); err != nil {
+			t.Errorf("Expected warn mode not to fail, got %v", err)
+		}
+		logWriter.Flush()
+		if !strings.Contains(buf.String(), "WARNING: Path audit") {
+			t.Errorf("Expected a warning in the log, got %q", buf.String())
+		}
+	})
+}
+
+func TestRetryResumesFromStep(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "prepare.count")
+	flag := filepath.Join(dir, "ready")
+
+	wf := createTestWorkflow(t, db, fmt.Sprintf(`
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: prepare
+    run: echo run >> %s
+  - name: convert
+    run: test -f %s
+  - name: finish
+    run: touch "${{ output_path }}"
+`, counter, flag))
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusFailed {
+		t.Fatalf("Expected first run to fail, got %s", status)
+	}
+
+	// Retry from step 2 once the failing step can succeed
+	if err := os.WriteFile(flag, nil, 0644); err != nil {
+		t.Fatalf("Failed to write flag: %v", err)
+	}
+	retried := getTestTask(t, db, task.ID)
+	retried.Status = models.TaskStatusPending
+	retried.ResumeFrom = 2
+	if err := database.NewTaskRepo(db).Update(retried); err != nil {
+		t.Fatalf("Failed to reset task: %v", err)
+	}
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusCompleted {
+		t.Errorf("Expected resumed task to complete, got %s", status)
+	}
+	runs, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("Expected step 1 to run once, ran %d times", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); err != nil {
+		t.Errorf("Expected step 3 to produce output: %v", err)
+	}
+}
+
+func TestRetryResumeRestoresSkippedStepResults(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	flag := filepath.Join(dir, "ready")
+	result := filepath.Join(dir, "result.txt")
+
+	pluginYAML := `name: probe-plugin
+version: 1.0.0
+outputs:
+  width:
+    description: Frame width
+steps:
+  - name: probe
+    run: echo "width=1920" >> "$FILEACTION_OUTPUT"
+`
+	if _, _, err := database.NewPluginRepo(db).CreatePlugin("probe-plugin", "", pluginYAML, "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	wf := createTestWorkflow(t, db, fmt.Sprintf(`
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: probe-input
+    uses: probe-plugin@1.0.0
+  - name: convert
+    run: test -f %s
+  - name: report
+    run: printf '%%s|%%s' '${{ steps.probe-input.status }}' '${{ steps.probe-input.outputs.width }}' > %s
+`, flag, result))
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	if err := os.WriteFile(flag, nil, 0644); err != nil {
+		t.Fatalf("Failed to write flag: %v", err)
+	}
+	retried := getTestTask(t, db, task.ID)
+	retried.Status = models.TaskStatusPending
+	retried.ResumeFrom = 2
+	if err := database.NewTaskRepo(db).Update(retried); err != nil {
+		t.Fatalf("Failed to reset task: %v", err)
+	}
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	// The skipped plugin step's status and outputs come from the first run
+	content, err := os.ReadFile(result)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+	if want := "completed|1920"; string(content) != want {
+		t.Errorf("Expected %q, got %q", want, content)
+	}
+}
+
+func TestTaskEnvOverridesReachCommand(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+env:
+  DEBUG: "0"
+  LEVEL: info
+steps:
+  - name: convert
+    run: echo "debug=$DEBUG level=$LEVEL" > "${{ output_path }}"
+    env:
+      DEBUG: step
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+	task.Env = map[string]string{"DEBUG": "1"}
+	if err := database.NewTaskRepo(db).Update(task); err != nil {
+		t.Fatalf("Failed to set task env: %v", err)
+	}
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s", status)
+	}
+
+	output, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != "debug=1 level=info" {
+		t.Errorf("Expected task env to override step env, got %q", got)
+	}
+}
+
+func TestStepOutputLoggedInEmissionOrder(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: interleave
+    run: for i in 1 2 3; do echo out$i; sleep 0.05; echo err$i >&2; sleep 0.05; done
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	// The log keeps the order the lines were written in
+	logText := getTestTask(t, db, task.ID).LogText
+	last := -1
+	for _, line := range []string{"[stdout] out1", "[stderr] err1", "[stdout] out2", "[stderr] err2", "[stdout] out3", "[stderr] err3"} {
+		i := strings.Index(logText, line)
+		if i < 0 {
+			t.Fatalf("Expected %q in log:\n%s", line, logText)
+		}
+		if i < last {
+			t.Errorf("Expected %q after the previous line in log:\n%s", line, logText)
+		}
+		last = i
+	}
+
+	// The step record still separates the streams
+	step := getTestSteps(t, db, task.ID)["interleave"]
+	if step.Stdout != "out1\nout2\nout3\n" || step.Stderr != "err1\nerr2\nerr3\n" {
+		t.Errorf("Unexpected step output: stdout=%q stderr=%q", step.Stdout, step.Stderr)
+	}
+}
+
+func TestChangedInputSupersedesTask(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(inputPath, []byte("queued"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  verify_input_hash: true
+steps:
+  - name: convert
+    run: cp "${{ input_path }}" "${{ output_path }}"
+`)
+	task := createTestTask(t, db, wf.ID, inputPath, filepath.Join(dir, "out.txt"))
+	task.InputMD5 = describeFile(inputPath).MD5
+	if err := database.NewTaskRepo(db).Update(task); err != nil {
+		t.Fatalf("Failed to record input hash: %v", err)
+	}
+
+	// The file changes again while the task waits in the queue
+	if err := os.WriteFile(inputPath, []byte("changed while waiting"), 0644); err != nil {
+		t.Fatalf("Failed to rewrite input: %v", err)
+	}
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	got := getTestTask(t, db, task.ID)
+	if got.Status != models.TaskStatusCancelled || got.CancelReason != models.CancelReasonSuperseded {
+		t.Errorf("Expected stale task to be superseded, got %s (%q)", got.Status, got.CancelReason)
+	}
+	if !strings.Contains(got.LogText, "Input changed since the task was queued") {
+		t.Errorf("Expected the skip to be stored in the log, got %q", got.LogText)
+	}
+	if steps := getTestSteps(t, db, task.ID); len(steps) != 0 {
+		t.Errorf("Expected no steps to run, got %d", len(steps))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no output for the stale task, got %v", err)
+	}
+}
+
+func TestAtomicOutputPublishedOnlyOnSuccess(t *testing.T) {
+	workflowYAML := func(lastStep string) string {
+		return `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  atomic_output: true
+steps:
+  - name: convert
+    run: echo converted > "${{ output_tmp }}"
+  - name: finish
+    run: ` + lastStep + `
+`
+	}
+
+	tests := []struct {
+		name      string
+		lastStep  string
+		status    string
+		published bool
+	}{
+		{"failure", "exit 1", models.TaskStatusFailed, false},
+		{"success", "test -f \"${{ output_tmp }}\" && test ! -e \"${{ output_path }}\"", models.TaskStatusCompleted, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			dir := t.TempDir()
+			outputPath := filepath.Join(dir, "out.txt")
+			wf := createTestWorkflow(t, db, workflowYAML(tt.lastStep))
+			task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+			executor := newTestExecutor(t, db)
+			if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+			if status := getTestTask(t, db, task.ID).Status; status != tt.status {
+				t.Errorf("Expected status %s, got %s", tt.status, status)
+			}
+
+			// Only the published output is left in the directory
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("Failed to read output directory: %v", err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if tt.published {
+				content, err := os.ReadFile(outputPath)
+				if err != nil || string(content) != "converted\n" {
+					t.Errorf("Expected published output, got %q (%v)", content, err)
+				}
+				if len(names) != 1 {
+					t.Errorf("Expected only the output, got %v", names)
+				}
+			} else if len(names) != 0 {
+				t.Errorf("Expected no output or temporary file after failure, got %v", names)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetriesFlakyStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		failCode int
+		status   string
+		attempts int
+	}{
+		{"listed exit code", 75, models.TaskStatusCompleted, 2},
+		{"unlisted exit code", 2, models.TaskStatusFailed, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			dir := t.TempDir()
+			marker := filepath.Join(dir, "attempted")
+
+			// The step fails the first time it runs and succeeds afterwards
+			wf := createTestWorkflow(t, db, fmt.Sprintf(`
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  retry:
+    max_attempts: 3
+    backoff: 10ms
+    on_exit_codes: [1, 75]
+steps:
+  - name: flaky
+    run: if [ -e %q ]; then echo ok; else touch %q; exit %d; fi
+`, marker, marker, tt.failCode))
+			task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+			executor := newTestExecutor(t, db)
+			if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+
+			got := getTestTask(t, db, task.ID)
+			if got.Status != tt.status {
+				t.Errorf("Expected status %s, got %s: %s", tt.status, got.Status, got.ErrorMessage)
+			}
+			step := getTestSteps(t, db, task.ID)["flaky"]
+			if step == nil || step.Attempts != tt.attempts {
+				t.Fatalf("Expected %d attempt(s), got %+v", tt.attempts, step)
+			}
+			wantExit := 0
+			if tt.status == models.TaskStatusFailed {
+				wantExit = tt.failCode
+			}
+			if step.ExitCode == nil || *step.ExitCode != wantExit {
+				t.Errorf("Expected exit code %d, got %v", wantExit, step.ExitCode)
+			}
+			if want := fmt.Sprintf("Attempts: %d", tt.attempts); !strings.Contains(got.LogText, want) {
+				t.Errorf("Expected %q in log:\n%s", want, got.LogText)
+			}
+		})
+	}
+}
+
+func TestStepRunsInWorkingDir(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	outputPath := filepath.Join(t.TempDir(), "out.txt")
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: where
+    working_dir: ${{ file_dir }}
+    run: pwd > "${{ output_path }}"
+  - name: missing
+    working_dir: ${{ file_dir }}/missing
+    run: "true"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if got := strings.TrimSpace(string(content)); got != dir {
+		t.Errorf("Expected step to run in %s, got %s", dir, got)
+	}
+
+	// The missing directory fails its step without running it
+	if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusFailed {
+		t.Errorf("Expected task to fail, got %s", status)
+	}
+	step := getTestSteps(t, db, task.ID)["missing"]
+	if step == nil || step.Status != models.StepStatusFailed || !strings.Contains(step.Stderr, "working directory") {
+		t.Errorf("Expected missing working directory to fail the step, got %+v", step)
+	}
+}
+
+func TestLogsCappedToMaxLogBytes(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: chatty
+    run: seq 1 5000
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	executor.SetMaxLogBytes(1000)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	// The head and tail survive around the omission marker
+	step := getTestSteps(t, db, task.ID)["chatty"]
+	if !strings.HasPrefix(step.Stdout, "1\n2\n3\n") || !strings.HasSuffix(step.Stdout, "4999\n5000\n") {
+		t.Errorf("Expected head and tail of the output, got %q", step.Stdout)
+	}
+	if !strings.Contains(step.Stdout, " bytes omitted] ...") || len(step.Stdout) > 1100 {
+		t.Errorf("Expected stdout truncated to about 1000 bytes, got %d bytes", len(step.Stdout))
+	}
+
+	logText := getTestTask(t, db, task.ID).LogText
+	if !strings.Contains(logText, " bytes omitted] ...") || len(logText) > 1100 {
+		t.Errorf("Expected log truncated to about 1000 bytes, got %d bytes", len(logText))
+	}
+	if !strings.Contains(logText, "Total execution time") {
+		t.Errorf("Expected the end of the log to be kept:\n%s", logText)
+	}
+}
+
+func TestLogCapKeepsWholeCharacters(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	// 3-byte characters on 4-byte lines, so the cut points land inside them
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: chatty
+    run: for i in $(seq 1 2000); do echo "€"; done
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	executor.SetMaxLogBytes(1001)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	step := getTestSteps(t, db, task.ID)["chatty"]
+	if !strings.Contains(step.Stdout, " bytes omitted] ...") {
+		t.Errorf("Expected stdout to be truncated, got %d bytes", len(step.Stdout))
+	}
+	if !utf8.ValidString(step.Stdout) {
+		t.Errorf("Expected stdout cut on character boundaries, got %q", step.Stdout)
+	}
+	if logText := getTestTask(t, db, task.ID).LogText; !utf8.ValidString(logText) {
+		t.Errorf("Expected log cut on character boundaries, got %q", logText)
+	}
+}
+
+func TestWorkflowTimeoutsOverrideGlobal(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		message string
+		status  string
+	}{
+		{"task timeout", "timeout: 300ms", "Task exceeded timeout of 300ms", models.StepStatusTimedOut},
+		{"step timeout", "step_timeout: 300ms", "One or more steps failed", models.StepStatusTimedOut},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			dir := t.TempDir()
+			wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  `+tt.options+`
+steps:
+  - name: slow
+    run: exec sleep 5
+`)
+			task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+			// The executor's own timeouts are a minute; the workflow's apply instead
+			executor := newTestExecutor(t, db)
+			start := time.Now()
+			if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("Expected the step to be killed early, took %v", elapsed)
+			}
+
+			got := getTestTask(t, db, task.ID)
+			if got.Status != models.TaskStatusFailed || got.ErrorMessage != tt.message {
+				t.Errorf("Expected failed task with %q, got %s: %q", tt.message, got.Status, got.ErrorMessage)
+			}
+			if step := getTestSteps(t, db, task.ID)["slow"]; step == nil || step.Status != tt.status {
+				t.Errorf("Expected step status %s, got %+v", tt.status, step)
+			}
+		})
+	}
+}
+
+func TestExecutionRecordStored(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+env:
+  GREETING: hello
+steps:
+  - name: greet
+    run: echo "$GREETING"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	execution, err := database.NewTaskExecutionRepo(db).GetLatestByTaskID(task.ID)
+	if err != nil {
+		t.Fatalf("Expected an execution record: %v", err)
+	}
+	var record ExecutionRecord
+	if err := json.Unmarshal([]byte(execution.Record), &record); err != nil {
+		t.Fatalf("Invalid execution record: %v", err)
+	}
+
+	if record.TaskID != task.ID || record.Environment["GREETING"] != "hello" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if record.EndTime.Before(record.StartTime) || len(record.LogEntries) == 0 {
+		t.Errorf("Expected timings and log entries, got %+v", record)
+	}
+	if len(record.Steps) != 1 || record.Steps[0].Name != "greet" || !strings.Contains(record.Steps[0].Stdout, "hello") {
+		t.Errorf("Expected the greet step with its output, got %+v", record.Steps)
+	}
+}
+
+func TestTriggerWorkflowChainsTasks(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "out.txt")
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  trigger_workflow: next-workflow
+steps:
+  - name: produce
+    run: echo intermediate > "${{ output_path }}"
+`)
+	next := &models.Workflow{
+		Name:        "next-workflow",
+		YAMLContent: "name: next-workflow\non:\n  paths:\n    - ./other\nconvert:\n  from: txt\n  to: md\nsteps:\n  - name: noop\n    run: \"true\"\n",
+		Enabled:     true,
+	}
+	if err := database.NewWorkflowRepo(db).Create(next); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+	taskRepo := database.NewTaskRepo(db)
+
+	executor := newTestExecutor(t, db)
+	executor.SetMaxChainDepth(1)
+	executor.SetHashAlgorithm(filehash.SHA256)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	chained, err := taskRepo.List(next.ID, "", 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if len(chained) != 1 {
+		t.Fatalf("Expected one chained task, got %d", len(chained))
+	}
+	if c := chained[0]; c.InputPath != outputPath || c.OutputPath != filepath.Join(dir, "out.md") ||
+		c.ChainDepth != 1 || c.Status != models.TaskStatusPending {
+		t.Errorf("Unexpected chained task %+v", c)
+	}
+
+	// The output is indexed under the triggered workflow with the configured hash
+	fileRepo := database.NewFileRepo(db)
+	indexed, err := fileRepo.GetByWorkflowAndPath(next.ID, outputPath)
+	if err != nil || indexed == nil {
+		t.Fatalf("Expected the output to be indexed under the triggered workflow: %v", err)
+	}
+	if c := chained[0]; c.FileID != indexed.ID || c.FileID == task.FileID || c.InputMD5 != indexed.FileMD5 ||
+		filehash.AlgorithmOf(indexed.FileMD5) != filehash.SHA256 {
+		t.Errorf("Expected the chained task to use the indexed output, got task %+v and file %+v", c, indexed)
+	}
+
+	// A task at the depth limit doesn't trigger another hop
+	deep := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+	deep.ChainDepth = 1
+	if err := taskRepo.Update(deep); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if err := executor.ExecuteTask(context.Background(), deep.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if count, _ := taskRepo.Count(next.ID, ""); count != 1 {
+		t.Errorf("Expected the depth limit to stop the chain, got %d chained tasks", count)
+	}
+	if log := getTestTask(t, db, deep.ID).LogText; !strings.Contains(log, "chain depth limit of 1 reached") {
+		t.Errorf("Expected the skipped trigger to be logged, got:\n%s", log)
+	}
+
+	// A paused workflow gets the output flagged for its resume instead of a task
+	next.Paused = true
+	if err := database.NewWorkflowRepo(db).Update(next); err != nil {
+		t.Fatalf("Failed to pause workflow: %v", err)
+	}
+	paused := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+	if err := executor.ExecuteTask(context.Background(), paused.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if count, _ := taskRepo.Count(next.ID, ""); count != 1 {
+		t.Errorf("Expected no task for the paused workflow, got %d chained tasks", count)
+	}
+	if deferred, _ := fileRepo.ListDeferred(next.ID); len(deferred) != 1 || deferred[0].FilePath != outputPath {
+		t.Errorf("Expected the output to be deferred, got %+v", deferred)
+	}
+}
+
+func TestHostEnvResolvedForCommands(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "out.txt")
+	t.Setenv("FILEACTION_TEST_SECRET", "s3cret")
+	os.Unsetenv("FILEACTION_TEST_UNSET")
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+env:
+  API_KEY: ${FILEACTION_TEST_SECRET}
+steps:
+  - name: use-env
+    env:
+      TOKEN: token-${FILEACTION_TEST_SECRET}
+      EMPTY: ${FILEACTION_TEST_UNSET}
+    run: printf '%s|%s|%s' "$API_KEY" "$TOKEN" "$EMPTY" > "${{ output_path }}"
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), outputPath)
+
+	executor := newTestExecutor(t, db)
+	if err := executor.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if got := string(content); got != "s3cret|token-s3cret|" {
+		t.Errorf("Expected host variables in the step env, got %q", got)
+	}
+
+	log := getTestTask(t, db, task.ID).LogText
+	if strings.Contains(log, "s3cret") {
+		t.Errorf("Expected the resolved secret to stay out of the log, got:\n%s", log)
+	}
+	if !strings.Contains(log, "WARNING: Host environment variable FILEACTION_TEST_UNSET used by EMPTY is not set") {
+		t.Errorf("Expected a warning about the unset variable, got:\n%s", log)
+	}
+}
+
+func TestStepShellSelection(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: bash-only
+    shell: bash
+    run: '[[ -n "$BASH_VERSION" ]] && touch `+dir+`/bash'
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected task to complete, got %s: %s", got.Status, got.ErrorMessage)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bash")); err != nil {
+		t.Errorf("Expected the step to run under bash: %v", err)
+	}
+
+	// options.shell applies to steps without a shell and to the workflow's own commands
+	db = setupTestDB(t)
+	wf = createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  shell: bash
+  metadata_command: '[[ -n "$BASH_VERSION" ]] && echo "{\"shell\": \"bash\"}"'
+  verify_command: '[[ -n "$BASH_VERSION" ]]'
+steps:
+  - name: default-shell
+    run: '[[ -n "$BASH_VERSION" ]] && echo ${{ meta.shell }} > "${{ output_path }}"'
+`)
+	task = createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "default.out"))
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusCompleted {
+		t.Fatalf("Expected options.shell to run every command under bash, got %s: %s", got.Status, got.ErrorMessage)
+	}
+	if out, _ := os.ReadFile(filepath.Join(dir, "default.out")); string(out) != "bash\n" {
+		t.Errorf("Expected the step to see the metadata, got %q", out)
+	}
+
+	// A shell that isn't installed fails the step before it runs
+	if _, err := exec.LookPath("pwsh"); err == nil {
+		t.Skip("pwsh is installed")
+	}
+	db = setupTestDB(t)
+	wf = createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: powershell
+    shell: pwsh
+    run: New-Item `+dir+`/pwsh
+`)
+	task = createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+	if got := getTestTask(t, db, task.ID); got.Status != models.TaskStatusFailed {
+		t.Fatalf("Expected task to fail, got %s", got.Status)
+	}
+	if step := getTestSteps(t, db, task.ID)["powershell"]; !strings.Contains(step.Stderr, "shell pwsh is not available") {
+		t.Errorf("Expected a missing shell error, got %q", step.Stderr)
+	}
+}
+
+func TestStepIfConditions(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: png-only
+    if: ${{ file_ext == '.png' }}
+    run: touch `+dir+`/png
+  - name: flaky
+    run: "false"
+    continue_on_error: true
+  - name: cleanup
+    if: ${{ steps.flaky.status == 'failed' && steps.png-only.status == 'skipped' }}
+    run: touch `+dir+`/cleanup
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+
+	if err := newTestExecutor(t, db).ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("ExecuteTask failed: %v", err)
+	}
+
+	steps := getTestSteps(t, db, task.ID)
+	if steps["png-only"].Status != models.StepStatusSkipped {
+		t.Errorf("Expected png-only to be skipped, got %+v", steps["png-only"])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "png")); err == nil {
+		t.Error("Expected the skipped step not to run")
+	}
+	if steps["cleanup"].Status != models.StepStatusCompleted {
+		t.Errorf("Expected cleanup to run after the failed step, got %+v", steps["cleanup"])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cleanup")); err != nil {
+		t.Errorf("Expected cleanup output: %v", err)
+	}
+}
+
+func TestExecutorStatusDuringExecution(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: wait
+    run: sleep 0.3
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt"))
+	executor := newTestExecutor(t, db)
+
+	done := make(chan error)
+	go func() {
+		done <- executor.ExecuteTask(context.Background(), task.ID)
+	}()
+
+	// Poll from another goroutine, as the status endpoints do
+	sawBusy := false
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("ExecuteTask failed: %v", err)
+			}
+			if !sawBusy {
+				t.Error("Expected to see the executor busy")
+			}
+			if status := executor.Status(time.Now()); status.Busy || status.CurrentTask != "" || status.StartedAt != nil {
+				t.Errorf("Expected an idle executor after the task, got %+v", status)
+			}
+			return
+		default:
+		}
+		status := executor.Status(time.Now())
+		if status.Busy {
+			sawBusy = true
+			if status.CurrentTask != task.ID {
+				t.Fatalf("Expected a busy executor to report task %s, got %+v", task.ID, status)
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
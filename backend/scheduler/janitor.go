@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+)
+
+// defaultCleanupInterval is how often the janitor runs when no interval is set
+const defaultCleanupInterval = time.Hour
+
+// SetRetention enables the janitor: every interval it archives completed,
+// failed and cancelled tasks that finished more than retentionDays ago, then
+// purges tasks archived longer ago than archiveRetention. Pending and running
+// tasks and the files index are never touched. A retentionDays of 0 disables
+// the janitor; an archiveRetention of 0 leaves archived tasks in place.
+// It must be called before Start.
+func (s *Scheduler) SetRetention(retentionDays int, archiveRetention, interval time.Duration) {
+	if retentionDays < 0 {
+		retentionDays = 0
+	}
+	if interval <= 0 {
+		interval = defaultCleanupInterval
+	}
+	s.retentionDays = retentionDays
+	s.archiveRetention = archiveRetention
+	s.cleanupInterval = interval
+}
+
+// runJanitor periodically cleans up old tasks in its own goroutine, so a slow
+// cleanup never delays dispatching
+func (s *Scheduler) runJanitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	s.cleanupOldTasks()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.cleanupOldTasks()
+		}
+	}
+}
+
+// cleanupOldTasks archives finished tasks past the retention period and purges
+// archived tasks past the archive retention
+func (s *Scheduler) cleanupOldTasks() {
+	retention := time.Duration(s.retentionDays) * 24 * time.Hour
+	archived, err := s.taskRepo.ArchiveFinishedBefore(time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Error archiving tasks older than %d day(s): %v", s.retentionDays, err)
+	} else if archived > 0 {
+		log.Printf("Archived %d finished task(s) older than %d day(s)", archived, s.retentionDays)
+	}
+
+	if s.archiveRetention <= 0 {
+		return
+	}
+	purged, err := s.taskRepo.PurgeArchived(time.Now().Add(-s.archiveRetention))
+	if err != nil {
+		log.Printf("Error purging archived tasks: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d task(s) archived longer than %v", purged, s.archiveRetention)
+	}
+}
@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// defaultMaxLogBytes caps each stored task log and step output unless configured otherwise
+const defaultMaxLogBytes = 10 << 20
+
+// SetMaxLogBytes caps the task log and the step stdout and stderr stored in
+// the database. Longer content keeps its head and tail around a marker
+// saying how much was omitted. 0 or less stores everything.
+func (e *Executor) SetMaxLogBytes(n int64) {
+	e.maxLogBytes = n
+}
+
+// omissionMarker replaces the middle of truncated content
+func omissionMarker(omitted int64) string {
+	return fmt.Sprintf("\n... [%d bytes omitted] ...\n", omitted)
+}
+
+// cappedBuffer collects an output stream while it is produced, keeping at
+// most max bytes: the head and the tail of longer output, so it is never held
+// in memory whole. 0 or less keeps everything.
+type cappedBuffer struct {
+	max   int64
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func newCappedBuffer(max int64) *cappedBuffer {
+	return &cappedBuffer{max: max}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+	if b.max <= 0 {
+		b.head = append(b.head, p...)
+		return n, nil
+	}
+
+	if room := b.max/2 - int64(len(b.head)); room > 0 {
+		take := int64(len(p))
+		if take > room {
+			take = room
+		}
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	b.tail = append(b.tail, p...)
+	// Drop what can no longer be part of the tail once it has doubled
+	if keep := b.tailMax(); int64(len(b.tail)) > 2*keep {
+		b.tail = append([]byte(nil), b.tail[int64(len(b.tail))-keep:]...)
+	}
+	return n, nil
+}
+
+func (b *cappedBuffer) tailMax() int64 {
+	return b.max - b.max/2
+}
+
+// String returns the collected output, with an omission marker in place of
+// the middle if it was longer than max bytes
+func (b *cappedBuffer) String() string {
+	if b.max <= 0 || b.total <= b.max {
+		return string(b.head) + string(b.tail)
+	}
+	tail := b.tail
+	if keep := b.tailMax(); int64(len(tail)) > keep {
+		tail = tail[int64(len(tail))-keep:]
+	}
+	return string(joinCapped(b.head, tail, b.total))
+}
+
+// joinCapped joins the head and tail of content that was total bytes long
+// around an omission marker. Both are cut back to whole UTF-8 characters.
+func joinCapped(head, tail []byte, total int64) []byte {
+	// A character split at the end of the head is dropped
+	for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(head[i]) {
+			if !utf8.FullRune(head[i:]) {
+				head = head[:i]
+			}
+			break
+		}
+	}
+	// So are the continuation bytes at the start of the tail
+	for n := 0; n < utf8.UTFMax-1 && len(tail) > 0 && !utf8.RuneStart(tail[0]); n++ {
+		tail = tail[1:]
+	}
+
+	content := append(append([]byte(nil), head...), omissionMarker(total-int64(len(head))-int64(len(tail)))...)
+	return append(content, tail...)
+}
+
+// readLogFile reads a task log file. Logs longer than max bytes keep only
+// their head and tail, so an oversized log is never read into memory whole.
+func readLogFile(path string, max int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if max <= 0 || size <= max {
+		return io.ReadAll(file)
+	}
+
+	head := make([]byte, max/2)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, max-int64(len(head)))
+	if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+
+	return joinCapped(head, tail, size), nil
+}
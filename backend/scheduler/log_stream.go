@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// lineLogger writes a command's output stream to the task log line by line
+// as it is produced, tagged with the stream name, while keeping the stream,
+// capped at maxLogBytes, for the step record. Giving stdout and stderr each their own
+// lineLogger keeps their lines in emission order in the log.
+type lineLogger struct {
+	e       *Executor
+	w       *bufio.Writer
+	record  *ExecutionRecord
+	tag     string
+	output  *cappedBuffer
+	partial []byte
+}
+
+func newLineLogger(e *Executor, w *bufio.Writer, record *ExecutionRecord, stream string) *lineLogger {
+	return &lineLogger{e: e, w: w, record: record, tag: "[" + stream + "] ", output: newCappedBuffer(e.maxLogBytes)}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.output.Write(p)
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.e.writeLog(l.w, l.record, l.tag+string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs a final line that was not terminated by a newline
+func (l *lineLogger) Flush() {
+	if len(l.partial) > 0 {
+		l.e.writeLog(l.w, l.record, l.tag+string(l.partial))
+		l.partial = nil
+	}
+}
+
+// String returns what was written to the stream, capped like stored step output
+func (l *lineLogger) String() string {
+	return l.output.String()
+}
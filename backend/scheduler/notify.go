@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// notifyAttempts is how many times a notification is posted before it is dropped
+const notifyAttempts = 3
+
+// TaskNotification is the JSON payload posted to the notification webhook
+type TaskNotification struct {
+	Text         string  `json:"text"` // One-line summary, shown by Slack and Teams incoming webhooks
+	TaskID       string  `json:"task_id"`
+	Workflow     string  `json:"workflow"`
+	Status       string  `json:"status"`
+	InputPath    string  `json:"input_path"`
+	ErrorMessage string  `json:"error_message,omitempty"`
+	Duration     float64 `json:"duration"` // Seconds
+
+	// Context is the workflow's rendered notifications.context
+	Context map[string]string `json:"context,omitempty"`
+}
+
+// webhookNotifier posts finished tasks with selected statuses to a webhook.
+// Delivery runs in the background and never holds up the task.
+type webhookNotifier struct {
+	url        string
+	on         map[string]bool
+	client     *http.Client
+	retryDelay time.Duration // Doubles after each failed attempt
+}
+
+// newWebhookNotifier creates a notifier for tasks finishing with one of the
+// given statuses
+func newWebhookNotifier(url string, on []string) (*webhookNotifier, error) {
+	statuses := make(map[string]bool, len(on))
+	for _, status := range on {
+		switch status {
+		case models.TaskStatusCompleted, models.TaskStatusFailed, models.TaskStatusCancelled:
+			statuses[status] = true
+		default:
+			return nil, fmt.Errorf("unknown notification status %q (expected completed, failed or cancelled)", status)
+		}
+	}
+	return &webhookNotifier{
+		url:        url,
+		on:         statuses,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retryDelay: 2 * time.Second,
+	}, nil
+}
+
+// notify posts a finished task in the background if its status is selected.
+// A nil notifier does nothing.
+func (n *webhookNotifier) notify(task *models.Task, workflowName string, duration time.Duration, context map[string]string) {
+	if n == nil || !n.on[task.Status] {
+		return
+	}
+
+	text := fmt.Sprintf("Task %s of workflow %s %s: %s", task.ID, workflowName, task.Status, task.InputPath)
+	if task.ErrorMessage != "" {
+		text += " (" + task.ErrorMessage + ")"
+	}
+	go n.deliver(TaskNotification{
+		Text:         text,
+		TaskID:       task.ID,
+		Workflow:     workflowName,
+		Status:       task.Status,
+		InputPath:    task.InputPath,
+		ErrorMessage: task.ErrorMessage,
+		Duration:     duration.Seconds(),
+		Context:      context,
+	})
+}
+
+// notificationContext renders the workflow's notifications.context. ${NAME}
+// references resolve against the workflow env first, then the server's
+// environment. An entry that refers to a variable listed under secrets, or
+// whose value contains a secret's value, is left out.
+func notificationContext(workflowDef *workflow.WorkflowDef, vars workflow.Variables) map[string]string {
+	if len(workflowDef.Notifications.Context) == 0 {
+		return nil
+	}
+
+	lookup := func(name string) (string, bool) {
+		if value, ok := workflowDef.Env[name]; ok {
+			expanded, _ := workflow.ExpandHostEnv(value)
+			return expanded, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	secretNames := make(map[string]bool, len(workflowDef.Secrets))
+	var secretValues []string
+	for _, name := range workflowDef.Secrets {
+		secretNames[name] = true
+		if value, ok := lookup(name); ok && value != "" {
+			secretValues = append(secretValues, value)
+		}
+	}
+
+	context := make(map[string]string, len(workflowDef.Notifications.Context))
+	for key, template := range workflowDef.Notifications.Context {
+		value, _ := workflow.ExpandEnv(workflow.SubstituteVariables(template, vars), lookup)
+		if refersToSecret(template, secretNames) || containsAny(value, secretValues) {
+			slog.Warn("Leaving secret out of notification context", "workflow", workflowDef.Name, "key", key)
+			continue
+		}
+		context[key] = value
+	}
+	return context
+}
+
+// refersToSecret reports whether template has a ${NAME} reference to a secret
+func refersToSecret(template string, secretNames map[string]bool) bool {
+	for _, name := range workflow.EnvReferences(template) {
+		if secretNames[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny reports whether value contains any of the substrings
+func containsAny(value string, substrings []string) bool {
+	for _, s := range substrings {
+		if strings.Contains(value, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver posts a notification, retrying failed attempts with backoff
+func (n *webhookNotifier) deliver(notification TaskNotification) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		slog.Error("Failed to encode task notification", "task_id", notification.TaskID, "error", err)
+		return
+	}
+
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := n.post(body)
+		if err == nil {
+			return
+		}
+		if attempt == notifyAttempts {
+			slog.Error("Failed to deliver task notification", "task_id", notification.TaskID, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Warn("Task notification failed, retrying", "task_id", notification.TaskID, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post sends one notification request; any non-2xx response is an error
+func (n *webhookNotifier) post(body []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SetNotifier sets where finished tasks are reported; nil disables it
+func (e *Executor) SetNotifier(n *webhookNotifier) {
+	e.notifier = n
+}
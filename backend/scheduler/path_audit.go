@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// Path audit modes for commands that reference paths outside the allowed roots
+const (
+	PathAuditWarn = "warn" // Log a warning and run the command
+	PathAuditFail = "fail" // Fail the step without running it
+)
+
+// ParsePathAuditMode returns the path audit mode a configured value selects;
+// empty means warn
+func ParsePathAuditMode(mode string) (string, error) {
+	switch mode {
+	case "", PathAuditWarn:
+		return PathAuditWarn, nil
+	case PathAuditFail:
+		return PathAuditFail, nil
+	}
+	return "", fmt.Errorf("unknown path audit mode %q", mode)
+}
+
+// SetPathAudit checks step commands against the allowed roots before they run.
+// An empty roots list disables the audit.
+func (e *Executor) SetPathAudit(roots []string, mode string) {
+	e.allowedRoots = roots
+	e.pathAuditMode = mode
+}
+
+// auditCommand checks the absolute paths of a resolved command against the
+// allowed roots. It logs the offending paths and, in fail mode, returns an error.
+func (e *Executor) auditCommand(command string, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+	if len(e.allowedRoots) == 0 {
+		return nil
+	}
+	outside := workflow.PathsOutsideRoots(command, e.allowedRoots)
+	if len(outside) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("command references paths outside allowed roots: %s", strings.Join(outside, ", "))
+	if e.pathAuditMode == PathAuditFail {
+		e.writeLog(logWriter, execRecord, "ERROR: Path audit failed: "+message)
+		return fmt.Errorf("path audit failed: %s", message)
+	}
+	e.writeLog(logWriter, execRecord, "WARNING: Path audit: "+message)
+	return nil
+}
+
+// auditStdinFile checks a step's resolved stdin_file against the allowed roots
+// like auditCommand, since it streams that file into the command
+func (e *Executor) auditStdinFile(path string, logWriter *bufio.Writer, execRecord *ExecutionRecord) error {
+	if len(e.allowedRoots) == 0 || workflow.PathInsideRoots(path, e.allowedRoots) {
+		return nil
+	}
+
+	message := fmt.Sprintf("stdin_file is outside allowed roots: %s", path)
+	if e.pathAuditMode == PathAuditFail {
+		e.writeLog(logWriter, execRecord, "ERROR: Path audit failed: "+message)
+		return fmt.Errorf("path audit failed: %s", message)
+	}
+	e.writeLog(logWriter, execRecord, "WARNING: Path audit: "+message)
+	return nil
+}
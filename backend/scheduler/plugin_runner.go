@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// stepStore records the steps of a plugin run
+type stepStore interface {
+	Create(step *models.TaskStep) error
+	Update(step *models.TaskStep) error
+}
+
+// memoryStepStore keeps step records in memory for runs without a task
+type memoryStepStore struct {
+	steps []*models.TaskStep
+}
+
+func (m *memoryStepStore) Create(step *models.TaskStep) error {
+	step.CreatedAt = time.Now()
+	step.UpdatedAt = step.CreatedAt
+	m.steps = append(m.steps, step)
+	return nil
+}
+
+func (m *memoryStepStore) Update(step *models.TaskStep) error {
+	step.UpdatedAt = time.Now()
+	return nil
+}
+
+// PluginTestResult is the outcome of running a plugin against a sample file
+type PluginTestResult struct {
+	Success bool               `json:"success"`
+	Error   string             `json:"error,omitempty"`
+	Log     string             `json:"log"`
+	Steps   []*models.TaskStep `json:"steps"`
+	Outputs map[string]string  `json:"outputs,omitempty"` // Declared outputs the steps wrote
+}
+
+// RunPluginTest runs a plugin's steps against a sample input without creating a
+// task. The output is written to a temporary directory that is removed afterwards.
+func RunPluginTest(ctx context.Context, pluginYAML string, inputs map[string]string, sampleInput string, stepTimeout time.Duration) (*PluginTestResult, error) {
+	return runPluginTest(ctx, &Executor{stepTimeout: stepTimeout}, pluginYAML, inputs, sampleInput)
+}
+
+// runPluginTest runs a plugin test with e, which only needs the settings that
+// apply to commands: the step timeout and the path audit
+func runPluginTest(ctx context.Context, e *Executor, pluginYAML string, inputs map[string]string, sampleInput string) (*PluginTestResult, error) {
+	pluginDef, err := workflow.ParsePlugin(pluginYAML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plugin: %w", err)
+	}
+
+	outputDir, err := os.MkdirTemp("", "fileaction-plugin-test-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	inputName := filepath.Base(sampleInput)
+	if sampleInput == "" {
+		inputName = "output"
+	}
+	vars := workflow.GetVariables(sampleInput, filepath.Join(outputDir, inputName))
+
+	var logBuf bytes.Buffer
+	logWriter := bufio.NewWriter(&logBuf)
+	store := &memoryStepStore{}
+
+	outputPath := filepath.Join(outputDir, ".outputs")
+	env := map[string]string{workflow.OutputFileEnv: outputPath}
+	runErr := e.runPlugin(ctx, store, "", "test", pluginDef, inputs, vars, nil, env, e.stepTimeout, logWriter, nil)
+	var outputs map[string]string
+	if runErr == nil {
+		outputs, runErr = e.readPluginOutputs(outputPath, pluginDef, logWriter, nil)
+	}
+	logWriter.Flush()
+
+	result := &PluginTestResult{
+		Success: runErr == nil,
+		Log:     logBuf.String(),
+		Steps:   store.steps,
+		Outputs: outputs,
+	}
+	var stopSuccess *WorkflowStopSuccess
+	if errors.As(runErr, &stopSuccess) {
+		result.Success = true
+	} else if runErr != nil {
+		result.Error = runErr.Error()
+	}
+	return result, nil
+}
+
+// TestPlugin runs a plugin against a sample input using the scheduler's step timeout
+func (s *Scheduler) TestPlugin(ctx context.Context, pluginYAML string, inputs map[string]string, sampleInput string) (interface{}, error) {
+	return runPluginTest(ctx, s.executorPool.pluginTestExecutor(), pluginYAML, inputs, sampleInput)
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// runWithPTY runs cmd with stdin, stdout and stderr attached to a new
+// pseudo-terminal and copies everything it prints to output as it is printed
+func runWithPTY(cmd *exec.Cmd, output io.Writer) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return fmt.Errorf("failed to open pty: %w", err)
+	}
+	defer master.Close()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		slave.Close()
+		return err
+	}
+	slave.Close()
+
+	// Reads fail with EIO once every process holding the terminal has exited
+	out := &crlfWriter{w: output}
+	copyDone := make(chan struct{})
+	go func() {
+		io.Copy(out, master)
+		out.Flush()
+		close(copyDone)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-copyDone:
+	case <-time.After(time.Second):
+		// A background child still holds the terminal
+		master.Close()
+		<-copyDone
+	}
+	return err
+}
+
+// crlfWriter undoes the terminal's translation of \n to \r\n. A trailing \r
+// is held back until the next write shows whether a \n follows it.
+type crlfWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p)+1)
+	if c.cr {
+		buf = append(buf, '\r')
+		c.cr = false
+	}
+	buf = append(buf, p...)
+	if len(buf) > 0 && buf[len(buf)-1] == '\r' {
+		buf = buf[:len(buf)-1]
+		c.cr = true
+	}
+	if _, err := c.w.Write(bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes a held back trailing \r
+func (c *crlfWriter) Flush() {
+	if c.cr {
+		c.w.Write([]byte{'\r'})
+		c.cr = false
+	}
+}
+
+// openPTY opens a pseudo-terminal pair
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Use the raw descriptor without Fd(), which would switch the file to blocking mode
+	var ptyNumber uint32
+	var ioctlErr error
+	rawConn, err := master.SyscallConn()
+	if err == nil {
+		err = rawConn.Control(func(fd uintptr) {
+			if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+				return
+			}
+			ptyNumber, ioctlErr = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+		})
+	}
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", ptyNumber), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+)
+
+// runWithPTY is only implemented on Linux
+func runWithPTY(cmd *exec.Cmd, output io.Writer) error {
+	return fmt.Errorf("options.pty is not supported on %s", runtime.GOOS)
+}
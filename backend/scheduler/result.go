@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/models"
+)
+
+// defaultResultJSONSuffix is appended to the output path for result files
+const defaultResultJSONSuffix = ".fileaction.json"
+
+// TaskResult is the machine-readable receipt written next to a task's output
+type TaskResult struct {
+	TaskID          string       `json:"task_id"`
+	Workflow        string       `json:"workflow"`
+	Status          string       `json:"status"`
+	ErrorMessage    string       `json:"error_message,omitempty"`
+	StartedAt       *time.Time   `json:"started_at,omitempty"`
+	CompletedAt     *time.Time   `json:"completed_at,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Input           FileResult   `json:"input"`
+	Output          FileResult   `json:"output"`
+	Steps           []StepResult `json:"steps"`
+}
+
+// FileResult describes an input or output file. MD5 and size are omitted when
+// the file does not exist.
+type FileResult struct {
+	Path string `json:"path"`
+	MD5  string `json:"md5,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// StepResult summarizes a step of the task
+type StepResult struct {
+	Name            string  `json:"name"`
+	Status          string  `json:"status"`
+	ExitCode        *int    `json:"exit_code,omitempty"`
+	Attempts        int     `json:"attempts,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// resultJSONPath returns where the result file of an output is written
+func resultJSONPath(outputPath, suffix string) string {
+	if suffix == "" {
+		suffix = defaultResultJSONSuffix
+	}
+	return outputPath + suffix
+}
+
+// buildTaskResult assembles the result of a finished task
+func buildTaskResult(task *models.Task, workflowName string, steps []*models.TaskStep) *TaskResult {
+	result := &TaskResult{
+		TaskID:       task.ID,
+		Workflow:     workflowName,
+		Status:       task.Status,
+		ErrorMessage: task.ErrorMessage,
+		StartedAt:    task.StartedAt,
+		CompletedAt:  task.CompletedAt,
+		Input:        describeFile(task.InputPath),
+		Output:       describeFile(task.OutputPath),
+		Steps:        make([]StepResult, len(steps)),
+	}
+	if task.StartedAt != nil && task.CompletedAt != nil {
+		result.DurationSeconds = task.CompletedAt.Sub(*task.StartedAt).Seconds()
+	}
+	for i, step := range steps {
+		result.Steps[i] = StepResult{
+			Name:     step.Name,
+			Status:   step.Status,
+			ExitCode: step.ExitCode,
+			Attempts: step.Attempts,
+		}
+		if step.StartedAt != nil && step.CompletedAt != nil {
+			result.Steps[i].DurationSeconds = step.CompletedAt.Sub(*step.StartedAt).Seconds()
+		}
+	}
+	return result
+}
+
+// writeTaskResult writes a task result as indented JSON, creating the directory if needed
+func writeTaskResult(path string, result *TaskResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// hashFileLike hashes a file with the algorithm that produced digest, so a
+// hash recorded by the watcher can be checked whichever algorithm it uses.
+// It returns "" if the file or the algorithm can't be read.
+func hashFileLike(path, digest string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	sum, _, err := filehash.Sum(file, filehash.AlgorithmOf(digest))
+	if err != nil {
+		return ""
+	}
+	return sum
+}
+
+// describeFile hashes a file if it exists
+func describeFile(path string) FileResult {
+	result := FileResult{Path: path}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return result
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return result
+	}
+	result.MD5 = fmt.Sprintf("%x", hash.Sum(nil))
+	result.Size = size
+	return result
+}
@@ -2,19 +2,26 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/logsink"
+	"github.com/andi/fileaction/backend/metrics"
 	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
 )
 
-// WebSocketHub interface for broadcasting logs
+// WebSocketHub interface for broadcasting logs and scheduler events
 type WebSocketHub interface {
 	BroadcastLog(taskID, content string)
 	BroadcastTaskComplete(taskID string)
+	BroadcastSchedulerEvent(event Event)
 }
 
 // Scheduler handles task scheduling and execution
@@ -28,9 +35,16 @@ type Scheduler struct {
 	wg           sync.WaitGroup
 	mu           sync.Mutex
 	stopped      bool
-	runningTasks map[string]context.CancelFunc
-	wsHub        WebSocketHub
-	wsHubMu      sync.RWMutex
+	draining     bool
+	runningTasks map[string]context.CancelCauseFunc
+	// Dispatched tasks per workflow, capped by the workflow's options.concurrency
+	workflowRunning map[string]int
+	wsHub           WebSocketHub
+	wsHubMu         sync.RWMutex
+	// Janitor settings, see SetRetention
+	retentionDays    int
+	archiveRetention time.Duration
+	cleanupInterval  time.Duration
 }
 
 // New creates a new scheduler
@@ -57,13 +71,14 @@ func New(db *database.DB, maxRunning int, scanInterval time.Duration, logDir str
 	executorPool := NewExecutorPool(maxRunning, db, logDir, taskTimeout, stepTimeout)
 
 	return &Scheduler{
-		taskRepo:     database.NewTaskRepo(db),
-		executorPool: executorPool,
-		db:           db,
-		maxRunning:   maxRunning,
-		scanInterval: scanInterval,
-		stopChan:     make(chan struct{}),
-		runningTasks: make(map[string]context.CancelFunc),
+		taskRepo:        database.NewTaskRepo(db),
+		executorPool:    executorPool,
+		db:              db,
+		maxRunning:      maxRunning,
+		scanInterval:    scanInterval,
+		stopChan:        make(chan struct{}),
+		runningTasks:    make(map[string]context.CancelCauseFunc),
+		workflowRunning: make(map[string]int),
 	}
 }
 
@@ -73,6 +88,12 @@ func (s *Scheduler) Start() {
 
 	s.wg.Add(1)
 	go s.run()
+
+	if s.retentionDays > 0 {
+		log.Printf("Task janitor enabled: archiving finished tasks older than %d day(s) every %v", s.retentionDays, s.cleanupInterval)
+		s.wg.Add(1)
+		go s.runJanitor()
+	}
 }
 
 // Stop stops the scheduler
@@ -95,6 +116,24 @@ func (s *Scheduler) Stop() {
 	log.Println("Scheduler stopped")
 }
 
+// Drain stops dispatching new tasks while letting running tasks finish.
+// The scheduler loop keeps running so status reporting stays accurate.
+func (s *Scheduler) Drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.draining {
+		s.draining = true
+		log.Println("Scheduler entering drain mode, no new tasks will be dispatched")
+	}
+}
+
+// IsDraining returns whether the scheduler is in drain mode
+func (s *Scheduler) IsDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
 // SetWebSocketHub sets the WebSocket hub for real-time log broadcasting
 func (s *Scheduler) SetWebSocketHub(hub WebSocketHub) {
 	s.wsHubMu.Lock()
@@ -104,14 +143,82 @@ func (s *Scheduler) SetWebSocketHub(hub WebSocketHub) {
 	log.Println("WebSocket hub connected to scheduler")
 }
 
+// SetLogSink sets the external sink used to store completed task logs
+func (s *Scheduler) SetLogSink(sink logsink.Sink) {
+	s.executorPool.SetLogSink(sink)
+	log.Println("Log sink connected to scheduler")
+}
+
+// SetMaxLogBytes caps the task log and step output stored per task; longer
+// content is truncated in the middle. 0 or less stores everything.
+func (s *Scheduler) SetMaxLogBytes(n int64) {
+	s.executorPool.SetMaxLogBytes(n)
+}
+
+// SetMaxChainDepth limits how many trigger_workflow hops may follow a task
+// queued for a watched file
+func (s *Scheduler) SetMaxChainDepth(n int) {
+	s.executorPool.SetMaxChainDepth(n)
+}
+
+// SetHashAlgorithm sets the algorithm the outputs passed to trigger_workflow
+// are indexed with; it should be the watcher's
+func (s *Scheduler) SetHashAlgorithm(algorithm string) error {
+	if algorithm == "" {
+		algorithm = filehash.Default
+	}
+	if _, err := filehash.New(algorithm); err != nil {
+		return err
+	}
+	s.executorPool.SetHashAlgorithm(algorithm)
+	return nil
+}
+
+// SetNotifications posts tasks that finish with one of the given statuses
+// (completed, failed, cancelled; only failed if none are given) to a webhook
+// such as a Slack or Teams incoming webhook. An empty URL disables it.
+func (s *Scheduler) SetNotifications(url string, on []string) error {
+	if url == "" {
+		s.executorPool.SetNotifier(nil)
+		return nil
+	}
+	if len(on) == 0 {
+		on = []string{models.TaskStatusFailed}
+	}
+	notifier, err := newWebhookNotifier(url, on)
+	if err != nil {
+		return err
+	}
+	s.executorPool.SetNotifier(notifier)
+	log.Printf("Task notifications enabled for statuses: %v", on)
+	return nil
+}
+
+// SetPathAudit checks step commands against the allowed roots before they run,
+// either warning (PathAuditWarn) or failing the step (PathAuditFail)
+func (s *Scheduler) SetPathAudit(roots []string, mode string) error {
+	mode, err := ParsePathAuditMode(mode)
+	if err != nil {
+		return err
+	}
+	s.executorPool.SetPathAudit(roots, mode)
+	if len(roots) > 0 {
+		log.Printf("Path audit enabled (%s), allowed roots: %v", mode, roots)
+	}
+	return nil
+}
+
 // run is the main scheduler loop
 func (s *Scheduler) run() {
 	defer s.wg.Done()
 
 	ticker := time.NewTicker(s.scanInterval)
 	defer ticker.Stop()
+	sweepTicker := time.NewTicker(pendingSweepInterval)
+	defer sweepTicker.Stop()
 
 	// Initial scan on startup
+	s.cancelStalePending()
 	s.scanAndExecute()
 
 	for {
@@ -120,12 +227,52 @@ func (s *Scheduler) run() {
 			return
 		case <-ticker.C:
 			s.scanAndExecute()
+		case <-sweepTicker.C:
+			s.cancelStalePending()
+		}
+	}
+}
+
+// pendingSweepInterval is how often pending tasks are checked against their workflow's pending_ttl
+const pendingSweepInterval = time.Minute
+
+// cancelStalePending cancels pending tasks older than their workflow's
+// options.pending_ttl, including those of disabled workflows that would
+// otherwise wait forever
+func (s *Scheduler) cancelStalePending() {
+	workflows, err := database.NewWorkflowRepo(s.db).List()
+	if err != nil {
+		log.Printf("Error listing workflows for pending TTL: %v", err)
+		return
+	}
+
+	for _, wf := range workflows {
+		workflowDef, err := workflow.Parse(wf.YAMLContent)
+		if err != nil {
+			continue
+		}
+		ttl, err := workflowDef.Options.GetPendingTTL()
+		if err != nil || ttl <= 0 {
+			continue
+		}
+
+		cancelled, err := s.taskRepo.CancelStalePending(wf.ID, time.Now().Add(-ttl), models.CancelReasonPendingTTL)
+		if err != nil {
+			log.Printf("Error cancelling stale pending tasks of workflow %s: %v", wf.Name, err)
+		} else if cancelled > 0 {
+			metrics.TasksCancelled(wf.Name, int(cancelled))
+			log.Printf("Cancelled %d task(s) of workflow %s pending longer than %v", cancelled, wf.Name, ttl)
 		}
 	}
 }
 
 // scanAndExecute scans for pending tasks and executes them if possible
 func (s *Scheduler) scanAndExecute() {
+	if s.IsDraining() {
+		log.Printf("Scheduler draining, skipping dispatch (running=%d)", s.GetRunningCount())
+		return
+	}
+
 	availableExecutors := s.executorPool.GetAvailableCount()
 	busyExecutors := s.executorPool.GetBusyCount()
 
@@ -137,37 +284,113 @@ func (s *Scheduler) scanAndExecute() {
 		return
 	}
 
-	// Get pending tasks
-	tasks, err := s.taskRepo.GetPendingTasks(availableExecutors)
-	if err != nil {
-		log.Printf("Error getting pending tasks: %v", err)
-		return
+	// Dispatch pending tasks, passing over workflows already at their
+	// options.concurrency so one busy workflow cannot starve the others
+	limits := make(map[string]int)
+	dispatched := make(map[string]bool) // Stay pending until their executor starts them
+	for len(dispatched) < availableExecutors {
+		// Fetching a full batch leaves room for the new tasks even if every
+		// task dispatched so far comes back again
+		tasks, err := s.taskRepo.GetPendingTasks(availableExecutors, s.cappedWorkflows(limits)...)
+		if err != nil {
+			log.Printf("Error getting pending tasks: %v", err)
+			return
+		}
+
+		progressed := false
+		for _, task := range tasks {
+			if dispatched[task.ID] || len(dispatched) >= availableExecutors || !s.reserveWorkflowSlot(task.WorkflowID, limits) {
+				continue
+			}
+			s.executeTask(task)
+			dispatched[task.ID] = true
+			progressed = true
+		}
+		if !progressed {
+			break
+		}
 	}
 
-	if len(tasks) == 0 {
+	if len(dispatched) == 0 {
 		log.Println("No pending tasks found")
 		return
 	}
+	log.Printf("Dispatched %d pending task(s), %d executor(s) available", len(dispatched), availableExecutors)
+}
+
+// workflowLimit returns a workflow's options.concurrency, or 0 (no cap) if it
+// is not set or the definition cannot be loaded. Limits are cached in limits for one scan.
+func (s *Scheduler) workflowLimit(workflowID string, limits map[string]int) int {
+	if limit, ok := limits[workflowID]; ok {
+		return limit
+	}
+	limit := 0
+	if wf, err := database.NewWorkflowRepo(s.db).GetByID(workflowID); err == nil {
+		if workflowDef, err := workflow.Parse(wf.YAMLContent); err == nil {
+			limit = int(workflowDef.Options.Concurrency)
+		}
+	}
+	limits[workflowID] = limit
+	return limit
+}
+
+// cappedWorkflows returns the workflows whose dispatched tasks have reached their limit
+func (s *Scheduler) cappedWorkflows(limits map[string]int) []string {
+	s.mu.Lock()
+	running := make(map[string]int, len(s.workflowRunning))
+	for workflowID, count := range s.workflowRunning {
+		running[workflowID] = count
+	}
+	s.mu.Unlock()
+
+	var capped []string
+	for workflowID, count := range running {
+		if limit := s.workflowLimit(workflowID, limits); limit > 0 && count >= limit {
+			capped = append(capped, workflowID)
+		}
+	}
+	return capped
+}
+
+// reserveWorkflowSlot counts a task against its workflow's limit, reporting
+// false if the workflow is already at it
+func (s *Scheduler) reserveWorkflowSlot(workflowID string, limits map[string]int) bool {
+	limit := s.workflowLimit(workflowID, limits)
 
-	log.Printf("Found %d pending task(s), %d executor(s) available", len(tasks), availableExecutors)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit > 0 && s.workflowRunning[workflowID] >= limit {
+		return false
+	}
+	s.workflowRunning[workflowID]++
+	return true
+}
 
-	// Execute tasks
-	for _, task := range tasks {
-		s.executeTask(task)
+// releaseWorkflowSlot frees the slot taken by reserveWorkflowSlot
+func (s *Scheduler) releaseWorkflowSlot(workflowID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workflowRunning[workflowID] <= 1 {
+		delete(s.workflowRunning, workflowID)
+	} else {
+		s.workflowRunning[workflowID]--
 	}
 }
 
 // executeTask executes a single task in a goroutine
 func (s *Scheduler) executeTask(task *models.Task) {
 	s.wg.Add(1)
-	go func(taskID string) {
+	go func(taskID, workflowID string) {
 		defer s.wg.Done()
+		defer s.releaseWorkflowSlot(workflowID)
+		defer s.recoverTask(taskID)
 
-		log.Printf("Starting task execution: %s", taskID)
+		logger := slog.With("task_id", taskID, "workflow_id", workflowID)
+		logger.Info("Starting task execution")
 
-		// Create cancellable context for the task
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		// Create cancellable context for the task; CancelTask sets a TaskCancelled cause
+		ctx, cancel := context.WithCancelCause(context.Background())
+		defer cancel(nil)
 
 		s.mu.Lock()
 		s.runningTasks[taskID] = cancel
@@ -176,7 +399,7 @@ func (s *Scheduler) executeTask(task *models.Task) {
 		// Acquire an executor from the pool
 		executor, err := s.executorPool.Acquire(ctx)
 		if err != nil {
-			log.Printf("Failed to acquire executor for task %s: %v", taskID, err)
+			logger.Error("Failed to acquire executor", "error", err)
 			s.mu.Lock()
 			delete(s.runningTasks, taskID)
 			s.mu.Unlock()
@@ -184,24 +407,63 @@ func (s *Scheduler) executeTask(task *models.Task) {
 		}
 
 		// Ensure executor is released back to pool when done
-		defer s.executorPool.Release(executor)
+		executorID := executor.GetID()
+		defer func() {
+			s.executorPool.Release(executor)
+			s.broadcastEvent(Event{Type: EventExecutorIdle, ExecutorID: executorID})
+		}()
 		defer func() {
 			s.mu.Lock()
 			delete(s.runningTasks, taskID)
 			s.mu.Unlock()
 		}()
 
+		workflowName := s.workflowName(workflowID)
+		s.broadcastEvent(Event{Type: EventExecutorBusy, TaskID: taskID, ExecutorID: executorID})
+		s.broadcastEvent(Event{Type: EventTaskStarted, TaskID: taskID, WorkflowID: workflowID, Workflow: workflowName, ExecutorID: executorID})
+
 		// Execute the task
+		logger = logger.With("executor_id", executorID)
 		if err := executor.ExecuteTask(ctx, taskID); err != nil {
-			log.Printf("Error executing task %s: %v", taskID, err)
+			logger.Error("Error executing task", "error", err)
 		} else {
-			log.Printf("Task execution completed: %s", taskID)
+			logger.Info("Task execution completed")
+		}
+
+		finished := Event{Type: EventTaskFinished, TaskID: taskID, WorkflowID: workflowID, Workflow: workflowName, ExecutorID: executorID}
+		if task, err := s.taskRepo.GetByID(taskID); err == nil {
+			finished.Status = task.Status
 		}
-	}(task.ID)
+		s.broadcastEvent(finished)
+	}(task.ID, task.WorkflowID)
+}
+
+// recoverTask recovers from a panic in a task goroutine so it cannot take the
+// whole process down. The executor has already been released by the time this
+// runs; the task is marked failed if the executor did not get to do so.
+func (s *Scheduler) recoverTask(taskID string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	slog.Error("Recovered panic in task", "task_id", taskID, "panic", fmt.Sprint(r))
+
+	task, err := s.taskRepo.GetByID(taskID)
+	if err != nil || task.Status != models.TaskStatusRunning {
+		return
+	}
+	completedAt := time.Now()
+	task.Status = models.TaskStatusFailed
+	task.ErrorMessage = fmt.Sprintf("Executor panic: %v", r)
+	task.CompletedAt = &completedAt
+	if err := s.taskRepo.Update(task); err != nil {
+		slog.Error("Failed to mark panicked task as failed", "task_id", taskID, "error", err)
+	}
 }
 
-// CancelTask cancels a running task
-func (s *Scheduler) CancelTask(taskID string) error {
+// CancelTask cancels a running task, recording the reason (one of the
+// models.CancelReason constants) on the task
+func (s *Scheduler) CancelTask(taskID, reason string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -211,12 +473,12 @@ func (s *Scheduler) CancelTask(taskID string) error {
 		return nil
 	}
 
-	log.Printf("Cancelling task: %s", taskID)
-	cancel()
+	log.Printf("Cancelling task: %s (reason: %s)", taskID, reason)
+	cancel(&TaskCancelled{Reason: reason})
 	delete(s.runningTasks, taskID)
 
 	// Update task status to cancelled
-	if err := s.taskRepo.UpdateStatus(taskID, models.TaskStatusCancelled); err != nil {
+	if err := s.taskRepo.MarkCancelled(taskID, reason); err != nil {
 		log.Printf("Failed to update task status: %v", err)
 		return err
 	}
@@ -229,21 +491,42 @@ func (s *Scheduler) GetRunningCount() int {
 	return s.executorPool.GetBusyCount()
 }
 
+// GetWorkflowRunningCount returns the number of dispatched tasks of a workflow
+func (s *Scheduler) GetWorkflowRunningCount(workflowID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workflowRunning[workflowID]
+}
+
 // GetMaxRunning returns the maximum number of concurrent tasks
 func (s *Scheduler) GetMaxRunning() int {
 	return s.maxRunning
 }
 
-// GetExecutorStatus returns the status of all executors in the pool
-func (s *Scheduler) GetExecutorStatus() interface{} {
-	return s.executorPool.GetExecutorStatus()
+// GetExecutorStatus returns the status of executors in the pool, optionally
+// only the busy ones. A limit of 0 returns every matching executor.
+func (s *Scheduler) GetExecutorStatus(busyOnly bool, limit, offset int) interface{} {
+	statuses := s.executorPool.GetExecutorStatus(busyOnly)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(statuses) {
+		offset = len(statuses)
+	}
+	statuses = statuses[offset:]
+	if limit > 0 && limit < len(statuses) {
+		statuses = statuses[:limit]
+	}
+	return statuses
 }
 
-// GetExecutorPoolStats returns statistics about the executor pool
-func (s *Scheduler) GetExecutorPoolStats() map[string]int {
-	return map[string]int{
+// GetExecutorPoolStats returns statistics about the executor pool and whether
+// the scheduler is draining
+func (s *Scheduler) GetExecutorPoolStats() map[string]interface{} {
+	return map[string]interface{}{
 		"total":     s.executorPool.GetPoolSize(),
 		"available": s.executorPool.GetAvailableCount(),
 		"busy":      s.executorPool.GetBusyCount(),
+		"draining":  s.IsDraining(),
 	}
 }
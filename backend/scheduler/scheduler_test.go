@@ -0,0 +1,376 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/models"
+)
+
+// waitForStatus polls a task until it reaches the wanted status or the timeout elapses
+func waitForStatus(t *testing.T, db *database.DB, taskID, want string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if getTestTask(t, db, taskID).Status == want {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDrainFinishesRunningTaskWithoutDispatchingNew(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: slow
+    run: sleep 0.5
+`)
+	first := createTestTask(t, db, wf.ID, filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.out"))
+
+	sched := New(db, 2, 50*time.Millisecond, t.TempDir(), time.Minute, time.Minute)
+	sched.Start()
+	defer sched.Stop()
+
+	if !waitForStatus(t, db, first.ID, models.TaskStatusRunning, 5*time.Second) {
+		t.Fatal("First task never started running")
+	}
+
+	sched.Drain()
+	if !sched.IsDraining() {
+		t.Fatal("Expected scheduler to report draining")
+	}
+	if draining, _ := sched.GetExecutorPoolStats()["draining"].(bool); !draining {
+		t.Error("Expected executor pool stats to report draining")
+	}
+
+	second := createTestTask(t, db, wf.ID, filepath.Join(dir, "b.txt"), filepath.Join(dir, "b.out"))
+
+	if !waitForStatus(t, db, first.ID, models.TaskStatusCompleted, 5*time.Second) {
+		t.Fatal("Running task did not finish while draining")
+	}
+
+	// Give the scheduler several scan intervals to (incorrectly) pick up the new task
+	time.Sleep(300 * time.Millisecond)
+
+	if status := getTestTask(t, db, second.ID).Status; status != models.TaskStatusPending {
+		t.Errorf("Expected new task to stay pending while draining, got %s", status)
+	}
+	if running := sched.GetRunningCount(); running != 0 {
+		t.Errorf("Expected no running tasks after drain, got %d", running)
+	}
+}
+
+func TestExecutorStatusBusyOnly(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: slow
+    run: sleep 0.5
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.out"))
+
+	sched := New(db, 3, 50*time.Millisecond, t.TempDir(), time.Minute, time.Minute)
+	sched.Start()
+	defer sched.Stop()
+
+	if !waitForStatus(t, db, task.ID, models.TaskStatusRunning, 5*time.Second) {
+		t.Fatal("Task never started running")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if all := sched.GetExecutorStatus(false, 0, 0).([]ExecutorStatus); len(all) != 3 {
+		t.Fatalf("Expected 3 executors, got %d", len(all))
+	}
+
+	busy := sched.GetExecutorStatus(true, 0, 0).([]ExecutorStatus)
+	if len(busy) != 1 {
+		t.Fatalf("Expected 1 busy executor, got %d", len(busy))
+	}
+	if busy[0].CurrentTask != task.ID {
+		t.Errorf("Expected busy executor to run %s, got %s", task.ID, busy[0].CurrentTask)
+	}
+	if busy[0].CurrentWorkflow != "test-workflow" {
+		t.Errorf("Expected workflow name test-workflow, got %q", busy[0].CurrentWorkflow)
+	}
+	if busy[0].StartedAt == nil || busy[0].ElapsedSeconds <= 0 {
+		t.Errorf("Expected elapsed time to be populated, got started_at=%v elapsed=%v", busy[0].StartedAt, busy[0].ElapsedSeconds)
+	}
+
+	if page := sched.GetExecutorStatus(false, 2, 2).([]ExecutorStatus); len(page) != 1 {
+		t.Errorf("Expected 1 executor on second page, got %d", len(page))
+	}
+}
+
+// panicLogSink panics on the first upload to simulate a bug in task handling
+type panicLogSink struct {
+	mockLogSink
+	once sync.Once
+}
+
+func (p *panicLogSink) Put(taskID string, content []byte) (string, error) {
+	p.once.Do(func() { panic("simulated sink failure") })
+	return p.mockLogSink.Put(taskID, content)
+}
+
+func TestPanicDuringTaskReleasesExecutor(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, echoWorkflow)
+
+	sched := New(db, 1, 50*time.Millisecond, t.TempDir(), time.Minute, time.Minute)
+	sched.SetLogSink(&panicLogSink{mockLogSink: mockLogSink{objects: make(map[string][]byte)}})
+
+	first := createTestTask(t, db, wf.ID, filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.out"))
+	sched.Start()
+	defer sched.Stop()
+
+	if !waitForStatus(t, db, first.ID, models.TaskStatusFailed, 5*time.Second) {
+		t.Fatalf("Expected panicked task to be failed, got %s", getTestTask(t, db, first.ID).Status)
+	}
+	failed := getTestTask(t, db, first.ID)
+	if !strings.Contains(failed.ErrorMessage, "simulated sink failure") {
+		t.Errorf("Expected panic message in error, got %q", failed.ErrorMessage)
+	}
+	if !strings.Contains(failed.LogText, "hello-from-step") {
+		t.Errorf("Expected partial log to be stored, got %q", failed.LogText)
+	}
+
+	// The single executor must be back in the pool to run the next task
+	second := createTestTask(t, db, wf.ID, filepath.Join(dir, "b.txt"), filepath.Join(dir, "b.out"))
+	if !waitForStatus(t, db, second.ID, models.TaskStatusCompleted, 5*time.Second) {
+		t.Fatalf("Expected next task to complete after panic, got %s", getTestTask(t, db, second.ID).Status)
+	}
+}
+
+func TestCancelTaskRecordsReason(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: slow
+    run: exec sleep 5
+`)
+	task := createTestTask(t, db, wf.ID, filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.out"))
+
+	sched := New(db, 1, 50*time.Millisecond, t.TempDir(), time.Minute, time.Minute)
+	sched.Start()
+	defer sched.Stop()
+
+	if !waitForStatus(t, db, task.ID, models.TaskStatusRunning, 5*time.Second) {
+		t.Fatal("Task never started running")
+	}
+	if err := sched.CancelTask(task.ID, models.CancelReasonUser); err != nil {
+		t.Fatalf("CancelTask failed: %v", err)
+	}
+
+	// The executor finishes the task without overwriting the cancellation
+	deadline := time.Now().Add(5 * time.Second)
+	for sched.GetRunningCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	stored := getTestTask(t, db, task.ID)
+	if stored.Status != models.TaskStatusCancelled {
+		t.Fatalf("Expected status cancelled, got %s (%s)", stored.Status, stored.ErrorMessage)
+	}
+	if stored.CancelReason != models.CancelReasonUser {
+		t.Errorf("Expected cancel reason %q, got %q", models.CancelReasonUser, stored.CancelReason)
+	}
+	if stored.CompletedAt == nil {
+		t.Error("Expected the executor to record completion of the cancelled task")
+	}
+}
+
+func TestPendingTTLCancelsStaleTasks(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  pending_ttl: 1h
+steps:
+  - name: noop
+    run: "true"
+`)
+	// The workflow is disabled, so nothing would ever run its tasks
+	wf.Enabled = false
+	if err := database.NewWorkflowRepo(db).Update(wf); err != nil {
+		t.Fatalf("Failed to disable workflow: %v", err)
+	}
+
+	repo := database.NewTaskRepo(db)
+	stale := createTestTask(t, db, wf.ID, filepath.Join(dir, "old.txt"), filepath.Join(dir, "old.out"))
+	queuedAt := time.Now().Add(-2 * time.Hour)
+	stale.CreatedAt, stale.QueuedAt = queuedAt, &queuedAt
+	if err := repo.Update(stale); err != nil {
+		t.Fatalf("Failed to age task: %v", err)
+	}
+	fresh := createTestTask(t, db, wf.ID, filepath.Join(dir, "new.txt"), filepath.Join(dir, "new.out"))
+	// An old task retried just now counts from the retry
+	retried := createTestTask(t, db, wf.ID, filepath.Join(dir, "retried.txt"), filepath.Join(dir, "retried.out"))
+	retried.CreatedAt = queuedAt
+	if err := repo.Update(retried); err != nil {
+		t.Fatalf("Failed to age task: %v", err)
+	}
+
+	sched := New(db, 1, time.Hour, t.TempDir(), time.Minute, time.Minute)
+	sched.cancelStalePending()
+
+	got := getTestTask(t, db, stale.ID)
+	if got.Status != models.TaskStatusCancelled || got.CancelReason != models.CancelReasonPendingTTL {
+		t.Errorf("Expected stale task cancelled with reason %s, got %s (%q)", models.CancelReasonPendingTTL, got.Status, got.CancelReason)
+	}
+	if status := getTestTask(t, db, fresh.ID).Status; status != models.TaskStatusPending {
+		t.Errorf("Expected fresh task to stay pending, got %s", status)
+	}
+	if status := getTestTask(t, db, retried.ID).Status; status != models.TaskStatusPending {
+		t.Errorf("Expected retried task to stay pending, got %s", status)
+	}
+}
+
+func TestWorkflowConcurrencyCapsDispatch(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	capped := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+options:
+  concurrency: 1
+steps:
+  - name: slow
+    run: sleep 0.5
+`)
+	other := &models.Workflow{
+		Name:        "other-workflow",
+		YAMLContent: "name: other-workflow\non:\n  paths: [./other]\nsteps:\n  - name: slow\n    run: sleep 0.5\n",
+		Enabled:     true,
+	}
+	if err := database.NewWorkflowRepo(db).Create(other); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	// The capped workflow's tasks are oldest, so they would fill every executor without the cap
+	var cappedTasks []*models.Task
+	for _, name := range []string{"a", "b", "c"} {
+		cappedTasks = append(cappedTasks, createTestTask(t, db, capped.ID, filepath.Join(dir, name+".txt"), filepath.Join(dir, name+".out")))
+	}
+	otherTasks := []*models.Task{
+		createTestTask(t, db, other.ID, filepath.Join(dir, "d.txt"), filepath.Join(dir, "d.out")),
+		createTestTask(t, db, other.ID, filepath.Join(dir, "e.txt"), filepath.Join(dir, "e.out")),
+	}
+
+	sched := New(db, 3, time.Hour, t.TempDir(), time.Minute, time.Minute)
+	defer sched.Stop()
+	sched.scanAndExecute()
+
+	for _, task := range append([]*models.Task{cappedTasks[0]}, otherTasks...) {
+		if !waitForStatus(t, db, task.ID, models.TaskStatusRunning, 5*time.Second) {
+			t.Fatalf("Task %s never started running", task.InputPath)
+		}
+	}
+	for _, task := range cappedTasks[1:] {
+		if status := getTestTask(t, db, task.ID).Status; status != models.TaskStatusPending {
+			t.Errorf("Expected %s to wait for the workflow's concurrency, got %s", task.InputPath, status)
+		}
+	}
+
+	// Once the first task finishes, the next one of the capped workflow is dispatched
+	if !waitForStatus(t, db, cappedTasks[0].ID, models.TaskStatusCompleted, 5*time.Second) {
+		t.Fatal("First task did not complete")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for getTestTask(t, db, cappedTasks[1].ID).Status == models.TaskStatusPending && time.Now().Before(deadline) {
+		sched.scanAndExecute()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !waitForStatus(t, db, cappedTasks[1].ID, models.TaskStatusRunning, 5*time.Second) {
+		t.Fatal("Second task of the capped workflow was not dispatched")
+	}
+	if status := getTestTask(t, db, cappedTasks[2].ID).Status; status != models.TaskStatusPending {
+		t.Errorf("Expected third task to stay pending, got %s", status)
+	}
+}
+
+func TestJanitorArchivesOldFinishedTasks(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	wf := createTestWorkflow(t, db, `
+name: test-workflow
+on:
+  paths:
+    - ./test
+steps:
+  - name: noop
+    run: "true"
+`)
+
+	repo := database.NewTaskRepo(db)
+	longAgo := time.Now().Add(-10 * 24 * time.Hour)
+	old := createTestTask(t, db, wf.ID, filepath.Join(dir, "old.txt"), filepath.Join(dir, "old.out"))
+	old.Status = models.TaskStatusCompleted
+	old.CompletedAt = &longAgo
+	if err := repo.Update(old); err != nil {
+		t.Fatalf("Failed to age task: %v", err)
+	}
+	recent := createTestTask(t, db, wf.ID, filepath.Join(dir, "recent.txt"), filepath.Join(dir, "recent.out"))
+	now := time.Now()
+	recent.Status = models.TaskStatusFailed
+	recent.CompletedAt = &now
+	if err := repo.Update(recent); err != nil {
+		t.Fatalf("Failed to finish task: %v", err)
+	}
+	pending := createTestTask(t, db, wf.ID, filepath.Join(dir, "pending.txt"), filepath.Join(dir, "pending.out"))
+	pending.CreatedAt = longAgo
+	if err := repo.Update(pending); err != nil {
+		t.Fatalf("Failed to age task: %v", err)
+	}
+
+	sched := New(db, 1, time.Hour, t.TempDir(), time.Minute, time.Minute)
+	sched.SetRetention(7, 0, 0)
+	sched.cleanupOldTasks()
+
+	if _, err := repo.GetByID(old.ID); err == nil {
+		t.Error("Expected the old completed task to be archived")
+	}
+	if _, err := repo.GetIncludingArchived(old.ID); err != nil {
+		t.Errorf("Expected the archived task to be kept: %v", err)
+	}
+	for _, task := range []*models.Task{recent, pending} {
+		if _, err := repo.GetByID(task.ID); err != nil {
+			t.Errorf("Expected task %s to be kept: %v", task.InputPath, err)
+		}
+	}
+
+	// A short archive retention purges it on the next run
+	sched.SetRetention(7, time.Nanosecond, 0)
+	sched.cleanupOldTasks()
+	if _, err := repo.GetIncludingArchived(old.ID); err == nil {
+		t.Error("Expected the archived task to be purged")
+	}
+}
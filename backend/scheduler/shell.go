@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// resolveShell returns the interpreter and leading arguments for a step's
+// shell, failing if the interpreter is not installed
+func resolveShell(shell string) ([]string, error) {
+	args, err := workflow.ShellArgs(shell)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("shell %s is not available: not found in PATH", args[0])
+	}
+	return args, nil
+}
+
+// workflowCommand builds the command running one of the workflow's own
+// commands (metadata_command, verify_command, on_timeout) with options.shell
+func workflowCommand(ctx context.Context, workflowDef *workflow.WorkflowDef, script string) (*exec.Cmd, error) {
+	shellArgs, err := resolveShell(workflowDef.Options.Shell)
+	if err != nil {
+		return nil, err
+	}
+	return shellCommand(ctx, shellArgs, script), nil
+}
+
+// shellCommand builds the command running a script with a resolved shell
+func shellCommand(ctx context.Context, shellArgs []string, script string) *exec.Cmd {
+	args := append(append([]string(nil), shellArgs[1:]...), script)
+	return exec.CommandContext(ctx, shellArgs[0], args...)
+}
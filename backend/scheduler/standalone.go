@@ -0,0 +1,14 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/andi/fileaction/backend/database"
+)
+
+// NewExecutor creates an executor outside any pool, for running tasks in the
+// foreground as "fileaction run" does. Its log lines reach the hub set with
+// SetWebSocketHub as they are written.
+func NewExecutor(db *database.DB, logDir string, taskTimeout, stepTimeout time.Duration) *Executor {
+	return newExecutor(1, db, logDir, taskTimeout, stepTimeout)
+}
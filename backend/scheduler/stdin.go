@@ -0,0 +1,33 @@
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkStdinFile verifies that a step's stdin_file is a regular file
+func checkStdinFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stdin file %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("stdin file %s is not a regular file", path)
+	}
+	return nil
+}
+
+// openStdin returns what a step's command reads on standard input: the stdin
+// text, or the contents of stdin_file. Each attempt of a retried step needs
+// its own reader. It returns nil if the step sets neither.
+func openStdin(text, file string) (io.ReadCloser, error) {
+	if file != "" {
+		return os.Open(file)
+	}
+	if text != "" {
+		return io.NopCloser(strings.NewReader(text)), nil
+	}
+	return nil, nil
+}
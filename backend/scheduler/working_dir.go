@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkWorkingDir verifies that a step's working directory exists
+func checkWorkingDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("working directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("working directory %s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// DryRunWorkflow runs a workflow's scan without touching the file index or
+// creating tasks, returning the tasks it would create (public method for API)
+func (w *Watcher) DryRunWorkflow(workflowID string) (*ScanResult, error) {
+	return w.runScan(workflowID, true)
+}
+
+// planFile records what a scan would do with a file, counting it like scanFile does
+func (w *Watcher) planFile(result *ScanResult, workflowID, filePath string, outputPaths []string, existingFile *models.File, digest string, workflowDef *workflow.WorkflowDef, baseline, paused bool) error {
+	reason := "unchanged"
+	fileChanged := true
+	switch {
+	case existingFile == nil:
+		result.FilesNew++
+		reason = "new"
+	case w.contentChanged(filePath, existingFile.FileMD5, digest):
+		result.FilesChanged++
+		reason = "changed"
+	default:
+		fileChanged = false
+		result.FilesSkipped++
+		if workflowDef.Options.SkipOnNoChange {
+			planTasks(result, filePath, outputPaths, false, reason)
+			return nil
+		}
+	}
+
+	if baseline {
+		planTasks(result, filePath, outputPaths, false, "baseline")
+		return nil
+	}
+	if paused {
+		result.FilesPaused++
+		planTasks(result, filePath, outputPaths, false, "paused")
+		return nil
+	}
+	queued, err := w.taskQueued(workflowID, filePath, digest, workflowDef, fileChanged)
+	if err != nil {
+		return fmt.Errorf("failed to check pending tasks: %w", err)
+	}
+	if queued {
+		if fileChanged {
+			result.FilesSkipped++
+		}
+		planTasks(result, filePath, outputPaths, false, "queued")
+		return nil
+	}
+	planTasks(result, filePath, outputPaths, true, reason)
+	result.TasksCreated += len(outputPaths)
+	return nil
+}
+
+// planTasks records one planned task per output path
+func planTasks(result *ScanResult, filePath string, outputPaths []string, wouldCreate bool, reason string) {
+	for _, outputPath := range outputPaths {
+		result.Planned = append(result.Planned, PlannedTask{
+			InputPath:   filePath,
+			OutputPath:  outputPath,
+			WouldCreate: wouldCreate,
+			Reason:      reason,
+		})
+	}
+}
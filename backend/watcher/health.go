@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// healthCacheTTL is how long a dependency check is reused, so tools installed
+// after a failed check are picked up without restarting
+const healthCacheTTL = 5 * time.Minute
+
+// WorkflowHealth reports whether the tools a workflow needs are installed
+type WorkflowHealth struct {
+	Healthy             bool      `json:"healthy"`
+	MissingDependencies []string  `json:"missing_dependencies,omitempty"`
+	Errors              []string  `json:"errors,omitempty"` // Plugins that could not be loaded
+	CheckedAt           time.Time `json:"checked_at"`
+}
+
+// healthEntry is a cached check of one version of a workflow
+type healthEntry struct {
+	health      *WorkflowHealth
+	yamlContent string
+}
+
+// CheckHealth checks the dependencies of a workflow and of the plugins its
+// steps use. Results are cached until the workflow YAML changes or the TTL expires.
+func (w *Watcher) CheckHealth(wf *models.Workflow) *WorkflowHealth {
+	w.healthMu.Lock()
+	entry, ok := w.health[wf.ID]
+	w.healthMu.Unlock()
+	if ok && entry.yamlContent == wf.YAMLContent && time.Since(entry.health.CheckedAt) < healthCacheTTL {
+		return entry.health
+	}
+
+	health := w.checkHealth(wf)
+
+	w.healthMu.Lock()
+	w.health[wf.ID] = &healthEntry{health: health, yamlContent: wf.YAMLContent}
+	w.healthMu.Unlock()
+	return health
+}
+
+// checkHealth runs the dependency checks of a workflow without the cache
+func (w *Watcher) checkHealth(wf *models.Workflow) *WorkflowHealth {
+	health := &WorkflowHealth{CheckedAt: time.Now()}
+
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		health.Errors = append(health.Errors, fmt.Sprintf("invalid workflow YAML: %v", err))
+		return health
+	}
+
+	dependencies := append([]string{}, workflowDef.Dependencies...)
+	for _, step := range workflowDef.Steps {
+		if step.Uses == "" {
+			continue
+		}
+		pluginDef, err := w.loadPlugin(step.Uses)
+		if err != nil {
+			health.Errors = append(health.Errors, fmt.Sprintf("step %s: %v", step.Name, err))
+			continue
+		}
+		dependencies = append(dependencies, pluginDef.Dependencies...)
+	}
+
+	seen := make(map[string]bool)
+	for _, command := range workflow.MissingDependencies(dependencies) {
+		if !seen[command] {
+			seen[command] = true
+			health.MissingDependencies = append(health.MissingDependencies, command)
+		}
+	}
+	sort.Strings(health.MissingDependencies)
+
+	health.Healthy = len(health.MissingDependencies) == 0 && len(health.Errors) == 0
+	return health
+}
+
+// loadPlugin resolves a plugin reference the same way the executor does
+func (w *Watcher) loadPlugin(uses string) (*workflow.PluginDef, error) {
+	pluginName, version, err := workflow.ParsePluginReference(uses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin reference: %w", err)
+	}
+
+	var pluginVersion *database.PluginVersion
+	if version != "" {
+		pluginVersion, err = w.pluginRepo.ResolvePluginVersion(pluginName, version)
+	} else {
+		plugin, pluginErr := w.pluginRepo.GetPluginByName(pluginName)
+		if pluginErr != nil {
+			return nil, fmt.Errorf("plugin not found: %s", pluginName)
+		}
+		pluginVersion, err = w.pluginRepo.GetPluginCurrentVersion(plugin.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugin %s: %w", pluginName, err)
+	}
+
+	return workflow.ParsePlugin(pluginVersion.YAMLContent)
+}
+
+// healthError describes why an unhealthy workflow cannot be enabled
+func healthError(health *WorkflowHealth) error {
+	var reasons []string
+	if len(health.MissingDependencies) > 0 {
+		reasons = append(reasons, "missing dependencies: "+strings.Join(health.MissingDependencies, ", "))
+	}
+	reasons = append(reasons, health.Errors...)
+	return fmt.Errorf("workflow is unhealthy: %s", strings.Join(reasons, "; "))
+}
@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// defaultOpenRetries is how often opening a file for hashing is retried
+const defaultOpenRetries = 3
+
+// openRetryBackoff is the delay before the first retry, doubled after each attempt
+const openRetryBackoff = 100 * time.Millisecond
+
+// SetOpenRetries sets how many times opening a locked file for hashing is
+// retried with backoff before the file is skipped. 0 disables retries.
+func (w *Watcher) SetOpenRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	w.openRetries = n
+}
+
+// openWithRetry opens a file, retrying while another process (an antivirus
+// scanner, an SMB client still writing) holds a lock on it
+func (w *Watcher) openWithRetry(path string) (*os.File, error) {
+	backoff := w.openBackoff
+	for attempt := 0; ; attempt++ {
+		file, err := w.openFile(path)
+		if err == nil || attempt >= w.openRetries || !isLockError(err) {
+			return file, err
+		}
+		log.Printf("File %s is locked, retrying in %v (attempt %d/%d): %v", path, backoff, attempt+1, w.openRetries, err)
+		select {
+		case <-time.After(backoff):
+		case <-w.stopChan:
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// isLockError reports whether err is a transient lock or sharing conflict
+// rather than a missing or unreadable file
+func isLockError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, lockErrno := range lockErrnos {
+		if errno == lockErrno {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package watcher
+
+import "syscall"
+
+// lockErrnos are the open errors caused by another process holding the file
+var lockErrnos = []syscall.Errno{syscall.EBUSY, syscall.EAGAIN, syscall.ETXTBSY}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package watcher
+
+import "syscall"
+
+// Windows reports a file another process opened without sharing, or a locked
+// byte range, with these errors. Access denied (ERROR_ACCESS_DENIED) is not
+// retried.
+const (
+	errorSharingViolation syscall.Errno = 32 // ERROR_SHARING_VIOLATION
+	errorLockViolation    syscall.Errno = 33 // ERROR_LOCK_VIOLATION
+)
+
+// lockErrnos are the open errors caused by another process holding the file
+var lockErrnos = []syscall.Errno{errorSharingViolation, errorLockViolation}
@@ -0,0 +1,97 @@
+package watcher
+
+import (
+	"log"
+	"os"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// handleRemoveEvent applies each matching workflow's options.on_delete to a
+// source file that was removed or renamed away
+func (w *Watcher) handleRemoveEvent(path string) {
+	// Editors that save by removing and recreating a file put it back right away
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+
+	workflows := w.findWorkflowsForPath(path)
+	if len(workflows) == 0 {
+		return
+	}
+
+	// A change still waiting out its debounce can no longer be hashed
+	w.debounceMu.Lock()
+	for _, wf := range workflows {
+		key := wf.ID + ":" + path
+		if entry, exists := w.debounceMap[key]; exists {
+			entry.timer.Stop()
+			delete(w.debounceMap, key)
+		}
+	}
+	w.debounceMu.Unlock()
+
+	for _, wf := range workflows {
+		w.processRemovedFile(wf, path)
+	}
+}
+
+// processRemovedFile handles the removal of an indexed source file
+func (w *Watcher) processRemovedFile(wf *models.Workflow, filePath string) {
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		log.Printf("Error parsing workflow %s: %v", wf.Name, err)
+		return
+	}
+
+	onDelete := workflowDef.Options.OnDelete
+	if onDelete == "" || onDelete == workflow.OnDeleteIgnore {
+		return
+	}
+
+	existingFile, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, filePath)
+	if err != nil {
+		log.Printf("Error checking file index: %v", err)
+		return
+	}
+	if existingFile == nil {
+		return
+	}
+
+	switch onDelete {
+	case workflow.OnDeleteDeleteOutput:
+		// Remove exactly the outputs a task for this file would have written
+		outputPaths := workflow.GenerateOutputPaths(filePath, workflowDef.Convert, workflowDef.Options.OutputDirPattern)
+		if workflowDef.UsesOutputToken("") {
+			// Dated or hashed directories depend on when the tasks ran, so use what they recorded
+			if outputPaths, err = w.taskRepo.OutputPathsByFile(wf.ID, existingFile.ID); err != nil {
+				log.Printf("Error listing outputs of deleted file: %v", err)
+				return
+			}
+		}
+		for _, outputPath := range outputPaths {
+			if err := os.Remove(outputPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing output %s: %v", outputPath, err)
+				continue
+			}
+			log.Printf("Removed output of deleted file: %s", outputPath)
+		}
+		if err := w.fileRepo.Delete(existingFile.ID); err != nil {
+			log.Printf("Error deleting file record: %v", err)
+			return
+		}
+		log.Printf("File removed: %s", filePath)
+
+	case workflow.OnDeleteMarkStale:
+		if existingFile.Stale {
+			return
+		}
+		existingFile.Stale = true
+		if err := w.fileRepo.Update(existingFile); err != nil {
+			log.Printf("Error updating file record: %v", err)
+			return
+		}
+		log.Printf("File removed, marked stale: %s", filePath)
+	}
+}
@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"log"
+	"time"
+
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// startSchedule (re)starts the periodic rescans of a workflow's on.schedule,
+// for paths where file system events are unreliable such as network shares.
+// It is called with w.mu held whenever the workflow's watches are set up.
+func (w *Watcher) startSchedule(wf *models.Workflow, workflowDef *workflow.WorkflowDef) {
+	w.stopSchedule(wf.ID)
+	if workflowDef.On.Schedule == "" {
+		return
+	}
+
+	schedule, err := workflow.ParseSchedule(workflowDef.On.Schedule)
+	if err != nil {
+		log.Printf("Warning: Not scheduling scans of workflow %s: %v", wf.Name, err)
+		return
+	}
+
+	stop := make(chan struct{})
+	w.schedules[wf.ID] = stop
+	w.wg.Add(1)
+	go w.runSchedule(wf.ID, wf.Name, schedule, stop)
+	log.Printf("Scheduled scans of workflow %s: %s", wf.Name, workflowDef.On.Schedule)
+}
+
+// stopSchedule stops a workflow's periodic rescans, if any. It is called with
+// w.mu held.
+func (w *Watcher) stopSchedule(workflowID string) {
+	if stop, ok := w.schedules[workflowID]; ok {
+		close(stop)
+		delete(w.schedules, workflowID)
+	}
+}
+
+// runSchedule scans a workflow each time its schedule comes due until stop
+// is closed or the watcher stops
+func (w *Watcher) runSchedule(workflowID, name string, schedule *workflow.Schedule, stop chan struct{}) {
+	defer w.wg.Done()
+
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("Warning: Schedule of workflow %s never comes due", name)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-w.stopChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		result, err := w.scanWorkflow(workflowID)
+		if err != nil {
+			log.Printf("Error in scheduled scan of workflow %s: %v", name, err)
+			continue
+		}
+		log.Printf("Scheduled scan completed for workflow %s: scanned=%d, new=%d, changed=%d, skipped=%d, tasks=%d",
+			name, result.FilesScanned, result.FilesNew, result.FilesChanged, result.FilesSkipped, result.TasksCreated)
+	}
+}
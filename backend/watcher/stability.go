@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// defaultStabilityChecks is how often a file is polled within the stability window
+const defaultStabilityChecks = 3
+
+// maxStabilityWindows bounds how long a file that keeps changing is waited
+// for. Its later write events queue it again once it settles.
+const maxStabilityWindows = 10
+
+// SetStability sets how long a file's size and modification time must stay
+// unchanged, checked the given number of times, before a file event is
+// processed. A window of 0 disables the check.
+func (w *Watcher) SetStability(window time.Duration, checks int) {
+	if window < 0 {
+		window = 0
+	}
+	if checks < 1 {
+		checks = defaultStabilityChecks
+	}
+	w.stabilityWindow = window
+	w.stabilityChecks = checks
+}
+
+// waitForStable polls a file until its size and modification time have been
+// unchanged for the stability window. It returns false if the file disappears,
+// keeps changing for too long, or the watcher stops while waiting.
+func (w *Watcher) waitForStable(path string) bool {
+	return len(w.waitForStableFiles([]string{path})) == 1
+}
+
+// waitForStableFiles is waitForStable for several files at once, polled
+// together so a batch waits one stability window rather than one per file. It
+// returns the files that settled, in their original order.
+func (w *Watcher) waitForStableFiles(paths []string) []string {
+	if w.stabilityWindow <= 0 || len(paths) == 0 {
+		return paths
+	}
+
+	type pollState struct {
+		info   os.FileInfo
+		stable int
+	}
+	waiting := make(map[string]*pollState, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("File %s disappeared before processing, skipping", path)
+			continue
+		}
+		waiting[path] = &pollState{info: info}
+	}
+	settled := make(map[string]bool, len(waiting))
+	interval := w.stabilityWindow / time.Duration(w.stabilityChecks)
+	deadline := time.Now().Add(maxStabilityWindows * w.stabilityWindow)
+
+	for len(waiting) > 0 {
+		select {
+		case <-w.stopChan:
+			return nil
+		case <-time.After(interval):
+		}
+
+		for path, state := range waiting {
+			current, err := os.Stat(path)
+			if err != nil {
+				log.Printf("File %s disappeared while waiting for it to settle, skipping", path)
+				delete(waiting, path)
+				continue
+			}
+			if current.Size() == state.info.Size() && current.ModTime().Equal(state.info.ModTime()) {
+				state.stable++
+				if state.stable >= w.stabilityChecks {
+					settled[path] = true
+					delete(waiting, path)
+				}
+				continue
+			}
+			if time.Now().After(deadline) {
+				log.Printf("File %s is still being written, skipping until its next change", path)
+				delete(waiting, path)
+				continue
+			}
+			state.info, state.stable = current, 0
+		}
+	}
+
+	stable := make([]string, 0, len(settled))
+	for _, path := range paths {
+		if settled[path] {
+			stable = append(stable, path)
+		}
+	}
+	return stable
+}
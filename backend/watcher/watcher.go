@@ -1,16 +1,17 @@
 package watcher
 
 import (
-	"crypto/md5"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/metrics"
 	"github.com/andi/fileaction/backend/models"
 	"github.com/andi/fileaction/backend/workflow"
 	"github.com/fsnotify/fsnotify"
@@ -18,12 +19,23 @@ import (
 
 // ScanResult represents the result of a scan operation
 type ScanResult struct {
-	FilesScanned int
-	FilesNew     int
-	FilesChanged int
-	FilesSkipped int
-	TasksCreated int
-	Errors       []error
+	FilesScanned  int
+	FilesNew      int
+	FilesChanged  int
+	FilesSkipped  int
+	TasksCreated  int
+	TasksDeferred int // Not created because options.max_tasks_per_scan was reached
+	FilesPaused   int // New or changed while the workflow is paused; flagged for resume instead of queued
+	Errors        []error
+	Planned       []PlannedTask // Filled by dry runs only; TasksCreated then counts tasks that would be created
+}
+
+// PlannedTask is a task a dry run would or would not create, and why
+type PlannedTask struct {
+	InputPath   string `json:"input_path"`
+	OutputPath  string `json:"output_path,omitempty"`
+	WouldCreate bool   `json:"would_create"`
+	Reason      string `json:"reason"` // new, changed, unchanged, ignored, size, baseline, paused, queued or task_limit
 }
 
 // Watcher monitors file system changes and triggers workflows
@@ -32,6 +44,7 @@ type Watcher struct {
 	fileRepo     *database.FileRepo
 	taskRepo     *database.TaskRepo
 	workflowRepo *database.WorkflowRepo
+	pluginRepo   *database.PluginRepo
 	watcher      *fsnotify.Watcher
 	stopChan     chan struct{}
 	wg           sync.WaitGroup
@@ -41,12 +54,44 @@ type Watcher struct {
 	// Map of workflow ID to watched paths
 	watchedPaths map[string][]string
 
+	// YAML each watched workflow was watched with, to detect changes on reload
+	watchedYAML map[string]string
+
 	// Debounce map to avoid processing same file multiple times
 	debounceMap map[string]*debounceEntry
 	debounceMu  sync.Mutex
 
 	// Maximum pending tasks per workflow (0 means no limit)
 	maxPendingTasks int
+
+	// Batch mode: events within the window are processed in one pass (0 disables)
+	batchWindow  time.Duration
+	batch        map[string]*pendingBatch
+	batchTimer   *time.Timer
+	batchStarted time.Time
+	batchMu      sync.Mutex
+
+	// Maximum number of workflows scanned at once on startup
+	scanConcurrency int
+
+	// Retries for opening a locked file for hashing
+	openRetries int
+	openBackoff time.Duration
+	openFile    func(name string) (*os.File, error)
+
+	// Algorithm files are hashed with
+	hashAlgorithm string
+
+	// File events wait until the file's size and mtime stop changing (0 disables)
+	stabilityWindow time.Duration
+	stabilityChecks int
+
+	// Cached dependency checks by workflow ID
+	health   map[string]*healthEntry
+	healthMu sync.Mutex
+
+	// Stops the on.schedule rescans of each workflow, guarded by mu
+	schedules map[string]chan struct{}
 }
 
 type debounceEntry struct {
@@ -55,6 +100,18 @@ type debounceEntry struct {
 	path       string
 }
 
+// pendingBatch collects the changed paths of one workflow until the batch is flushed
+type pendingBatch struct {
+	workflow *models.Workflow
+	paths    map[string]struct{}
+}
+
+// maxBatchWindows bounds how long a continuous stream of events can delay a flush
+const maxBatchWindows = 10
+
+// defaultScanConcurrency is the number of workflows scanned at once on startup
+const defaultScanConcurrency = 2
+
 // New creates a new file watcher
 func New(db *database.DB, maxPendingTasks int) (*Watcher, error) {
 	fsWatcher, err := fsnotify.NewWatcher()
@@ -72,14 +129,43 @@ func New(db *database.DB, maxPendingTasks int) (*Watcher, error) {
 		fileRepo:        database.NewFileRepo(db),
 		taskRepo:        database.NewTaskRepo(db),
 		workflowRepo:    database.NewWorkflowRepo(db),
+		pluginRepo:      database.NewPluginRepo(db),
 		watcher:         fsWatcher,
 		stopChan:        make(chan struct{}),
 		watchedPaths:    make(map[string][]string),
+		watchedYAML:     make(map[string]string),
 		debounceMap:     make(map[string]*debounceEntry),
 		maxPendingTasks: maxPendingTasks,
+		scanConcurrency: defaultScanConcurrency,
+		openRetries:     defaultOpenRetries,
+		openBackoff:     openRetryBackoff,
+		openFile:        os.Open,
+		hashAlgorithm:   filehash.Default,
+		stabilityChecks: defaultStabilityChecks,
+		batch:           make(map[string]*pendingBatch),
+		health:          make(map[string]*healthEntry),
+		schedules:       make(map[string]chan struct{}),
 	}, nil
 }
 
+// SetScanConcurrency sets how many workflows are scanned at once on startup.
+// Values below 1 are treated as 1. Call before Start.
+func (w *Watcher) SetScanConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	w.scanConcurrency = n
+}
+
+// SetBatchWindow enables batch mode: file events arriving within the window are
+// collected and processed together with batched database reads and inserts.
+// A window of 0 keeps per-file debouncing.
+func (w *Watcher) SetBatchWindow(window time.Duration) {
+	w.batchMu.Lock()
+	defer w.batchMu.Unlock()
+	w.batchWindow = window
+}
+
 // Start starts the file watcher
 func (w *Watcher) Start() error {
 	// Get all enabled workflows
@@ -89,29 +175,65 @@ func (w *Watcher) Start() error {
 	}
 
 	// Add file system watches first (non-blocking)
+	w.mu.Lock()
 	for _, wf := range workflows {
 		if !wf.Enabled {
 			continue
 		}
 
+		// Don't queue tasks that would all fail on a missing tool
+		if health := w.CheckHealth(wf); !health.Healthy {
+			log.Printf("Warning: Not watching workflow %s: %v", wf.Name, healthError(health))
+			continue
+		}
+
 		// Add file system watches (fast)
 		if err := w.addWorkflowWatch(wf); err != nil {
 			log.Printf("Warning: Failed to add watch for workflow %s: %v", wf.Name, err)
 		}
 	}
 
+	watching := len(w.watchedPaths)
+	w.mu.Unlock()
+
 	// Start event processing
 	w.wg.Add(1)
 	go w.processEvents()
 
-	log.Printf("File watcher started, monitoring %d workflow(s)", len(w.watchedPaths))
+	log.Printf("File watcher started, monitoring %d workflow(s)", watching)
 
-	// Perform initial scans asynchronously (non-blocking)
+	// Perform initial scans in the background, a few workflows at a time, so
+	// events keep being processed while existing files are backfilled
+	w.wg.Add(1)
 	go func() {
-		for _, wf := range workflows {
-			if !wf.Enabled {
-				continue
-			}
+		defer w.wg.Done()
+		w.runInitialScans(workflows)
+	}()
+
+	return nil
+}
+
+// runInitialScans scans the enabled, healthy workflows with at most
+// scanConcurrency scans running at once
+func (w *Watcher) runInitialScans(workflows []*models.Workflow) {
+	sem := make(chan struct{}, w.scanConcurrency)
+	var wg sync.WaitGroup
+	for _, wf := range workflows {
+		if !wf.Enabled || !w.CheckHealth(wf).Healthy {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-w.stopChan:
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(wf *models.Workflow) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
 			log.Printf("Performing initial scan for workflow: %s", wf.Name)
 			result, err := w.scanWorkflow(wf.ID)
@@ -121,11 +243,10 @@ func (w *Watcher) Start() error {
 				log.Printf("Scan completed for workflow %s: scanned=%d, new=%d, changed=%d, skipped=%d, tasks=%d",
 					wf.Name, result.FilesScanned, result.FilesNew, result.FilesChanged, result.FilesSkipped, result.TasksCreated)
 			}
-		}
-		log.Println("All initial workflow scans completed")
-	}()
-
-	return nil
+		}(wf)
+	}
+	wg.Wait()
+	log.Println("All initial workflow scans completed")
 }
 
 // Stop stops the file watcher
@@ -139,6 +260,11 @@ func (w *Watcher) Stop() {
 	w.mu.Unlock()
 
 	log.Println("Stopping file watcher...")
+	w.batchMu.Lock()
+	if w.batchTimer != nil {
+		w.batchTimer.Stop()
+	}
+	w.batchMu.Unlock()
 	close(w.stopChan)
 	w.watcher.Close()
 	w.wg.Wait()
@@ -175,6 +301,10 @@ func (w *Watcher) addWorkflowWatch(wf *models.Workflow) error {
 					return nil
 				}
 				if info.IsDir() && path != absPath {
+					// Ignored directories are neither watched nor descended into
+					if workflow.MatchesIgnorePattern(path, workflowDef.Options.Ignore) {
+						return filepath.SkipDir
+					}
 					if err := w.watcher.Add(path); err != nil {
 						log.Printf("Warning: Failed to watch subdirectory %s: %v", path, err)
 					} else {
@@ -187,9 +317,32 @@ func (w *Watcher) addWorkflowWatch(wf *models.Workflow) error {
 	}
 
 	w.watchedPaths[wf.ID] = paths
+	w.watchedYAML[wf.ID] = wf.YAMLContent
+	w.startSchedule(wf, workflowDef)
 	return nil
 }
 
+// removeUnusedWatches removes the watches on paths that no watched workflow
+// still uses, so workflows sharing a directory keep receiving its events
+func (w *Watcher) removeUnusedWatches(paths []string) {
+	inUse := make(map[string]bool)
+	for _, watched := range w.watchedPaths {
+		for _, path := range watched {
+			inUse[path] = true
+		}
+	}
+
+	for _, path := range paths {
+		if inUse[path] {
+			continue
+		}
+		if err := w.watcher.Remove(path); err != nil {
+			log.Printf("Warning: Failed to remove watch for path %s: %v", path, err)
+		}
+		inUse[path] = true // don't remove a path listed twice again
+	}
+}
+
 // processEvents processes file system events
 func (w *Watcher) processEvents() {
 	defer w.wg.Done()
@@ -204,9 +357,11 @@ func (w *Watcher) processEvents() {
 				return
 			}
 
-			// Only handle Create and Write events
+			// Create and Write events queue the file, Remove and Rename apply options.on_delete
 			if event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write {
 				w.handleFileEvent(event.Name)
+			} else if event.Op&fsnotify.Remove == fsnotify.Remove || event.Op&fsnotify.Rename == fsnotify.Rename {
+				w.handleRemoveEvent(event.Name)
 			}
 
 		case err, ok := <-w.watcher.Errors:
@@ -226,6 +381,11 @@ func (w *Watcher) handleFileEvent(path string) {
 		return
 	}
 
+	// Batch mode: collect the event and process it with the rest of the batch
+	if w.addToBatch(workflows, path) {
+		return
+	}
+
 	// Debounce: wait a bit to see if more events come for the same file
 	w.debounceMu.Lock()
 	defer w.debounceMu.Unlock()
@@ -260,44 +420,284 @@ func (w *Watcher) handleFileEvent(path string) {
 	}
 }
 
+// addToBatch queues a path for batch processing. It returns false when batch
+// mode is disabled. The flush timer restarts on every event so files still being
+// written are not picked up early, but a flush happens at the latest after
+// maxBatchWindows windows.
+func (w *Watcher) addToBatch(workflows []*models.Workflow, path string) bool {
+	w.batchMu.Lock()
+	defer w.batchMu.Unlock()
+
+	if w.batchWindow <= 0 {
+		return false
+	}
+
+	for _, wf := range workflows {
+		pending, exists := w.batch[wf.ID]
+		if !exists {
+			pending = &pendingBatch{workflow: wf, paths: make(map[string]struct{})}
+			w.batch[wf.ID] = pending
+		}
+		pending.paths[path] = struct{}{}
+	}
+
+	delay := w.batchWindow
+	if w.batchTimer == nil {
+		w.batchStarted = time.Now()
+	} else {
+		w.batchTimer.Stop()
+		if remaining := time.Until(w.batchStarted.Add(maxBatchWindows * w.batchWindow)); remaining < delay {
+			delay = remaining
+		}
+	}
+	w.batchTimer = time.AfterFunc(delay, w.flushBatch)
+	return true
+}
+
+// flushBatch processes all collected events, one pass per workflow
+func (w *Watcher) flushBatch() {
+	w.batchMu.Lock()
+	batch := w.batch
+	w.batch = make(map[string]*pendingBatch)
+	w.batchTimer = nil
+	w.batchMu.Unlock()
+
+	for _, pending := range batch {
+		paths := make([]string, 0, len(pending.paths))
+		for path := range pending.paths {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		result := w.processBatch(pending.workflow, paths)
+		log.Printf("Batch processed for workflow %s: files=%d, new=%d, changed=%d, skipped=%d, tasks=%d, errors=%d",
+			pending.workflow.Name, result.FilesScanned, result.FilesNew, result.FilesChanged, result.FilesSkipped, result.TasksCreated, len(result.Errors))
+	}
+}
+
+// processBatch processes many changed files of a workflow in one pass. It gives
+// the same result as calling processFile for each path, but looks up the file
+// index with a single query and inserts all tasks together.
+func (w *Watcher) processBatch(wf *models.Workflow, filePaths []string) *ScanResult {
+	result := &ScanResult{}
+
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("failed to parse workflow %s: %w", wf.Name, err))
+		return result
+	}
+
+	// Filter and hash files before touching the database
+	type candidate struct {
+		path    string
+		md5     string
+		size    int64
+		modTime int64
+	}
+	matched := make([]string, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		result.FilesScanned++
+		if workflow.MatchesIgnorePattern(filePath, workflowDef.Options.Ignore) ||
+			!matchesFilePatterns(filePath, workflowDef) {
+			result.FilesSkipped++
+			continue
+		}
+		// One overlong path must not fail the inserts of the whole batch
+		if err := database.CheckPathLength("file", filePath); err != nil {
+			result.Errors = append(result.Errors, err)
+			continue
+		}
+		matched = append(matched, filePath)
+	}
+
+	// As in processFile, files still being written are left to their next event
+	stable := w.waitForStableFiles(matched)
+	result.FilesSkipped += len(matched) - len(stable)
+
+	candidates := make([]candidate, 0, len(stable))
+	for _, filePath := range stable {
+		statSize, modTime := statFile(filePath)
+		if !workflowDef.Options.SizeInRange(statSize) {
+			result.FilesSkipped++
+			continue
+		}
+		md5Hash, fileSize, err := w.calculateHash(filePath)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("failed to hash %s: %w", filePath, err))
+			continue
+		}
+		candidates = append(candidates, candidate{path: filePath, md5: md5Hash, size: fileSize, modTime: modTime})
+	}
+	if len(candidates) == 0 {
+		return result
+	}
+
+	paths := make([]string, len(candidates))
+	for i, c := range candidates {
+		paths[i] = c.path
+	}
+	existingFiles, err := w.fileRepo.GetByWorkflowAndPaths(wf.ID, paths)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("failed to check file index: %w", err))
+		return result
+	}
+
+	now := time.Now()
+	var newFiles, taskFiles []*models.File
+	for _, c := range candidates {
+		existingFile, exists := existingFiles[c.path]
+		if !exists {
+			file := &models.File{
+				WorkflowID:    wf.ID,
+				FilePath:      c.path,
+				FileMD5:       c.md5,
+				FileSize:      c.size,
+				ModTime:       c.modTime,
+				LastScannedAt: now,
+			}
+			newFiles = append(newFiles, file)
+			taskFiles = append(taskFiles, file)
+			continue
+		}
+
+		fileChanged := false
+		if w.contentChanged(c.path, existingFile.FileMD5, c.md5) {
+			existingFile.FileMD5 = c.md5
+			existingFile.Stale = false
+			existingFile.FileSize = c.size
+			existingFile.ModTime = c.modTime
+			existingFile.LastScannedAt = now
+			if err := w.fileRepo.Update(existingFile); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to update file record: %w", err))
+				continue
+			}
+			fileChanged = true
+			result.FilesChanged++
+		} else {
+			if err := w.refreshIndexed(existingFile, c.md5, c.modTime); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to update file record: %w", err))
+			}
+			result.FilesSkipped++
+		}
+
+		if fileChanged || !workflowDef.Options.SkipOnNoChange {
+			queued, err := w.taskQueued(wf.ID, c.path, c.md5, workflowDef, fileChanged)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to check pending tasks: %w", err))
+				continue
+			}
+			if queued {
+				if fileChanged {
+					result.FilesSkipped++
+				}
+				continue
+			}
+			taskFiles = append(taskFiles, existingFile)
+		}
+	}
+
+	// A paused workflow keeps the index current but queues nothing; the
+	// files are flagged so resuming can queue them
+	if wf.Paused {
+		for _, file := range taskFiles {
+			if file.ID == "" {
+				file.Deferred = true // Stored by CreateBatch below
+			} else if err := w.deferFile(file); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to update file record: %w", err))
+				continue
+			}
+			result.FilesPaused++
+		}
+		taskFiles = nil
+	}
+
+	// New files are inserted together; this assigns the IDs the tasks refer to
+	if err := w.fileRepo.CreateBatch(newFiles); err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("failed to create file records: %w", err))
+		return result
+	}
+	result.FilesNew = len(newFiles)
+
+	tasks := make([]*models.Task, 0, len(taskFiles))
+	for _, file := range taskFiles {
+		for _, outputPath := range workflowDef.TaskOutputPaths(file.FilePath, now, file.FileMD5) {
+			if err := database.CheckPathLength("output", outputPath); err != nil {
+				result.Errors = append(result.Errors, err)
+				continue
+			}
+			tasks = append(tasks, &models.Task{
+				WorkflowID: wf.ID,
+				FileID:     file.ID,
+				InputPath:  file.FilePath,
+				OutputPath: outputPath,
+				InputMD5:   file.FileMD5,
+				Priority:   workflowDef.Priority,
+				Status:     models.TaskStatusPending,
+			})
+		}
+	}
+
+	if err := w.taskRepo.CreateBatch(tasks); err != nil {
+		result.Errors = append(result.Errors, fmt.Errorf("failed to create tasks: %w", err))
+		return result
+	}
+	result.TasksCreated = len(tasks)
+	metrics.TasksCreated(wf.Name, len(tasks))
+
+	return result
+}
+
 // findWorkflowsForPath finds workflows that should process this path
 func (w *Watcher) findWorkflowsForPath(path string) []*models.Workflow {
 	var result []*models.Workflow
 
-	for workflowID, paths := range w.watchedPaths {
-		for _, watchedPath := range paths {
-			// Check if the file is under a watched path
-			if isPathUnder(path, watchedPath) {
-				wf, err := w.workflowRepo.GetByID(workflowID)
-				if err != nil {
-					log.Printf("Error getting workflow %s: %v", workflowID, err)
-					continue
-				}
+	for _, workflowID := range w.workflowsWatching(path) {
+		wf, err := w.workflowRepo.GetByID(workflowID)
+		if err != nil {
+			log.Printf("Error getting workflow %s: %v", workflowID, err)
+			continue
+		}
 
-				// Check if file matches the workflow's file glob
-				workflowDef, err := workflow.Parse(wf.YAMLContent)
-				if err != nil {
-					log.Printf("Error parsing workflow %s: %v", wf.Name, err)
-					continue
-				}
+		// Check if file matches the workflow's file glob
+		workflowDef, err := workflow.Parse(wf.YAMLContent)
+		if err != nil {
+			log.Printf("Error parsing workflow %s: %v", wf.Name, err)
+			continue
+		}
 
-				// Check if file is in ignore list
-				if workflow.MatchesIgnorePattern(path, workflowDef.Options.Ignore) {
-					log.Printf("File %s matches ignore pattern, skipping", path)
-					break
-				}
+		// Check if file is in ignore list
+		if workflow.MatchesIgnorePattern(path, workflowDef.Options.Ignore) {
+			log.Printf("File %s matches ignore pattern, skipping", path)
+			continue
+		}
 
-				if workflow.MatchesFileGlob(path, workflowDef.Options.FileGlob) {
-					result = append(result, wf)
-				}
-				break
-			}
+		if matchesFilePatterns(path, workflowDef) {
+			result = append(result, wf)
 		}
 	}
 
 	return result
 }
 
+// workflowsWatching returns the IDs of the workflows with a watched path that
+// contains path. The watch maps are only read under w.mu, since reloads
+// rewrite them while events are being handled.
+func (w *Watcher) workflowsWatching(path string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var ids []string
+	for workflowID, paths := range w.watchedPaths {
+		for _, watchedPath := range paths {
+			if isPathUnder(path, watchedPath) {
+				ids = append(ids, workflowID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
 // processFile processes a single file for a workflow
 func (w *Watcher) processFile(wf *models.Workflow, filePath string) {
 	log.Printf("Processing file change: %s (workflow: %s)", filePath, wf.Name)
@@ -315,16 +715,30 @@ func (w *Watcher) processFile(wf *models.Workflow, filePath string) {
 		return
 	}
 
-	// Check if file matches glob pattern
-	if !workflow.MatchesFileGlob(filePath, workflowDef.Options.FileGlob) {
-		log.Printf("File %s does not match glob pattern %s, skipping", filePath, workflowDef.Options.FileGlob)
+	// Check if file matches file_glob and path_regex
+	if !matchesFilePatterns(filePath, workflowDef) {
+		log.Printf("File %s does not match the workflow's file_glob or path_regex, skipping", filePath)
+		return
+	}
+
+	// The debounce only covers bursts of events; a large copy can pause
+	// longer than that between writes
+	if !w.waitForStable(filePath) {
+		return
+	}
+
+	// A file still being copied is usually below min_size; its next write
+	// event brings it back here
+	statSize, modTime := statFile(filePath)
+	if !workflowDef.Options.SizeInRange(statSize) {
+		log.Printf("File %s size %d is outside the configured range, skipping", filePath, statSize)
 		return
 	}
 
-	// Calculate file MD5
-	md5Hash, fileSize, err := w.calculateMD5(filePath)
+	// Hash the file
+	md5Hash, fileSize, err := w.calculateHash(filePath)
 	if err != nil {
-		log.Printf("Error calculating MD5 for %s: %v", filePath, err)
+		log.Printf("Error hashing %s: %v", filePath, err)
 		return
 	}
 
@@ -336,29 +750,31 @@ func (w *Watcher) processFile(wf *models.Workflow, filePath string) {
 	}
 
 	fileChanged := false
-	var fileID string
+	indexed := existingFile
 
 	if existingFile == nil {
 		// New file
-		file := &models.File{
+		indexed = &models.File{
 			WorkflowID:    wf.ID,
 			FilePath:      filePath,
 			FileMD5:       md5Hash,
 			FileSize:      fileSize,
+			ModTime:       modTime,
 			LastScannedAt: now,
+			Deferred:      wf.Paused,
 		}
-		if err := w.fileRepo.Create(file); err != nil {
+		if err := w.fileRepo.Create(indexed); err != nil {
 			log.Printf("Error creating file record: %v", err)
 			return
 		}
-		fileID = file.ID
 		fileChanged = true
 		log.Printf("New file detected: %s", filePath)
 	} else {
-		fileID = existingFile.ID
-		if existingFile.FileMD5 != md5Hash {
+		if w.contentChanged(filePath, existingFile.FileMD5, md5Hash) {
 			existingFile.FileMD5 = md5Hash
+			existingFile.Stale = false
 			existingFile.FileSize = fileSize
+			existingFile.ModTime = modTime
 			existingFile.LastScannedAt = now
 			if err := w.fileRepo.Update(existingFile); err != nil {
 				log.Printf("Error updating file record: %v", err)
@@ -366,6 +782,9 @@ func (w *Watcher) processFile(wf *models.Workflow, filePath string) {
 			}
 			fileChanged = true
 			log.Printf("File changed: %s", filePath)
+		} else if err := w.refreshIndexed(existingFile, md5Hash, modTime); err != nil {
+			log.Printf("Error updating file record: %v", err)
+			return
 		} else if workflowDef.Options.SkipOnNoChange {
 			log.Printf("File unchanged, skipping: %s", filePath)
 			return
@@ -374,23 +793,91 @@ func (w *Watcher) processFile(wf *models.Workflow, filePath string) {
 
 	// Create task if file is new or changed
 	if fileChanged || !workflowDef.Options.SkipOnNoChange {
-		outputPath := workflow.GenerateOutputPath(filePath, workflowDef.Convert, workflowDef.Options.OutputDirPattern)
-
-		task := &models.Task{
-			WorkflowID: wf.ID,
-			FileID:     fileID,
-			InputPath:  filePath,
-			OutputPath: outputPath,
-			Status:     models.TaskStatusPending,
+		if wf.Paused {
+			if err := w.deferFile(indexed); err != nil {
+				log.Printf("Error updating file record: %v", err)
+				return
+			}
+			log.Printf("Workflow %s is paused, task deferred for file: %s", wf.Name, filePath)
+			return
 		}
 
-		if err := w.taskRepo.Create(task); err != nil {
-			log.Printf("Error creating task: %v", err)
+		queued, err := w.taskQueued(wf.ID, filePath, md5Hash, workflowDef, fileChanged)
+		if err != nil {
+			log.Printf("Error checking pending tasks: %v", err)
 			return
 		}
+		if queued {
+			log.Printf("Task already pending for file, skipping: %s", filePath)
+			return
+		}
+
+		// One task per conversion target
+		for _, outputPath := range workflowDef.TaskOutputPaths(filePath, now, md5Hash) {
+			task := &models.Task{
+				WorkflowID: wf.ID,
+				FileID:     indexed.ID,
+				InputPath:  filePath,
+				OutputPath: outputPath,
+				InputMD5:   md5Hash,
+				Priority:   workflowDef.Priority,
+				Status:     models.TaskStatusPending,
+			}
+
+			if err := w.taskRepo.Create(task); err != nil {
+				log.Printf("Error creating task: %v", err)
+				return
+			}
+			metrics.TasksCreated(wf.Name, 1)
+
+			log.Printf("Task created for file: %s -> %s", filePath, outputPath)
+		}
+	}
+}
+
+// deferFile flags an indexed file of a paused workflow so that resuming the
+// workflow can queue its tasks
+func (w *Watcher) deferFile(file *models.File) error {
+	if file.Deferred {
+		return nil
+	}
+	file.Deferred = true
+	return w.fileRepo.Update(file)
+}
 
-		log.Printf("Task created for file: %s -> %s", filePath, outputPath)
+// taskQueued reports whether a file already has a pending task, or a running
+// task started on its current content, which another task would only
+// duplicate (e.g. after a burst of write events overlapping a scan). A task
+// running on older content does not count: the new content still needs a run.
+// With verify_input_hash a changed file is never treated as queued, since the
+// executor supersedes the stale task instead.
+func (w *Watcher) taskQueued(workflowID, filePath, fileHash string, workflowDef *workflow.WorkflowDef, fileChanged bool) (bool, error) {
+	if fileChanged && workflowDef.Options.VerifyInputHash {
+		return false, nil
 	}
+	return w.taskRepo.HasPendingTask(workflowID, filePath, fileHash)
+}
+
+// matchesFilePatterns checks a file against the workflow's file_glob and
+// path_regex; it must match both. Path globs like "src/**/*.jpg" are matched
+// against the path relative to the watched path containing the file, the regex
+// against the absolute path.
+func matchesFilePatterns(filePath string, workflowDef *workflow.WorkflowDef) bool {
+	if !workflowDef.Options.MatchesPathRegex(filePath) {
+		return false
+	}
+	rel := filePath
+	for _, watchPath := range workflowDef.On.Paths {
+		absPath, err := filepath.Abs(watchPath)
+		if err != nil {
+			continue
+		}
+		if isPathUnder(filePath, absPath) {
+			rel, _ = filepath.Rel(absPath, filePath)
+			break
+		}
+	}
+	return workflow.MatchesFileGlob(rel, workflowDef.Options.FileGlob)
 }
 
 // isPathUnder checks if path is under basePath
@@ -408,36 +895,69 @@ func (w *Watcher) ReloadWorkflows() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Remove all existing watches
-	for _, paths := range w.watchedPaths {
-		for _, path := range paths {
-			w.watcher.Remove(path)
-		}
-	}
-	w.watchedPaths = make(map[string][]string)
-
-	// Reload workflows
 	workflows, err := w.workflowRepo.List()
 	if err != nil {
 		return err
 	}
 
+	// Only workflows that were added, changed or removed have their watches
+	// touched. New watches are added before stale ones are removed so that
+	// unchanged paths never stop delivering events.
+	var stale []string
+	keep := make(map[string]bool)
+	added, unchanged := 0, 0
 	for _, wf := range workflows {
 		if !wf.Enabled {
 			continue
 		}
 
+		if health := w.CheckHealth(wf); !health.Healthy {
+			log.Printf("Warning: Not watching workflow %s: %v", wf.Name, healthError(health))
+			continue
+		}
+
+		if yaml, ok := w.watchedYAML[wf.ID]; ok && yaml == wf.YAMLContent {
+			keep[wf.ID] = true
+			unchanged++
+			continue
+		}
+
+		oldPaths := w.watchedPaths[wf.ID]
 		if err := w.addWorkflowWatch(wf); err != nil {
 			log.Printf("Warning: Failed to add watch for workflow %s: %v", wf.Name, err)
+			continue
+		}
+		stale = append(stale, oldPaths...)
+		keep[wf.ID] = true
+		added++
+	}
+
+	removed := 0
+	for workflowID, paths := range w.watchedPaths {
+		if keep[workflowID] {
+			continue
 		}
+		stale = append(stale, paths...)
+		delete(w.watchedPaths, workflowID)
+		delete(w.watchedYAML, workflowID)
+		w.stopSchedule(workflowID)
+		removed++
 	}
+	w.removeUnusedWatches(stale)
 
-	log.Printf("Workflows reloaded, monitoring %d workflow(s)", len(w.watchedPaths))
+	log.Printf("Workflows reloaded, monitoring %d workflow(s) (%d added or changed, %d removed, %d unchanged)",
+		len(w.watchedPaths), added, removed, unchanged)
 	return nil
 }
 
 // scanWorkflow scans all paths for a workflow and creates tasks
 func (w *Watcher) scanWorkflow(workflowID string) (*ScanResult, error) {
+	return w.runScan(workflowID, false)
+}
+
+// runScan scans a workflow's paths. A dry run reads the file index but
+// neither updates it nor creates tasks, recording them in result.Planned instead.
+func (w *Watcher) runScan(workflowID string, dryRun bool) (*ScanResult, error) {
 	result := &ScanResult{}
 
 	// Get workflow
@@ -452,47 +972,62 @@ func (w *Watcher) scanWorkflow(workflowID string) (*ScanResult, error) {
 		return nil, fmt.Errorf("failed to parse workflow: %w", err)
 	}
 
+	// The first scan of a baseline workflow indexes existing files without
+	// queuing them. Workflows indexed before the marker existed already had it.
+	baseline := false
+	if workflowDef.Options.Baseline && wf.BaselineAt == nil {
+		indexed, err := w.fileRepo.CountByWorkflow(workflowID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count indexed files: %w", err)
+		}
+		baseline = indexed == 0
+		if baseline && !dryRun {
+			log.Printf("Workflow %s has no baseline yet, recording existing files as baseline", wf.Name)
+		}
+	}
+
 	// Scan each path
 	for _, scanPath := range workflowDef.On.Paths {
-		pathResult, err := w.scanPath(workflowID, scanPath, workflowDef)
-		if err != nil {
+		if err := w.scanPath(workflowID, scanPath, workflowDef, baseline, wf.Paused, dryRun, result); err != nil {
 			result.Errors = append(result.Errors, err)
-			continue
 		}
-
-		result.FilesScanned += pathResult.FilesScanned
-		result.FilesNew += pathResult.FilesNew
-		result.FilesChanged += pathResult.FilesChanged
-		result.FilesSkipped += pathResult.FilesSkipped
-		result.TasksCreated += pathResult.TasksCreated
-		result.Errors = append(result.Errors, pathResult.Errors...)
 	}
 
+	if workflowDef.Options.Baseline && wf.BaselineAt == nil && !dryRun {
+		if err := w.workflowRepo.MarkBaseline(workflowID, time.Now()); err != nil {
+			result.Errors = append(result.Errors, fmt.Errorf("failed to record baseline: %w", err))
+		}
+	}
+	if !dryRun {
+		metrics.TasksCreated(wf.Name, result.TasksCreated)
+	}
+	if result.TasksDeferred > 0 && !dryRun {
+		log.Printf("Workflow %s: task limit of %d reached, deferred %d task(s) to the next scan",
+			wf.Name, workflowDef.Options.MaxTasksPerScan, result.TasksDeferred)
+	}
 	return result, nil
 }
 
-// scanPath scans a single path
-func (w *Watcher) scanPath(workflowID, scanPath string, workflowDef *workflow.WorkflowDef) (*ScanResult, error) {
-	result := &ScanResult{}
-
+// scanPath scans a single path, adding its counts to result
+func (w *Watcher) scanPath(workflowID, scanPath string, workflowDef *workflow.WorkflowDef, baseline, paused, dryRun bool, result *ScanResult) error {
 	// Resolve absolute path
 	absPath, err := filepath.Abs(scanPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve path %s: %w", scanPath, err)
+		return fmt.Errorf("failed to resolve path %s: %w", scanPath, err)
 	}
 
 	// Check if path exists
 	info, err := os.Stat(absPath)
 	if err != nil {
-		return nil, fmt.Errorf("path not found %s: %w", absPath, err)
+		return fmt.Errorf("path not found %s: %w", absPath, err)
 	}
 
 	// If it's a file, scan just that file
 	if !info.IsDir() {
-		if err := w.scanFile(workflowID, absPath, workflowDef, result); err != nil {
+		if err := w.scanFile(workflowID, absPath, workflowDef, baseline, paused, dryRun, result); err != nil {
 			result.Errors = append(result.Errors, err)
 		}
-		return result, nil
+		return nil
 	}
 
 	// Walk directory
@@ -501,22 +1036,33 @@ func (w *Watcher) scanPath(workflowID, scanPath string, workflowDef *workflow.Wo
 			return err
 		}
 
+		// Stop walking once the watcher is shutting down
+		select {
+		case <-w.stopChan:
+			return filepath.SkipAll
+		default:
+		}
+
 		// Skip directories
 		if info.IsDir() {
 			// Skip subdirectories if not enabled
 			if !workflowDef.Options.IncludeSubdirs && path != absPath {
 				return filepath.SkipDir
 			}
+			// Don't descend into ignored directories such as node_modules
+			if path != absPath && workflow.MatchesIgnorePattern(path, workflowDef.Options.Ignore) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		// Check if file matches glob pattern
-		if !workflow.MatchesFileGlob(path, workflowDef.Options.FileGlob) {
+		// Check if file matches file_glob and path_regex
+		if !matchesFilePatterns(path, workflowDef) {
 			return nil
 		}
 
 		// Scan file
-		if err := w.scanFile(workflowID, path, workflowDef, result); err != nil {
+		if err := w.scanFile(workflowID, path, workflowDef, baseline, paused, dryRun, result); err != nil {
 			result.Errors = append(result.Errors, err)
 		}
 
@@ -524,70 +1070,108 @@ func (w *Watcher) scanPath(workflowID, scanPath string, workflowDef *workflow.Wo
 	}
 
 	if err := filepath.Walk(absPath, walkFn); err != nil {
-		return nil, fmt.Errorf("failed to walk directory %s: %w", absPath, err)
+		return fmt.Errorf("failed to walk directory %s: %w", absPath, err)
 	}
 
-	return result, nil
+	return nil
 }
 
-// scanFile processes a single file during scan
-func (w *Watcher) scanFile(workflowID, filePath string, workflowDef *workflow.WorkflowDef, result *ScanResult) error {
+// scanFile processes a single file during scan. A baseline scan only updates
+// the index; for a paused workflow it also flags files that would get a task.
+func (w *Watcher) scanFile(workflowID, filePath string, workflowDef *workflow.WorkflowDef, baseline, paused, dryRun bool, result *ScanResult) error {
 	result.FilesScanned++
 
 	// Check if file matches ignore patterns
 	if workflow.MatchesIgnorePattern(filePath, workflowDef.Options.Ignore) {
 		log.Printf("File %s matches ignore pattern, skipping", filePath)
 		result.FilesSkipped++
+		if dryRun {
+			result.Planned = append(result.Planned, PlannedTask{InputPath: filePath, Reason: "ignored"})
+		}
 		return nil
 	}
 
-	// Double-check if file matches glob pattern before processing
-	if !workflow.MatchesFileGlob(filePath, workflowDef.Options.FileGlob) {
-		log.Printf("File %s does not match glob pattern %s, skipping", filePath, workflowDef.Options.FileGlob)
+	// Double-check if file matches file_glob and path_regex before processing
+	if !matchesFilePatterns(filePath, workflowDef) {
+		log.Printf("File %s does not match the workflow's file_glob or path_regex, skipping", filePath)
 		result.FilesSkipped++
 		return nil
 	}
 
-	// Calculate MD5
-	md5Hash, fileSize, err := w.calculateMD5(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to calculate MD5 for %s: %w", filePath, err)
+	statSize, modTime := statFile(filePath)
+	if !workflowDef.Options.SizeInRange(statSize) {
+		log.Printf("File %s size %d is outside the configured range, skipping", filePath, statSize)
+		result.FilesSkipped++
+		if dryRun {
+			result.Planned = append(result.Planned, PlannedTask{InputPath: filePath, Reason: "size"})
+		}
+		return nil
 	}
 
-	now := time.Now()
-
 	// Check if file already indexed
 	existingFile, err := w.fileRepo.GetByWorkflowAndPath(workflowID, filePath)
 	if err != nil {
 		return fmt.Errorf("failed to check file index: %w", err)
 	}
 
+	// Hash the file, unless the fast path can tell from its size and
+	// modification time that it is unchanged
+	var md5Hash string
+	var fileSize int64
+	if workflowDef.Options.UseMtimeFastpath && w.unchangedByStat(existingFile, statSize, modTime) {
+		md5Hash, fileSize = existingFile.FileMD5, existingFile.FileSize
+	} else if md5Hash, fileSize, err = w.calculateHash(filePath); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+
+	now := time.Now()
+
+	outputPaths := workflowDef.TaskOutputPaths(filePath, now, md5Hash)
+
+	// Past the task limit, leave files unindexed so the next scan picks them up
+	if maxTasks := workflowDef.Options.MaxTasksPerScan; !baseline && maxTasks > 0 && result.TasksCreated >= maxTasks {
+		changed := existingFile == nil || w.contentChanged(filePath, existingFile.FileMD5, md5Hash)
+		if changed || !workflowDef.Options.SkipOnNoChange {
+			result.TasksDeferred += len(outputPaths)
+			if dryRun {
+				planTasks(result, filePath, outputPaths, false, "task_limit")
+			}
+			return nil
+		}
+	}
+
+	if dryRun {
+		return w.planFile(result, workflowID, filePath, outputPaths, existingFile, md5Hash, workflowDef, baseline, paused)
+	}
+
 	fileChanged := false
-	var fileID string
+	indexed := existingFile
 
 	if existingFile == nil {
 		// New file
-		file := &models.File{
+		indexed = &models.File{
 			WorkflowID:    workflowID,
 			FilePath:      filePath,
 			FileMD5:       md5Hash,
 			FileSize:      fileSize,
+			ModTime:       modTime,
 			LastScannedAt: now,
+			Deferred:      paused && !baseline,
 		}
-		if err := w.fileRepo.Create(file); err != nil {
+		if err := w.fileRepo.Create(indexed); err != nil {
 			return fmt.Errorf("failed to create file record: %w", err)
 		}
-		fileID = file.ID
 		result.FilesNew++
 		fileChanged = true
 		log.Printf("New file detected: %s", filePath)
 	} else {
 		// Existing file
-		fileID = existingFile.ID
-		if existingFile.FileMD5 != md5Hash {
+		if w.contentChanged(filePath, existingFile.FileMD5, md5Hash) {
 			// File changed
 			existingFile.FileMD5 = md5Hash
+			existingFile.Stale = false
 			existingFile.FileSize = fileSize
+			existingFile.ModTime = modTime
 			existingFile.LastScannedAt = now
 			if err := w.fileRepo.Update(existingFile); err != nil {
 				return fmt.Errorf("failed to update file record: %w", err)
@@ -597,6 +1181,9 @@ func (w *Watcher) scanFile(workflowID, filePath string, workflowDef *workflow.Wo
 			log.Printf("File changed: %s", filePath)
 		} else {
 			// File unchanged
+			if err := w.refreshIndexed(existingFile, md5Hash, modTime); err != nil {
+				return fmt.Errorf("failed to update file record: %w", err)
+			}
 			result.FilesSkipped++
 			if workflowDef.Options.SkipOnNoChange {
 				log.Printf("File unchanged, skipping: %s", filePath)
@@ -605,47 +1192,58 @@ func (w *Watcher) scanFile(workflowID, filePath string, workflowDef *workflow.Wo
 		}
 	}
 
+	if baseline {
+		return nil
+	}
+
 	// Create task if file is new or changed
 	if fileChanged || !workflowDef.Options.SkipOnNoChange {
-		// Wait if pending task limit is reached for this workflow
-		w.waitForTaskSlot(workflowID)
-
-		outputPath := workflow.GenerateOutputPath(filePath, workflowDef.Convert, workflowDef.Options.OutputDirPattern)
-
-		task := &models.Task{
-			WorkflowID: workflowID,
-			FileID:     fileID,
-			InputPath:  filePath,
-			OutputPath: outputPath,
-			Status:     models.TaskStatusPending,
+		if paused {
+			if err := w.deferFile(indexed); err != nil {
+				return fmt.Errorf("failed to update file record: %w", err)
+			}
+			result.FilesPaused++
+			return nil
 		}
 
-		if err := w.taskRepo.Create(task); err != nil {
-			return fmt.Errorf("failed to create task: %w", err)
+		queued, err := w.taskQueued(workflowID, filePath, md5Hash, workflowDef, fileChanged)
+		if err != nil {
+			return fmt.Errorf("failed to check pending tasks: %w", err)
+		}
+		if queued {
+			// An unchanged file was already counted as skipped
+			if fileChanged {
+				result.FilesSkipped++
+			}
+			log.Printf("Task already pending for file, skipping: %s", filePath)
+			return nil
 		}
 
-		result.TasksCreated++
-		log.Printf("Task created for file: %s -> %s", filePath, outputPath)
-	}
-
-	return nil
-}
+		// One task per conversion target
+		for _, outputPath := range outputPaths {
+			// Wait if pending task limit is reached for this workflow
+			w.waitForTaskSlot(workflowID, workflowDef.Options.PendingLimit(w.maxPendingTasks))
+
+			task := &models.Task{
+				WorkflowID: workflowID,
+				FileID:     indexed.ID,
+				InputPath:  filePath,
+				OutputPath: outputPath,
+				InputMD5:   md5Hash,
+				Priority:   workflowDef.Priority,
+				Status:     models.TaskStatusPending,
+			}
 
-// calculateMD5 calculates the MD5 hash of a file
-func (w *Watcher) calculateMD5(filePath string) (string, int64, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", 0, err
-	}
-	defer file.Close()
+			if err := w.taskRepo.Create(task); err != nil {
+				return fmt.Errorf("failed to create task: %w", err)
+			}
 
-	hash := md5.New()
-	size, err := io.Copy(hash, file)
-	if err != nil {
-		return "", 0, err
+			result.TasksCreated++
+			log.Printf("Task created for file: %s -> %s", filePath, outputPath)
+		}
 	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), size, nil
+	return nil
 }
 
 // EnableWorkflow enables a workflow and starts watching it
@@ -665,6 +1263,10 @@ func (w *Watcher) EnableWorkflow(workflowID string) error {
 		return fmt.Errorf("failed to get workflow: %w", err)
 	}
 
+	if health := w.CheckHealth(wf); !health.Healthy {
+		return healthError(health)
+	}
+
 	// Perform initial scan
 	log.Printf("Performing initial scan for enabled workflow: %s", wf.Name)
 	result, err := w.scanWorkflow(workflowID)
@@ -696,15 +1298,11 @@ func (w *Watcher) DisableWorkflow(workflowID string) error {
 		return nil
 	}
 
-	// Remove file system watches
-	for _, path := range paths {
-		if err := w.watcher.Remove(path); err != nil {
-			log.Printf("Warning: Failed to remove watch for path %s: %v", path, err)
-		}
-	}
-
-	// Remove from watched paths map
+	// Remove from watched paths map, then the watches no other workflow uses
 	delete(w.watchedPaths, workflowID)
+	delete(w.watchedYAML, workflowID)
+	w.removeUnusedWatches(paths)
+	w.stopSchedule(workflowID)
 
 	// Cancel any pending debounce timers for this workflow
 	w.debounceMu.Lock()
@@ -716,6 +1314,11 @@ func (w *Watcher) DisableWorkflow(workflowID string) error {
 	}
 	w.debounceMu.Unlock()
 
+	// Drop any events batched for this workflow
+	w.batchMu.Lock()
+	delete(w.batch, workflowID)
+	w.batchMu.Unlock()
+
 	log.Printf("Workflow %s disabled and watching stopped", workflowID)
 	return nil
 }
@@ -725,10 +1328,131 @@ func (w *Watcher) ScanWorkflow(workflowID string) (*ScanResult, error) {
 	return w.scanWorkflow(workflowID)
 }
 
-// waitForTaskSlot waits until pending task count is below the limit for the given workflow
-func (w *Watcher) waitForTaskSlot(workflowID string) {
-	// If maxPendingTasks is 0, no limit
-	if w.maxPendingTasks == 0 {
+// ReprocessWorkflow creates new pending tasks for every indexed file of a
+// workflow without rescanning or re-hashing, so changed steps are applied to
+// files that were already processed. Files that no longer exist or already
+// have a pending task are skipped.
+func (w *Watcher) ReprocessWorkflow(workflowID string) (*ScanResult, error) {
+	wf, err := w.workflowRepo.GetByID(workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	workflowDef, err := workflow.Parse(wf.YAMLContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow: %w", err)
+	}
+
+	files, err := w.fileRepo.ListByWorkflow(workflowID, -1, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	// A paused workflow only flags the files, for resuming to queue them
+	if wf.Paused {
+		result := &ScanResult{}
+		for _, file := range files {
+			result.FilesScanned++
+			if err := w.deferFile(file); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to update file record: %w", err))
+				continue
+			}
+			result.FilesPaused++
+		}
+		log.Printf("Reprocess of paused workflow %s: deferred %d file(s) until it resumes", wf.Name, result.FilesPaused)
+		return result, nil
+	}
+
+	result, err := w.queueIndexedFiles(wf, workflowDef, files)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reprocess of workflow %s: files=%d, skipped=%d, tasks=%d",
+		wf.Name, result.FilesScanned, result.FilesSkipped, result.TasksCreated)
+	return result, nil
+}
+
+// ResumeWorkflow clears the deferred flag of the files that were new or
+// changed while the workflow was paused and, if enqueue is set, queues tasks
+// for them. The workflow must already be stored as not paused.
+func (w *Watcher) ResumeWorkflow(workflowID string, enqueue bool) (*ScanResult, error) {
+	wf, err := w.workflowRepo.GetByID(workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	result := &ScanResult{}
+	if enqueue {
+		workflowDef, err := workflow.Parse(wf.YAMLContent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse workflow: %w", err)
+		}
+		files, err := w.fileRepo.ListDeferred(workflowID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deferred files: %w", err)
+		}
+		if result, err = w.queueIndexedFiles(wf, workflowDef, files); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.fileRepo.ClearDeferred(workflowID); err != nil {
+		return result, fmt.Errorf("failed to clear deferred files: %w", err)
+	}
+
+	log.Printf("Workflow %s resumed: deferred files=%d, tasks=%d", wf.Name, result.FilesScanned, result.TasksCreated)
+	return result, nil
+}
+
+// queueIndexedFiles creates pending tasks for indexed files of a workflow
+// without re-hashing them. Files that no longer exist or already have a
+// pending task are skipped.
+func (w *Watcher) queueIndexedFiles(wf *models.Workflow, workflowDef *workflow.WorkflowDef, files []*models.File) (*ScanResult, error) {
+	workflowID := wf.ID
+	pending, err := w.taskRepo.PendingFileIDs(workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending tasks: %w", err)
+	}
+
+	result := &ScanResult{}
+	for _, file := range files {
+		result.FilesScanned++
+		if pending[file.ID] {
+			result.FilesSkipped++
+			continue
+		}
+		if _, err := os.Stat(file.FilePath); err != nil {
+			result.FilesSkipped++
+			continue
+		}
+
+		for _, outputPath := range workflowDef.TaskOutputPaths(file.FilePath, time.Now(), file.FileMD5) {
+			// Wait if pending task limit is reached for this workflow
+			w.waitForTaskSlot(workflowID, workflowDef.Options.PendingLimit(w.maxPendingTasks))
+
+			task := &models.Task{
+				WorkflowID: workflowID,
+				FileID:     file.ID,
+				InputPath:  file.FilePath,
+				OutputPath: outputPath,
+				InputMD5:   file.FileMD5,
+				Priority:   workflowDef.Priority,
+				Status:     models.TaskStatusPending,
+			}
+			if err := w.taskRepo.Create(task); err != nil {
+				result.Errors = append(result.Errors, fmt.Errorf("failed to create task for %s: %w", file.FilePath, err))
+				continue
+			}
+			result.TasksCreated++
+			metrics.TasksCreated(wf.Name, 1)
+		}
+	}
+	return result, nil
+}
+
+// waitForTaskSlot waits until the workflow has fewer pending tasks than limit,
+// the global limit or the workflow's options.max_pending_tasks. 0 means no limit.
+func (w *Watcher) waitForTaskSlot(workflowID string, limit int) {
+	if limit <= 0 {
 		return
 	}
 
@@ -751,12 +1475,12 @@ func (w *Watcher) waitForTaskSlot(workflowID string) {
 		}
 
 		// If below limit, proceed
-		if pendingCount < w.maxPendingTasks {
+		if pendingCount < limit {
 			return
 		}
 
 		// Log and wait
-		log.Printf("Workflow %s: Pending task limit reached (%d/%d), waiting for tasks to be processed...", workflowID, pendingCount, w.maxPendingTasks)
+		log.Printf("Workflow %s: Pending task limit reached (%d/%d), waiting for tasks to be processed...", workflowID, pendingCount, limit)
 		time.Sleep(checkInterval)
 	}
 }
@@ -0,0 +1,1275 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/models"
+)
+
+func setupTestWatcher(tb testing.TB) (*Watcher, *models.Workflow) {
+	db, err := database.New(filepath.Join(tb.TempDir(), "test.db"))
+	if err != nil {
+		tb.Fatalf("Failed to create test database: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	w, err := New(db, 0)
+	if err != nil {
+		tb.Fatalf("Failed to create watcher: %v", err)
+	}
+	tb.Cleanup(w.Stop)
+
+	wf := &models.Workflow{
+		Name: "test-workflow",
+		YAMLContent: `
+name: test-workflow
+on:
+  paths:
+    - ./test
+convert:
+  from: txt
+  to: out
+options:
+  file_glob: "*.txt"
+  ignore:
+    - "*skip*"
+steps:
+  - name: noop
+    run: "true"
+`,
+		Enabled: true,
+	}
+	if err := w.workflowRepo.Create(wf); err != nil {
+		tb.Fatalf("Failed to create workflow: %v", err)
+	}
+	return w, wf
+}
+
+// setupTestWatcherWith is setupTestWatcher with the workflow watching dir and
+// the given lines added to its options
+func setupTestWatcherWith(tb testing.TB, dir string, options ...string) (*Watcher, *models.Workflow) {
+	w, wf := setupTestWatcher(tb)
+	var extra strings.Builder
+	for _, option := range options {
+		extra.WriteString("  " + option + "\n")
+	}
+	wf.YAMLContent = "name: test-workflow\non:\n  paths:\n    - " + dir + "\nconvert:\n  from: txt\n  to: out\noptions:\n  file_glob: \"*.txt\"\n" + extra.String() + "  ignore:\n    - \"*skip*\"\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	if err := w.workflowRepo.Update(wf); err != nil {
+		tb.Fatalf("Failed to update workflow: %v", err)
+	}
+	return w, wf
+}
+
+// writeTestFiles creates n matching files plus one ignored and one non-matching file
+func writeTestFiles(tb testing.TB, dir string, n int) []string {
+	var paths []string
+	for i := 0; i < n; i++ {
+		paths = append(paths, filepath.Join(dir, fmt.Sprintf("file-%04d.txt", i)))
+	}
+	paths = append(paths, filepath.Join(dir, "skip-me.txt"), filepath.Join(dir, "image.jpg"))
+	for i, path := range paths {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("content %d", i)), 0644); err != nil {
+			tb.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	return paths
+}
+
+// snapshot returns the indexed files and pending tasks of a workflow in a comparable form
+func snapshot(t *testing.T, w *Watcher, workflowID string) (files, tasks []string) {
+	fileList, err := w.fileRepo.ListByWorkflow(workflowID, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	for _, f := range fileList {
+		files = append(files, fmt.Sprintf("%s %s %d", f.FilePath, f.FileMD5, f.FileSize))
+	}
+
+	taskList, err := w.taskRepo.List(workflowID, models.TaskStatusPending, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	for _, task := range taskList {
+		if task.ID == "" || task.FileID == "" {
+			t.Errorf("Task for %s is missing IDs", task.InputPath)
+		}
+		tasks = append(tasks, task.InputPath+" -> "+task.OutputPath)
+	}
+	sort.Strings(tasks)
+	return files, tasks
+}
+
+func assertEqualLists(t *testing.T, kind string, want, got []string) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Fatalf("Expected %d %s, got %d", len(want), kind, len(got))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Errorf("%s mismatch at %d: want %q, got %q", kind, i, want[i], got[i])
+		}
+	}
+}
+
+func TestProcessBatchMatchesPerFile(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 20)
+
+	single, singleWf := setupTestWatcher(t)
+	batched, batchedWf := setupTestWatcher(t)
+
+	// Index half of the files up front so the batch sees new, changed and unchanged files
+	for _, path := range paths[:10] {
+		single.processFile(singleWf, path)
+	}
+	batched.processBatch(batchedWf, paths[:10])
+	if err := os.WriteFile(paths[0], []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+
+	for _, path := range paths {
+		single.processFile(singleWf, path)
+	}
+	result := batched.processBatch(batchedWf, paths)
+
+	if len(result.Errors) != 0 {
+		t.Fatalf("Unexpected batch errors: %v", result.Errors)
+	}
+	// The changed file still has its first task pending, so only new files queue tasks
+	if result.FilesNew != 10 || result.FilesChanged != 1 || result.TasksCreated != 10 {
+		t.Errorf("Unexpected batch result: new=%d changed=%d tasks=%d", result.FilesNew, result.FilesChanged, result.TasksCreated)
+	}
+
+	singleFiles, singleTasks := snapshot(t, single, singleWf.ID)
+	batchedFiles, batchedTasks := snapshot(t, batched, batchedWf.ID)
+	assertEqualLists(t, "files", singleFiles, batchedFiles)
+	assertEqualLists(t, "tasks", singleTasks, batchedTasks)
+}
+
+func TestBatchWindowFlushesCollectedEvents(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 5)
+
+	w, wf := setupTestWatcher(t)
+	w.SetBatchWindow(50 * time.Millisecond)
+
+	for _, path := range paths {
+		if !w.addToBatch([]*models.Workflow{wf}, path) {
+			t.Fatal("Expected event to be batched")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count == 5 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending)
+	t.Fatalf("Expected 5 tasks after batch flush, got %d", count)
+}
+
+func TestConvertTargetsCreateTaskPerOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(input, []byte("jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	w, wf := setupTestWatcher(t)
+	wf.YAMLContent = `
+name: test-workflow
+on:
+  paths:
+    - ./test
+convert:
+  from: jpg
+  targets:
+    - to: png
+    - to: webp
+steps:
+  - name: noop
+    run: "true"
+`
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	w.processFile(wf, input)
+
+	_, tasks := snapshot(t, w, wf.ID)
+	assertEqualLists(t, "tasks", []string{
+		input + " -> " + filepath.Join(dir, "photo.png"),
+		input + " -> " + filepath.Join(dir, "photo.webp"),
+	}, tasks)
+}
+
+func TestMissingDependencyBlocksEnable(t *testing.T) {
+	w, wf := setupTestWatcher(t)
+
+	if _, _, err := w.pluginRepo.CreatePlugin("needs-tool", "Test plugin", `
+name: needs-tool
+version: 1.0.0
+dependencies:
+  - fileaction-missing-plugin-tool
+steps:
+  - name: run
+    run: fileaction-missing-plugin-tool
+`, "test"); err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+
+	wf.Enabled = false
+	wf.YAMLContent = `
+name: test-workflow
+on:
+  paths:
+    - ` + t.TempDir() + `
+dependencies:
+  - sh
+  - fileaction-missing-tool>=1.0
+steps:
+  - name: convert
+    uses: needs-tool@1.0.0
+`
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	health := w.CheckHealth(wf)
+	if health.Healthy {
+		t.Fatal("Expected workflow with missing dependencies to be unhealthy")
+	}
+	assertEqualLists(t, "missing dependencies",
+		[]string{"fileaction-missing-plugin-tool", "fileaction-missing-tool"}, health.MissingDependencies)
+
+	err := w.EnableWorkflow(wf.ID)
+	if err == nil || !strings.Contains(err.Error(), "fileaction-missing-tool") {
+		t.Fatalf("Expected enable to fail listing the missing tool, got %v", err)
+	}
+	if _, watching := w.watchedPaths[wf.ID]; watching {
+		t.Error("Unhealthy workflow should not be watched")
+	}
+
+	// Dropping the missing requirements makes the workflow healthy again
+	wf.YAMLContent = strings.Replace(wf.YAMLContent, "  - fileaction-missing-tool>=1.0\n", "", 1)
+	wf.YAMLContent = strings.Replace(wf.YAMLContent, "uses: needs-tool@1.0.0", `run: "true"`, 1)
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	if health := w.CheckHealth(wf); !health.Healthy {
+		t.Fatalf("Expected workflow to be healthy, got %+v", health)
+	}
+	if err := w.EnableWorkflow(wf.ID); err != nil {
+		t.Fatalf("Expected healthy workflow to enable, got %v", err)
+	}
+}
+
+func TestReprocessWorkflowKeepsIndex(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 5)
+
+	w, wf := setupTestWatcher(t)
+	w.processBatch(wf, paths)
+
+	// Finish the initial tasks so only reprocessed ones are pending
+	tasks, err := w.taskRepo.List(wf.ID, models.TaskStatusPending, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	for _, task := range tasks {
+		if err := w.taskRepo.UpdateStatus(task.ID, models.TaskStatusCompleted); err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+	}
+	filesBefore, _ := snapshot(t, w, wf.ID)
+
+	// Content changes are not picked up: reprocess uses the stored index as is
+	if err := os.WriteFile(paths[0], []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+
+	result, err := w.ReprocessWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Reprocess failed: %v", err)
+	}
+	if result.FilesScanned != 5 || result.TasksCreated != 5 {
+		t.Errorf("Expected 5 tasks for 5 indexed files, got files=%d tasks=%d", result.FilesScanned, result.TasksCreated)
+	}
+
+	filesAfter, pendingTasks := snapshot(t, w, wf.ID)
+	assertEqualLists(t, "files", filesBefore, filesAfter)
+	if len(pendingTasks) != 5 {
+		t.Errorf("Expected 5 pending tasks, got %d", len(pendingTasks))
+	}
+
+	// Files that already have a pending task are not queued twice
+	result, err = w.ReprocessWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Reprocess failed: %v", err)
+	}
+	if result.TasksCreated != 0 || result.FilesSkipped != 5 {
+		t.Errorf("Expected pending files to be skipped, got tasks=%d skipped=%d", result.TasksCreated, result.FilesSkipped)
+	}
+}
+
+func TestWorkflowPendingLimitOverridesGlobal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, 3)
+
+	w, wf := setupTestWatcherWith(t, dir, "max_pending_tasks: 0")
+	// The global limit would stall the scan after its first task
+	w.maxPendingTasks = 1
+
+	done := make(chan *ScanResult, 1)
+	go func() {
+		result, err := w.scanWorkflow(wf.ID)
+		if err != nil {
+			t.Errorf("Scan failed: %v", err)
+		}
+		done <- result
+	}()
+
+	select {
+	case result := <-done:
+		if result != nil && result.TasksCreated != 3 {
+			t.Errorf("Expected 3 tasks without a pending limit, got %d", result.TasksCreated)
+		}
+	case <-time.After(5 * time.Second):
+		close(w.stopChan)
+		t.Fatal("Scan waited on the global pending limit despite max_pending_tasks: 0")
+	}
+}
+
+func TestEventsHandledDuringInitialScan(t *testing.T) {
+	w, live := setupTestWatcher(t)
+	// A pending-task limit of 1 stalls each scan after its first task
+	w.maxPendingTasks = 1
+	w.SetScanConcurrency(2)
+
+	workflowYAML := func(name, dir string) string {
+		return "name: " + name + "\non:\n  paths:\n    - " + dir + "\nconvert:\n  from: txt\n  to: out\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	}
+
+	liveDir := t.TempDir()
+	live.YAMLContent = workflowYAML("test-workflow", liveDir)
+	if err := w.workflowRepo.Update(live); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	var stalled []*models.Workflow
+	for _, name := range []string{"stalled-a", "stalled-b"} {
+		dir := t.TempDir()
+		writeTestFiles(t, dir, 3)
+		wf := &models.Workflow{Name: name, YAMLContent: workflowYAML(name, dir), Enabled: true}
+		if err := w.workflowRepo.Create(wf); err != nil {
+			t.Fatalf("Failed to create workflow: %v", err)
+		}
+		stalled = append(stalled, wf)
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+
+	waitForPending := func(wf *models.Workflow, want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count == want {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending)
+		t.Fatalf("Expected %d pending tasks for %s, got %d", want, wf.Name, count)
+	}
+
+	// Both scans run at once and are stuck on their pending-task limit
+	for _, wf := range stalled {
+		waitForPending(wf, 1)
+	}
+
+	// A new file is still picked up while the scans are in progress
+	if err := os.WriteFile(filepath.Join(liveDir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	waitForPending(live, 1)
+
+	for _, wf := range stalled {
+		if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count != 1 {
+			t.Errorf("Expected scan of %s to still be stalled, got %d tasks", wf.Name, count)
+		}
+	}
+}
+
+func TestBaselineIndexesWithoutQueuing(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 3)
+
+	w, wf := setupTestWatcherWith(t, dir, "skip_on_nochange: true", "baseline: true")
+
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Baseline scan failed: %v", err)
+	}
+	if result.FilesNew != 3 || result.TasksCreated != 0 {
+		t.Fatalf("Expected 3 indexed files and no tasks, got new=%d tasks=%d", result.FilesNew, result.TasksCreated)
+	}
+	files, tasks := snapshot(t, w, wf.ID)
+	if len(files) != 3 || len(tasks) != 0 {
+		t.Fatalf("Expected 3 files and no tasks after baseline, got %d files and %d tasks", len(files), len(tasks))
+	}
+
+	// Only the modified file is queued on the next scan
+	if err := os.WriteFile(paths[0], []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if _, err := w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	_, tasks = snapshot(t, w, wf.ID)
+	assertEqualLists(t, "tasks", []string{paths[0] + " -> " + filepath.Join(dir, "file-0000.out")}, tasks)
+
+	// The baseline is taken once: with the index cleared every file is new again
+	if err := w.fileRepo.DeleteByWorkflow(wf.ID); err != nil {
+		t.Fatalf("Failed to clear index: %v", err)
+	}
+	result, err = w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if result.TasksCreated != 2 {
+		t.Errorf("Expected the unqueued files to get tasks after the baseline, got %d", result.TasksCreated)
+	}
+}
+
+func TestMaxTasksPerScanDefersRemainingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, 5)
+
+	w, wf := setupTestWatcherWith(t, dir, "skip_on_nochange: true", "max_tasks_per_scan: 2")
+
+	// Each scan queues up to the limit; deferred files stay unindexed for the next one
+	for i, want := range []struct{ created, deferred int }{{2, 3}, {2, 1}, {1, 0}, {0, 0}} {
+		result, err := w.scanWorkflow(wf.ID)
+		if err != nil {
+			t.Fatalf("Scan %d failed: %v", i+1, err)
+		}
+		if result.TasksCreated != want.created || result.TasksDeferred != want.deferred {
+			t.Errorf("Scan %d: expected created=%d deferred=%d, got created=%d deferred=%d",
+				i+1, want.created, want.deferred, result.TasksCreated, result.TasksDeferred)
+		}
+	}
+
+	files, tasks := snapshot(t, w, wf.ID)
+	if len(files) != 5 || len(tasks) != 5 {
+		t.Errorf("Expected every file indexed and queued once, got %d files and %d tasks", len(files), len(tasks))
+	}
+}
+
+func TestPendingTaskIsNotDuplicated(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 1)
+
+	w, wf := setupTestWatcherWith(t, dir)
+
+	// A write event and an overlapping scan queue a single task
+	w.processFile(wf, paths[0])
+	if err := os.WriteFile(paths[0], []byte("rewritten"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.FilesChanged != 1 || result.TasksCreated != 0 {
+		t.Errorf("Expected the changed file to queue no task, got changed=%d tasks=%d", result.FilesChanged, result.TasksCreated)
+	}
+	if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count != 1 {
+		t.Fatalf("Expected 1 pending task, got %d", count)
+	}
+
+	// A task already running on older content does not hold back the new content
+	running, err := w.taskRepo.List(wf.ID, models.TaskStatusPending, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	running[0].Status = models.TaskStatusRunning
+	if err := w.taskRepo.Update(running[0]); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if err := os.WriteFile(paths[0], []byte("rewritten while running"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	result, err = w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.TasksCreated != 1 {
+		t.Errorf("Expected the change during the run to queue a task, got %d", result.TasksCreated)
+	}
+
+	// Once the task has finished the file is queued again
+	tasks, err := w.taskRepo.List(wf.ID, models.TaskStatusPending, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	tasks[0].Status = models.TaskStatusCompleted
+	if err := w.taskRepo.Update(tasks[0]); err != nil {
+		t.Fatalf("Failed to update task: %v", err)
+	}
+	if err := os.WriteFile(paths[0], []byte("rewritten again"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	w.processFile(wf, paths[0])
+	if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count != 1 {
+		t.Errorf("Expected a new pending task, got %d", count)
+	}
+}
+
+func TestMtimeFastpathSkipsHashing(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 1)
+
+	w, wf := setupTestWatcherWith(t, dir, "use_mtime_fastpath: true")
+	if _, err := w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+
+	// Same size and mtime: the rewrite goes unnoticed because the file isn't hashed
+	if err := os.WriteFile(paths[0], []byte("content X"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if err := os.Chtimes(paths[0], info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("Failed to reset mtime: %v", err)
+	}
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.FilesChanged != 0 {
+		t.Errorf("Expected the fast path to skip the file, got changed=%d", result.FilesChanged)
+	}
+
+	// A newer mtime falls back to hashing
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(paths[0], later, later); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+	if result, err = w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.FilesChanged != 1 {
+		t.Errorf("Expected the rewritten file to be detected, got changed=%d", result.FilesChanged)
+	}
+
+	file, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, paths[0])
+	if err != nil || file == nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if file.ModTime != later.UnixNano() {
+		t.Errorf("Expected mtime %d to be recorded, got %d", later.UnixNano(), file.ModTime)
+	}
+}
+
+func TestFileSizeLimitsSkipFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 2)
+
+	w, wf := setupTestWatcherWith(t, dir, "min_size: 1KB", "max_size: 4KB")
+
+	// Both files are a few bytes, below min_size
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.TasksCreated != 0 || result.FilesNew != 0 {
+		t.Errorf("Expected undersized files to be skipped, got new=%d tasks=%d", result.FilesNew, result.TasksCreated)
+	}
+
+	// One file grows into the range, the other past max_size
+	if err := os.WriteFile(paths[0], make([]byte, 2048), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := os.WriteFile(paths[1], make([]byte, 8192), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if result, err = w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.TasksCreated != 1 {
+		t.Errorf("Expected 1 task for the file within the size range, got %d", result.TasksCreated)
+	}
+	if file, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, paths[1]); err != nil || file != nil {
+		t.Errorf("Expected the oversized file not to be indexed, got %v (err %v)", file, err)
+	}
+}
+
+func TestPathRegexFiltersFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 2)
+
+	w, wf := setupTestWatcherWith(t, dir, `path_regex: '/file-\d*1\.\w+$'`)
+	// Matches the regex but not the glob
+	if err := os.WriteFile(filepath.Join(dir, "file-0001.jpg"), []byte("jpg"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if result.TasksCreated != 1 {
+		t.Fatalf("Expected 1 task for the file matching both glob and regex, got %d", result.TasksCreated)
+	}
+	tasks, err := w.taskRepo.List(wf.ID, models.TaskStatusPending, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].InputPath != paths[1] {
+		t.Errorf("Expected a task for %s, got %+v", paths[1], tasks)
+	}
+}
+
+func TestProcessFileWaitsForStableFile(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 2)
+
+	w, wf := setupTestWatcherWith(t, dir)
+	w.SetStability(150*time.Millisecond, 3)
+
+	// A copy still in progress is hashed only once it has finished
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for i := 0; i < 5; i++ {
+			time.Sleep(40 * time.Millisecond)
+			f.Write([]byte(" more"))
+		}
+	}()
+	w.processFile(wf, paths[0])
+	<-done
+
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	file, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, paths[0])
+	if err != nil || file == nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if file.FileSize != info.Size() {
+		t.Errorf("Expected the complete file of %d bytes to be indexed, got %d", info.Size(), file.FileSize)
+	}
+
+	// A file removed during the wait is skipped
+	go func() {
+		time.Sleep(40 * time.Millisecond)
+		os.Remove(paths[1])
+	}()
+	w.processFile(wf, paths[1])
+	if count, _ := w.taskRepo.Count(wf.ID, models.TaskStatusPending); count != 1 {
+		t.Errorf("Expected only the first file to be queued, got %d pending tasks", count)
+	}
+}
+
+func TestProcessBatchWaitsForStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 2)
+
+	w, wf := setupTestWatcherWith(t, dir)
+	w.SetStability(150*time.Millisecond, 3)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f, err := os.OpenFile(paths[0], os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		for i := 0; i < 5; i++ {
+			time.Sleep(40 * time.Millisecond)
+			f.Write([]byte(" more"))
+		}
+	}()
+	start := time.Now()
+	result := w.processBatch(wf, paths)
+	<-done
+
+	if len(result.Errors) > 0 || result.TasksCreated != 2 {
+		t.Fatalf("Expected 2 tasks, got %d (errors: %v)", result.TasksCreated, result.Errors)
+	}
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	file, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, paths[0])
+	if err != nil || file == nil {
+		t.Fatalf("Failed to get file: %v", err)
+	}
+	if file.FileSize != info.Size() {
+		t.Errorf("Expected the complete file of %d bytes to be indexed, got %d", info.Size(), file.FileSize)
+	}
+	// The files are polled together rather than one after the other
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected one stability wait for the batch, took %v", elapsed)
+	}
+}
+
+func TestReloadKeepsUnchangedWatches(t *testing.T) {
+	w, live := setupTestWatcher(t)
+
+	workflowYAML := func(name, dir string) string {
+		return "name: " + name + "\non:\n  paths:\n    - " + dir + "\nconvert:\n  from: txt\n  to: out\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	}
+
+	liveDir := t.TempDir()
+	live.YAMLContent = workflowYAML("test-workflow", liveDir)
+	if err := w.workflowRepo.Update(live); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	otherDirs := []string{t.TempDir(), t.TempDir()}
+	other := &models.Workflow{Name: "other", YAMLContent: workflowYAML("other", otherDirs[0]), Enabled: true}
+	if err := w.workflowRepo.Create(other); err != nil {
+		t.Fatalf("Failed to create workflow: %v", err)
+	}
+
+	if err := w.Start(); err != nil {
+		t.Fatalf("Failed to start watcher: %v", err)
+	}
+
+	// Keep changing the other workflow and reloading while files are created
+	const files = 20
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < files; i++ {
+			other.YAMLContent = workflowYAML("other", otherDirs[(i+1)%2])
+			if err := w.workflowRepo.Update(other); err != nil {
+				t.Errorf("Failed to update workflow: %v", err)
+				return
+			}
+			if err := w.ReloadWorkflows(); err != nil {
+				t.Errorf("Reload failed: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(liveDir, fmt.Sprintf("file-%02d.txt", i)), []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	<-done
+
+	deadline := time.Now().Add(5 * time.Second)
+	count := 0
+	for time.Now().Before(deadline) {
+		if count, _ = w.taskRepo.Count(live.ID, models.TaskStatusPending); count == files {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if count != files {
+		t.Errorf("Expected a task for each of the %d files created during reloads, got %d", files, count)
+	}
+
+	// The changed workflow moved to its new directory
+	watched := make(map[string]bool)
+	for _, path := range w.watcher.WatchList() {
+		watched[path] = true
+	}
+	if !watched[liveDir] || !watched[otherDirs[0]] || watched[otherDirs[1]] {
+		t.Errorf("Unexpected watch list after reloads: %v", w.watcher.WatchList())
+	}
+}
+
+func TestHashingRetriesLockedFile(t *testing.T) {
+	w, _ := setupTestWatcher(t)
+	w.openBackoff = time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "locked.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The first two opens fail as if another process held the file
+	attempts := 0
+	w.openFile = func(name string) (*os.File, error) {
+		attempts++
+		if attempts <= 2 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: lockErrnos[0]}
+		}
+		return os.Open(name)
+	}
+
+	hash, size, err := w.calculateHash(path)
+	if err != nil {
+		t.Fatalf("Expected hashing to succeed after retries, got %v", err)
+	}
+	if hash != "9a0364b9e99bb480dd25e1f0284c8555" || size != 7 || attempts != 3 {
+		t.Errorf("Unexpected result: hash=%s size=%d attempts=%d", hash, size, attempts)
+	}
+
+	// A file that is simply unreadable is not retried
+	attempts = 0
+	w.openFile = func(name string) (*os.File, error) {
+		attempts++
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	if _, _, err := w.calculateHash(path); !errors.Is(err, os.ErrPermission) || attempts != 1 {
+		t.Errorf("Expected an unreadable file to fail without retries, got %v after %d attempts", err, attempts)
+	}
+
+	// Without retries the lock error is returned right away
+	attempts = 0
+	w.openFile = func(name string) (*os.File, error) {
+		attempts++
+		return nil, &os.PathError{Op: "open", Path: name, Err: lockErrnos[0]}
+	}
+	w.SetOpenRetries(0)
+	if _, _, err := w.calculateHash(path); !errors.Is(err, lockErrnos[0]) || attempts != 1 {
+		t.Errorf("Expected a single failed attempt, got %v after %d attempts", err, attempts)
+	}
+}
+
+func TestSwitchingHashAlgorithmKeepsUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 3)
+
+	w, wf := setupTestWatcherWith(t, dir, "skip_on_nochange: true", "baseline: true")
+	if _, err := w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Baseline scan failed: %v", err)
+	}
+
+	// Files indexed with md5 are not requeued after switching to sha256
+	if err := w.SetHashAlgorithm("sha256"); err != nil {
+		t.Fatalf("SetHashAlgorithm failed: %v", err)
+	}
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	if result.TasksCreated != 0 || result.FilesChanged != 0 {
+		t.Errorf("Expected unchanged files to be skipped, got tasks=%d changed=%d", result.TasksCreated, result.FilesChanged)
+	}
+	fileList, err := w.fileRepo.ListByWorkflow(wf.ID, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to list files: %v", err)
+	}
+	for _, f := range fileList {
+		if len(f.FileMD5) != 64 {
+			t.Errorf("Expected %s to be rehashed with sha256, got %q", f.FilePath, f.FileMD5)
+		}
+	}
+
+	// A real change is still detected
+	if err := os.WriteFile(paths[1], []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if _, err := w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Rescan failed: %v", err)
+	}
+	_, tasks := snapshot(t, w, wf.ID)
+	assertEqualLists(t, "tasks", []string{paths[1] + " -> " + filepath.Join(dir, "file-0001.out")}, tasks)
+
+	if err := w.SetHashAlgorithm("crc32"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+func TestOnDeleteHandlesRemovedSource(t *testing.T) {
+	tests := []struct {
+		onDelete   string
+		outputKept bool
+		indexed    bool
+		stale      bool
+	}{
+		{"ignore", true, true, false},
+		{"delete_output", false, false, false},
+		{"mark_stale", true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onDelete, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := writeTestFiles(t, dir, 1)
+			outputPath := filepath.Join(dir, "file-0000.out")
+			if err := os.WriteFile(outputPath, []byte("converted"), 0644); err != nil {
+				t.Fatalf("Failed to write output: %v", err)
+			}
+
+			w, wf := setupTestWatcher(t)
+			wf.YAMLContent = strings.Replace(wf.YAMLContent, "options:\n", "options:\n  on_delete: "+tt.onDelete+"\n", 1)
+			w.processFile(wf, paths[0])
+
+			if err := os.Remove(paths[0]); err != nil {
+				t.Fatalf("Failed to remove source: %v", err)
+			}
+			w.processRemovedFile(wf, paths[0])
+
+			if _, err := os.Stat(outputPath); (err == nil) != tt.outputKept {
+				t.Errorf("Expected output kept=%v, got stat error %v", tt.outputKept, err)
+			}
+			file, err := w.fileRepo.GetByWorkflowAndPath(wf.ID, paths[0])
+			if err != nil {
+				t.Fatalf("Failed to get file: %v", err)
+			}
+			if (file != nil) != tt.indexed {
+				t.Fatalf("Expected indexed=%v, got %+v", tt.indexed, file)
+			}
+			if file != nil && file.Stale != tt.stale {
+				t.Errorf("Expected stale=%v, got %v", tt.stale, file.Stale)
+			}
+		})
+	}
+}
+
+func TestDryRunPlansWithoutIndexing(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 3)
+
+	w, wf := setupTestWatcherWith(t, dir, "skip_on_nochange: true")
+
+	plan := func() []string {
+		t.Helper()
+		result, err := w.DryRunWorkflow(wf.ID)
+		if err != nil {
+			t.Fatalf("Dry run failed: %v", err)
+		}
+		var entries []string
+		for _, p := range result.Planned {
+			entries = append(entries, fmt.Sprintf("%s %s %v %s", filepath.Base(p.InputPath), filepath.Base(p.OutputPath), p.WouldCreate, p.Reason))
+		}
+		sort.Strings(entries)
+		return entries
+	}
+
+	assertEqualLists(t, "entries", []string{
+		"file-0000.txt file-0000.out true new",
+		"file-0001.txt file-0001.out true new",
+		"file-0002.txt file-0002.out true new",
+		"skip-me.txt . false ignored",
+	}, plan())
+	files, tasks := snapshot(t, w, wf.ID)
+	if len(files) != 0 || len(tasks) != 0 {
+		t.Fatalf("Expected a dry run to leave no files or tasks, got %d files and %d tasks", len(files), len(tasks))
+	}
+
+	// After a real scan only the modified file would be queued again, were its
+	// task from that scan not still pending
+	if _, err := w.scanWorkflow(wf.ID); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if err := os.WriteFile(paths[2], []byte("modified"), 0644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	assertEqualLists(t, "entries", []string{
+		"file-0000.txt file-0000.out false unchanged",
+		"file-0001.txt file-0001.out false unchanged",
+		"file-0002.txt file-0002.out false queued",
+		"skip-me.txt . false ignored",
+	}, plan())
+	if _, tasks := snapshot(t, w, wf.ID); len(tasks) != 3 {
+		t.Errorf("Expected the dry run to add no tasks, got %d", len(tasks))
+	}
+}
+
+func TestIgnoredDirectoriesAreNotScanned(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, 2)
+	for _, sub := range []string{"node_modules", "photos"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", sub, err)
+		}
+		writeTestFiles(t, filepath.Join(dir, sub), 1)
+	}
+
+	w, wf := setupTestWatcher(t)
+	wf.YAMLContent = "name: test-workflow\non:\n  paths:\n    - " + dir + "\nconvert:\n  from: txt\n  to: out\noptions:\n  file_glob: \"*.txt\"\n  include_subdirs: true\n  ignore:\n    - node_modules\n    - \"*skip*\"\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	// Two files at the top and one in photos; each skip-me.txt is ignored, node_modules is never entered
+	if result.FilesScanned != 5 || result.FilesSkipped != 2 || result.TasksCreated != 3 {
+		t.Errorf("Unexpected scan result: scanned=%d skipped=%d tasks=%d", result.FilesScanned, result.FilesSkipped, result.TasksCreated)
+	}
+
+	if err := w.addWorkflowWatch(wf); err != nil {
+		t.Fatalf("Failed to watch workflow: %v", err)
+	}
+	for _, path := range w.watchedPaths[wf.ID] {
+		if filepath.Base(path) == "node_modules" {
+			t.Errorf("Expected node_modules not to be watched, got %v", w.watchedPaths[wf.ID])
+		}
+	}
+}
+
+func TestRecursiveFileGlobMatchesRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, 1)
+	for _, sub := range []string{"src", "src/a/b", "other"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", sub, err)
+		}
+		writeTestFiles(t, filepath.Join(dir, sub), 1)
+	}
+
+	w, wf := setupTestWatcher(t)
+	wf.YAMLContent = "name: test-workflow\non:\n  paths:\n    - " + dir + "\nconvert:\n  from: txt\n  to: out\noptions:\n  file_glob: \"src/**/file*.txt\"\n  include_subdirs: true\nsteps:\n  - name: noop\n    run: \"true\"\n"
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	result, err := w.scanWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	// Only the files under src, at any depth, match; the directory holding
+	// the watched path doesn't count towards the pattern
+	if result.TasksCreated != 2 {
+		t.Errorf("Expected tasks for src and src/a/b only, got %d", result.TasksCreated)
+	}
+	tasks, err := w.taskRepo.List("", "", 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	for _, task := range tasks {
+		rel, _ := filepath.Rel(dir, task.InputPath)
+		if !strings.HasPrefix(rel, "src"+string(filepath.Separator)) {
+			t.Errorf("Unexpected task for %s", rel)
+		}
+	}
+}
+
+func BenchmarkProcessFiles(b *testing.B) {
+	const fileCount = 200
+	dir := b.TempDir()
+	paths := writeTestFiles(b, dir, fileCount)
+
+	b.Run("per-file", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			w, wf := setupTestWatcher(b)
+			b.StartTimer()
+			for _, path := range paths {
+				w.processFile(wf, path)
+			}
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			w, wf := setupTestWatcher(b)
+			b.StartTimer()
+			w.processBatch(wf, paths)
+		}
+	})
+}
+
+func TestOutputDirPatternTokens(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(input, []byte("jpeg data"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	hash, _, err := filehash.Sum(strings.NewReader("jpeg data"), filehash.Default)
+	if err != nil {
+		t.Fatalf("Failed to hash input: %v", err)
+	}
+
+	w, wf := setupTestWatcher(t)
+	wf.YAMLContent = `
+name: test-workflow
+on:
+  paths:
+    - ./test
+convert:
+  to: png
+options:
+  output_dir_pattern: ./out/{year}/{month}/{day}/{workflow}-{hash}
+  on_delete: delete_output
+steps:
+  - name: noop
+    run: "true"
+`
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	now := time.Now()
+	w.processFile(wf, input)
+
+	_, tasks := snapshot(t, w, wf.ID)
+	outputPath := filepath.Join(dir, "out", now.Format("2006"), now.Format("01"), now.Format("02"), "test-workflow-"+hash[:8], "photo.png")
+	assertEqualLists(t, "tasks", []string{input + " -> " + outputPath}, tasks)
+
+	// Deleting the source removes the output the task recorded
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	if err := os.WriteFile(outputPath, []byte("png data"), 0644); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+	if err := os.Remove(input); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	w.processRemovedFile(wf, input)
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Expected dated output to be removed, got stat error %v", err)
+	}
+}
+
+func TestScheduleFollowsEnableAndDisable(t *testing.T) {
+	w, wf := setupTestWatcher(t)
+	wf.YAMLContent = `
+name: test-workflow
+on:
+  paths:
+    - ` + t.TempDir() + `
+  schedule: "*/15 * * * *"
+steps:
+  - name: noop
+    run: "true"
+`
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	if err := w.EnableWorkflow(wf.ID); err != nil {
+		t.Fatalf("Failed to enable workflow: %v", err)
+	}
+	if _, scheduled := w.schedules[wf.ID]; !scheduled {
+		t.Fatal("Expected enabling the workflow to schedule its scans")
+	}
+
+	// Reloading unchanged YAML keeps the schedule; dropping on.schedule removes it
+	wf.YAMLContent = strings.Replace(wf.YAMLContent, "  schedule: \"*/15 * * * *\"\n", "", 1)
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	if err := w.ReloadWorkflows(); err != nil {
+		t.Fatalf("Failed to reload workflows: %v", err)
+	}
+	if _, scheduled := w.schedules[wf.ID]; scheduled {
+		t.Error("Expected the schedule to be removed with on.schedule")
+	}
+
+	wf.YAMLContent = strings.Replace(wf.YAMLContent, "steps:", "  schedule: \"@daily\"\nsteps:", 1)
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	if err := w.ReloadWorkflows(); err != nil {
+		t.Fatalf("Failed to reload workflows: %v", err)
+	}
+	if _, scheduled := w.schedules[wf.ID]; !scheduled {
+		t.Fatal("Expected the reloaded workflow to be scheduled")
+	}
+
+	if err := w.DisableWorkflow(wf.ID); err != nil {
+		t.Fatalf("Failed to disable workflow: %v", err)
+	}
+	if _, scheduled := w.schedules[wf.ID]; scheduled {
+		t.Error("Expected disabling the workflow to stop its scheduled scans")
+	}
+}
+
+func TestPausedWorkflowIndexesWithoutQueuing(t *testing.T) {
+	dir := t.TempDir()
+	paths := writeTestFiles(t, dir, 3)
+
+	w, wf := setupTestWatcherWith(t, dir)
+	wf.Paused = true
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+
+	// A dry run plans no tasks either
+	planned, err := w.DryRunWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("DryRunWorkflow failed: %v", err)
+	}
+	if planned.TasksCreated != 0 || planned.FilesPaused != 3 || planned.Planned[0].Reason != "paused" {
+		t.Errorf("Expected 3 files planned as paused, got %+v", planned)
+	}
+
+	result := w.processBatch(wf, paths[:2])
+	w.processFile(wf, paths[2])
+	if result.FilesPaused != 2 || result.TasksCreated != 0 {
+		t.Errorf("Expected 2 paused files and no tasks, got %+v", result)
+	}
+	files, tasks := snapshot(t, w, wf.ID)
+	if len(files) != 3 || len(tasks) != 0 {
+		t.Fatalf("Expected 3 indexed files and no tasks while paused, got %v and %v", files, tasks)
+	}
+	reprocessed, err := w.ReprocessWorkflow(wf.ID)
+	if err != nil {
+		t.Fatalf("ReprocessWorkflow failed: %v", err)
+	}
+	if _, tasks := snapshot(t, w, wf.ID); reprocessed.FilesPaused != 3 || len(tasks) != 0 {
+		t.Errorf("Expected reprocessing to defer 3 files without tasks, got %d paused and %v", reprocessed.FilesPaused, tasks)
+	}
+
+	wf.Paused = false
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	resumed, err := w.ResumeWorkflow(wf.ID, true)
+	if err != nil {
+		t.Fatalf("ResumeWorkflow failed: %v", err)
+	}
+	if resumed.TasksCreated != 3 {
+		t.Errorf("Expected a task for each file changed while paused, got %d", resumed.TasksCreated)
+	}
+	if deferred, _ := w.fileRepo.ListDeferred(wf.ID); len(deferred) != 0 {
+		t.Errorf("Expected resuming to clear deferred files, got %d", len(deferred))
+	}
+
+	// Without enqueue_changed, changes made while paused are dropped
+	wf.Paused = true
+	if err := w.workflowRepo.Update(wf); err != nil {
+		t.Fatalf("Failed to update workflow: %v", err)
+	}
+	if err := os.WriteFile(paths[0], []byte("changed"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", paths[0], err)
+	}
+	w.processFile(wf, paths[0])
+	if deferred, _ := w.fileRepo.ListDeferred(wf.ID); len(deferred) != 1 {
+		t.Fatalf("Expected the changed file to be deferred, got %d", len(deferred))
+	}
+	resumed, err = w.ResumeWorkflow(wf.ID, false)
+	if err != nil {
+		t.Fatalf("ResumeWorkflow failed: %v", err)
+	}
+	if deferred, _ := w.fileRepo.ListDeferred(wf.ID); resumed.TasksCreated != 0 || len(deferred) != 0 {
+		t.Errorf("Expected no tasks and no deferred files, got %d tasks and %d files", resumed.TasksCreated, len(deferred))
+	}
+}
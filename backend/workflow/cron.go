@@ -0,0 +1,36 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule is a parsed five-field cron expression (minute hour day-of-month
+// month day-of-week)
+type Schedule struct {
+	spec cron.Schedule
+}
+
+// scheduleParser accepts the standard five fields and the @ shorthands
+var scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSchedule parses a cron expression such as "*/15 * * * *". Fields
+// accept "*", numbers, names ("MON", "JAN"), ranges ("1-5"), lists ("1,15")
+// and steps ("*/15", "0-30/10"). The @hourly, @daily, @weekly, @monthly,
+// @yearly and @every <duration> shorthands are also accepted.
+func ParseSchedule(expr string) (*Schedule, error) {
+	spec, err := scheduleParser.Parse(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+	}
+	return &Schedule{spec: spec}, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's
+// location, or the zero time if none does within five years (e.g. "0 0 30 2 *")
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.spec.Next(t)
+}
@@ -0,0 +1,53 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, 3, 7, 10, 7, 30, 0, time.UTC) // A Thursday
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 3, 7, 10, 15, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2024, 3, 7, 10, 8, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 3, 8, 2, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * 1-5", time.Date(2024, 3, 7, 13, 30, 0, 0, time.UTC)},
+		{"0 0 * * SUN", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matches
+		{"0 0 20 * 5", time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse schedule: %v", err)
+			}
+			if next := schedule.Next(from); !next.Equal(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, next)
+			}
+		})
+	}
+
+	never, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Failed to parse schedule: %v", err)
+	}
+	if next := never.Next(from); !next.IsZero() {
+		t.Errorf("Expected no run for February 30th, got %v", next)
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "* * * * 7"} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
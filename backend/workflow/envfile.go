@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFile is a dotenv file whose variables are merged under a workflow's or
+// plugin's inline env. It is written either as a path or as {path, optional}.
+type EnvFile struct {
+	Path     string `yaml:"path"`
+	Optional bool   `yaml:"optional"` // A missing file is skipped instead of failing the task
+}
+
+// UnmarshalYAML accepts a plain path as well as the mapping form
+func (f *EnvFile) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&f.Path)
+	}
+	type plain EnvFile
+	return value.Decode((*plain)(f))
+}
+
+// Load reads the file's variables. It returns nil if no path is set or an
+// optional file does not exist.
+func (f EnvFile) Load() (map[string]string, error) {
+	if f.Path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(f.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if f.Optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("env_file %s does not exist (set optional: true to skip a missing file)", f.Path)
+		}
+		return nil, fmt.Errorf("failed to read env_file: %w", err)
+	}
+	env, err := ParseEnvFile(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("env_file %s: %w", f.Path, err)
+	}
+	return env, nil
+}
+
+// envKeyPattern matches variable names allowed in an env file
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnvFile parses KEY=VALUE lines. Blank lines and lines starting with #
+// are skipped and an "export " prefix is allowed. Values may be single quoted
+// (taken literally) or double quoted (\n, \t, \" and \\ are unescaped); an
+// unquoted value ends at " #", which starts a comment.
+func ParseEnvFile(content string) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// parseEnvValue unquotes a value and strips a trailing comment
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	quote := value[0]
+	if quote != '\'' && quote != '"' {
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		if c == quote {
+			if rest := strings.TrimSpace(value[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected text after closing quote")
+			}
+			return b.String(), nil
+		}
+		if c == '\\' && quote == '"' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(value[i])
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return "", fmt.Errorf("unterminated quoted value")
+}
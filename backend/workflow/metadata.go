@@ -0,0 +1,53 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ParseMetadata parses the JSON printed by a metadata extractor into
+// ${{ meta.* }} variables. The output may be an object or, like
+// `exiftool -json`, an array whose first object describes the file. Nested
+// objects are flattened with dotted keys.
+func ParseMetadata(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var parsed interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("invalid metadata JSON: %w", err)
+	}
+	if list, ok := parsed.([]interface{}); ok {
+		if len(list) == 0 {
+			return map[string]string{}, nil
+		}
+		parsed = list[0]
+	}
+	object, ok := parsed.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metadata JSON must be an object or an array of objects")
+	}
+
+	meta := make(map[string]string)
+	flattenMetadata("", object, meta)
+	return meta, nil
+}
+
+// flattenMetadata adds the values of object to meta, prefixing nested keys
+func flattenMetadata(prefix string, object map[string]interface{}, meta map[string]string) {
+	for key, value := range object {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenMetadata(prefix+key+".", v, meta)
+		case nil:
+			meta[prefix+key] = ""
+		case string:
+			meta[prefix+key] = v
+		default:
+			// Numbers, booleans and arrays keep their JSON spelling
+			encoded, _ := json.Marshal(v)
+			meta[prefix+key] = string(encoded)
+		}
+	}
+}
@@ -1,81 +1,505 @@
 package workflow
 
 import (
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/andi/fileaction/backend/filehash"
 	"gopkg.in/yaml.v3"
 )
 
 // WorkflowDef represents a parsed workflow definition
 type WorkflowDef struct {
-	Name        string            `yaml:"name"`
-	Description string            `yaml:"description"`
-	On          OnConfig          `yaml:"on"`
-	Convert     ConvertConfig     `yaml:"convert"`
-	Steps       []Step            `yaml:"steps"`
-	Options     Options           `yaml:"options"`
-	Env         map[string]string `yaml:"env"`
+	Name         string            `yaml:"name"`
+	Description  string            `yaml:"description"`
+	On           OnConfig          `yaml:"on"`
+	Convert      ConvertConfig     `yaml:"convert"`
+	Steps        []Step            `yaml:"steps"`
+	Options      Options           `yaml:"options"`
+	Validate     ValidateConfig    `yaml:"validate"`
+	Env          map[string]string `yaml:"env"`
+	EnvFile      EnvFile           `yaml:"env_file"`     // Dotenv file loaded under env, e.g. "/etc/fileaction/shared.env"
+	Dependencies []string          `yaml:"dependencies"` // Commands checked before the workflow is enabled
+	Priority     int               `yaml:"priority"`     // Tasks with a higher priority are dispatched first
+	Outputs      []string          `yaml:"outputs"`      // Additional files each task must produce, e.g. "${{ output_dir }}/${{ file_base }}.jpg"
+	Secrets      []string          `yaml:"secrets"`      // Env variables whose values are never sent in notifications
+
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	// Source is the YAML text of the document the workflow was read from,
+	// set by ParseMulti
+	Source string `yaml:"-"`
 }
 
 // OnConfig specifies trigger conditions
 type OnConfig struct {
-	Paths []string `yaml:"paths"`
+	Paths    []string `yaml:"paths"`
+	Schedule string   `yaml:"schedule"` // Cron expression for periodic rescans, e.g. "*/15 * * * *"
+}
+
+// NotificationsConfig adds workflow-specific details to task notifications
+type NotificationsConfig struct {
+	// Context is sent as the notification's "context" object, e.g.
+	// {deployment: "${DEPLOYMENT}"}. Values may use ${{ variables }} and
+	// ${NAME} references to the workflow env or the server's environment.
+	Context map[string]string `yaml:"context"`
 }
 
 // ConvertConfig specifies conversion settings
 type ConvertConfig struct {
-	From string `yaml:"from"`
-	To   string `yaml:"to"`
+	From    string          `yaml:"from"`
+	To      string          `yaml:"to"`
+	Targets []ConvertTarget `yaml:"targets"` // Optional list of outputs; each yields its own task
+}
+
+// ConvertTarget is one of several outputs produced from a single input.
+// Empty fields fall back to convert.to and options.output_dir_pattern.
+type ConvertTarget struct {
+	To               string `yaml:"to"`
+	OutputDirPattern string `yaml:"output_dir_pattern"`
+	Suffix           string `yaml:"suffix"` // Appended to the file name before the extension, e.g. "-thumb"
 }
 
 // Step represents a workflow step
 type Step struct {
-	Name      string            `yaml:"name"`
-	Run       string            `yaml:"run"`
-	Uses      string            `yaml:"uses"`      // Plugin reference (e.g., "plugin_name@v1.0.0")
-	With      map[string]string `yaml:"with"`      // Plugin input parameters
-	Condition string            `yaml:"condition"` // Optional condition for step execution
-	Env       map[string]string `yaml:"env"`
+	Name       string            `yaml:"name"`
+	Run        string            `yaml:"run"`
+	Uses       string            `yaml:"uses"`        // Plugin reference (e.g., "plugin_name@v1.0.0")
+	With       map[string]string `yaml:"with"`        // Plugin input parameters
+	If         string            `yaml:"if"`          // Run the step only when this condition holds, e.g. "${{ file_ext == '.png' }}"
+	Condition  string            `yaml:"condition"`   // Older name for if
+	Match      StepMatch         `yaml:"match"`       // Optional input type filter for step execution
+	WorkingDir string            `yaml:"working_dir"` // Directory the command runs in, e.g. "${{ file_dir }}"
+	Shell      string            `yaml:"shell"`       // Interpreter for run: sh (default), bash, pwsh, powershell, python, python3 or cmd
+	Stdin      string            `yaml:"stdin"`       // Text piped to the command's standard input; variables are substituted
+	StdinFile  string            `yaml:"stdin_file"`  // File streamed to the command's standard input, e.g. "${{ input_path }}"
+	Env        map[string]string `yaml:"env"`
+
+	// ContinueOnError lets the task go on to the next step when this one
+	// fails; the step is still recorded as failed
+	ContinueOnError bool `yaml:"continue_on_error"`
+
+	// Matrix runs the step once per combination of values, each exposed as
+	// ${{ matrix.<key> }}, e.g. {size: [720p, 1080p]}
+	Matrix map[string][]string `yaml:"matrix"`
+
+	// MatrixValues are the values of one expansion, set by ExpandMatrix
+	MatrixValues map[string]string `yaml:"-"`
+}
+
+// GetCondition returns the step's if condition, falling back to condition
+func (s Step) GetCondition() string {
+	if s.If != "" {
+		return s.If
+	}
+	return s.Condition
+}
+
+// ExpandMatrix returns one step per combination of the step's matrix values,
+// each named after its values, e.g. "encode (size=720p)". Keys vary in
+// alphabetical order with the last one changing fastest. A step without a
+// matrix is returned as is.
+func ExpandMatrix(step Step) []Step {
+	if len(step.Matrix) == 0 {
+		return []Step{step}
+	}
+
+	keys := make([]string, 0, len(step.Matrix))
+	for key := range step.Matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range step.Matrix[key] {
+				values := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					values[k] = v
+				}
+				values[key] = value
+				next = append(next, values)
+			}
+		}
+		combinations = next
+	}
+
+	steps := make([]Step, len(combinations))
+	for i, values := range combinations {
+		labels := make([]string, len(keys))
+		for j, key := range keys {
+			labels[j] = key + "=" + values[key]
+		}
+		steps[i] = step
+		steps[i].Name = fmt.Sprintf("%s (%s)", step.Name, strings.Join(labels, ", "))
+		steps[i].MatrixValues = values
+	}
+	return steps
+}
+
+// ValidateConfig lists checks run against the output after the main steps
+// succeed. A failing check fails the task.
+type ValidateConfig struct {
+	Steps           []Step `yaml:"steps"`
+	DeleteOnFailure bool   `yaml:"delete_on_failure"` // Remove the output when a check fails
+}
+
+// StepMatch restricts a step to inputs of certain types.
+// A step runs if the input matches any listed extension or content type;
+// an empty match runs the step for every input.
+type StepMatch struct {
+	Extensions   []string `yaml:"extensions"`    // e.g. [".mp4", "mov"]
+	ContentTypes []string `yaml:"content_types"` // e.g. ["video/*", "image/png"]
 }
 
 // Options represents workflow execution options
 type Options struct {
-	Concurrency      int      `yaml:"concurrency"`
-	IncludeSubdirs   bool     `yaml:"include_subdirs"`
-	FileGlob         string   `yaml:"file_glob"`
-	SkipOnNoChange   bool     `yaml:"skip_on_nochange"`
-	OutputDirPattern string   `yaml:"output_dir_pattern"`
-	Ignore           []string `yaml:"ignore"`
+	Concurrency      Concurrency `yaml:"concurrency"`
+	IncludeSubdirs   bool        `yaml:"include_subdirs"`
+	FileGlob         string      `yaml:"file_glob"`
+	PathRegex        string      `yaml:"path_regex"` // Files must also match this regular expression on their absolute path
+	SkipOnNoChange   bool        `yaml:"skip_on_nochange"`
+	UseMtimeFastpath bool        `yaml:"use_mtime_fastpath"` // Scans skip hashing files whose size and mtime are unchanged
+	OutputDirPattern string      `yaml:"output_dir_pattern"`
+	Ignore           []string    `yaml:"ignore"`
+	LazyOutputDir    bool        `yaml:"lazy_output_dir"`    // Steps create ${{ output_dir }} themselves; empty dirs are removed
+	Baseline         bool        `yaml:"baseline"`           // The first scan indexes existing files without queuing tasks
+	PTY              bool        `yaml:"pty"`                // Run steps on a pseudo-terminal; stdout and stderr are combined
+	Shell            string      `yaml:"shell"`              // Interpreter for steps without a shell and for metadata_command, verify_command and on_timeout
+	MaxTasksPerScan  int         `yaml:"max_tasks_per_scan"` // Stop queuing after this many tasks per scan (0 = no limit)
+	MaxPendingTasks  *int        `yaml:"max_pending_tasks"`  // Overrides watcher.max_pending_tasks for this workflow (0 = no limit)
+	PendingTTL       string      `yaml:"pending_ttl"`        // Cancel tasks still pending after this long, e.g. "24h"
+	VerifyInputHash  bool        `yaml:"verify_input_hash"`  // Supersede a task whose input changed after it was queued
+	AtomicOutput     bool        `yaml:"atomic_output"`      // Steps write ${{ output_tmp }}, renamed to the output once all steps succeed
+	Retry            RetryPolicy `yaml:"retry"`              // Re-run failing run steps
+	OnDelete         string      `yaml:"on_delete"`          // What happens when a source file is removed: ignore, delete_output or mark_stale
+	TriggerWorkflow  string      `yaml:"trigger_workflow"`   // Workflow queued with the output as its input once a task succeeds
+
+	// Files outside this size range are skipped, e.g. "1KB" or "10MB"
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+
+	// Command printing JSON about the input (e.g. "exiftool -json ${{ input_path }}"),
+	// run once per task; its fields become ${{ meta.* }} variables
+	MetadataCommand string `yaml:"metadata_command"`
+
+	// Result receipts written next to the output on completion
+	EmitResultJSON      bool   `yaml:"emit_result_json"`
+	ResultJSONSuffix    string `yaml:"result_json_suffix"`     // Defaults to ".fileaction.json"
+	ResultJSONOnFailure bool   `yaml:"result_json_on_failure"` // Also write a receipt for failed tasks
+
+	// Output checks after the steps succeed: the output must exist and be non-empty
+	VerifyOutput  bool   `yaml:"verify_output"`
+	VerifyCommand string `yaml:"verify_command"` // Optional extra check, e.g. "identify ${{ output_path }}"
+	ExpectedSize  string `yaml:"expected_size"`  // Exact output size, e.g. "1024" or "2MB"; implies verify_output
+	ExpectedHash  string `yaml:"expected_hash"`  // Hex md5, sha1 or sha256 digest of the output; implies verify_output
+
+	// Overrides of the global execution.task_timeout and execution.step_timeout, e.g. "2h"
+	Timeout     string `yaml:"timeout"`
+	StepTimeout string `yaml:"step_timeout"`
+
+	// Two-phase task timeout: warn and run on_timeout at the soft limit, kill at the hard limit
+	SoftTimeout string `yaml:"soft_timeout"` // e.g. "5m"
+	HardTimeout string `yaml:"hard_timeout"` // e.g. "15m"
+	OnTimeout   string `yaml:"on_timeout"`   // Command run once when the soft timeout is reached
+
+	pathRegex *regexp.Regexp // PathRegex compiled by Decode
+}
+
+// OnDelete values
+const (
+	OnDeleteIgnore       = "ignore"        // Leave the output and index entry alone (default)
+	OnDeleteDeleteOutput = "delete_output" // Remove the outputs and the index entry
+	OnDeleteMarkStale    = "mark_stale"    // Keep the outputs, flag the index entry as stale
+)
+
+// GetTimeout returns the parsed task timeout override (0 if unset)
+func (o Options) GetTimeout() (time.Duration, error) {
+	if o.Timeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(o.Timeout)
+}
+
+// GetStepTimeout returns the parsed step timeout override (0 if unset)
+func (o Options) GetStepTimeout() (time.Duration, error) {
+	if o.StepTimeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(o.StepTimeout)
+}
+
+// GetSoftTimeout returns the parsed soft timeout (0 if unset)
+func (o Options) GetSoftTimeout() (time.Duration, error) {
+	if o.SoftTimeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(o.SoftTimeout)
+}
+
+// GetHardTimeout returns the parsed hard timeout (0 if unset)
+func (o Options) GetHardTimeout() (time.Duration, error) {
+	if o.HardTimeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(o.HardTimeout)
+}
+
+// MatchesPathRegex reports whether a file path matches path_regex. An unset
+// regex matches everything; an invalid one matches nothing.
+func (o Options) MatchesPathRegex(filePath string) bool {
+	if o.PathRegex == "" {
+		return true
+	}
+	re := o.pathRegex
+	if re == nil {
+		var err error
+		if re, err = regexp.Compile(o.PathRegex); err != nil {
+			return false
+		}
+	}
+	return re.MatchString(filepath.ToSlash(filePath))
+}
+
+// PendingLimit returns how many pending tasks the workflow may have before the
+// watcher waits to queue more: max_pending_tasks if set, otherwise the global
+// limit. 0 means no limit.
+func (o Options) PendingLimit(global int) int {
+	if o.MaxPendingTasks != nil {
+		return *o.MaxPendingTasks
+	}
+	return global
+}
+
+// GetMinSize returns the parsed minimum file size in bytes (0 if unset)
+func (o Options) GetMinSize() (int64, error) {
+	if o.MinSize == "" {
+		return 0, nil
+	}
+	return ParseByteSize(o.MinSize)
+}
+
+// GetExpectedSize returns the parsed expected output size in bytes (0 if unset)
+func (o Options) GetExpectedSize() (int64, error) {
+	if o.ExpectedSize == "" {
+		return 0, nil
+	}
+	return ParseByteSize(o.ExpectedSize)
+}
+
+// VerifiesOutput reports whether the output is checked after the steps
+// succeed, either explicitly or because an expected size or hash is set
+func (o Options) VerifiesOutput() bool {
+	return o.VerifyOutput || o.ExpectedSize != "" || o.ExpectedHash != ""
+}
+
+// GetMaxSize returns the parsed maximum file size in bytes (0 if unset)
+func (o Options) GetMaxSize() (int64, error) {
+	if o.MaxSize == "" {
+		return 0, nil
+	}
+	return ParseByteSize(o.MaxSize)
+}
+
+// SizeInRange reports whether a file of the given size lies within min_size
+// and max_size. Unset or invalid limits don't restrict anything.
+func (o Options) SizeInRange(size int64) bool {
+	if minSize, err := o.GetMinSize(); err == nil && minSize > 0 && size < minSize {
+		return false
+	}
+	if maxSize, err := o.GetMaxSize(); err == nil && maxSize > 0 && size > maxSize {
+		return false
+	}
+	return true
+}
+
+// byteSizeUnits maps size suffixes to their multipliers. KB, MB, ... are
+// binary units, the same as KiB, MiB, ...
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+	"T":   1 << 40,
+	"TB":  1 << 40,
+	"TIB": 1 << 40,
+}
+
+// ParseByteSize parses a byte size such as "512", "100KB", "1.5GB" or "10 MiB"
+func ParseByteSize(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+	i := 0
+	for i < len(trimmed) && (trimmed[i] >= '0' && trimmed[i] <= '9' || trimmed[i] == '.') {
+		i++
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(trimmed[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	multiplier, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(trimmed[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+	}
+	size := value * float64(multiplier)
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return int64(size), nil
+}
+
+// RetryPolicy re-runs a failing step. The wait before attempt n+1 is backoff * n.
+type RetryPolicy struct {
+	MaxAttempts int    `yaml:"max_attempts"`  // Total attempts including the first (0 or 1 = no retries)
+	Backoff     string `yaml:"backoff"`       // e.g. "5s"
+	OnExitCodes []int  `yaml:"on_exit_codes"` // Exit codes worth retrying; empty means any failure
+}
+
+// Attempts returns the total number of attempts, at least 1
+func (r RetryPolicy) Attempts() int {
+	if r.MaxAttempts < 1 {
+		return 1
+	}
+	return r.MaxAttempts
+}
+
+// GetBackoff returns the parsed backoff (0 if unset)
+func (r RetryPolicy) GetBackoff() (time.Duration, error) {
+	if r.Backoff == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(r.Backoff)
+}
+
+// ShouldRetry reports whether a step that exited with exitCode is retried.
+// Success and the workflow control codes 100 and 101 never are.
+func (r RetryPolicy) ShouldRetry(exitCode int) bool {
+	if exitCode == 0 || exitCode == 100 || exitCode == 101 {
+		return false
+	}
+	if len(r.OnExitCodes) == 0 {
+		return true
+	}
+	for _, code := range r.OnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPendingTTL returns the parsed pending task TTL (0 if unset)
+func (o Options) GetPendingTTL() (time.Duration, error) {
+	if o.PendingTTL == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(o.PendingTTL)
+}
+
+// Concurrency is the number of tasks of a workflow that may run at once.
+// In YAML, 0 or "auto" means one per CPU, capped by the configured maximum.
+// Leaving it out is 0 here, meaning only the executor pool limits the workflow.
+type Concurrency int
+
+// maxAutoConcurrency caps "auto" concurrency
+var maxAutoConcurrency = 16
+
+// SetMaxConcurrency sets the cap applied when concurrency is "auto"
+func SetMaxConcurrency(n int) {
+	if n > 0 {
+		maxAutoConcurrency = n
+	}
+}
+
+// AutoConcurrency returns the concurrency "auto" resolves to
+func AutoConcurrency() int {
+	return min(runtime.NumCPU(), maxAutoConcurrency)
+}
+
+// UnmarshalYAML resolves "auto" and 0 to AutoConcurrency
+func (c *Concurrency) UnmarshalYAML(value *yaml.Node) error {
+	if value.Value == "auto" {
+		*c = Concurrency(AutoConcurrency())
+		return nil
+	}
+
+	var n int
+	if err := value.Decode(&n); err != nil {
+		return fmt.Errorf("concurrency must be a number or \"auto\"")
+	}
+	if n == 0 {
+		n = AutoConcurrency()
+	}
+	*c = Concurrency(n)
+	return nil
 }
 
 // Variables available for substitution
 type Variables struct {
 	InputPath  string
 	OutputPath string
+	OutputDir  string
+	OutputTmp  string // Where steps write with options.atomic_output; the output path otherwise
 	FileName   string
 	FileDir    string
 	FileBase   string
 	FileExt    string
+	Meta       map[string]string // ${{ meta.* }} values from options.metadata_command
+	Matrix     map[string]string // ${{ matrix.* }} values of the running step expansion
+
+	// ${{ steps.<step>.outputs.* }} values written by earlier plugin steps, by step name
+	StepOutputs map[string]map[string]string
+
+	// ${{ steps.<step>.status }} of earlier steps: completed, failed or skipped
+	StepStatuses map[string]string
 }
 
-// Parse parses a YAML workflow definition
-func Parse(yamlContent string) (*WorkflowDef, error) {
-	var workflow WorkflowDef
-	if err := yaml.Unmarshal([]byte(yamlContent), &workflow); err != nil {
-		return nil, fmt.Errorf("failed to parse workflow YAML: %w", err)
+// Clone returns a copy of v with its own maps, which stays unchanged while
+// the steps keep recording results in v
+func (v Variables) Clone() Variables {
+	clone := v
+	clone.Meta = maps.Clone(v.Meta)
+	clone.Matrix = maps.Clone(v.Matrix)
+	clone.StepStatuses = maps.Clone(v.StepStatuses)
+	if v.StepOutputs != nil {
+		clone.StepOutputs = make(map[string]map[string]string, len(v.StepOutputs))
+		for name, outputs := range v.StepOutputs {
+			clone.StepOutputs[name] = maps.Clone(outputs)
+		}
 	}
+	return clone
+}
 
-	// Set defaults
-	if workflow.Options.Concurrency == 0 {
-		workflow.Options.Concurrency = 4
-	}
-	if workflow.Options.FileGlob == "" {
-		workflow.Options.FileGlob = "*"
+// Parse parses a YAML workflow definition
+func Parse(yamlContent string) (*WorkflowDef, error) {
+	workflow, err := Decode(yamlContent)
+	if err != nil {
+		return nil, err
 	}
-	workflow.Options.SkipOnNoChange = true // Default to true
 
 	// Validate required fields
 	if workflow.Name == "" {
@@ -88,27 +512,249 @@ func Parse(yamlContent string) (*WorkflowDef, error) {
 		return nil, fmt.Errorf("at least one step is required")
 	}
 
+	return workflow, nil
+}
+
+// DocumentError is a problem with one document of a multi-document YAML stream
+type DocumentError struct {
+	Index int    `json:"index"` // Position of the document, starting at 0
+	Name  string `json:"name,omitempty"`
+	Err   error  `json:"-"`
+}
+
+func (e DocumentError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("document %d: %v", e.Index+1, e.Err)
+	}
+	return fmt.Sprintf("document %d (%s): %v", e.Index+1, e.Name, e.Err)
+}
+
+func (e DocumentError) Unwrap() error {
+	return e.Err
+}
+
+// DocumentErrors lists every document of a stream that failed to parse or validate
+type DocumentErrors []DocumentError
+
+func (e DocumentErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// documentSeparator matches a "---" line, optionally followed by a comment
+var documentSeparator = regexp.MustCompile(`^---\s*(#.*)?$`)
+
+// SplitDocuments splits a YAML stream on "---" lines and drops documents that
+// hold nothing but whitespace and comments
+func SplitDocuments(yamlContent string) []string {
+	var docs []string
+	var current []string
+	flush := func() {
+		doc := strings.Join(current, "\n")
+		current = nil
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(doc), &value); err == nil && value == nil {
+			return
+		}
+		docs = append(docs, strings.TrimSpace(doc)+"\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(yamlContent, "\r\n", "\n"), "\n") {
+		if documentSeparator.MatchString(strings.TrimRight(line, " \t")) {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return docs
+}
+
+// ParseMulti parses and validates every workflow in a YAML stream whose
+// documents are separated by "---". Each definition's Source holds the text
+// of its document. If any document fails, or two share a name, the error is
+// a DocumentErrors covering all of them and the failed documents' entries
+// are nil.
+func ParseMulti(yamlContent string) ([]*WorkflowDef, error) {
+	docs := SplitDocuments(yamlContent)
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no workflows found")
+	}
+
+	var errs DocumentErrors
+	defs := make([]*WorkflowDef, len(docs))
+	seen := make(map[string]int)
+	for i, doc := range docs {
+		def, err := Parse(doc)
+		if err == nil {
+			err = Validate(def)
+		}
+		if err != nil {
+			docErr := DocumentError{Index: i, Err: err}
+			if def != nil {
+				docErr.Name = def.Name
+			}
+			errs = append(errs, docErr)
+			continue
+		}
+		if first, ok := seen[def.Name]; ok {
+			errs = append(errs, DocumentError{Index: i, Name: def.Name, Err: fmt.Errorf("duplicate workflow name, also used by document %d", first+1)})
+			continue
+		}
+		seen[def.Name] = i
+		def.Source = doc
+		defs[i] = def
+	}
+	if len(errs) > 0 {
+		return defs, errs
+	}
+	return defs, nil
+}
+
+// Decode unmarshals workflow YAML and applies defaults without checking
+// required fields, so ValidateAll can report every problem at once
+func Decode(yamlContent string) (*WorkflowDef, error) {
+	var workflow WorkflowDef
+	if err := yaml.Unmarshal([]byte(yamlContent), &workflow); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow YAML: %w", err)
+	}
+
+	// Set defaults. An omitted concurrency stays 0: no per-workflow cap.
+	if workflow.Options.FileGlob == "" {
+		workflow.Options.FileGlob = "*"
+	}
+	if workflow.Options.PathRegex != "" {
+		// Invalid expressions are reported by Validate
+		workflow.Options.pathRegex, _ = regexp.Compile(workflow.Options.PathRegex)
+	}
+	workflow.Options.SkipOnNoChange = true // Default to true
+
 	return &workflow, nil
 }
 
-// SubstituteVariables replaces variables in a string
-func SubstituteVariables(template string, vars Variables) string {
-	result := template
+// matrixKeyPattern matches keys usable as ${{ matrix.<key> }}
+var matrixKeyPattern = regexp.MustCompile(`^\w+$`)
+
+// stepRefPattern matches step names usable in ${{ steps.<name>.* }}
+var stepRefPattern = regexp.MustCompile(`^\w[\w-]*$`)
+
+// stepTemplates joins the fields of a workflow where variables are
+// substituted, for finding the steps they refer to
+func stepTemplates(workflow *WorkflowDef) string {
+	var b strings.Builder
+	for _, step := range append(append([]Step{}, workflow.Steps...), workflow.Validate.Steps...) {
+		for _, field := range []string{step.Run, step.If, step.Condition, step.WorkingDir, step.Stdin, step.StdinFile} {
+			b.WriteString(field)
+			b.WriteByte('\n')
+		}
+		for _, values := range []map[string]string{step.Env, step.With} {
+			for _, value := range values {
+				b.WriteString(value)
+				b.WriteByte('\n')
+			}
+		}
+	}
+	b.WriteString(workflow.Options.OnTimeout)
+	return b.String()
+}
 
+// variablePattern matches ${{ name }} with any whitespace inside the braces.
+// Segments after the first may contain dashes, as step names do.
+// A leading $ escapes the placeholder: $${{ name }} yields the literal ${{ name }}.
+var variablePattern = regexp.MustCompile(`\$?\$\{\{\s*(\w+(?:\.\w[\w-]*)*)\s*\}\}`)
+
+// SubstituteVariables replaces variables in a string. Unknown variables are left as is.
+func SubstituteVariables(template string, vars Variables) string {
 	replacements := map[string]string{
-		"${{ input_path }}":  vars.InputPath,
-		"${{ output_path }}": vars.OutputPath,
-		"${{ file_name }}":   vars.FileName,
-		"${{ file_dir }}":    vars.FileDir,
-		"${{ file_base }}":   vars.FileBase,
-		"${{ file_ext }}":    vars.FileExt,
+		"input_path":  vars.InputPath,
+		"output_path": vars.OutputPath,
+		"output_dir":  vars.OutputDir,
+		"output_tmp":  vars.OutputTmp,
+		"file_name":   vars.FileName,
+		"file_dir":    vars.FileDir,
+		"file_base":   vars.FileBase,
+		"file_ext":    vars.FileExt,
 	}
 
-	for placeholder, value := range replacements {
-		result = strings.ReplaceAll(result, placeholder, value)
+	return variablePattern.ReplaceAllStringFunc(template, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := variablePattern.FindStringSubmatch(match)[1]
+		value, ok := replacements[name]
+		if key, isMeta := strings.CutPrefix(name, "meta."); isMeta {
+			value, ok = vars.Meta[key]
+		}
+		if key, isMatrix := strings.CutPrefix(name, "matrix."); isMatrix {
+			value, ok = vars.Matrix[key]
+		}
+		if ref, isStep := strings.CutPrefix(name, "steps."); isStep {
+			if stepName, output, found := strings.Cut(ref, ".outputs."); found {
+				value, ok = vars.StepOutputs[stepName][output]
+			} else if stepName, found := strings.CutSuffix(ref, ".status"); found {
+				value, ok = vars.StepStatuses[stepName]
+			}
+		}
+		if !ok {
+			return match
+		}
+		return value
+	})
+}
+
+// hostEnvPattern matches ${NAME} references to the host environment. Workflow
+// variables (${{ name }}) never match since a name can't start with a brace.
+// A leading $ escapes the reference: $${NAME} yields the literal ${NAME}.
+var hostEnvPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandHostEnv replaces ${NAME} in an env value with the variable of the
+// server's own environment. Variables that are not set expand to an empty
+// string and are returned in missing.
+func ExpandHostEnv(value string) (expanded string, missing []string) {
+	return ExpandEnv(value, os.LookupEnv)
+}
+
+// ExpandEnv replaces ${NAME} in value with what lookup returns for NAME.
+// Variables lookup doesn't find expand to an empty string and are returned in
+// missing.
+func ExpandEnv(value string, lookup func(name string) (string, bool)) (expanded string, missing []string) {
+	expanded = hostEnvPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+		name := hostEnvPattern.FindStringSubmatch(match)[1]
+		envValue, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return envValue
+	})
+	return expanded, missing
+}
+
+// EnvReferences returns the names of the ${NAME} references in value
+func EnvReferences(value string) []string {
+	var names []string
+	for _, match := range hostEnvPattern.FindAllStringSubmatch(value, -1) {
+		if !strings.HasPrefix(match[0], "$$") {
+			names = append(names, match[1])
+		}
 	}
+	return names
+}
 
-	return result
+// PreviewCommand resolves a step command for a sample input file the same way
+// the executor would, substituting plugin inputs when provided. It returns the
+// resolved command and the variables used.
+func PreviewCommand(command, sampleInput, outputPath string, inputs map[string]string) (string, Variables) {
+	vars := GetVariables(sampleInput, outputPath)
+	result := SubstituteVariables(command, vars)
+	if len(inputs) > 0 {
+		result = SubstitutePluginInputs(result, inputs)
+	}
+	return result, vars
 }
 
 // GenerateOutputPath generates the output path based on conversion config
@@ -137,10 +783,149 @@ func GenerateOutputPath(inputPath string, convertConfig ConvertConfig, outputDir
 	return filepath.Join(dir, nameWithoutExt+newExt)
 }
 
+// GenerateOutputPaths generates one output path per conversion target. Without
+// targets it returns the single path of GenerateOutputPath.
+func GenerateOutputPaths(inputPath string, convertConfig ConvertConfig, outputDirPattern string) []string {
+	if len(convertConfig.Targets) == 0 {
+		return []string{GenerateOutputPath(inputPath, convertConfig, outputDirPattern)}
+	}
+
+	paths := make([]string, 0, len(convertConfig.Targets))
+	for _, target := range convertConfig.Targets {
+		to := target.To
+		if to == "" {
+			to = convertConfig.To
+		}
+		pattern := target.OutputDirPattern
+		if pattern == "" {
+			pattern = outputDirPattern
+		}
+
+		path := GenerateOutputPath(inputPath, ConvertConfig{From: convertConfig.From, To: to}, pattern)
+		if target.Suffix != "" {
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + target.Suffix + ext
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Output directory pattern tokens, expanded when a task is created
+const (
+	OutputTokenYear     = "{year}"
+	OutputTokenMonth    = "{month}"
+	OutputTokenDay      = "{day}"
+	OutputTokenHash     = "{hash}"     // First 8 characters of the input's content hash
+	OutputTokenWorkflow = "{workflow}" // Workflow name from the YAML
+)
+
+var outputTokens = []string{OutputTokenYear, OutputTokenMonth, OutputTokenDay, OutputTokenHash, OutputTokenWorkflow}
+
+// OutputTokens holds the values substituted for output directory pattern tokens
+type OutputTokens struct {
+	Time     time.Time
+	Hash     string
+	Workflow string
+}
+
+// ExpandOutputTokens replaces the tokens in an output directory pattern. It
+// runs before GenerateOutputPath, so a pattern such as "./{year}/{month}"
+// is still relative to the input's directory.
+func ExpandOutputTokens(pattern string, tokens OutputTokens) string {
+	if !strings.Contains(pattern, "{") {
+		return pattern
+	}
+	hash := tokens.Hash
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	return strings.NewReplacer(
+		OutputTokenYear, tokens.Time.Format("2006"),
+		OutputTokenMonth, tokens.Time.Format("01"),
+		OutputTokenDay, tokens.Time.Format("02"),
+		OutputTokenHash, hash,
+		OutputTokenWorkflow, tokens.Workflow,
+	).Replace(pattern)
+}
+
+// UsesOutputToken reports whether the workflow's output_dir_pattern or any
+// target's pattern contains token, or any token at all if token is empty
+func (def *WorkflowDef) UsesOutputToken(token string) bool {
+	patterns := []string{def.Options.OutputDirPattern}
+	for _, target := range def.Convert.Targets {
+		patterns = append(patterns, target.OutputDirPattern)
+	}
+	for _, pattern := range patterns {
+		if token != "" {
+			if strings.Contains(pattern, token) {
+				return true
+			}
+			continue
+		}
+		for _, t := range outputTokens {
+			if strings.Contains(pattern, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TaskOutputPaths generates the output paths of a task created at createdAt
+// for an input with the given content hash, expanding output directory
+// pattern tokens first
+func (def *WorkflowDef) TaskOutputPaths(inputPath string, createdAt time.Time, hash string) []string {
+	tokens := OutputTokens{Time: createdAt, Hash: hash, Workflow: def.Name}
+	convert := def.Convert
+	if len(convert.Targets) > 0 {
+		convert.Targets = make([]ConvertTarget, len(def.Convert.Targets))
+		for i, target := range def.Convert.Targets {
+			target.OutputDirPattern = ExpandOutputTokens(target.OutputDirPattern, tokens)
+			convert.Targets[i] = target
+		}
+	}
+	return GenerateOutputPaths(inputPath, convert, ExpandOutputTokens(def.Options.OutputDirPattern, tokens))
+}
+
+// OutputCollision is an output path that several distinct inputs map to
+type OutputCollision struct {
+	OutputPath string   `json:"output_path"`
+	Inputs     []string `json:"inputs"`
+}
+
+// FindOutputCollisions generates the output paths of the given inputs and
+// reports every output that more than one input would write, such as
+// photo.jpg and photo.jpeg both converting to photo.png.
+func FindOutputCollisions(inputPaths []string, convertConfig ConvertConfig, outputDirPattern string) []OutputCollision {
+	sources := make(map[string][]string)
+	var order []string
+	for _, inputPath := range inputPaths {
+		for _, outputPath := range GenerateOutputPaths(inputPath, convertConfig, outputDirPattern) {
+			if _, seen := sources[outputPath]; !seen {
+				order = append(order, outputPath)
+			}
+			sources[outputPath] = append(sources[outputPath], inputPath)
+		}
+	}
+
+	var collisions []OutputCollision
+	for _, outputPath := range order {
+		if inputs := sources[outputPath]; len(inputs) > 1 {
+			collisions = append(collisions, OutputCollision{OutputPath: outputPath, Inputs: inputs})
+		}
+	}
+	return collisions
+}
+
 // MatchesFileGlob checks if a file matches the glob pattern
-// Supports multiple patterns separated by comma or pipe, e.g., "*.jpg,*.jpeg" or "*.jpg|*.jpeg"
+// Supports multiple patterns separated by comma or pipe, e.g., "*.jpg,*.jpeg" or "*.jpg|*.jpeg".
+// Patterns without a slash match the file name. Patterns with a slash, such as
+// "src/**/*.jpg", match the whole filePath, which callers pass relative to the
+// scan root; "**" matches any number of directories.
 func MatchesFileGlob(filePath, globPattern string) bool {
 	fileName := filepath.Base(filePath)
+	slashPath := filepath.ToSlash(filePath)
 
 	// Split pattern by comma or pipe to support multiple patterns
 	patterns := strings.FieldsFunc(globPattern, func(r rune) bool {
@@ -154,7 +939,13 @@ func MatchesFileGlob(filePath, globPattern string) bool {
 
 	// Check if file matches any of the patterns
 	for _, pattern := range patterns {
-		pattern = strings.TrimSpace(pattern)
+		pattern = filepath.ToSlash(strings.TrimSpace(pattern))
+		if strings.Contains(pattern, "/") || strings.Contains(pattern, "**") {
+			if matchGlobSegments(strings.Split(pattern, "/"), strings.Split(slashPath, "/")) {
+				return true
+			}
+			continue
+		}
 		matched, err := filepath.Match(pattern, fileName)
 		if err != nil {
 			continue
@@ -167,6 +958,29 @@ func MatchesFileGlob(filePath, globPattern string) bool {
 	return false
 }
 
+// matchGlobSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments
+func matchGlobSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchGlobSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
 // MatchesIgnorePattern checks if a file path matches any of the ignore patterns
 // Supports:
 // - Glob patterns for filenames (e.g., "*.tmp", "*.log")
@@ -236,6 +1050,69 @@ func MatchesIgnorePattern(filePath string, ignorePatterns []string) bool {
 	return false
 }
 
+// IsEmpty reports whether the match has no filters
+func (m StepMatch) IsEmpty() bool {
+	return len(m.Extensions) == 0 && len(m.ContentTypes) == 0
+}
+
+// Matches checks whether an input path and its detected content type satisfy the match
+func (m StepMatch) Matches(inputPath, contentType string) bool {
+	if m.IsEmpty() {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(inputPath))
+	for _, want := range m.Extensions {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if !strings.HasPrefix(want, ".") {
+			want = "." + want
+		}
+		if ext == want {
+			return true
+		}
+	}
+
+	for _, want := range m.ContentTypes {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if strings.HasSuffix(want, "/*") {
+			if strings.HasPrefix(contentType, strings.TrimSuffix(want, "*")) {
+				return true
+			}
+		} else if contentType == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DetectContentType detects the MIME type of a file by sniffing its content,
+// falling back to the file extension when the content is not recognized
+func DetectContentType(filePath string) string {
+	contentType := "application/octet-stream"
+
+	if f, err := os.Open(filePath); err == nil {
+		buf := make([]byte, 512)
+		n, _ := f.Read(buf)
+		f.Close()
+		if n > 0 {
+			contentType = http.DetectContentType(buf[:n])
+		}
+	}
+
+	if contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(filePath)); byExt != "" {
+			contentType = byExt
+		}
+	}
+
+	// Strip parameters such as "; charset=utf-8"
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
 // GetVariables extracts variables from a file path
 func GetVariables(inputPath, outputPath string) Variables {
 	fileName := filepath.Base(inputPath)
@@ -246,6 +1123,8 @@ func GetVariables(inputPath, outputPath string) Variables {
 	return Variables{
 		InputPath:  inputPath,
 		OutputPath: outputPath,
+		OutputDir:  filepath.Dir(outputPath),
+		OutputTmp:  outputPath,
 		FileName:   fileName,
 		FileDir:    fileDir,
 		FileBase:   fileBase,
@@ -253,38 +1132,239 @@ func GetVariables(inputPath, outputPath string) Variables {
 	}
 }
 
-// Validate validates a workflow definition
+// ValidationError describes a problem with one field of a workflow definition
+type ValidationError struct {
+	Field   string `json:"field"` // Path of the field, e.g. "steps[1].run"; empty for the whole document
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + " " + e.Message
+}
+
+// validName matches allowed workflow names (alphanumeric, hyphens, underscores)
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Validate validates a workflow definition and returns the first problem found
 func Validate(workflow *WorkflowDef) error {
-	if workflow.Name == "" {
-		return fmt.Errorf("workflow name is required")
+	if errs := ValidateAll(workflow); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// ValidateAll validates a workflow definition and returns every problem found,
+// each tied to the path of the offending field
+func ValidateAll(workflow *WorkflowDef) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
 	}
 
-	// Validate name format (alphanumeric, hyphens, underscores)
-	validName := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if !validName.MatchString(workflow.Name) {
-		return fmt.Errorf("workflow name must contain only alphanumeric characters, hyphens, and underscores")
+	if workflow.Name == "" {
+		add("name", "is required")
+	} else if !validName.MatchString(workflow.Name) {
+		add("name", "must contain only alphanumeric characters, hyphens, and underscores")
 	}
 
 	if len(workflow.On.Paths) == 0 {
-		return fmt.Errorf("at least one path must be specified")
+		add("on.paths", "must specify at least one path")
+	}
+	if workflow.On.Schedule != "" {
+		if _, err := ParseSchedule(workflow.On.Schedule); err != nil {
+			add("on.schedule", "is invalid: %v", err)
+		}
 	}
 
 	if len(workflow.Steps) == 0 {
-		return fmt.Errorf("at least one step is required")
+		add("steps", "must contain at least one step")
 	}
 
 	for i, step := range workflow.Steps {
 		if step.Name == "" {
-			return fmt.Errorf("step %d: name is required", i+1)
+			add(fmt.Sprintf("steps[%d].name", i), "is required")
+		}
+		if step.Run == "" && step.Uses == "" {
+			add(fmt.Sprintf("steps[%d].run", i), "is required (or a uses plugin reference)")
+		}
+		if step.If != "" && step.Condition != "" {
+			add(fmt.Sprintf("steps[%d].if", i), "cannot be combined with condition")
+		}
+		if step.Shell != "" {
+			if step.Uses != "" {
+				add(fmt.Sprintf("steps[%d].shell", i), "cannot be combined with uses; plugin steps select their own shell")
+			} else if _, err := ShellArgs(step.Shell); err != nil {
+				add(fmt.Sprintf("steps[%d].shell", i), "%v", err)
+			}
+		}
+		if step.WorkingDir != "" && step.Uses != "" {
+			add(fmt.Sprintf("steps[%d].working_dir", i), "cannot be combined with uses; plugin steps set their own working_dir")
+		}
+		if step.Stdin != "" || step.StdinFile != "" {
+			switch {
+			case step.Stdin != "" && step.StdinFile != "":
+				add(fmt.Sprintf("steps[%d].stdin", i), "cannot be combined with stdin_file")
+			case step.Uses != "":
+				add(fmt.Sprintf("steps[%d].stdin", i), "cannot be combined with uses; plugin steps set their own stdin")
+			case workflow.Options.PTY:
+				add(fmt.Sprintf("steps[%d].stdin", i), "cannot be used with options.pty, which attaches stdin to the terminal")
+			}
+		}
+		matrixKeys := make([]string, 0, len(step.Matrix))
+		for key := range step.Matrix {
+			matrixKeys = append(matrixKeys, key)
+		}
+		sort.Strings(matrixKeys)
+		for _, key := range matrixKeys {
+			if !matrixKeyPattern.MatchString(key) {
+				add(fmt.Sprintf("steps[%d].matrix", i), "key %q is invalid: use letters, digits and underscores", key)
+			}
+			if len(step.Matrix[key]) == 0 {
+				add(fmt.Sprintf("steps[%d].matrix.%s", i, key), "must list at least one value")
+			}
+		}
+	}
+
+	// ${{ steps.<name>... }} only resolves names made of word characters and
+	// dashes, and a matrix step has one result per expansion rather than one
+	// under its name, so references to other steps could never match
+	templates := stepTemplates(workflow)
+	for i, step := range workflow.Steps {
+		if step.Name == "" {
+			continue
+		}
+		names := []string{step.Name}
+		for _, expanded := range ExpandMatrix(step) {
+			if expanded.Name != step.Name {
+				names = append(names, expanded.Name)
+			}
+		}
+		for _, name := range names {
+			if !strings.Contains(templates, "steps."+name+".") {
+				continue
+			}
+			if len(step.Matrix) > 0 {
+				add(fmt.Sprintf("steps[%d].matrix", i), "steps.%s cannot be referenced: a matrix step has a separate result per expansion", name)
+			} else if !stepRefPattern.MatchString(name) {
+				add(fmt.Sprintf("steps[%d].name", i), "%q cannot be referenced as steps.<name>: use only letters, digits, underscores and hyphens", name)
+			}
+			break
+		}
+	}
+
+	for i, step := range workflow.Validate.Steps {
+		if step.Name == "" {
+			add(fmt.Sprintf("validate.steps[%d].name", i), "is required")
 		}
 		if step.Run == "" {
-			return fmt.Errorf("step %d (%s): run command is required", i+1, step.Name)
+			add(fmt.Sprintf("validate.steps[%d].run", i), "is required")
 		}
 	}
 
-	if workflow.Options.Concurrency < 1 {
-		return fmt.Errorf("concurrency must be at least 1")
+	for i, output := range workflow.Outputs {
+		if strings.TrimSpace(output) == "" {
+			add(fmt.Sprintf("outputs[%d]", i), "must not be empty")
+		}
 	}
 
-	return nil
+	// Targets that resolve to the same output would overwrite each other
+	seenTargets := make(map[ConvertTarget]bool)
+	for i, target := range workflow.Convert.Targets {
+		if target.To == "" {
+			target.To = workflow.Convert.To
+		}
+		if target.OutputDirPattern == "" {
+			target.OutputDirPattern = workflow.Options.OutputDirPattern
+		}
+		if seenTargets[target] {
+			add(fmt.Sprintf("convert.targets[%d]", i), "duplicates an earlier target")
+		}
+		seenTargets[target] = true
+	}
+
+	if timeout, err := workflow.Options.GetTimeout(); err != nil || (workflow.Options.Timeout != "" && timeout <= 0) {
+		add("options.timeout", "%q is invalid: must be a positive duration", workflow.Options.Timeout)
+	}
+	if stepTimeout, err := workflow.Options.GetStepTimeout(); err != nil || (workflow.Options.StepTimeout != "" && stepTimeout <= 0) {
+		add("options.step_timeout", "%q is invalid: must be a positive duration", workflow.Options.StepTimeout)
+	}
+
+	softTimeout, softErr := workflow.Options.GetSoftTimeout()
+	if softErr != nil || softTimeout < 0 {
+		add("options.soft_timeout", "%q is invalid", workflow.Options.SoftTimeout)
+	}
+	hardTimeout, hardErr := workflow.Options.GetHardTimeout()
+	if hardErr != nil || hardTimeout < 0 {
+		add("options.hard_timeout", "%q is invalid", workflow.Options.HardTimeout)
+	}
+	if softErr == nil && hardErr == nil && softTimeout > 0 && hardTimeout > 0 && softTimeout >= hardTimeout {
+		add("options.soft_timeout", "must be shorter than hard_timeout")
+	}
+	if pendingTTL, err := workflow.Options.GetPendingTTL(); err != nil || pendingTTL < 0 {
+		add("options.pending_ttl", "%q is invalid", workflow.Options.PendingTTL)
+	}
+	minSize, minErr := workflow.Options.GetMinSize()
+	if minErr != nil {
+		add("options.min_size", "%q is invalid", workflow.Options.MinSize)
+	}
+	maxSize, maxErr := workflow.Options.GetMaxSize()
+	if maxErr != nil {
+		add("options.max_size", "%q is invalid", workflow.Options.MaxSize)
+	}
+	if minErr == nil && maxErr == nil && maxSize > 0 && minSize > maxSize {
+		add("options.min_size", "must not be larger than max_size")
+	}
+	if expectedSize, err := workflow.Options.GetExpectedSize(); err != nil || workflow.Options.ExpectedSize != "" && expectedSize == 0 {
+		add("options.expected_size", "%q is invalid", workflow.Options.ExpectedSize)
+	}
+	if hash := workflow.Options.ExpectedHash; hash != "" {
+		if _, err := hex.DecodeString(hash); err != nil || filehash.AlgorithmOf(hash) == "" {
+			add("options.expected_hash", "must be a hex md5, sha1 or sha256 digest")
+		}
+	}
+	if workflow.Options.MaxPendingTasks != nil && *workflow.Options.MaxPendingTasks < 0 {
+		add("options.max_pending_tasks", "must not be negative")
+	}
+	if workflow.Options.PathRegex != "" {
+		if _, err := regexp.Compile(workflow.Options.PathRegex); err != nil {
+			add("options.path_regex", "%q is invalid: %v", workflow.Options.PathRegex, err)
+		}
+	}
+	if workflow.Options.Shell != "" {
+		if _, err := ShellArgs(workflow.Options.Shell); err != nil {
+			add("options.shell", "%v", err)
+		}
+	}
+	switch workflow.Options.OnDelete {
+	case "", OnDeleteIgnore, OnDeleteDeleteOutput, OnDeleteMarkStale:
+	default:
+		add("options.on_delete", "%q is invalid (expected ignore, delete_output or mark_stale)", workflow.Options.OnDelete)
+	}
+	if workflow.Options.Retry.MaxAttempts < 0 {
+		add("options.retry.max_attempts", "must not be negative")
+	}
+	if backoff, err := workflow.Options.Retry.GetBackoff(); err != nil || backoff < 0 {
+		add("options.retry.backoff", "%q is invalid", workflow.Options.Retry.Backoff)
+	}
+
+	// 0 means "auto" and is resolved when the YAML is parsed
+	if workflow.Options.Concurrency < 0 {
+		add("options.concurrency", "must be at least 1, or 0 for auto")
+	}
+
+	for i, name := range workflow.Secrets {
+		if !envKeyPattern.MatchString(name) {
+			add(fmt.Sprintf("secrets[%d]", i), "%q is not a valid variable name", name)
+		}
+	}
+	for key := range workflow.Notifications.Context {
+		if key == "" {
+			add("notifications.context", "keys must not be empty")
+		}
+	}
+
+	return errs
 }
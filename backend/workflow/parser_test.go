@@ -1,8 +1,14 @@
 package workflow
 
 import (
+	"errors"
+	"os"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestParse(t *testing.T) {
@@ -54,7 +60,79 @@ options:
 	}
 }
 
+func TestParseMulti(t *testing.T) {
+	stream := "# leading comment\n---\nname: first\non:\n  paths: [./in]\nsteps:\n  - name: a\n    run: \"true\"\n--- # second\nname: second\non:\n  paths: [./in]\nsteps:\n  - name: b\n    run: \"true\"\n---\n"
+	defs, err := ParseMulti(stream)
+	if err != nil {
+		t.Fatalf("ParseMulti failed: %v", err)
+	}
+	if len(defs) != 2 || defs[0].Name != "first" || defs[1].Name != "second" {
+		t.Fatalf("Expected workflows first and second, got %+v", defs)
+	}
+	if !strings.HasPrefix(defs[1].Source, "name: second\n") || strings.Contains(defs[1].Source, "first") {
+		t.Errorf("Expected Source to hold only the second document, got %q", defs[1].Source)
+	}
+
+	bad := stream + "name: bad name\non:\n  paths: [./in]\nsteps:\n  - name: c\n    run: \"true\"\n---\nname: first\non:\n  paths: [./in]\nsteps:\n  - name: d\n    run: \"true\"\n"
+	defs, err = ParseMulti(bad)
+	var docErrs DocumentErrors
+	if !errors.As(err, &docErrs) {
+		t.Fatalf("Expected DocumentErrors, got %v", err)
+	}
+	if len(docErrs) != 2 || docErrs[0].Index != 2 || docErrs[1].Index != 3 || !strings.Contains(docErrs[1].Error(), "duplicate") {
+		t.Errorf("Expected errors for documents 2 and 3, got %v", docErrs)
+	}
+	if len(defs) != 4 || defs[0] == nil || defs[2] != nil || defs[3] != nil {
+		t.Errorf("Expected only valid documents to be returned, got %+v", defs)
+	}
+
+	if _, err := ParseMulti("# nothing here\n---\n"); err == nil {
+		t.Error("Expected an error for a stream without workflows")
+	}
+}
+
+func TestAutoConcurrency(t *testing.T) {
+	for _, value := range []string{"auto", "0"} {
+		t.Run(value, func(t *testing.T) {
+			def, err := Parse(`
+name: test
+on:
+  paths:
+    - ./test
+steps:
+  - name: step1
+    run: echo test
+options:
+  concurrency: ` + value + `
+`)
+			if err != nil {
+				t.Fatalf("Failed to parse workflow: %v", err)
+			}
+			expected := min(runtime.NumCPU(), maxAutoConcurrency)
+			if int(def.Options.Concurrency) != expected {
+				t.Errorf("Expected concurrency %d, got %d", expected, def.Options.Concurrency)
+			}
+			if err := Validate(def); err != nil {
+				t.Errorf("Expected auto concurrency to validate, got: %v", err)
+			}
+		})
+	}
+
+	if _, err := Parse("name: test\non:\n  paths: [./test]\nsteps:\n  - name: s\n    run: echo\noptions:\n  concurrency: lots\n"); err == nil {
+		t.Error("Expected error for non-numeric concurrency")
+	}
+
+	def, err := Parse("name: test\non:\n  paths: [./test]\nsteps:\n  - name: s\n    run: echo\n")
+	if err != nil {
+		t.Fatalf("Failed to parse workflow: %v", err)
+	}
+	if def.Options.Concurrency != 0 {
+		t.Errorf("Expected no concurrency cap when omitted, got %d", def.Options.Concurrency)
+	}
+}
+
 func TestValidate(t *testing.T) {
+	negative := -1
 	tests := []struct {
 		name        string
 		workflow    *WorkflowDef
@@ -107,6 +185,48 @@ func TestValidate(t *testing.T) {
 			},
 			shouldError: true,
 		},
+		{
+			name: "plugin step",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On: OnConfig{
+					Paths: []string{"./test"},
+				},
+				Steps: []Step{
+					{Name: "step1", Uses: "image-optimizer@1.0.0"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: false,
+		},
+		{
+			name: "plugin step with working_dir",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On: OnConfig{
+					Paths: []string{"./test"},
+				},
+				Steps: []Step{
+					{Name: "step1", Uses: "image-optimizer@1.0.0", WorkingDir: "/tmp"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "step without run or uses",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On: OnConfig{
+					Paths: []string{"./test"},
+				},
+				Steps: []Step{
+					{Name: "step1"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
 		{
 			name: "no steps",
 			workflow: &WorkflowDef{
@@ -117,6 +237,183 @@ func TestValidate(t *testing.T) {
 			},
 			shouldError: true,
 		},
+		{
+			name: "duplicate convert targets",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On: OnConfig{
+					Paths: []string{"./test"},
+				},
+				Convert: ConvertConfig{
+					To:      "png",
+					Targets: []ConvertTarget{{To: "png"}, {}},
+				},
+				Steps: []Step{
+					{Name: "step1", Run: "echo test"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "timeout overrides",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo test"}},
+				Options: Options{Concurrency: 1, Timeout: "2h", StepTimeout: "30m"},
+			},
+			shouldError: false,
+		},
+		{
+			name: "non-positive timeout",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo test"}},
+				Options: Options{Concurrency: 1, Timeout: "0s"},
+			},
+			shouldError: true,
+		},
+		{
+			name: "invalid step timeout",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo test"}},
+				Options: Options{Concurrency: 1, StepTimeout: "soon"},
+			},
+			shouldError: true,
+		},
+		{
+			name: "min size above max size",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo test"}},
+				Options: Options{Concurrency: 1, MinSize: "10MB", MaxSize: "1MB"},
+			},
+			shouldError: true,
+		},
+		{
+			name: "empty matrix values",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo ${{ matrix.size }}", Matrix: map[string][]string{"size": {}}}},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "reference to a step name with spaces",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On:   OnConfig{Paths: []string{"./test"}},
+				Steps: []Step{
+					{Name: "probe file", Run: "echo probe"},
+					{Name: "step2", Run: "echo done", If: "${{ steps.probe file.status == 'completed' }}"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "unreferenced step name with spaces",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "probe file", Run: "echo probe"}},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: false,
+		},
+		{
+			name: "reference to a matrix step",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On:   OnConfig{Paths: []string{"./test"}},
+				Steps: []Step{
+					{Name: "encode", Run: "echo ${{ matrix.size }}", Matrix: map[string][]string{"size": {"720p"}}},
+					{Name: "report", Run: "echo ${{ steps.encode.status }}"},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "reference to a matrix expansion",
+			workflow: &WorkflowDef{
+				Name: "test",
+				On:   OnConfig{Paths: []string{"./test"}},
+				Steps: []Step{
+					{Name: "encode", Run: "echo ${{ matrix.size }}", Matrix: map[string][]string{"size": {"720p"}}},
+					{Name: "report", Run: "echo done", Env: map[string]string{"RESULT": "${{ steps.encode (size=720p).status }}"}},
+				},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "negative max pending tasks",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo hi"}},
+				Options: Options{Concurrency: 1, MaxPendingTasks: &negative},
+			},
+			shouldError: true,
+		},
+		{
+			name: "invalid path regex",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo hi"}},
+				Options: Options{Concurrency: 1, PathRegex: "([a-z"},
+			},
+			shouldError: true,
+		},
+		{
+			name: "invalid expected hash",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo hi"}},
+				Options: Options{Concurrency: 1, ExpectedSize: "5", ExpectedHash: "not-a-digest"},
+			},
+			shouldError: true,
+		},
+		{
+			name: "stdin with pty",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "cat", Stdin: "hello"}},
+				Options: Options{Concurrency: 1, PTY: true},
+			},
+			shouldError: true,
+		},
+		{
+			name: "unknown shell",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo hi", Shell: "fish"}},
+				Options: Options{Concurrency: 1},
+			},
+			shouldError: true,
+		},
+		{
+			name: "unknown workflow shell",
+			workflow: &WorkflowDef{
+				Name:    "test",
+				On:      OnConfig{Paths: []string{"./test"}},
+				Steps:   []Step{{Name: "step1", Run: "echo hi"}},
+				Options: Options{Concurrency: 1, Shell: "fish"},
+			},
+			shouldError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,6 +459,26 @@ func TestSubstituteVariables(t *testing.T) {
 			template: "Dir: ${{ file_dir }}",
 			expected: "Dir: /path/to",
 		},
+		{
+			template: "convert ${{input_path}} ${{output_path}}",
+			expected: "convert /path/to/input.jpg /path/to/output.png",
+		},
+		{
+			template: "File: ${{   file_name\t}}",
+			expected: "File: input.jpg",
+		},
+		{
+			template: "echo '$${{ input_path }}' ${{ file_ext }}",
+			expected: "echo '${{ input_path }}' .jpg",
+		},
+		{
+			template: "echo $${{file_name}}",
+			expected: "echo ${{file_name}}",
+		},
+		{
+			template: "Unknown: ${{ not_a_variable }}",
+			expected: "Unknown: ${{ not_a_variable }}",
+		},
 	}
 
 	for _, tt := range tests {
@@ -214,6 +531,129 @@ func TestGenerateOutputPath(t *testing.T) {
 	}
 }
 
+func TestGenerateOutputPathsWithTargets(t *testing.T) {
+	def, err := Parse(`
+name: multi-output
+on:
+  paths:
+    - /input
+convert:
+  from: jpg
+  targets:
+    - to: png
+    - to: webp
+      output_dir_pattern: /web
+    - to: webp
+      output_dir_pattern: /web
+      suffix: -thumb
+steps:
+  - name: convert
+    run: convert ${{ input_path }} ${{ output_path }}
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse workflow: %v", err)
+	}
+
+	paths := GenerateOutputPaths("/input/photo.jpg", def.Convert, def.Options.OutputDirPattern)
+	expected := []string{"/input/photo.png", "/web/photo.webp", "/web/photo-thumb.webp"}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %d output paths, got %v", len(expected), paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Target %d: expected %s, got %s", i, expected[i], paths[i])
+		}
+	}
+
+	// Without targets the single convert.to output is used
+	single := GenerateOutputPaths("/input/photo.jpg", ConvertConfig{From: "jpg", To: "png"}, "")
+	if len(single) != 1 || single[0] != "/input/photo.png" {
+		t.Errorf("Expected single output /input/photo.png, got %v", single)
+	}
+}
+
+func TestTaskOutputPathsExpandTokens(t *testing.T) {
+	def := &WorkflowDef{
+		Name:    "photos",
+		Convert: ConvertConfig{From: "jpg", Targets: []ConvertTarget{{To: "png"}, {To: "webp", OutputDirPattern: "/web/{workflow}/{hash}"}}},
+		Options: Options{OutputDirPattern: "../{year}/{month}/{day}"},
+	}
+	createdAt := time.Date(2024, 3, 7, 12, 0, 0, 0, time.UTC)
+
+	paths := def.TaskOutputPaths("/input/photo.jpg", createdAt, "0123456789abcdef")
+	expected := []string{"/2024/03/07/photo.png", "/web/photos/01234567/photo.webp"}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+	if !def.UsesOutputToken("") || !def.UsesOutputToken(OutputTokenHash) {
+		t.Error("Expected the workflow to report its output tokens")
+	}
+
+	// Patterns without tokens are left alone
+	if got := ExpandOutputTokens("../out", OutputTokens{Time: createdAt}); got != "../out" {
+		t.Errorf("Expected ../out, got %s", got)
+	}
+}
+
+func TestFindOutputCollisions(t *testing.T) {
+	inputs := []string{
+		"/input/a/photo.jpg",
+		"/input/a/photo.jpeg",
+		"/input/a/other.jpg",
+		"/input/b/photo.jpg",
+	}
+
+	// Converting both .jpg and .jpeg to .png in place collides within a directory
+	collisions := FindOutputCollisions(inputs, ConvertConfig{To: "png"}, "")
+	if len(collisions) != 1 {
+		t.Fatalf("Expected 1 collision, got %v", collisions)
+	}
+	if collisions[0].OutputPath != "/input/a/photo.png" || len(collisions[0].Inputs) != 2 {
+		t.Errorf("Unexpected collision: %+v", collisions[0])
+	}
+
+	// A flat output directory also merges same-named files from different directories
+	collisions = FindOutputCollisions(inputs, ConvertConfig{To: "png"}, "/output")
+	if len(collisions) != 1 || collisions[0].OutputPath != "/output/photo.png" || len(collisions[0].Inputs) != 3 {
+		t.Errorf("Expected 3 inputs colliding on /output/photo.png, got %+v", collisions)
+	}
+
+	// Keeping the extension in a per-directory output folder keeps outputs distinct
+	if collisions := FindOutputCollisions(inputs, ConvertConfig{}, "./out"); len(collisions) != 0 {
+		t.Errorf("Expected no collisions, got %+v", collisions)
+	}
+}
+
+func TestExpandHostEnv(t *testing.T) {
+	t.Setenv("FILEACTION_TEST_KEY", "secret")
+	os.Unsetenv("FILEACTION_TEST_MISSING")
+
+	tests := []struct {
+		value    string
+		expected string
+		missing  []string
+	}{
+		{value: "${FILEACTION_TEST_KEY}", expected: "secret"},
+		{value: "Bearer ${FILEACTION_TEST_KEY}!", expected: "Bearer secret!"},
+		{value: "${FILEACTION_TEST_MISSING}", expected: "", missing: []string{"FILEACTION_TEST_MISSING"}},
+		{value: "$${FILEACTION_TEST_KEY}", expected: "${FILEACTION_TEST_KEY}"},
+		{value: "${{ input_path }}", expected: "${{ input_path }}"},
+		{value: "$FILEACTION_TEST_KEY", expected: "$FILEACTION_TEST_KEY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, missing := ExpandHostEnv(tt.value)
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+			if strings.Join(missing, ",") != strings.Join(tt.missing, ",") {
+				t.Errorf("Expected missing %v, got %v", tt.missing, missing)
+			}
+		})
+	}
+}
+
 func TestMatchesFileGlob(t *testing.T) {
 	tests := []struct {
 		filePath string
@@ -225,10 +665,22 @@ func TestMatchesFileGlob(t *testing.T) {
 		{"/path/to/file.jpeg", "*.jp*g", true},
 		{"/path/to/test.txt", "test.*", true},
 		{"/path/to/other.txt", "test.*", false},
+		{"/path/to/file.jpeg", "*.jpg,*.jpeg", true},
+		{"/path/to/file.gif", "*.jpg|*.png", false},
+		{"image.png", "**/*.png", true},
+		{"deep/nested/dir/image.png", "**/*.png", true},
+		{"deep/nested/dir/image.jpg", "**/*.png", false},
+		{"a/b/note.txt", "a/**/b/*.txt", true},
+		{"a/x/y/b/note.txt", "a/**/b/*.txt", true},
+		{"a/x/b/c/note.txt", "a/**/b/*.txt", false},
+		{"c/b/note.txt", "a/**/b/*.txt", false},
+		{"src/photos/cat.jpg", "src/**/*.jpg,*.png", true},
+		{"other/cat.png", "src/**/*.jpg,*.png", true},
+		{"other/cat.jpg", "src/**/*.jpg|*.png", false},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.filePath, func(t *testing.T) {
+		t.Run(tt.pattern+" "+tt.filePath, func(t *testing.T) {
 			result := MatchesFileGlob(tt.filePath, tt.pattern)
 			if result != tt.expected {
 				t.Errorf("Expected %v for pattern '%s' on file '%s', got %v",
@@ -238,6 +690,46 @@ func TestMatchesFileGlob(t *testing.T) {
 	}
 }
 
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"100KB", 100 << 10, false},
+		{"10MB", 10 << 20, false},
+		{"10 mb", 10 << 20, false},
+		{"1.5GB", 3 << 29, false},
+		{"2MiB", 2 << 20, false},
+		{"1TB", 1 << 40, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"10XB", 0, true},
+		{"-5MB", 0, true},
+		{"1.2.3KB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			size, err := ParseByteSize(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error for %q, got %d", tt.input, size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error for %q: %v", tt.input, err)
+			}
+			if size != tt.expected {
+				t.Errorf("Expected %d for %q, got %d", tt.expected, tt.input, size)
+			}
+		})
+	}
+}
+
 func TestMatchesIgnorePattern(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -329,3 +821,229 @@ func TestMatchesIgnorePattern(t *testing.T) {
 		})
 	}
 }
+
+func TestPreviewCommand(t *testing.T) {
+	inputs := map[string]string{"quality": "85"}
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"${{ input_path }}", "/data/in/photo.jpg"},
+		{"${{ output_path }}", "/data/out/photo.heic"},
+		{"${{ output_dir }}", "/data/out"},
+		{"${{ file_name }}", "photo.jpg"},
+		{"${{ file_dir }}", "/data/in"},
+		{"${{ file_base }}", "photo"},
+		{"${{ file_ext }}", ".jpg"},
+		{"convert -q ${{ inputs.quality }} ${{ input_path }}", "convert -q 85 /data/in/photo.jpg"},
+		{"echo ${{ inputs.missing }}", "echo ${{ inputs.missing }}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			result, vars := PreviewCommand(tt.command, "/data/in/photo.jpg", "/data/out/photo.heic", inputs)
+			if result != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, result)
+			}
+			if vars.FileBase != "photo" {
+				t.Errorf("Expected file_base 'photo', got '%s'", vars.FileBase)
+			}
+		})
+	}
+}
+
+func TestPinPluginReferences(t *testing.T) {
+	yamlContent := `steps:
+  - name: a
+    uses: optimizer
+  - name: b
+    uses: "optimizer"
+  - name: c
+    uses: optimizer@1.0.0
+  - name: d
+    uses: other # not installed
+  - name: e
+    run: echo uses: optimizer
+`
+	result, pinned := PinPluginReferences(yamlContent, map[string]string{"optimizer": "2.1.0"})
+
+	expected := `steps:
+  - name: a
+    uses: optimizer@2.1.0
+  - name: b
+    uses: "optimizer@2.1.0"
+  - name: c
+    uses: optimizer@1.0.0
+  - name: d
+    uses: other # not installed
+  - name: e
+    run: echo uses: optimizer
+`
+	if pinned != 2 {
+		t.Errorf("Expected 2 references pinned, got %d", pinned)
+	}
+	if result != expected {
+		t.Errorf("Unexpected result:\n%s", result)
+	}
+}
+
+func TestPreparePluginInputsTypes(t *testing.T) {
+	pluginDef, err := ParsePlugin(`name: optimizer
+version: 1.0.0
+inputs:
+  quality:
+    type: number
+    default: 80
+  strip:
+    type: boolean
+    default: true
+  format:
+    type: enum
+    options: [jpeg, webp]
+    default: jpeg
+  label:
+    type: string
+steps:
+  - name: run
+    run: "true"
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse plugin: %v", err)
+	}
+
+	inputs, err := PreparePluginInputs(pluginDef, map[string]string{"quality": " 75 ", "strip": "No", "format": "webp", "label": "x"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{"quality": "75", "strip": "false", "format": "webp", "label": "x"}
+	for name, want := range expected {
+		if inputs[name] != want {
+			t.Errorf("Expected %s=%q, got %q", name, want, inputs[name])
+		}
+	}
+
+	if inputs, _ := PreparePluginInputs(pluginDef, nil); inputs["strip"] != "true" || inputs["quality"] != "80" {
+		t.Errorf("Expected defaults to pass type checks, got %v", inputs)
+	}
+
+	for _, bad := range []map[string]string{{"quality": "abc"}, {"strip": "maybe"}, {"format": "png"}} {
+		if _, err := PreparePluginInputs(pluginDef, bad); err == nil {
+			t.Errorf("Expected error for %v", bad)
+		}
+	}
+
+	if _, err := ParsePlugin("name: p\nversion: 1.0.0\ninputs:\n  mode:\n    type: enum\nsteps:\n  - name: run\n    run: \"true\"\n"); err == nil {
+		t.Error("Expected error for enum input without options")
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	outputs, err := ParseOutputs("width=1920\n\nempty=\nnote=a=b\nsummary<<END\nline one\nline two\nEND\nwidth=1280\n")
+	if err != nil {
+		t.Fatalf("ParseOutputs failed: %v", err)
+	}
+	expected := map[string]string{"width": "1280", "empty": "", "note": "a=b", "summary": "line one\nline two"}
+	if len(outputs) != len(expected) {
+		t.Errorf("Expected %d outputs, got %v", len(expected), outputs)
+	}
+	for name, value := range expected {
+		if outputs[name] != value {
+			t.Errorf("Expected %s=%q, got %q", name, value, outputs[name])
+		}
+	}
+
+	for _, content := range []string{"no separator\n", "summary<<END\nnever closed\n"} {
+		if _, err := ParseOutputs(content); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestParseEnvFile(t *testing.T) {
+	env, err := ParseEnvFile(`# shared settings
+QUALITY=80
+export PRESET = slow
+EMPTY=
+NOTE=keep # this is a comment
+HASH=a#b
+SINGLE='literal \n $HOME'
+DOUBLE="two\nlines \"quoted\"" # trailing comment
+`)
+	if err != nil {
+		t.Fatalf("ParseEnvFile failed: %v", err)
+	}
+	expected := map[string]string{
+		"QUALITY": "80",
+		"PRESET":  "slow",
+		"EMPTY":   "",
+		"NOTE":    "keep",
+		"HASH":    "a#b",
+		"SINGLE":  `literal \n $HOME`,
+		"DOUBLE":  "two\nlines \"quoted\"",
+	}
+	if len(env) != len(expected) {
+		t.Errorf("Expected %d variables, got %v", len(expected), env)
+	}
+	for key, value := range expected {
+		if env[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, env[key])
+		}
+	}
+
+	for _, content := range []string{"no equals sign\n", "1BAD=x\n", "OPEN=\"never closed\n", "EXTRA='a' b\n"} {
+		if _, err := ParseEnvFile(content); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+
+	var def WorkflowDef
+	if err := yaml.Unmarshal([]byte("env_file:\n  path: /missing.env\n  optional: true\n"), &def); err != nil {
+		t.Fatalf("Failed to decode env_file mapping: %v", err)
+	}
+	if env, err := def.EnvFile.Load(); err != nil || env != nil {
+		t.Errorf("Expected a missing optional file to load nothing, got %v, %v", env, err)
+	}
+	def = WorkflowDef{}
+	if err := yaml.Unmarshal([]byte("env_file: /missing.env\n"), &def); err != nil {
+		t.Fatalf("Failed to decode env_file path: %v", err)
+	}
+	if _, err := def.EnvFile.Load(); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected a missing required file to fail, got %v", err)
+	}
+}
+
+func TestEvaluateCondition(t *testing.T) {
+	vars := Variables{
+		FileExt:      ".png",
+		FileName:     "John's photo.png",
+		StepStatuses: map[string]string{"encode": "failed"},
+	}
+	inputs := map[string]string{"enabled": "true", "mode": "fast"}
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"", true},
+		{"${{ file_ext == '.png' }}", true},
+		{"${{ file_ext == '.jpg' }}", false},
+		{"${{ file_ext != '.jpg' }}", true},
+		{"${{ file_ext == '.jpg' || file_ext == '.png' }}", true},
+		{"${{ file_ext == '.png' && inputs.mode == 'slow' }}", false},
+		{"${{ file_ext == '.png' && inputs.mode == 'fast' }}", true},
+		{"${{ file_ext == '.jpg' && inputs.mode == 'fast' || inputs.enabled }}", true},
+		{"${{ file_name == 'a || b' }}", false},
+		{"${{ steps.encode.status == 'failed' }}", true},
+		{"${{ inputs.mode }} == 'fast'", true},
+		{"${{ file_name }} == 'x.png'", false},
+		{"${{ inputs.enabled }}", true},
+		{"false", false},
+	}
+
+	for _, tt := range tests {
+		if got := EvaluateCondition(tt.condition, inputs, vars); got != tt.expected {
+			t.Errorf("EvaluateCondition(%q) = %v, expected %v", tt.condition, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package workflow
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// absolutePathPattern finds absolute paths in a shell command: a / at the start
+// of a word, after an = or : (e.g. --out=/x, host:/x) or inside quotes
+var absolutePathPattern = regexp.MustCompile(`(?:^|[\s=:'"(])(/[^\s'"();|&<>]*)`)
+
+// auditExemptPaths are always allowed: the standard streams and tool locations
+var auditExemptPaths = []string{"/dev/null", "/dev/stdin", "/dev/stdout", "/dev/stderr"}
+var auditExemptDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin", "/usr/local/bin"}
+
+// PathsOutsideRoots returns the absolute paths a command references that are
+// not inside any of the allowed roots. It is a heuristic: paths assembled at
+// runtime (variables, globs, relative paths) are not seen.
+func PathsOutsideRoots(command string, roots []string) []string {
+	var outside []string
+	seen := make(map[string]bool)
+	for _, match := range absolutePathPattern.FindAllStringSubmatch(command, -1) {
+		path := filepath.Clean(match[1])
+		if seen[path] || pathAllowed(path, roots) {
+			continue
+		}
+		seen[path] = true
+		outside = append(outside, path)
+	}
+	return outside
+}
+
+// PathInsideRoots reports whether a file path, made absolute, is exempt or
+// inside one of the allowed roots
+func PathInsideRoots(path string, roots []string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return pathAllowed(abs, roots)
+}
+
+// pathAllowed reports whether path is exempt or inside one of the roots
+func pathAllowed(path string, roots []string) bool {
+	for _, exempt := range auditExemptPaths {
+		if path == exempt {
+			return true
+		}
+	}
+	for _, dir := range auditExemptDirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,14 @@
 package workflow
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,6 +23,60 @@ type PluginDef struct {
 	Steps        []PluginStep           `yaml:"steps"`
 	Tags         []string               `yaml:"tags"`
 	Env          map[string]string      `yaml:"env"`
+	EnvFile      EnvFile                `yaml:"env_file"` // Dotenv file loaded under env
+
+	// Outputs are values the steps write to $FILEACTION_OUTPUT; later workflow
+	// steps read them as ${{ steps.<step name>.outputs.<name> }}
+	Outputs map[string]PluginOutput `yaml:"outputs"`
+}
+
+// PluginOutput describes a value a plugin passes back to the workflow
+type PluginOutput struct {
+	Description string `yaml:"description"`
+}
+
+// OutputFileEnv is the environment variable naming the file plugin steps
+// write their outputs to
+const OutputFileEnv = "FILEACTION_OUTPUT"
+
+// outputNamePattern matches names usable in ${{ steps.<step>.outputs.<name> }}
+var outputNamePattern = regexp.MustCompile(`^\w[\w-]*$`)
+
+// ParseOutputs parses an outputs file. Each line is name=value. A multi-line
+// value is written as name<<DELIMITER, the value's lines, then DELIMITER on a
+// line of its own. Blank lines are ignored; a name written twice keeps the
+// last value.
+func ParseOutputs(content string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if name, delimiter, ok := strings.Cut(line, "<<"); ok && !strings.Contains(name, "=") {
+			var value []string
+			closed := false
+			for i++; i < len(lines); i++ {
+				if lines[i] == delimiter {
+					closed = true
+					break
+				}
+				value = append(value, lines[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("output %q: missing closing delimiter %q", name, delimiter)
+			}
+			outputs[name] = strings.Join(value, "\n")
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid output line %q (expected name=value)", line)
+		}
+		outputs[name] = value
+	}
+	return outputs, nil
 }
 
 // PluginInput represents an input parameter for a plugin
@@ -27,15 +85,41 @@ type PluginInput struct {
 	Default     interface{} `yaml:"default"`
 	Required    bool        `yaml:"required"`
 	Description string      `yaml:"description"`
+	Options     []string    `yaml:"options"` // Allowed values of an enum input
 }
 
+// Plugin input types
+const (
+	InputTypeString  = "string"
+	InputTypeNumber  = "number"
+	InputTypeBoolean = "boolean"
+	InputTypeEnum    = "enum"
+)
+
 // PluginStep represents a step within a plugin
 type PluginStep struct {
-	Name      string            `yaml:"name"`
-	Run       string            `yaml:"run"`
-	Condition string            `yaml:"condition"`
-	Timeout   int               `yaml:"timeout"` // In seconds
-	Env       map[string]string `yaml:"env"`
+	Name       string            `yaml:"name"`
+	Run        string            `yaml:"run"`
+	Condition  string            `yaml:"condition"`
+	Timeout    int               `yaml:"timeout"`     // In seconds
+	Retry      int               `yaml:"retry"`       // Additional attempts after a failed run
+	RetryDelay string            `yaml:"retry_delay"` // Delay between attempts (e.g. "5s")
+	WorkingDir string            `yaml:"working_dir"` // Directory the command runs in; inputs and variables are substituted
+	Shell      string            `yaml:"shell"`       // Interpreter for run, as for workflow steps
+	Stdin      string            `yaml:"stdin"`       // Text piped to standard input; inputs and variables are substituted
+	StdinFile  string            `yaml:"stdin_file"`  // File streamed to standard input, e.g. "${{ input_path }}"
+	Env        map[string]string `yaml:"env"`
+
+	// ContinueOnError runs the plugin's next step even if this one fails
+	ContinueOnError bool `yaml:"continue_on_error"`
+}
+
+// GetRetryDelay returns the parsed delay between retry attempts
+func (s PluginStep) GetRetryDelay() (time.Duration, error) {
+	if s.RetryDelay == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s.RetryDelay)
 }
 
 // ParsePlugin parses a plugin YAML definition
@@ -55,6 +139,36 @@ func ParsePlugin(yamlContent string) (*PluginDef, error) {
 	if len(plugin.Steps) == 0 {
 		return nil, fmt.Errorf("plugin must have at least one step")
 	}
+	for name, input := range plugin.Inputs {
+		switch input.Type {
+		case "", InputTypeString, InputTypeNumber, InputTypeBoolean:
+		case InputTypeEnum:
+			if len(input.Options) == 0 {
+				return nil, fmt.Errorf("input '%s': enum type requires options", name)
+			}
+		default:
+			return nil, fmt.Errorf("input '%s': unknown type %q (expected string, number, boolean or enum)", name, input.Type)
+		}
+	}
+	for name := range plugin.Outputs {
+		if !outputNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("output '%s': use letters, digits, '_' and '-'", name)
+		}
+	}
+	for i, step := range plugin.Steps {
+		if step.Retry < 0 {
+			return nil, fmt.Errorf("step %d (%s): retry must not be negative", i+1, step.Name)
+		}
+		if delay, err := step.GetRetryDelay(); err != nil || delay < 0 {
+			return nil, fmt.Errorf("step %d (%s): invalid retry_delay %q", i+1, step.Name, step.RetryDelay)
+		}
+		if _, err := ShellArgs(step.Shell); err != nil {
+			return nil, fmt.Errorf("step %d (%s): %w", i+1, step.Name, err)
+		}
+		if step.Stdin != "" && step.StdinFile != "" {
+			return nil, fmt.Errorf("step %d (%s): stdin cannot be combined with stdin_file", i+1, step.Name)
+		}
+	}
 
 	return &plugin, nil
 }
@@ -80,31 +194,142 @@ func ParsePluginReference(uses string) (string, string, error) {
 	return "", "", fmt.Errorf("invalid plugin reference format: %s", uses)
 }
 
+// UnpinnedPlugins returns the plugins that workflow steps reference without a
+// version, in order of first use. These follow the plugin's current version.
+func UnpinnedPlugins(def *WorkflowDef) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, step := range def.Steps {
+		if step.Uses == "" {
+			continue
+		}
+		name, version, err := ParsePluginReference(step.Uses)
+		if err != nil || version != "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// pluginUsesLine matches a bare "uses: name" line, optionally quoted and commented
+var pluginUsesLine = regexp.MustCompile(`(?m)^(\s*(?:-\s+)?uses:\s*)(["']?)([\w.-]+)(["']?)([ \t]*(?:#.*)?)$`)
+
+// PinPluginReferences rewrites bare "uses: name" references in workflow YAML to
+// "uses: name@version" for every plugin in versions. The rest of the document,
+// including formatting and comments, is left as is. It returns the new YAML and
+// the number of references pinned.
+func PinPluginReferences(yamlContent string, versions map[string]string) (string, int) {
+	pinned := 0
+	result := pluginUsesLine.ReplaceAllStringFunc(yamlContent, func(line string) string {
+		m := pluginUsesLine.FindStringSubmatch(line)
+		version, ok := versions[m[3]]
+		if !ok || m[2] != m[4] {
+			return line
+		}
+		pinned++
+		return m[1] + m[2] + m[3] + "@" + version + m[4] + m[5]
+	})
+	return result, pinned
+}
+
+// dependencyVersionFlags lists the version flag of tools that don't accept --version
+var dependencyVersionFlags = map[string]string{
+	"ffmpeg":  "-version",
+	"ffprobe": "-version",
+	"ffplay":  "-version",
+	"java":    "-version",
+	"go":      "version",
+}
+
+// dependencyVersionTimeout bounds how long a tool may take to print its version
+const dependencyVersionTimeout = 5 * time.Second
+
+// versionPattern finds the first dotted version number in a tool's output
+var versionPattern = regexp.MustCompile(`\d+\.\d+(?:\.\d+)?`)
+
 // ValidatePluginDependencies checks if all required dependencies are available
-func ValidatePluginDependencies(dependencies []string) error {
+// and satisfy their version constraints ("command>=version"). Tools whose
+// version can't be determined are reported as warnings instead of failing.
+func ValidatePluginDependencies(dependencies []string) (warnings []string, err error) {
+	if missing := MissingDependencies(dependencies); len(missing) > 0 {
+		return nil, fmt.Errorf("required dependency '%s' not found", missing[0])
+	}
+
 	for _, dep := range dependencies {
-		// Parse dependency (format: "command" or "command>=version")
-		parts := strings.FieldsFunc(dep, func(r rune) bool {
-			return r == '>' || r == '<' || r == '='
-		})
+		command, constraint := splitDependency(dep)
+		if command == "" || constraint == "" {
+			continue
+		}
+
+		c, err := ParseVersionConstraint(constraint)
+		if err != nil {
+			return warnings, fmt.Errorf("dependency '%s': %w", dep, err)
+		}
 
-		if len(parts) == 0 {
+		version, raw, err := commandVersion(command)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not determine version of %s (%v), skipping %s check", command, err, constraint))
 			continue
 		}
+		if !c.Matches(version) {
+			return warnings, fmt.Errorf("%s %s does not satisfy %s", command, raw, constraint)
+		}
+	}
+	return warnings, nil
+}
 
-		command := strings.TrimSpace(parts[0])
+// MissingDependencies returns the commands of dependencies that are not on the PATH
+func MissingDependencies(dependencies []string) []string {
+	var missing []string
+	for _, dep := range dependencies {
+		command, _ := splitDependency(dep)
+		if command == "" {
+			continue
+		}
 
 		// Check if command exists
-		_, err := exec.LookPath(command)
-		if err != nil {
-			return fmt.Errorf("required dependency '%s' not found", command)
+		if _, err := exec.LookPath(command); err != nil {
+			missing = append(missing, command)
 		}
+	}
+	return missing
+}
+
+// splitDependency splits a dependency ("command" or "command>=version") into
+// the command and its version constraint
+func splitDependency(dep string) (command, constraint string) {
+	dep = strings.TrimSpace(dep)
+	if i := strings.IndexAny(dep, "<>="); i >= 0 {
+		return strings.TrimSpace(dep[:i]), strings.TrimSpace(dep[i:])
+	}
+	return dep, ""
+}
 
-		// TODO: Implement version checking if version constraint is specified
-		// For now, we just check if the command exists
+// commandVersion runs a tool with its version flag and parses the first
+// version number in the output. It also returns the version as printed.
+func commandVersion(command string) (Version, string, error) {
+	flag, ok := dependencyVersionFlags[command]
+	if !ok {
+		flag = "--version"
 	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), dependencyVersionTimeout)
+	defer cancel()
+	// Some tools print their version to stderr or exit non-zero, so only the
+	// output is looked at
+	output, _ := exec.CommandContext(ctx, command, flag).CombinedOutput()
+
+	raw := versionPattern.FindString(string(output))
+	if raw == "" {
+		return Version{}, "", fmt.Errorf("no version in output of %s %s", command, flag)
+	}
+	version, err := ParseVersion(raw)
+	if err != nil {
+		return Version{}, "", err
+	}
+	return version, raw, nil
 }
 
 // SubstitutePluginInputs replaces input placeholders in a command string
@@ -130,7 +355,8 @@ func SubstitutePluginInputs(command string, inputs map[string]string) string {
 	return result
 }
 
-// PreparePluginInputs merges default values with provided values
+// PreparePluginInputs merges default values with provided values and checks
+// each declared input against its type
 func PreparePluginInputs(pluginDef *PluginDef, providedInputs map[string]string) (map[string]string, error) {
 	result := make(map[string]string)
 
@@ -146,20 +372,66 @@ func PreparePluginInputs(pluginDef *PluginDef, providedInputs map[string]string)
 		result[name] = value
 	}
 
-	// Validate required inputs
-	for name, input := range pluginDef.Inputs {
-		if input.Required {
-			if _, ok := result[name]; !ok {
+	// Validate required inputs and coerce values to their declared type
+	names := make([]string, 0, len(pluginDef.Inputs))
+	for name := range pluginDef.Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		input := pluginDef.Inputs[name]
+		value, ok := result[name]
+		if !ok {
+			if input.Required {
 				return nil, fmt.Errorf("required input '%s' is missing", name)
 			}
+			continue
 		}
+		coerced, err := coerceInput(input, value)
+		if err != nil {
+			return nil, fmt.Errorf("input '%s': %w", name, err)
+		}
+		result[name] = coerced
 	}
 
 	return result, nil
 }
 
-// EvaluateCondition evaluates a simple condition expression
-// Supports basic comparisons like: "${{ inputs.enabled == 'true' }}"
+// coerceInput checks a value against the declared input type and returns it
+// in normalized form; booleans become "true" or "false"
+func coerceInput(input PluginInput, value string) (string, error) {
+	switch input.Type {
+	case InputTypeNumber:
+		trimmed := strings.TrimSpace(value)
+		if _, err := strconv.ParseFloat(trimmed, 64); err != nil {
+			return "", fmt.Errorf("expected a number, got %q", value)
+		}
+		return trimmed, nil
+	case InputTypeBoolean:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "yes", "1":
+			return "true", nil
+		case "false", "no", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("expected a boolean (true/false, yes/no, 1/0), got %q", value)
+	case InputTypeEnum:
+		for _, option := range input.Options {
+			if value == option {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", value, strings.Join(input.Options, ", "))
+	}
+	return value, nil
+}
+
+// EvaluateCondition evaluates a condition expression such as
+// "${{ file_ext == '.jpg' || file_ext == '.jpeg' }}". Terms compare two
+// operands with == or !=, or test one operand for truth (anything but empty,
+// "false" and "0"); they combine with && and ||, where && binds tighter.
+// Operands are quoted literals or variable names (file_ext, inputs.quality,
+// steps.encode.status, ...); ${{ }} references are substituted first.
 func EvaluateCondition(condition string, inputs map[string]string, vars Variables) bool {
 	if condition == "" {
 		return true // No condition means always execute
@@ -177,29 +449,73 @@ func EvaluateCondition(condition string, inputs map[string]string, vars Variable
 	condition = strings.TrimSuffix(condition, "}}")
 	condition = strings.TrimSpace(condition)
 
-	// Simple equality check
-	if strings.Contains(condition, "==") {
-		parts := strings.Split(condition, "==")
-		if len(parts) == 2 {
-			left := strings.TrimSpace(strings.Trim(parts[0], "'\""))
-			right := strings.TrimSpace(strings.Trim(parts[1], "'\""))
-			return left == right
+	for _, alternative := range splitOutsideQuotes(condition, "||") {
+		matched := true
+		for _, term := range splitOutsideQuotes(alternative, "&&") {
+			if !evaluateTerm(term, inputs, vars) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
 		}
 	}
+	return false
+}
 
-	// Simple inequality check
-	if strings.Contains(condition, "!=") {
-		parts := strings.Split(condition, "!=")
-		if len(parts) == 2 {
-			left := strings.TrimSpace(strings.Trim(parts[0], "'\""))
-			right := strings.TrimSpace(strings.Trim(parts[1], "'\""))
-			return left != right
+// evaluateTerm evaluates a single comparison or truth test of a condition
+func evaluateTerm(term string, inputs map[string]string, vars Variables) bool {
+	for _, op := range []string{"==", "!="} {
+		if parts := splitOutsideQuotes(term, op); len(parts) == 2 {
+			equal := conditionOperand(parts[0], inputs, vars) == conditionOperand(parts[1], inputs, vars)
+			return equal == (op == "==")
 		}
 	}
 
 	// Boolean check (treat non-empty, non-false as true)
-	condition = strings.ToLower(condition)
-	return condition != "" && condition != "false" && condition != "0"
+	value := strings.ToLower(conditionOperand(term, inputs, vars))
+	return value != "" && value != "false" && value != "0"
+}
+
+// conditionOperand resolves an operand: quotes are removed from literals, and
+// a bare variable name is replaced by its value. Anything else, such as a
+// value substituted from ${{ }}, is used as is.
+func conditionOperand(operand string, inputs map[string]string, vars Variables) string {
+	operand = strings.TrimSpace(operand)
+	if len(operand) >= 2 && (operand[0] == '\'' || operand[0] == '"') && operand[len(operand)-1] == operand[0] {
+		return operand[1 : len(operand)-1]
+	}
+
+	reference := "${{ " + operand + " }}"
+	if value := SubstituteVariables(SubstitutePluginInputs(reference, inputs), vars); value != reference {
+		return value
+	}
+	return strings.Trim(operand, "'\"")
+}
+
+// splitOutsideQuotes splits s around sep, ignoring separators inside quoted
+// literals. A quote only starts a literal at the beginning of an operand, so
+// substituted values like "John's photo.jpg" don't hide what follows.
+func splitOutsideQuotes(s, sep string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case (s[i] == '\'' || s[i] == '"') && (i == 0 || strings.ContainsRune(" \t=!&|", rune(s[i-1]))):
+			quote = s[i]
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, s[start:])
 }
 
 // MergeEnvironment merges multiple environment variable maps
@@ -0,0 +1,203 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version represents a parsed semantic version
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	parts      int // number of numeric components present in the original string
+}
+
+// ParseVersion parses versions like "1", "1.2", "1.2.3", "v1.2.3" or "1.2.3-beta"
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" {
+		return v, fmt.Errorf("empty version")
+	}
+
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		if s[i] == '-' {
+			v.Prerelease = s[i+1:]
+			if j := strings.Index(v.Prerelease, "+"); j >= 0 {
+				v.Prerelease = v.Prerelease[:j]
+			}
+		}
+		s = s[:i]
+	}
+
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		return v, fmt.Errorf("invalid version: %s", s)
+	}
+	nums := make([]int, 3)
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version: %s", s)
+		}
+		nums[i] = n
+	}
+
+	v.Major, v.Minor, v.Patch = nums[0], nums[1], nums[2]
+	v.parts = len(fields)
+	return v, nil
+}
+
+// String returns the version formatted as major.minor.patch[-prerelease]
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than other.
+// A prerelease version sorts before the corresponding release.
+func (v Version) Compare(other Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	case v.Prerelease < other.Prerelease:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// VersionConstraint is a parsed version requirement such as "^1.2", "~1.2.3", ">=2" or "1.0"
+type VersionConstraint struct {
+	raw   string
+	op    string
+	base  Version
+	upper *Version // exclusive upper bound for caret, tilde and partial constraints
+}
+
+// ParseVersionConstraint parses a version constraint.
+// Supported forms: exact ("1.2.3"), prefix ("1.2" matches any 1.2.x),
+// caret ("^1.2.3"), tilde ("~1.2.3") and comparisons (">=", ">", "<=", "<", "=", "==").
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	raw := strings.TrimSpace(s)
+	c := &VersionConstraint{raw: raw}
+
+	rest := raw
+	for _, op := range []string{">=", "<=", "==", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(rest, op) {
+			c.op = op
+			rest = strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+
+	base, err := ParseVersion(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", raw, err)
+	}
+	c.base = base
+
+	switch c.op {
+	case "^":
+		upper := Version{Major: base.Major + 1}
+		if base.Major == 0 && base.parts > 1 {
+			upper = Version{Minor: base.Minor + 1}
+		}
+		c.upper = &upper
+	case "~":
+		upper := Version{Major: base.Major, Minor: base.Minor + 1}
+		if base.parts == 1 {
+			upper = Version{Major: base.Major + 1}
+		}
+		c.upper = &upper
+	case "", "=", "==":
+		// A partial version acts as a prefix: "1.2" matches 1.2.x
+		if base.parts == 1 {
+			c.upper = &Version{Major: base.Major + 1}
+		} else if base.parts == 2 {
+			c.upper = &Version{Major: base.Major, Minor: base.Minor + 1}
+		}
+	}
+
+	return c, nil
+}
+
+// String returns the original constraint text
+func (c *VersionConstraint) String() string {
+	return c.raw
+}
+
+// Matches checks whether a version satisfies the constraint
+func (c *VersionConstraint) Matches(v Version) bool {
+	cmp := v.Compare(c.base)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	}
+
+	if c.upper != nil {
+		return cmp >= 0 && v.Compare(*c.upper) < 0
+	}
+	return cmp == 0
+}
+
+// SelectVersion returns the highest available version satisfying the constraint.
+// An available version equal to the constraint string always matches, so
+// non-semver version labels keep resolving exactly.
+func SelectVersion(constraint string, available []string) (string, error) {
+	for _, candidate := range available {
+		if candidate == constraint {
+			return candidate, nil
+		}
+	}
+
+	c, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	best := ""
+	var bestVersion Version
+	for _, candidate := range available {
+		v, err := ParseVersion(candidate)
+		if err != nil || !c.Matches(v) {
+			continue
+		}
+		if best == "" || v.Compare(bestVersion) > 0 {
+			best = candidate
+			bestVersion = v
+		}
+	}
+
+	if best == "" {
+		sorted := append([]string(nil), available...)
+		sort.Strings(sorted)
+		return "", fmt.Errorf("no version matches %q (available: %s)", constraint, strings.Join(sorted, ", "))
+	}
+	return best, nil
+}
@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectVersion(t *testing.T) {
+	available := []string{"1.0.0", "1.0.3", "1.2.0", "2.0.0", "0.3.1", "0.4.0"}
+
+	tests := []struct {
+		name       string
+		constraint string
+		expected   string
+		shouldFail bool
+	}{
+		{name: "exact", constraint: "1.0.3", expected: "1.0.3"},
+		{name: "exact with v prefix", constraint: "v2.0.0", expected: "2.0.0"},
+		{name: "prefix minor", constraint: "1.0", expected: "1.0.3"},
+		{name: "prefix major", constraint: "1", expected: "1.2.0"},
+		{name: "caret", constraint: "^1.0.0", expected: "1.2.0"},
+		{name: "caret zero major", constraint: "^0.3.0", expected: "0.3.1"},
+		{name: "tilde", constraint: "~1.0.1", expected: "1.0.3"},
+		{name: "greater or equal", constraint: ">=1.1", expected: "2.0.0"},
+		{name: "less than", constraint: "<1.0.0", expected: "0.4.0"},
+		{name: "no match", constraint: "^3.0.0", shouldFail: true},
+		{name: "invalid", constraint: "abc", shouldFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectVersion(tt.constraint, available)
+			if tt.shouldFail {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %s", tt.constraint, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %s for %q, got %s", tt.expected, tt.constraint, got)
+			}
+		})
+	}
+}
+
+func TestSelectVersionErrorListsAvailable(t *testing.T) {
+	_, err := SelectVersion("^5.0.0", []string{"1.0.0", "2.0.0"})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if !strings.Contains(err.Error(), "1.0.0, 2.0.0") {
+		t.Errorf("Expected error to list available versions, got: %v", err)
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.1", "1.0.0", 1},
+		{"1.2.0", "1.10.0", -1},
+		{"1.0.0-beta", "1.0.0", -1},
+		{"2", "1.9.9", 1},
+	}
+
+	for _, tt := range tests {
+		a, _ := ParseVersion(tt.a)
+		b, _ := ParseVersion(tt.b)
+		if got := a.Compare(b); got != tt.expected {
+			t.Errorf("Compare(%s, %s) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestValidatePluginDependencyVersions(t *testing.T) {
+	dir := t.TempDir()
+	tools := map[string]string{
+		"fake-tool":        "#!/bin/sh\necho 'fake-tool version 4.2.1 (build 7)'\n",
+		"fake-versionless": "#!/bin/sh\necho 'no version here'\nexit 1\n",
+	}
+	for name, script := range tools {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		dep      string
+		errMsg   string
+		warnings int
+	}{
+		{dep: "fake-tool"},
+		{dep: "fake-tool>=4.0"},
+		{dep: "fake-tool==4.2.1"},
+		{dep: "fake-tool<5"},
+		{dep: "fake-tool>=5.0.0", errMsg: "fake-tool 4.2.1 does not satisfy >=5.0.0"},
+		{dep: "fake-tool>4.2.1", errMsg: "fake-tool 4.2.1 does not satisfy >4.2.1"},
+		{dep: "fake-tool<=4.2", errMsg: "does not satisfy <=4.2"},
+		{dep: "fake-tool>=abc", errMsg: "invalid version constraint"},
+		{dep: "fake-versionless>=1.0", warnings: 1},
+		{dep: "fake-missing-tool>=1.0", errMsg: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dep, func(t *testing.T) {
+			warnings, err := ValidatePluginDependencies([]string{tt.dep})
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("Expected %d warnings, got %v", tt.warnings, warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultShell runs steps that don't select a shell
+const DefaultShell = "sh"
+
+// shells maps the shells a step may select to the interpreter and the
+// arguments that come before the script
+var shells = map[string][]string{
+	"sh":         {"sh", "-c"},
+	"bash":       {"bash", "-c"},
+	"pwsh":       {"pwsh", "-NoProfile", "-NonInteractive", "-Command"},
+	"powershell": {"powershell", "-NoProfile", "-NonInteractive", "-Command"},
+	"python":     {"python", "-c"},
+	"python3":    {"python3", "-c"},
+	"cmd":        {"cmd", "/C"},
+}
+
+// ShellArgs returns the interpreter and leading arguments for a step's shell,
+// with an empty shell meaning DefaultShell. The script goes after them.
+func ShellArgs(shell string) ([]string, error) {
+	if shell == "" {
+		shell = DefaultShell
+	}
+	args, ok := shells[shell]
+	if !ok {
+		return nil, fmt.Errorf("unknown shell %q (expected one of %s)", shell, strings.Join(shellNames(), ", "))
+	}
+	return append([]string(nil), args...), nil
+}
+
+func shellNames() []string {
+	names := make([]string, 0, len(shells))
+	for name := range shells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -11,20 +11,23 @@ import (
 	"time"
 
 	"github.com/andi/fileaction/backend/api"
+	"github.com/andi/fileaction/backend/applog"
 	"github.com/andi/fileaction/backend/config"
 	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/logsink"
 	"github.com/andi/fileaction/backend/scheduler"
 	"github.com/andi/fileaction/backend/watcher"
+	"github.com/andi/fileaction/backend/workflow"
 )
 
 func main() {
-	// Load configuration
-	cfgPath := os.Getenv("CONFIG_PATH")
-	if cfgPath == "" {
-		cfgPath = "./config/config.yaml"
+	// "fileaction run" executes one workflow against one file and exits
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runCommand(os.Args[2:]))
 	}
 
-	cfg, err := config.LoadFromEnv(cfgPath)
+	// Load configuration
+	cfg, err := config.LoadFromEnv(configPath())
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -42,10 +45,15 @@ func main() {
 
 	// 设置日志同时输出到控制台和文件
 	multiWriter := io.MultiWriter(os.Stdout, logFile)
-	log.SetOutput(multiWriter)
+	if err := applog.Setup(multiWriter, cfg.Logging.Format, cfg.Logging.Level); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
 
 	log.Println("=== FileAction Starting ===")
-	log.Printf("Configuration: %+v", cfg)
+	log.Printf("Configuration: %+v", cfg.Redacted())
+
+	// Workflows with "concurrency: auto" use one task per CPU up to this cap
+	workflow.SetMaxConcurrency(cfg.Execution.MaxConcurrency)
 
 	// Initialize database
 	// cfg.Database.Path now should be MySQL DSN format: user:password@tcp(host:port)/dbname?params
@@ -54,6 +62,10 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
+	if err := db.SetIDFormat(cfg.Database.IDFormat); err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+	db.SetMaxRetries(cfg.Database.MaxRetries)
 	log.Println("Database initialized")
 
 	// Reset any running tasks to pending (handles interrupted tasks from previous run)
@@ -64,6 +76,28 @@ func main() {
 		log.Printf("Reset %d running task(s) to pending status", resetCount)
 	}
 
+	// Initialize external log sink (nil keeps task logs in the database)
+	var logSink logsink.Sink
+	switch cfg.Logging.Sink.Type {
+	case "":
+	case "filesystem":
+		logSink, err = logsink.NewFilesystemSink(cfg.Logging.Sink.Dir)
+	case "s3":
+		logSink, err = logsink.NewS3Sink(logsink.S3Options{
+			Endpoint:  cfg.Logging.Sink.S3.Endpoint,
+			Region:    cfg.Logging.Sink.S3.Region,
+			Bucket:    cfg.Logging.Sink.S3.Bucket,
+			Prefix:    cfg.Logging.Sink.S3.Prefix,
+			AccessKey: cfg.Logging.Sink.S3.AccessKey,
+			SecretKey: cfg.Logging.Sink.S3.SecretKey,
+		})
+	default:
+		err = fmt.Errorf("unknown log sink type: %s", cfg.Logging.Sink.Type)
+	}
+	if err != nil {
+		log.Fatalf("Failed to initialize log sink: %v", err)
+	}
+
 	// Initialize task scheduler with integrated executor pool
 	sched := scheduler.New(
 		db,
@@ -73,6 +107,21 @@ func main() {
 		cfg.Execution.TaskTimeout,
 		cfg.Execution.StepTimeout,
 	)
+	if logSink != nil {
+		sched.SetLogSink(logSink)
+	}
+	sched.SetMaxLogBytes(cfg.Logging.MaxLogBytes)
+	sched.SetMaxChainDepth(cfg.Execution.MaxChainDepth)
+	if err := sched.SetHashAlgorithm(cfg.Watcher.HashAlgorithm); err != nil {
+		log.Fatalf("Invalid watcher configuration: %v", err)
+	}
+	if err := sched.SetNotifications(cfg.Notifications.WebhookURL, cfg.Notifications.On); err != nil {
+		log.Fatalf("Invalid notification settings: %v", err)
+	}
+	if err := sched.SetPathAudit(cfg.Execution.AllowedRoots, cfg.Execution.PathAudit); err != nil {
+		log.Fatalf("Invalid execution configuration: %v", err)
+	}
+	sched.SetRetention(cfg.Scheduler.RetentionDays, cfg.Database.ArchiveRetention, cfg.Scheduler.CleanupInterval)
 	sched.Start()
 	defer sched.Stop()
 	log.Printf("Task scheduler initialized with %d executors", cfg.Execution.DefaultConcurrency)
@@ -82,6 +131,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize file watcher: %v", err)
 	}
+	watch.SetBatchWindow(cfg.Watcher.BatchWindow)
+	watch.SetScanConcurrency(cfg.Watcher.ScanConcurrency)
+	watch.SetOpenRetries(cfg.Watcher.OpenRetries)
+	watch.SetStability(cfg.Watcher.StabilityWindow, cfg.Watcher.StabilityChecks)
+	if err := watch.SetHashAlgorithm(cfg.Watcher.HashAlgorithm); err != nil {
+		log.Fatalf("Invalid watcher configuration: %v", err)
+	}
 	if err := watch.Start(); err != nil {
 		log.Fatalf("Failed to start file watcher: %v", err)
 	}
@@ -89,7 +145,16 @@ func main() {
 	log.Printf("File watcher initialized and started (max pending tasks: %d)", cfg.Watcher.MaxPendingTasks)
 
 	// Initialize API server
-	server := api.New(db, sched, watch, cfg.Logging.Dir)
+	server := api.New(db, sched, watch, cfg.Logging.Dir, api.AuthConfig{
+		Token:     cfg.Security.Token,
+		Username:  cfg.Security.BasicAuth.Username,
+		Password:  cfg.Security.BasicAuth.Password,
+		ProtectUI: cfg.Security.ProtectUI,
+	})
+	if logSink != nil {
+		server.SetLogSink(logSink)
+	}
+	server.SetArchiveRetention(cfg.Database.ArchiveRetention)
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
 	// Connect scheduler to WebSocket hub for real-time log broadcasting
@@ -117,6 +182,9 @@ func main() {
 		log.Printf("Received signal: %v", sig)
 		log.Println("Shutting down gracefully...")
 
+		// Dispatch nothing new while the server and scheduler shut down
+		sched.Drain()
+
 		// Create a deadline for shutdown
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -144,3 +212,11 @@ func main() {
 		log.Println("Shutdown complete")
 	}
 }
+
+// configPath returns the configuration file to load, CONFIG_PATH if set
+func configPath() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "./config/config.yaml"
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/andi/fileaction/backend/config"
+	"github.com/andi/fileaction/backend/database"
+	"github.com/andi/fileaction/backend/filehash"
+	"github.com/andi/fileaction/backend/models"
+	"github.com/andi/fileaction/backend/scheduler"
+	"github.com/andi/fileaction/backend/workflow"
+)
+
+// runCommand implements "fileaction run": it executes one workflow against one
+// file in the foreground, without the HTTP server or scheduler, and prints the
+// task log to stdout. Tasks are kept in a scratch database, so runs don't show
+// up in the server's task list. It returns the process exit code.
+func runCommand(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileaction run (--workflow <name> | --workflow-file <path>) --file <path>")
+		flags.PrintDefaults()
+	}
+	name := flags.String("workflow", "", "name of a workflow stored in the database")
+	yamlPath := flags.String("workflow-file", "", "workflow YAML file to run instead of a stored workflow")
+	inputPath := flags.String("file", "", "input file to process")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (*name == "") == (*yamlPath == "") || *inputPath == "" {
+		flags.Usage()
+		return 2
+	}
+
+	if err := runWorkflowOnce(*name, *yamlPath, *inputPath, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runWorkflowOnce runs the workflow, stored under name or read from yamlPath,
+// against inputPath and writes the log to out. It fails if any task does not
+// complete.
+func runWorkflowOnce(name, yamlPath, inputPath string, out io.Writer) error {
+	cfg, err := config.LoadFromEnv(configPath())
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	workflow.SetMaxConcurrency(cfg.Execution.MaxConcurrency)
+
+	// The configured database supplies stored workflows and plugins; it is
+	// only opened when needed
+	var source *database.DB
+	openSource := func() (*database.DB, error) {
+		if source == nil {
+			if source, err = database.New(cfg.Database.Path); err != nil {
+				return nil, fmt.Errorf("failed to open database: %w", err)
+			}
+		}
+		return source, nil
+	}
+	defer func() {
+		if source != nil {
+			source.Close()
+		}
+	}()
+
+	var yamlContent string
+	if yamlPath != "" {
+		content, err := os.ReadFile(yamlPath)
+		if err != nil {
+			return fmt.Errorf("failed to read workflow: %w", err)
+		}
+		yamlContent = string(content)
+	} else {
+		db, err := openSource()
+		if err != nil {
+			return err
+		}
+		wf, err := database.NewWorkflowRepo(db).GetByName(name)
+		if err != nil {
+			return fmt.Errorf("workflow %s not found: %w", name, err)
+		}
+		yamlContent = wf.YAMLContent
+	}
+
+	workflowDef, err := workflow.Parse(yamlContent)
+	if err != nil {
+		return err
+	}
+	if err := workflow.Validate(workflowDef); err != nil {
+		return err
+	}
+
+	absInput, err := filepath.Abs(inputPath)
+	if err != nil {
+		return err
+	}
+	digest, size, err := hashFile(absInput, cfg.Watcher.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	// Tasks and their logs live in a scratch database
+	scratchDir, err := os.MkdirTemp("", "fileaction-run-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratchDir)
+	scratch, err := database.New(filepath.Join(scratchDir, "run.db"))
+	if err != nil {
+		return fmt.Errorf("failed to create scratch database: %w", err)
+	}
+	defer scratch.Close()
+
+	for _, step := range workflowDef.Steps {
+		if step.Uses == "" {
+			continue
+		}
+		db, err := openSource()
+		if err != nil {
+			return err
+		}
+		if err := copyPlugin(db, scratch, step.Uses); err != nil {
+			return fmt.Errorf("step %s: %w", step.Name, err)
+		}
+	}
+
+	wf := &models.Workflow{Name: workflowDef.Name, YAMLContent: yamlContent, Enabled: true}
+	if err := database.NewWorkflowRepo(scratch).Create(wf); err != nil {
+		return err
+	}
+	file := &models.File{WorkflowID: wf.ID, FilePath: absInput, FileMD5: digest, FileSize: size, LastScannedAt: time.Now()}
+	if err := database.NewFileRepo(scratch).Create(file); err != nil {
+		return err
+	}
+
+	// The executor runs under the same limits as the server's. The log goes to
+	// out rather than a log sink, and the result is the exit code rather than
+	// a notification.
+	executor := scheduler.NewExecutor(scratch, scratchDir, cfg.Execution.TaskTimeout, cfg.Execution.StepTimeout)
+	executor.SetWebSocketHub(logPrinter{out})
+	executor.SetMaxLogBytes(cfg.Logging.MaxLogBytes)
+	executor.SetMaxChainDepth(cfg.Execution.MaxChainDepth)
+	executor.SetHashAlgorithm(cfg.Watcher.HashAlgorithm)
+	auditMode, err := scheduler.ParsePathAuditMode(cfg.Execution.PathAudit)
+	if err != nil {
+		return fmt.Errorf("invalid execution configuration: %w", err)
+	}
+	executor.SetPathAudit(cfg.Execution.AllowedRoots, auditMode)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	taskRepo := database.NewTaskRepo(scratch)
+	failed := 0
+	for _, outputPath := range workflowDef.TaskOutputPaths(absInput, time.Now(), digest) {
+		task := &models.Task{
+			WorkflowID: wf.ID,
+			FileID:     file.ID,
+			InputPath:  absInput,
+			OutputPath: outputPath,
+			InputMD5:   digest,
+			Status:     models.TaskStatusPending,
+		}
+		if err := taskRepo.Create(task); err != nil {
+			return err
+		}
+		if err := executor.ExecuteTask(ctx, task.ID); err != nil {
+			return err
+		}
+
+		task, err = taskRepo.GetByID(task.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "\n%s -> %s: %s\n", absInput, outputPath, task.Status)
+		if task.Status != models.TaskStatusCompleted {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d task(s) did not complete", failed)
+	}
+	return nil
+}
+
+// copyPlugin copies the plugin version a uses reference resolves to in the
+// configured database into the scratch database. Steps using other versions of
+// the same plugin each get theirs, and the scratch plugin's current version
+// follows the configured one.
+func copyPlugin(from, to *database.DB, uses string) error {
+	name, version, err := workflow.ParsePluginReference(uses)
+	if err != nil {
+		return err
+	}
+
+	source := database.NewPluginRepo(from)
+	plugin, err := source.GetPluginByName(name)
+	if err != nil {
+		return fmt.Errorf("plugin %s not found: %w", uses, err)
+	}
+	var resolved *database.PluginVersion
+	if version != "" {
+		resolved, err = source.ResolvePluginVersion(name, version)
+	} else {
+		resolved, err = source.GetPluginCurrentVersion(plugin.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s not found: %w", uses, err)
+	}
+
+	target := database.NewPluginRepo(to)
+	copied, err := target.GetPluginByName(name)
+	if err != nil {
+		_, _, err = target.CreatePlugin(name, resolved.Description, resolved.YAMLContent, "fileaction run")
+		return err
+	}
+	if _, err := target.GetPluginVersionByNumber(name, resolved.Version); err == nil {
+		return nil
+	}
+	if _, err := target.CreatePluginVersion(copied.ID, resolved.YAMLContent); err != nil {
+		return err
+	}
+
+	// Adding a version makes it current
+	current, err := source.GetPluginCurrentVersion(plugin.ID)
+	if err != nil {
+		return err
+	}
+	if copiedCurrent, err := target.GetPluginVersionByNumber(name, current.Version); err == nil {
+		return target.SetCurrentVersion(copied.ID, copiedCurrent.ID)
+	}
+	return nil
+}
+
+// hashFile returns the digest and size of the input file
+func hashFile(path, algorithm string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return filehash.Sum(f, algorithm)
+}
+
+// logPrinter prints task log lines as the executor writes them
+type logPrinter struct {
+	w io.Writer
+}
+
+func (p logPrinter) BroadcastLog(taskID, content string) {
+	fmt.Fprint(p.w, content)
+}
+
+func (p logPrinter) BroadcastTaskComplete(taskID string) {}
+
+func (p logPrinter) BroadcastSchedulerEvent(event scheduler.Event) {}
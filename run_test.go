@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andi/fileaction/backend/database"
+)
+
+// setupRunConfig writes a configuration whose database and logs live in dir
+// and points CONFIG_PATH at it. extra is appended to the execution section.
+func setupRunConfig(t *testing.T, dir, extra string) string {
+	t.Helper()
+	dbPath := filepath.Join(dir, "fileaction.db")
+	configYAML := "database:\n  path: " + dbPath + "\nlogging:\n  dir: " + filepath.Join(dir, "logs") + "\nexecution:\n  step_timeout: 30s\n" + extra
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(configYAML), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("CONFIG_PATH", configFile)
+	return dbPath
+}
+
+func TestRunWorkflowFileWithPluginVersions(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := setupRunConfig(t, dir, "")
+
+	// The step pinned to 1.0.0 and the one following the current version
+	// each run their own version of the plugin
+	db, err := database.New(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	pluginYAML := func(version string) string {
+		return "name: echo-plugin\nversion: " + version + "\nsteps:\n  - name: say\n    run: echo plugin-" + version + "\n"
+	}
+	pluginRepo := database.NewPluginRepo(db)
+	plugin, _, err := pluginRepo.CreatePlugin("echo-plugin", "", pluginYAML("1.0.0"), "test")
+	if err != nil {
+		t.Fatalf("Failed to create plugin: %v", err)
+	}
+	if _, err := pluginRepo.CreatePluginVersion(plugin.ID, pluginYAML("2.0.0")); err != nil {
+		t.Fatalf("Failed to create plugin version: %v", err)
+	}
+	db.Close()
+
+	input := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	workflowFile := filepath.Join(dir, "workflow.yaml")
+	workflowYAML := `name: run-test
+on:
+  paths:
+    - ` + dir + `
+convert:
+  from: txt
+  to: out
+steps:
+  - name: pinned
+    uses: echo-plugin@1.0.0
+  - name: current
+    uses: echo-plugin
+  - name: copy
+    run: cp "${{ input_path }}" "${{ output_path }}"
+`
+	if err := os.WriteFile(workflowFile, []byte(workflowYAML), 0644); err != nil {
+		t.Fatalf("Failed to write workflow: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runWorkflowOnce("", workflowFile, input, &out); err != nil {
+		t.Fatalf("runWorkflowOnce failed: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"plugin-1.0.0", "plugin-2.0.0", ": completed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the output:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunWorkflowFileAppliesPathAudit(t *testing.T) {
+	dir := t.TempDir()
+	setupRunConfig(t, dir, "  allowed_roots:\n    - "+dir+"\n  path_audit: fail\n")
+
+	input := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(input, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	workflowFile := filepath.Join(dir, "workflow.yaml")
+	workflowYAML := "name: run-test\non:\n  paths:\n    - " + dir + "\nsteps:\n  - name: escape\n    run: touch " + outside + "\n"
+	if err := os.WriteFile(workflowFile, []byte(workflowYAML), 0644); err != nil {
+		t.Fatalf("Failed to write workflow: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runWorkflowOnce("", workflowFile, input, &out); err == nil || !strings.Contains(out.String(), "Path audit failed") {
+		t.Errorf("Expected the run to fail the path audit, got %v:\n%s", err, out.String())
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Error("Expected the audited command not to run")
+	}
+}